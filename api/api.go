@@ -0,0 +1,165 @@
+// Package api serves the pipeline repository over HTTP as JSON
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+type Server struct {
+	BlobStore pipeline.BlobStore //required if the repository stores payloads out of line
+	Events    *pipeline.EventBus //if nil, /events isn't served
+
+	repo pipeline.Repository
+	raw  pipeline.Repository //r unvalidated, for functions that validate their input and need its transactions
+	mux  *http.ServeMux
+	log  *log.Logger
+	now  func() time.Time
+}
+
+// NewServer creates the API handler. Every request is validated before it
+// reaches r.
+func NewServer(r pipeline.Repository) *Server {
+	s := &Server{
+		repo: pipeline.NewValidationWrapper(r),
+		raw:  r,
+		mux:  http.NewServeMux(),
+		log:  log.New(os.Stderr, "api: ", log.LstdFlags),
+		now:  time.Now,
+	}
+	s.mux.HandleFunc("/jobs", s.handleJobs)
+	s.mux.HandleFunc("/jobs/", s.handleJob)
+	s.mux.HandleFunc("/runs", s.handleRuns)
+	s.mux.HandleFunc("/runs/", s.handleRun)
+	s.mux.HandleFunc("/backfills", s.handleBackfills)
+	s.mux.HandleFunc("/backfills/", s.handleBackfill)
+	s.mux.HandleFunc("/approvals", s.handleApprovals)
+	s.mux.HandleFunc("/hooks/", s.handleHook)
+	s.mux.HandleFunc("/executions", s.handleExecutions)
+	s.mux.HandleFunc("/executions/", s.handleExecution)
+	s.mux.HandleFunc("/graph", s.handleGraph)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/events/ws", s.handleEventsWebSocket)
+	s.mux.Handle("/ui/", uiHandler())
+	s.mux.HandleFunc("/", s.handleRoot)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ErrorResponse is the body of every 4xx and 5xx response
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes why a field of the request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// badRequestError is returned for requests that can't be parsed
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return string(e)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	resp := ErrorResponse{Error: err.Error()}
+	switch e := err.(type) {
+	case pipeline.ValidationErrors:
+		status = http.StatusUnprocessableEntity
+		resp.Error = "validation failed"
+		for _, fe := range e {
+			resp.Fields = append(resp.Fields, fieldError(fe))
+		}
+	case pipeline.ErrFieldRequired, pipeline.ErrFieldInvalid:
+		status = http.StatusUnprocessableEntity
+		resp.Error = "validation failed"
+		resp.Fields = []FieldError{fieldError(e)}
+	case badRequestError:
+		status = http.StatusBadRequest
+	case pipeline.Err:
+		switch e {
+		case pipeline.ErrJobNotFound, pipeline.ErrRunNotFound, pipeline.ErrJobVersionNotFound, pipeline.ErrExecutionNotFound, pipeline.ErrBackfillNotFound,
+			pipeline.ErrApprovalNotFound, pipeline.ErrWebhookNotFound, errNotFound:
+			status = http.StatusNotFound
+		case pipeline.ErrWebhookUnauthorized:
+			status = http.StatusUnauthorized
+		case errMethodNotAllowed:
+			status = http.StatusMethodNotAllowed
+		case pipeline.ErrEventsExpired:
+			status = http.StatusGone
+		case errRunStarted, pipeline.ErrBackfillNotRunning, pipeline.ErrApprovalNotWaiting:
+			status = http.StatusConflict
+		}
+	}
+	if status == http.StatusInternalServerError {
+		s.log.Printf("internal error: %s", err)
+		resp.Error = "internal error"
+	}
+	s.writeJSON(w, status, resp)
+}
+
+func fieldError(err error) FieldError {
+	switch e := err.(type) {
+	case pipeline.ErrFieldRequired:
+		return FieldError{Field: e.FieldName, Message: "is required"}
+	case pipeline.ErrFieldInvalid:
+		return FieldError{Field: e.FieldName, Message: e.Reason}
+	}
+	return FieldError{Message: err.Error()}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Printf("err writing response: %s", err)
+	}
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequestError("invalid request body: " + err.Error())
+	}
+	return nil
+}
+
+const (
+	errNotFound         = pipeline.Err("not found")
+	errMethodNotAllowed = pipeline.Err("method not allowed")
+	errRunStarted       = pipeline.Err("run has started or finished")
+)
+
+// pathParts splits the path below prefix, "/jobs/1/versions" with prefix
+// "/jobs/" is ["1", "versions"]
+func pathParts(r *http.Request, prefix string) []string {
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func parseID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, errNotFound
+	}
+	return id, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+// Approval is the JSON representation of a pipeline.Approval
+type Approval struct {
+	RunID       pipeline.RunID          `json:"run_id"`
+	JobID       pipeline.JobID          `json:"job_id"`
+	Status      pipeline.ApprovalStatus `json:"status"`
+	RequestedAt time.Time               `json:"requested_at"`
+	ExpiresAt   time.Time               `json:"expires_at"`
+	DecidedBy   string                  `json:"decided_by,omitempty"`
+	Comment     string                  `json:"comment,omitempty"`
+	DecidedAt   *time.Time              `json:"decided_at"`
+}
+
+func newApproval(a *pipeline.Approval) *Approval {
+	return &Approval{
+		RunID:       a.RunID,
+		JobID:       a.JobID,
+		Status:      a.Status,
+		RequestedAt: a.RequestedAt,
+		ExpiresAt:   a.ExpiresAt,
+		DecidedBy:   a.DecidedBy,
+		Comment:     a.Comment,
+		DecidedAt:   a.DecidedAt,
+	}
+}
+
+// DecisionInput is the body of POST /runs/{id}/approve and
+// POST /runs/{id}/reject
+type DecisionInput struct {
+	By      string `json:"by"` //who decided, required
+	Comment string `json:"comment"`
+}
+
+// handleApprovals serves /approvals?job_id=1,2&status=waiting
+func (s *Server) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	p := &queryParser{q: r.URL.Query()}
+	in := &pipeline.GetApprovalsInput{JobIDs: p.jobIDs("job_id")}
+	for _, st := range p.list("status") {
+		in.Statuses = append(in.Statuses, pipeline.ApprovalStatus(st))
+	}
+	if p.err != nil {
+		s.writeError(w, p.err)
+		return
+	}
+	approvals, err := s.repo.GetApprovals(in)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	resp := make([]*Approval, len(approvals))
+	for i, a := range approvals {
+		resp[i] = newApproval(a)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// approval serves GET /runs/{id}/approval
+func (s *Server) approval(w http.ResponseWriter, id pipeline.RunID) {
+	a, err := s.getApproval(id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newApproval(a))
+}
+
+// decide serves POST /runs/{id}/approve and POST /runs/{id}/reject, the run
+// then completes and its triggers fire
+func (s *Server) decide(w http.ResponseWriter, r *http.Request, id pipeline.RunID, status pipeline.ApprovalStatus) {
+	in := &DecisionInput{}
+	if err := readJSON(r, in); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	err := pipeline.ResolveApproval(s.raw, &pipeline.DecideApprovalInput{
+		RunID:     id,
+		Status:    status,
+		DecidedBy: in.By,
+		Comment:   in.Comment,
+		DecidedAt: s.now(),
+	})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.approval(w, id)
+}
+
+func (s *Server) getApproval(id pipeline.RunID) (*pipeline.Approval, error) {
+	approvals, err := s.repo.GetApprovals(&pipeline.GetApprovalsInput{RunIDs: pipeline.RunIDs{id}})
+	if err != nil {
+		return nil, err
+	}
+	if len(approvals) != 1 {
+		return nil, pipeline.ErrApprovalNotFound
+	}
+	return approvals[0], nil
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+func TestApprovals(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	now := time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC)
+	s.api.now = func() time.Time { return now }
+	_, err := s.repo.CreateJob(&pipeline.CreateJobInput{
+		Name:                 "sign-off",
+		Processor:            pipeline.ProcessorConfig{Type: pipeline.ProcessorTypeApproval},
+		InputPayloadTemplate: []byte("{}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []pipeline.RunID{1, 2} {
+		_, err := s.repo.CreateRun(&pipeline.CreateRunInput{
+			JobID:              1,
+			ProcessorConfig:    pipeline.ProcessorConfig{Type: pipeline.ProcessorTypeApproval},
+			Status:             pipeline.RunStatusPtr(pipeline.RunStatusWaiting),
+			ScheduledStartTime: now,
+			Attempt:            pipeline.IntPtr(1),
+			Input:              []byte("{}"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.repo.CreateApproval(&pipeline.CreateApprovalInput{RunID: id, JobID: 1, RequestedAt: now, ExpiresAt: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	approved := &Approval{}
+	if status := s.do("POST", "/runs/1/approve", &DecisionInput{By: "alice", Comment: "ship it"}, approved); status != http.StatusOK {
+		t.Fatalf("approve: expected status 200, got %d", status)
+	}
+	if approved.Status != pipeline.ApprovalStatusApproved || approved.DecidedBy != "alice" || approved.Comment != "ship it" ||
+		approved.DecidedAt == nil || !approved.DecidedAt.Equal(now) {
+		t.Errorf("approve: expected the approval by alice, got %+v", approved)
+	}
+	run := &Run{}
+	if status := s.do("GET", "/runs/1", nil, run); status != http.StatusOK {
+		t.Fatalf("get run: expected status 200, got %d", status)
+	}
+	if run.Status != pipeline.RunStatusPending || run.StatusDetail != "approved by alice" {
+		t.Errorf("get run: expected the approved run to be pending, got %+v", run)
+	}
+
+	list := []*Approval{}
+	if status := s.do("GET", "/approvals?status=waiting", nil, &list); status != http.StatusOK || len(list) != 1 || list[0].RunID != 2 {
+		t.Errorf("list waiting: expected run 2, got status %d and %+v", status, list)
+	}
+
+	errResp := &ErrorResponse{}
+	if status := s.do("POST", "/runs/1/reject", &DecisionInput{By: "bob"}, errResp); status != http.StatusConflict {
+		t.Errorf("reject decided: expected status 409, got %d", status)
+	}
+	if status := s.do("POST", "/runs/2/reject", &DecisionInput{}, errResp); status != http.StatusUnprocessableEntity {
+		t.Errorf("reject without by: expected status 422, got %d", status)
+	}
+	if status := s.do("POST", "/runs/3/approve", &DecisionInput{By: "alice"}, errResp); status != http.StatusNotFound {
+		t.Errorf("approve unknown: expected status 404, got %d", status)
+	}
+	rejected := &Approval{}
+	if status := s.do("POST", "/runs/2/reject", &DecisionInput{By: "bob"}, rejected); status != http.StatusOK || rejected.Status != pipeline.ApprovalStatusRejected {
+		t.Errorf("reject: expected a rejected approval, got status %d and %+v", status, rejected)
+	}
+	if status := s.do("GET", "/runs/2/approval", nil, rejected); status != http.StatusOK || rejected.DecidedBy != "bob" {
+		t.Errorf("get approval: expected the rejection by bob, got status %d and %+v", status, rejected)
+	}
+	if status := s.do("GET", "/runs/1/approve", nil, errResp); status != http.StatusMethodNotAllowed {
+		t.Errorf("get approve: expected status 405, got %d", status)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+// Backfill is the JSON representation of a pipeline.Backfill
+type Backfill struct {
+	ID                pipeline.BackfillID     `json:"id"`
+	JobID             pipeline.JobID          `json:"job_id"`
+	Status            pipeline.BackfillStatus `json:"status"`
+	IncludeDownstream bool                    `json:"include_downstream"`
+	MaxParallel       int                     `json:"max_parallel"`
+	Dates             int                     `json:"dates"`
+	FirstDate         time.Time               `json:"first_date"`
+	LastDate          time.Time               `json:"last_date"`
+	CreatedAt         time.Time               `json:"created_at"`
+	EndTime           *time.Time              `json:"end_time"`
+	Runs              map[string]int          `json:"runs"` //by state: queued, pending, running, succeeded, failed...
+}
+
+func newBackfill(b *pipeline.Backfill) *Backfill {
+	return &Backfill{
+		ID:                b.ID,
+		JobID:             b.JobID,
+		Status:            b.Status,
+		IncludeDownstream: b.IncludeDownstream,
+		MaxParallel:       b.MaxParallel,
+		Dates:             b.Dates,
+		FirstDate:         b.FirstDate,
+		LastDate:          b.LastDate,
+		CreatedAt:         b.CreatedAt,
+		EndTime:           b.EndTime,
+		Runs:              b.Runs,
+	}
+}
+
+// BackfillInput is the body of POST /jobs/{id}/backfill. Either
+// logical_dates or start and end are set, the dates of a range are the fire
+// times of cron_schedule, the job's schedule by default.
+type BackfillInput struct {
+	LogicalDates      []time.Time           `json:"logical_dates"`
+	Start             time.Time             `json:"start"`
+	End               time.Time             `json:"end"`
+	CronSchedule      pipeline.CronSchedule `json:"cron_schedule"`
+	IncludeDownstream bool                  `json:"include_downstream"`
+	MaxParallel       int                   `json:"max_parallel"`
+}
+
+// backfill serves POST /jobs/{id}/backfill
+func (s *Server) backfill(w http.ResponseWriter, r *http.Request, id pipeline.JobID) {
+	in := &BackfillInput{}
+	if err := readJSON(r, in); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	backfillID, err := pipeline.StartBackfill(s.repo, &pipeline.BackfillInput{
+		JobID:             id,
+		LogicalDates:      in.LogicalDates,
+		Start:             in.Start,
+		End:               in.End,
+		CronSchedule:      in.CronSchedule,
+		IncludeDownstream: in.IncludeDownstream,
+		MaxParallel:       in.MaxParallel,
+	}, s.now())
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeBackfill(w, http.StatusCreated, backfillID)
+}
+
+// handleBackfills serves /backfills?job_id=1,2&status=running
+func (s *Server) handleBackfills(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	p := &queryParser{q: r.URL.Query()}
+	in := &pipeline.GetBackfillsInput{JobIDs: p.jobIDs("job_id")}
+	for _, st := range p.list("status") {
+		in.Statuses = append(in.Statuses, pipeline.BackfillStatus(st))
+	}
+	if p.err != nil {
+		s.writeError(w, p.err)
+		return
+	}
+	backfills, err := s.repo.GetBackfills(in)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	resp := make([]*Backfill, len(backfills))
+	for i, b := range backfills {
+		resp[i] = newBackfill(b)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleBackfill serves /backfills/{id} and /backfills/{id}/cancel
+func (s *Server) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/backfills/")
+	if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "cancel") {
+		s.writeError(w, errNotFound)
+		return
+	}
+	id, err := parseID(parts[0])
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	backfillID := pipeline.BackfillID(id)
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.writeBackfill(w, http.StatusOK, backfillID)
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		if err := pipeline.CancelBackfill(s.raw, backfillID, s.now()); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeBackfill(w, http.StatusOK, backfillID)
+	default:
+		s.writeError(w, errMethodNotAllowed)
+	}
+}
+
+func (s *Server) writeBackfill(w http.ResponseWriter, status int, id pipeline.BackfillID) {
+	backfills, err := s.repo.GetBackfills(&pipeline.GetBackfillsInput{BackfillIDs: pipeline.BackfillIDs{id}})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if len(backfills) != 1 {
+		s.writeError(w, pipeline.ErrBackfillNotFound)
+		return
+	}
+	s.writeJSON(w, status, newBackfill(backfills[0]))
+}
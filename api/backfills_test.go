@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+func TestBackfills(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	s.api.now = func() time.Time { return time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC) }
+	_, err := s.repo.CreateJob(&pipeline.CreateJobInput{
+		Name:                 "extract",
+		Processor:            pipeline.ProcessorConfig{Type: "lambda"},
+		InputPayloadTemplate: []byte("{}"),
+		Triggers:             &pipeline.TriggerEventsInput{CronSchedule: pipeline.NewCronSchedule("0 2 * * *")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := &BackfillInput{
+		Start:       time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:         time.Date(2017, 3, 4, 0, 0, 0, 0, time.UTC),
+		MaxParallel: 1,
+	}
+	created := &Backfill{}
+	if status := s.do("POST", "/jobs/1/backfill", in, created); status != http.StatusCreated {
+		t.Fatalf("create: expected status 201, got %d", status)
+	}
+	if created.ID != 1 || created.Status != pipeline.BackfillStatusRunning || created.Dates != 3 ||
+		created.Runs["pending"] != 1 || created.Runs["queued"] != 2 {
+		t.Errorf("create: expected a running backfill of 3 dates, 1 pending, got %+v", created)
+	}
+	if !created.FirstDate.Equal(time.Date(2017, 3, 1, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("create: expected the first date to be the first fire time, got %s", created.FirstDate)
+	}
+	runs := &RunList{}
+	if status := s.do("GET", "/runs?backfill_id=1", nil, runs); status != http.StatusOK || len(runs.Runs) != 3 {
+		t.Errorf("runs of the backfill: expected 3 runs, got status %d and %d runs", status, len(runs.Runs))
+	}
+
+	list := []*Backfill{}
+	if status := s.do("GET", "/backfills?job_id=1&status=running", nil, &list); status != http.StatusOK || len(list) != 1 {
+		t.Errorf("list: expected 1 backfill, got status %d and %+v", status, list)
+	}
+	cancelled := &Backfill{}
+	if status := s.do("POST", "/backfills/1/cancel", nil, cancelled); status != http.StatusOK {
+		t.Fatalf("cancel: expected status 200, got %d", status)
+	}
+	if cancelled.Status != pipeline.BackfillStatusCancelled || cancelled.Runs["cancelled"] != 3 || cancelled.EndTime == nil {
+		t.Errorf("cancel: expected a cancelled backfill with its runs cancelled, got %+v", cancelled)
+	}
+
+	errResp := &ErrorResponse{}
+	if status := s.do("POST", "/backfills/1/cancel", nil, errResp); status != http.StatusConflict {
+		t.Errorf("cancel again: expected status 409, got %d", status)
+	}
+	if status := s.do("GET", "/backfills/2", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("get unknown: expected status 404, got %d", status)
+	}
+	if status := s.do("POST", "/jobs/1/backfill", &BackfillInput{}, errResp); status != http.StatusUnprocessableEntity {
+		t.Errorf("create without dates: expected status 422, got %d", status)
+	}
+	if status := s.do("POST", "/jobs/2/backfill", in, errResp); status != http.StatusNotFound {
+		t.Errorf("create for unknown job: expected status 404, got %d", status)
+	}
+}
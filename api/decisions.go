@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+// TriggerDecision is the JSON representation of a pipeline.TriggerDecision
+type TriggerDecision struct {
+	RunID     pipeline.RunID     `json:"run_id"`
+	JobID     pipeline.JobID     `json:"job_id"`
+	Condition pipeline.Condition `json:"condition"`
+	Triggered bool               `json:"triggered"`
+	Error     string             `json:"error,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// decisions serves GET /runs/{id}/decisions, the trigger conditions evaluated
+// when the run completed
+func (s *Server) decisions(w http.ResponseWriter, id pipeline.RunID) {
+	if _, err := s.getRun(id); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	decisions, err := s.repo.GetTriggerDecisions(&pipeline.GetTriggerDecisionsInput{RunIDs: pipeline.RunIDs{id}})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	resp := make([]*TriggerDecision, len(decisions))
+	for i, d := range decisions {
+		resp[i] = &TriggerDecision{
+			RunID:     d.RunID,
+			JobID:     d.JobID,
+			Condition: d.Condition,
+			Triggered: d.Triggered,
+			Error:     d.Error,
+			CreatedAt: d.CreatedAt,
+		}
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
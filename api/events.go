@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/robstrong/pipeline"
+)
+
+// Event is the JSON representation of a pipeline.Event
+type Event struct {
+	ID        pipeline.EventID   `json:"id"`
+	Type      pipeline.EventType `json:"type"`
+	Time      time.Time          `json:"time"`
+	JobID     pipeline.JobID     `json:"job_id"`
+	RunID     pipeline.RunID     `json:"run_id"`
+	Attempt   int                `json:"attempt"`
+	Detail    string             `json:"detail,omitempty"`
+	NextJobID pipeline.JobID     `json:"next_job_id,omitempty"`
+	NextRunID pipeline.RunID     `json:"next_run_id,omitempty"`
+}
+
+func newEvent(e *pipeline.Event) *Event {
+	return &Event{
+		ID:        e.ID,
+		Type:      e.Type,
+		Time:      e.Time,
+		JobID:     e.JobID,
+		RunID:     e.RunID,
+		Attempt:   e.Attempt,
+		Detail:    e.Detail,
+		NextJobID: e.NextJobID,
+		NextRunID: e.NextRunID,
+	}
+}
+
+// eventsHeartbeat is the interval of the comments or pings sent on idle
+// streams so proxies keep them open
+var eventsHeartbeat = 15 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// handleEvents serves /events as server-sent events
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	sub, ok := s.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, pipeline.Err("streaming not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				//dropped, the client reconnects with Last-Event-ID
+				return
+			}
+			data, err := json.Marshal(newEvent(e))
+			if err != nil {
+				s.log.Printf("err encoding event: %s", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// handleEventsWebSocket serves /events/ws, sending each event as a JSON text
+// message
+func (s *Server) handleEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	sub, ok := s.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//the upgrader has already replied
+		return
+	}
+	defer conn.Close()
+
+	//the client doesn't send anything, read to handle pongs and closes
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, sub.Err().Error())
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(newEvent(e)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// subscribe subscribes to the events selected by the job_id and type query
+// parameters, resuming after the Last-Event-ID header or last_event_id
+// parameter if set. On failure the error has been written to w.
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request) (*pipeline.Subscription, bool) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return nil, false
+	}
+	if s.Events == nil {
+		s.writeError(w, errNotFound)
+		return nil, false
+	}
+	q := r.URL.Query()
+	p := &queryParser{q: q}
+	f := pipeline.EventFilter{
+		JobIDs: p.jobIDs("job_id"),
+		Types:  p.eventTypes("type"),
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = q.Get("last_event_id")
+	}
+	var after uint64
+	if last != "" {
+		var err error
+		if after, err = strconv.ParseUint(last, 10, 64); err != nil {
+			p.fail("last_event_id")
+		}
+	}
+	if p.err != nil {
+		s.writeError(w, p.err)
+		return nil, false
+	}
+	sub, err := s.Events.Subscribe(f, pipeline.EventID(after))
+	if err != nil {
+		s.writeError(w, err)
+		return nil, false
+	}
+	return sub, true
+}
+
+func (p *queryParser) eventTypes(name string) []pipeline.EventType {
+	var types []pipeline.EventType
+	for _, v := range p.list(name) {
+		t := pipeline.EventType(v)
+		if !t.Valid() {
+			p.fail(name)
+			return nil
+		}
+		types = append(types, t)
+	}
+	return types
+}
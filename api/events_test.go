@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/robstrong/pipeline"
+)
+
+func TestEventsSSE(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	bus := pipeline.NewEventBus(10)
+	s.api.Events = bus
+
+	bus.Publish(pipeline.Event{Type: pipeline.EventRunClaimed, JobID: 1, RunID: 1})
+	bus.Publish(pipeline.Event{Type: pipeline.EventRunStarted, JobID: 1, RunID: 1})
+	bus.Publish(pipeline.Event{Type: pipeline.EventRunStarted, JobID: 2, RunID: 2})
+
+	req, err := http.NewRequest("GET", s.URL+"/events?job_id=1&type=started,succeeded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("expected 200 text/event-stream, got %d %s", resp.StatusCode, ct)
+	}
+	bus.Publish(pipeline.Event{Type: pipeline.EventRunSucceeded, JobID: 1, RunID: 1})
+
+	body := bufio.NewReader(resp.Body)
+	for _, expected := range []struct {
+		id  string
+		typ pipeline.EventType
+	}{
+		{"2", pipeline.EventRunStarted},
+		{"4", pipeline.EventRunSucceeded},
+	} {
+		lines := make([]string, 4)
+		for i := range lines {
+			if lines[i], err = body.ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if lines[0] != "id: "+expected.id+"\n" || lines[1] != "event: "+string(expected.typ)+"\n" {
+			t.Errorf("expected event %s %s, got %q", expected.id, expected.typ, lines)
+		}
+		e := &Event{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), e); err != nil {
+			t.Fatal(err)
+		}
+		if e.JobID != 1 || e.Type != expected.typ {
+			t.Errorf("expected data of event %s, got %+v", expected.id, e)
+		}
+	}
+
+	errResp := &ErrorResponse{}
+	if status := s.do("GET", "/events?type=done", nil, errResp); status != http.StatusBadRequest {
+		t.Errorf("invalid type: expected 400, got %d", status)
+	}
+	for i := 0; i < 10; i++ {
+		bus.Publish(pipeline.Event{Type: pipeline.EventRunCreated, JobID: 3})
+	}
+	if status := s.do("GET", "/events?last_event_id=1", nil, errResp); status != http.StatusGone {
+		t.Errorf("discarded last event: expected 410, got %d", status)
+	}
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	bus := pipeline.NewEventBus(10)
+	s.api.Events = bus
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/events/ws?job_id=2"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status 101, got %d", resp.StatusCode)
+	}
+
+	bus.Publish(pipeline.Event{Type: pipeline.EventRunCreated, JobID: 1, RunID: 1})
+	bus.Publish(pipeline.Event{Type: pipeline.EventRunTriggeredDownstream, JobID: 2, RunID: 2, NextJobID: 3, NextRunID: 4})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := &Event{}
+	if err := conn.ReadJSON(got); err != nil {
+		t.Fatal(err)
+	}
+	got.Time = time.Time{}
+	expected := &Event{ID: 2, Type: pipeline.EventRunTriggeredDownstream, JobID: 2, RunID: 2, NextJobID: 3, NextRunID: 4}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+// Execution is the JSON representation of a pipeline.Execution
+type Execution struct {
+	ID        pipeline.RunID           `json:"id"` //the root run
+	JobID     pipeline.JobID           `json:"job_id"`
+	Status    pipeline.ExecutionStatus `json:"status"`
+	StartTime *time.Time               `json:"start_time"`
+	EndTime   *time.Time               `json:"end_time"`
+	Duration  Duration                 `json:"duration"` //so far while running
+	Runs      int                      `json:"runs"`
+	Succeeded int                      `json:"succeeded"`
+	Failed    int                      `json:"failed"`
+	Cancelled int                      `json:"cancelled"`
+	RunList   []*Run                   `json:"run_list,omitempty"` //set by GET /executions/{id}
+}
+
+type ExecutionList struct {
+	Executions []*Execution    `json:"executions"`
+	NextBefore *pipeline.RunID `json:"next_before"` //before parameter of the following page, null on the last page
+}
+
+func newExecution(e *pipeline.Execution, now time.Time) *Execution {
+	return &Execution{
+		ID:        e.ID,
+		JobID:     e.JobID,
+		Status:    e.Status,
+		StartTime: e.StartTime,
+		EndTime:   e.EndTime,
+		Duration:  Duration(e.Duration(now)),
+		Runs:      e.Runs,
+		Succeeded: e.Succeeded,
+		Failed:    e.Failed,
+		Cancelled: e.Cancelled,
+	}
+}
+
+// handleExecutions serves /executions?job_id=1,2&limit=50&before=...
+func (s *Server) handleExecutions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	p := &queryParser{q: r.URL.Query()}
+	in := &pipeline.GetExecutionsInput{
+		JobIDs: p.jobIDs("job_id"),
+		Limit:  p.uint64("limit"),
+	}
+	if before := p.runIDs("before"); len(before) == 1 {
+		in.Before = &before[0]
+	} else if len(before) > 1 {
+		p.fail("before")
+	}
+	if p.err != nil {
+		s.writeError(w, p.err)
+		return
+	}
+	executions, err := s.repo.GetExecutions(in)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	now := s.now()
+	resp := &ExecutionList{Executions: make([]*Execution, len(executions))}
+	for i, e := range executions {
+		resp.Executions[i] = newExecution(e, now)
+	}
+	if in.Limit != nil && len(executions) > 0 && uint64(len(executions)) == *in.Limit {
+		last := executions[len(executions)-1].ID
+		resp.NextBefore = &last
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleExecution serves /executions/{id}, the execution with its runs
+func (s *Server) handleExecution(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/executions/")
+	if len(parts) != 1 {
+		s.writeError(w, errNotFound)
+		return
+	}
+	id, err := parseID(parts[0])
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	executionID := pipeline.RunID(id)
+	executions, err := s.repo.GetExecutions(&pipeline.GetExecutionsInput{ExecutionIDs: pipeline.RunIDs{executionID}})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if len(executions) != 1 {
+		s.writeError(w, pipeline.ErrExecutionNotFound)
+		return
+	}
+	orderBy := pipeline.RunsOrderByID
+	runs, err := s.repo.GetRuns(&pipeline.GetRunsInput{RootRunID: &executionID, OrderBy: &orderBy, Summary: true})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	resp := newExecution(executions[0], s.now())
+	resp.RunList = make([]*Run, len(runs))
+	for i, run := range runs {
+		resp.RunList[i] = newRun(run, false)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+func TestExecutions(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	start := time.Date(2017, 3, 1, 2, 0, 0, 0, time.UTC)
+	s.api.now = func() time.Time { return start.Add(10 * time.Minute) }
+	create := func(job pipeline.JobID, parent, root pipeline.RunID) pipeline.RunID {
+		id, err := s.repo.CreateRun(&pipeline.CreateRunInput{
+			JobID:              job,
+			ProcessorConfig:    pipeline.ProcessorConfig{Type: "lambda"},
+			ScheduledStartTime: start,
+			ParentRunID:        parent,
+			RootRunID:          root,
+			Input:              []byte("{}"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	root := create(1, 0, 0)
+	end := start.Add(time.Minute)
+	err := s.repo.UpdateRun(&pipeline.UpdateRunInput{
+		RunID:     root,
+		Status:    pipeline.RunStatusPtr(pipeline.RunStatusComplete),
+		Success:   pipeline.BoolPtr(true),
+		StartTime: &start,
+		EndTime:   &end,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(2, root, root)
+	create(1, 0, 0)
+
+	list := &ExecutionList{}
+	if status := s.do("GET", "/executions?job_id=1&limit=1", nil, list); status != http.StatusOK {
+		t.Fatalf("list: expected status 200, got %d", status)
+	}
+	if len(list.Executions) != 1 || list.Executions[0].ID != 3 || list.NextBefore == nil || *list.NextBefore != 3 {
+		t.Fatalf("list: expected execution 3 and a next page, got %+v", list)
+	}
+	list = &ExecutionList{}
+	if status := s.do("GET", "/executions?job_id=1&limit=1&before=3", nil, list); status != http.StatusOK {
+		t.Fatalf("second page: expected status 200, got %d", status)
+	}
+	if len(list.Executions) != 1 || list.Executions[0].ID != root {
+		t.Fatalf("second page: expected execution %d, got %+v", root, list)
+	}
+
+	got := &Execution{}
+	if status := s.do("GET", "/executions/1", nil, got); status != http.StatusOK {
+		t.Fatalf("get: expected status 200, got %d", status)
+	}
+	if got.Status != pipeline.ExecutionStatusRunning || got.Runs != 2 || got.Succeeded != 1 {
+		t.Errorf("get: expected a running execution of 2 runs, got %+v", got)
+	}
+	if got.Duration != Duration(10*time.Minute) {
+		t.Errorf("get: expected a duration of 10m so far, got %s", time.Duration(got.Duration))
+	}
+	if len(got.RunList) != 2 || got.RunList[1].ExecutionID != root || got.RunList[0].ExecutionID != root {
+		t.Errorf("get: expected both runs with execution_id %d, got %+v", root, got.RunList)
+	}
+
+	errResp := &ErrorResponse{}
+	if status := s.do("GET", "/executions/2", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("get a run that isn't a root: expected status 404, got %d", status)
+	}
+	if status := s.do("POST", "/executions/1", nil, errResp); status != http.StatusMethodNotAllowed {
+		t.Errorf("post: expected status 405, got %d", status)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/robstrong/pipeline"
+)
+
+// Graph is the JSON representation of a pipeline.JobGraph
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	JobID   pipeline.JobID `json:"job_id"`
+	Name    string         `json:"name"`
+	Paused  bool           `json:"paused"`
+	LastRun *GraphRun      `json:"last_run,omitempty"`
+}
+
+type GraphRun struct {
+	ID    pipeline.RunID `json:"id"`
+	State string         `json:"state"` //run status, with complete runs as succeeded or failed
+}
+
+type GraphEdge struct {
+	From      pipeline.JobID     `json:"from"`
+	To        pipeline.JobID     `json:"to"`
+	Type      pipeline.EdgeType  `json:"type"`
+	Condition pipeline.Condition `json:"condition,omitempty"`
+	Delay     Duration           `json:"delay,omitempty"`
+}
+
+func newGraph(g *pipeline.JobGraph) *Graph {
+	graph := &Graph{
+		Nodes: make([]*GraphNode, len(g.Nodes)),
+		Edges: make([]*GraphEdge, len(g.Edges)),
+	}
+	for i, n := range g.Nodes {
+		graph.Nodes[i] = &GraphNode{JobID: n.Job.ID, Name: n.Job.Name, Paused: n.Job.Paused}
+		if n.LastRun != nil {
+			graph.Nodes[i].LastRun = &GraphRun{ID: n.LastRun.RunID, State: pipeline.RunState(n.LastRun)}
+		}
+	}
+	for i, e := range g.Edges {
+		graph.Edges[i] = &GraphEdge{From: e.From, To: e.To, Type: e.Type, Condition: e.Condition, Delay: Duration(e.Delay)}
+	}
+	return graph
+}
+
+// handleGraph serves /graph?format=json|dot|mermaid&status=true, status
+// annotates each job with its latest run
+func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	p := &queryParser{q: r.URL.Query()}
+	withStatus := p.bool("status")
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json", "dot", "mermaid":
+	default:
+		p.fail("format")
+	}
+	if p.err != nil {
+		s.writeError(w, p.err)
+		return
+	}
+	g, err := pipeline.BuildJobGraph(s.repo, withStatus != nil && *withStatus)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	switch format {
+	case "dot":
+		s.writeText(w, "text/vnd.graphviz", g.DOT())
+	case "mermaid":
+		s.writeText(w, "text/plain; charset=utf-8", g.Mermaid())
+	default:
+		s.writeJSON(w, http.StatusOK, newGraph(g))
+	}
+}
+
+func (s *Server) writeText(w http.ResponseWriter, contentType, text string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, text); err != nil {
+		s.log.Printf("err writing response: %s", err)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+func TestGraph(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	extract, err := s.repo.CreateJob(&pipeline.CreateJobInput{Name: "extract", Processor: pipeline.ProcessorConfig{Type: "lambda"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.repo.CreateJob(&pipeline.CreateJobInput{
+		Name:      "load",
+		Processor: pipeline.ProcessorConfig{Type: "lambda"},
+		Triggers:  &pipeline.TriggerEventsInput{JobFailure: pipeline.JobIDs{extract}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.repo.CreateRun(&pipeline.CreateRunInput{
+		JobID:              extract,
+		ProcessorConfig:    pipeline.ProcessorConfig{Type: "lambda"},
+		ScheduledStartTime: time.Now(),
+		Input:              []byte("{}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &Graph{}
+	if status := s.do("GET", "/graph?status=true", nil, g); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	expected := &Graph{
+		Nodes: []*GraphNode{
+			{JobID: 1, Name: "extract", LastRun: &GraphRun{ID: 1, State: "pending"}},
+			{JobID: 2, Name: "load"},
+		},
+		Edges: []*GraphEdge{{From: 1, To: 2, Type: pipeline.EdgeTypeFailure}},
+	}
+	if !reflect.DeepEqual(g, expected) {
+		t.Errorf("expected %+v, got %+v", expected, g)
+	}
+
+	for format, want := range map[string]string{
+		"dot":     "job1 -> job2",
+		"mermaid": "job1 -.->|failure| job2",
+	} {
+		resp, err := http.Get(s.URL + "/graph?format=" + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+			t.Errorf("%s: got %d\n%s", format, resp.StatusCode, body)
+		}
+	}
+	if status := s.do("GET", "/graph?format=png", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("unknown format: expected status 400, got %d", status)
+	}
+}
@@ -0,0 +1,440 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+// Job is the JSON representation of a pipeline.Job
+type Job struct {
+	ID                   pipeline.JobID        `json:"id"`
+	VersionID            pipeline.JobVersionID `json:"version_id"`
+	Name                 string                `json:"name"`
+	Processor            Config                `json:"processor"`
+	InputPayloadTemplate string                `json:"input_payload_template"`
+	Retryer              Config                `json:"retryer"`
+	Triggers             Triggers              `json:"triggers"`
+	Paused               bool                  `json:"paused"`
+	LoopLimit            int                   `json:"loop_limit"`
+	Sensor               *Sensor               `json:"sensor,omitempty"` //nil unless the job is a sensor
+	NextFireTimes        []time.Time           `json:"next_fire_times"`  //empty if paused
+}
+
+// nextFireTimes is the number of upcoming cron times listed for a job
+const nextFireTimes = 5
+
+type Config struct {
+	Type   string            `json:"type"`
+	Config map[string]string `json:"config,omitempty"`
+}
+
+type Triggers struct {
+	CronSchedule         string          `json:"cron_schedule"`
+	JobSuccess           pipeline.JobIDs `json:"job_success"`
+	JobFailure           pipeline.JobIDs `json:"job_failure"`
+	JoinSuccess          bool            `json:"join_success"`
+	JoinTimeout          Duration        `json:"join_timeout"`
+	JobSuccessConditions Conditions      `json:"job_success_conditions,omitempty"`
+	JobFailureConditions Conditions      `json:"job_failure_conditions,omitempty"`
+	JobSuccessDelays     Delays          `json:"job_success_delays,omitempty"`
+	JobFailureDelays     Delays          `json:"job_failure_delays,omitempty"`
+	MapPath              string          `json:"map_path"`
+	MapMaxParallel       int             `json:"map_max_parallel"`
+	JobMapComplete       pipeline.JobIDs `json:"job_map_complete"`
+	FileWatch            *FileWatch      `json:"file_watch,omitempty"` //nil unless the job watches a directory
+}
+
+// FileWatch is the JSON representation of a pipeline.FileWatch
+type FileWatch struct {
+	Dir       string   `json:"dir"` //empty on update stops watching
+	Pattern   string   `json:"pattern"`
+	Recursive bool     `json:"recursive"`
+	StableFor Duration `json:"stable_for"`
+	Debounce  Duration `json:"debounce"`
+}
+
+func newFileWatch(w pipeline.FileWatch) *FileWatch {
+	if !w.Enabled() {
+		return nil
+	}
+	return &FileWatch{
+		Dir:       w.Dir,
+		Pattern:   w.Pattern,
+		Recursive: w.Recursive,
+		StableFor: Duration(w.StableFor),
+		Debounce:  Duration(w.Debounce),
+	}
+}
+
+func (w *FileWatch) fileWatch() pipeline.FileWatch {
+	return pipeline.FileWatch{
+		Dir:       w.Dir,
+		Pattern:   w.Pattern,
+		Recursive: w.Recursive,
+		StableFor: time.Duration(w.StableFor),
+		Debounce:  time.Duration(w.Debounce),
+	}
+}
+
+// Sensor is the JSON representation of a pipeline.Sensor
+type Sensor struct {
+	ReadyIf  pipeline.Condition `json:"ready_if"` //empty on update makes the job a plain job again
+	Interval Duration           `json:"interval"`
+	Timeout  Duration           `json:"timeout"`
+}
+
+func newSensor(s pipeline.Sensor) *Sensor {
+	if !s.Enabled() {
+		return nil
+	}
+	return &Sensor{ReadyIf: s.ReadyIf, Interval: Duration(s.Interval), Timeout: Duration(s.Timeout)}
+}
+
+func (s *Sensor) sensor() pipeline.Sensor {
+	return pipeline.Sensor{ReadyIf: s.ReadyIf, Interval: time.Duration(s.Interval), Timeout: time.Duration(s.Timeout)}
+}
+
+// Conditions are trigger conditions keyed by upstream job id, see
+// pipeline.Condition
+type Conditions map[pipeline.JobID]pipeline.Condition
+
+// Delays are trigger delays keyed by upstream job id
+type Delays map[pipeline.JobID]Duration
+
+func newDelays(delays map[pipeline.JobID]time.Duration) Delays {
+	if delays == nil {
+		return nil
+	}
+	d := Delays{}
+	for id, delay := range delays {
+		d[id] = Duration(delay)
+	}
+	return d
+}
+
+func (d Delays) durations() map[pipeline.JobID]time.Duration {
+	if d == nil {
+		return nil
+	}
+	durations := map[pipeline.JobID]time.Duration{}
+	for id, delay := range d {
+		durations[id] = time.Duration(delay)
+	}
+	return durations
+}
+
+// Duration is a time.Duration written as a string such as "1h30m", the
+// zero duration is ""
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if d == 0 {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func newJob(j *pipeline.Job, now time.Time) *Job {
+	next := []time.Time{}
+	if !j.Paused {
+		//an invalid schedule never fires
+		if times, err := j.Triggers.CronSchedule.Next(now, nextFireTimes); err == nil && times != nil {
+			next = times
+		}
+	}
+	return &Job{
+		ID:                   j.ID,
+		VersionID:            j.VersionID,
+		Name:                 j.Name,
+		Processor:            Config(j.ProcessorConfig),
+		InputPayloadTemplate: string(j.InputPayloadTemplate),
+		Retryer:              Config(j.RetryerConfig),
+		Triggers: Triggers{
+			CronSchedule:         string(j.Triggers.CronSchedule),
+			JobSuccess:           nonNilIDs(j.Triggers.JobSuccess),
+			JobFailure:           nonNilIDs(j.Triggers.JobFailure),
+			JoinSuccess:          j.Triggers.JoinSuccess,
+			JoinTimeout:          Duration(j.Triggers.JoinTimeout),
+			JobSuccessConditions: Conditions(j.Triggers.JobSuccessConditions),
+			JobFailureConditions: Conditions(j.Triggers.JobFailureConditions),
+			JobSuccessDelays:     newDelays(j.Triggers.JobSuccessDelays),
+			JobFailureDelays:     newDelays(j.Triggers.JobFailureDelays),
+			MapPath:              string(j.Triggers.MapPath),
+			MapMaxParallel:       j.Triggers.MapMaxParallel,
+			JobMapComplete:       nonNilIDs(j.Triggers.JobMapComplete),
+			FileWatch:            newFileWatch(j.Triggers.FileWatch),
+		},
+		Paused:        j.Paused,
+		LoopLimit:     j.LoopLimit,
+		Sensor:        newSensor(j.Sensor),
+		NextFireTimes: next,
+	}
+}
+
+func nonNilIDs(ids pipeline.JobIDs) pipeline.JobIDs {
+	if ids == nil {
+		return pipeline.JobIDs{}
+	}
+	return ids
+}
+
+// JobInput is the body of create and update requests. On update, only the
+// fields that are set are changed.
+type JobInput struct {
+	Name                 *string        `json:"name"`
+	Processor            *Config        `json:"processor"`
+	InputPayloadTemplate *string        `json:"input_payload_template"`
+	Retryer              *Config        `json:"retryer"`
+	Triggers             *TriggersInput `json:"triggers"`
+	LoopLimit            *int           `json:"loop_limit"` //allows the job in a trigger cycle
+	Sensor               *Sensor        `json:"sensor"`
+}
+
+type TriggersInput struct {
+	CronSchedule *string         `json:"cron_schedule"`
+	JobSuccess   pipeline.JobIDs `json:"job_success"`
+	JobFailure   pipeline.JobIDs `json:"job_failure"`
+	JoinSuccess  *bool           `json:"join_success"`
+	JoinTimeout  *Duration       `json:"join_timeout"`
+	//replaced along with job_success and job_failure, delays are durations
+	//such as "30m"
+	JobSuccessConditions Conditions      `json:"job_success_conditions"`
+	JobFailureConditions Conditions      `json:"job_failure_conditions"`
+	JobSuccessDelays     Delays          `json:"job_success_delays"`
+	JobFailureDelays     Delays          `json:"job_failure_delays"`
+	MapPath              *string         `json:"map_path"`
+	MapMaxParallel       *int            `json:"map_max_parallel"`
+	JobMapComplete       pipeline.JobIDs `json:"job_map_complete"`
+	FileWatch            *FileWatch      `json:"file_watch"`
+}
+
+func (in *JobInput) createJobInput() *pipeline.CreateJobInput {
+	c := &pipeline.CreateJobInput{
+		Triggers: in.Triggers.triggerEventsInput(),
+	}
+	if in.Name != nil {
+		c.Name = *in.Name
+	}
+	if in.Processor != nil {
+		c.Processor = pipeline.ProcessorConfig(*in.Processor)
+	}
+	if in.InputPayloadTemplate != nil {
+		c.InputPayloadTemplate = []byte(*in.InputPayloadTemplate)
+	}
+	if in.Retryer != nil {
+		c.Retryer = pipeline.RetryerConfig(*in.Retryer)
+	}
+	if in.LoopLimit != nil {
+		c.LoopLimit = *in.LoopLimit
+	}
+	if in.Sensor != nil {
+		c.Sensor = in.Sensor.sensor()
+	}
+	return c
+}
+
+func (in *JobInput) updateJobInput(id pipeline.JobID) *pipeline.UpdateJobInput {
+	u := &pipeline.UpdateJobInput{
+		JobID:     id,
+		Name:      in.Name,
+		Triggers:  in.Triggers.triggerEventsInput(),
+		LoopLimit: in.LoopLimit,
+	}
+	if in.Processor != nil {
+		p := pipeline.ProcessorConfig(*in.Processor)
+		u.Processor = &p
+	}
+	if in.InputPayloadTemplate != nil {
+		u.InputPayloadTemplate = []byte(*in.InputPayloadTemplate)
+	}
+	if in.Retryer != nil {
+		r := pipeline.RetryerConfig(*in.Retryer)
+		u.Retryer = &r
+	}
+	if in.Sensor != nil {
+		s := in.Sensor.sensor()
+		u.Sensor = &s
+	}
+	return u
+}
+
+func (t *TriggersInput) triggerEventsInput() *pipeline.TriggerEventsInput {
+	if t == nil {
+		return nil
+	}
+	in := &pipeline.TriggerEventsInput{
+		JobSuccess:           t.JobSuccess,
+		JobFailure:           t.JobFailure,
+		JoinSuccess:          t.JoinSuccess,
+		JobSuccessConditions: t.JobSuccessConditions,
+		JobFailureConditions: t.JobFailureConditions,
+		JobSuccessDelays:     t.JobSuccessDelays.durations(),
+		JobFailureDelays:     t.JobFailureDelays.durations(),
+		MapMaxParallel:       t.MapMaxParallel,
+		JobMapComplete:       t.JobMapComplete,
+	}
+	if t.MapPath != nil {
+		p := pipeline.OutputPath(*t.MapPath)
+		in.MapPath = &p
+	}
+	if t.FileWatch != nil {
+		w := t.FileWatch.fileWatch()
+		in.FileWatch = &w
+	}
+	if t.JoinTimeout != nil {
+		d := time.Duration(*t.JoinTimeout)
+		in.JoinTimeout = &d
+	}
+	if t.CronSchedule != nil {
+		in.CronSchedule = pipeline.NewCronSchedule(*t.CronSchedule)
+	}
+	return in
+}
+
+// handleJobs serves /jobs
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jobs, err := s.repo.GetJobs(&pipeline.GetJobsInput{All: true})
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		resp := make([]*Job, len(jobs))
+		for i, j := range jobs {
+			resp[i] = newJob(j, s.now())
+		}
+		s.writeJSON(w, http.StatusOK, resp)
+	case http.MethodPost:
+		in := &JobInput{}
+		if err := readJSON(r, in); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		id, err := s.repo.CreateJob(in.createJobInput())
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeJob(w, http.StatusCreated, id)
+	default:
+		s.writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleJob serves /jobs/{id}, /jobs/{id}/run, /jobs/{id}/pause,
+// /jobs/{id}/resume and /jobs/{id}/backfill
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/jobs/")
+	if len(parts) == 0 || len(parts) > 2 {
+		s.writeError(w, errNotFound)
+		return
+	}
+	id, err := parseID(parts[0])
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	jobID := pipeline.JobID(id)
+	if len(parts) == 2 && parts[1] == "webhook" {
+		s.webhook(w, r, jobID)
+		return
+	}
+	if len(parts) == 2 {
+		switch {
+		case parts[1] != "run" && parts[1] != "pause" && parts[1] != "resume" && parts[1] != "backfill":
+			s.writeError(w, errNotFound)
+		case r.Method != http.MethodPost:
+			s.writeError(w, errMethodNotAllowed)
+		case parts[1] == "run":
+			s.trigger(w, r, jobID)
+		case parts[1] == "backfill":
+			s.backfill(w, r, jobID)
+		default:
+			s.setPaused(w, jobID, parts[1] == "pause")
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJob(w, http.StatusOK, jobID)
+	case http.MethodPut, http.MethodPatch:
+		in := &JobInput{}
+		if err := readJSON(r, in); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if _, err := s.getJob(jobID); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if err := s.repo.UpdateJob(in.updateJobInput(jobID)); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeJob(w, http.StatusOK, jobID)
+	case http.MethodDelete:
+		if err := s.repo.DeleteJob(&pipeline.DeleteJobInput{JobID: jobID}); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.writeError(w, errMethodNotAllowed)
+	}
+}
+
+// setPaused serves POST /jobs/{id}/pause and /jobs/{id}/resume. Pending runs
+// of a paused job are held until it is resumed.
+func (s *Server) setPaused(w http.ResponseWriter, id pipeline.JobID, paused bool) {
+	if _, err := s.getJob(id); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if err := s.repo.UpdateJob(&pipeline.UpdateJobInput{JobID: id, Paused: &paused}); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJob(w, http.StatusOK, id)
+}
+
+func (s *Server) getJob(id pipeline.JobID) (*pipeline.Job, error) {
+	jobs, err := s.repo.GetJobs(&pipeline.GetJobsInput{JobIDs: pipeline.JobIDs{id}})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, pipeline.ErrJobNotFound
+	}
+	return jobs[0], nil
+}
+
+func (s *Server) writeJob(w http.ResponseWriter, status int, id pipeline.JobID) {
+	j, err := s.getJob(id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, status, newJob(j, s.now()))
+}
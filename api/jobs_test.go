@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/robstrong/pipeline"
+)
+
+type testServer struct {
+	*httptest.Server
+	api  *Server
+	repo *pipeline.SQLiteRepo
+	t    *testing.T
+	dir  string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := pipeline.NewSQLiteRepo(db)
+	if err := repo.MigrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	api := NewServer(repo)
+	return &testServer{
+		Server: httptest.NewServer(api),
+		api:    api,
+		repo:   repo,
+		t:      t,
+		dir:    dir,
+	}
+}
+
+func (s *testServer) Close() {
+	s.Server.Close()
+	s.repo.DB.Close()
+	os.RemoveAll(s.dir)
+}
+
+// do sends body as JSON and decodes the response into out, returning the
+// status code
+func (s *testServer) do(method, path string, body, out interface{}) int {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, s.URL+path, bytes.NewReader(reqBody))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			s.t.Fatalf("%s %s: err decoding response: %s", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestJobsCRUD(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.api.now = func() time.Time { return time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC) }
+
+	created := &Job{}
+	status := s.do("POST", "/jobs", map[string]interface{}{
+		"name":                   "extract",
+		"processor":              map[string]interface{}{"type": "lambda", "config": map[string]string{"FunctionName": "extract"}},
+		"input_payload_template": `{"day":"{{.day}}"}`,
+		"triggers":               map[string]interface{}{"cron_schedule": "0 2 * * *"},
+	}, created)
+	if status != http.StatusCreated {
+		t.Fatalf("create: expected status 201, got %d", status)
+	}
+	expected := &Job{
+		ID:                   1,
+		VersionID:            1,
+		Name:                 "extract",
+		Processor:            Config{Type: "lambda", Config: map[string]string{"FunctionName": "extract"}},
+		InputPayloadTemplate: `{"day":"{{.day}}"}`,
+		Triggers: Triggers{
+			CronSchedule:   "0 2 * * *",
+			JobSuccess:     pipeline.JobIDs{},
+			JobFailure:     pipeline.JobIDs{},
+			JobMapComplete: pipeline.JobIDs{},
+		},
+		NextFireTimes: []time.Time{
+			time.Date(2017, 3, 1, 2, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 2, 2, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 3, 2, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 4, 2, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 5, 2, 0, 0, 0, time.UTC),
+		},
+	}
+	if !reflect.DeepEqual(expected, created) {
+		t.Errorf("create: expected %+v, got %+v", expected, created)
+	}
+
+	errResp := &ErrorResponse{}
+	status = s.do("PATCH", "/jobs/1", map[string]interface{}{
+		"triggers": map[string]interface{}{"job_success": []int{1}},
+	}, errResp)
+	if status != http.StatusUnprocessableEntity || len(errResp.Fields) != 1 ||
+		!strings.HasPrefix(errResp.Fields[0].Message, "trigger cycle extract -> extract") {
+		t.Errorf("self trigger: expected a trigger cycle error, got %d %+v", status, errResp)
+	}
+	status = s.do("PATCH", "/jobs/1", map[string]interface{}{
+		"triggers": map[string]interface{}{"join_timeout": "soon"},
+	}, errResp)
+	if status != http.StatusBadRequest {
+		t.Errorf("invalid join timeout: expected status 400, got %d", status)
+	}
+	status = s.do("PATCH", "/jobs/1", map[string]interface{}{
+		"triggers": map[string]interface{}{"job_failure": []int{7}},
+	}, errResp)
+	if status != http.StatusUnprocessableEntity || errResp.Fields[0].Field != "Triggers.JobFailure" {
+		t.Errorf("unknown trigger: expected a field error, got %d %+v", status, errResp)
+	}
+
+	updated := &Job{}
+	status = s.do("PATCH", "/jobs/1", map[string]interface{}{
+		"name": "extract-v2",
+		"triggers": map[string]interface{}{
+			"job_success":            []int{1},
+			"job_success_conditions": map[string]string{"1": "$.more"},
+			"join_success":           true,
+			"join_timeout":           "30m",
+			"file_watch":             map[string]interface{}{"dir": "/data/in", "pattern": "*.csv", "stable_for": "30s"},
+		},
+		"loop_limit": 3,
+		"sensor":     map[string]interface{}{"ready_if": "$.exists", "interval": "5m"},
+	}, updated)
+	if status != http.StatusOK {
+		t.Fatalf("update: expected status 200, got %d", status)
+	}
+	expected.Name = "extract-v2"
+	expected.VersionID = 2
+	expected.Triggers.JobSuccess = pipeline.JobIDs{1}
+	expected.Triggers.JobSuccessConditions = Conditions{1: "$.more"}
+	expected.Triggers.JoinSuccess = true
+	expected.Triggers.JoinTimeout = Duration(30 * time.Minute)
+	expected.Triggers.FileWatch = &FileWatch{Dir: "/data/in", Pattern: "*.csv", StableFor: Duration(30 * time.Second)}
+	expected.LoopLimit = 3
+	expected.Sensor = &Sensor{ReadyIf: "$.exists", Interval: Duration(5 * time.Minute)}
+	if !reflect.DeepEqual(expected, updated) {
+		t.Errorf("update: expected %+v, got %+v", expected, updated)
+	}
+
+	got := &Job{}
+	if status := s.do("GET", "/jobs/1", nil, got); status != http.StatusOK {
+		t.Fatalf("get: expected status 200, got %d", status)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("get: expected %+v, got %+v", expected, got)
+	}
+
+	paused := &Job{}
+	if status := s.do("POST", "/jobs/1/pause", nil, paused); status != http.StatusOK {
+		t.Fatalf("pause: expected status 200, got %d", status)
+	}
+	if !paused.Paused || len(paused.NextFireTimes) != 0 || paused.VersionID != expected.VersionID {
+		t.Errorf("pause: expected a paused job with no fire times and the same version, got %+v", paused)
+	}
+	if status := s.do("POST", "/jobs/1/resume", nil, got); status != http.StatusOK {
+		t.Fatalf("resume: expected status 200, got %d", status)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("resume: expected %+v, got %+v", expected, got)
+	}
+
+	list := []*Job{}
+	if status := s.do("GET", "/jobs", nil, &list); status != http.StatusOK {
+		t.Fatalf("list: expected status 200, got %d", status)
+	}
+	if len(list) != 1 || !reflect.DeepEqual(expected, list[0]) {
+		t.Errorf("list: expected [%+v], got %+v", expected, list)
+	}
+
+	if status := s.do("DELETE", "/jobs/1", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("delete: expected status 204, got %d", status)
+	}
+	if status := s.do("GET", "/jobs/1", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("get deleted: expected status 404, got %d", status)
+	}
+	if status := s.do("DELETE", "/jobs/1", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("delete deleted: expected status 404, got %d", status)
+	}
+}
+
+func TestJobsErrors(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     interface{}
+		status   int
+		expected *ErrorResponse
+	}{
+		{
+			name:   "missing name",
+			method: "POST",
+			path:   "/jobs",
+			body:   map[string]interface{}{"processor": map[string]string{"type": "lambda"}},
+			status: http.StatusUnprocessableEntity,
+			expected: &ErrorResponse{
+				Error:  "validation failed",
+				Fields: []FieldError{{Field: "Name", Message: "is required"}},
+			},
+		},
+		{
+			name:     "unknown field",
+			method:   "POST",
+			path:     "/jobs",
+			body:     map[string]interface{}{"nmae": "typo"},
+			status:   http.StatusBadRequest,
+			expected: &ErrorResponse{Error: `invalid request body: json: unknown field "nmae"`},
+		},
+		{
+			name:     "unknown job",
+			method:   "GET",
+			path:     "/jobs/42",
+			status:   http.StatusNotFound,
+			expected: &ErrorResponse{Error: "job not found"},
+		},
+		{
+			name:     "invalid id",
+			method:   "GET",
+			path:     "/jobs/abc",
+			status:   http.StatusNotFound,
+			expected: &ErrorResponse{Error: "not found"},
+		},
+		{
+			name:     "wrong method",
+			method:   "PUT",
+			path:     "/jobs",
+			status:   http.StatusMethodNotAllowed,
+			expected: &ErrorResponse{Error: "method not allowed"},
+		},
+	}
+	for _, test := range tests {
+		got := &ErrorResponse{}
+		status := s.do(test.method, test.path, test.body, got)
+		if status != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, status)
+		}
+		if !reflect.DeepEqual(test.expected, got) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestUI(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Request.URL.Path != "/ui/" || !bytes.Contains(body, []byte(`<script src="app.js">`)) {
+		t.Errorf("expected / to redirect to the dashboard, got %s", resp.Request.URL)
+	}
+	for _, asset := range []string{"/ui/app.js", "/ui/style.css"} {
+		if status := s.do("GET", asset, nil, nil); status != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", asset, status)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+// Run is the JSON representation of a pipeline.Run. Input, Output and Log are
+// left out of run lists.
+type Run struct {
+	ID                 pipeline.RunID        `json:"id"`
+	JobID              pipeline.JobID        `json:"job_id"`
+	JobVersionID       pipeline.JobVersionID `json:"job_version_id"`
+	Processor          Config                `json:"processor"`
+	Status             pipeline.RunStatus    `json:"status"`
+	StatusDetail       string                `json:"status_detail"`
+	ScheduledStartTime time.Time             `json:"scheduled_start_time"`
+	NotBefore          time.Time             `json:"not_before"` //time the run is due, later than scheduled_start_time if it was held back
+	StartTime          *time.Time            `json:"start_time"`
+	EndTime            *time.Time            `json:"end_time"`
+	Attempt            int                   `json:"attempt"`
+	Pokes              int                   `json:"pokes,omitempty"` //set on runs of sensor jobs
+	Success            bool                  `json:"success"`
+	ParentRunID        pipeline.RunID        `json:"parent_run_id,omitempty"` //run that triggered this one
+	RootRunID          pipeline.RunID        `json:"root_run_id,omitempty"`   //first run of the execution
+	ExecutionID        pipeline.RunID        `json:"execution_id"`            //ID of the execution, root_run_id or the run's own ID for a root run
+	MapIndex           *int                  `json:"map_index,omitempty"`     //element of the fan-out, set for mapped runs
+	MapCount           int                   `json:"map_count,omitempty"`
+	BackfillID         pipeline.BackfillID   `json:"backfill_id,omitempty"`
+	Input              *string               `json:"input,omitempty"`
+	Output             *string               `json:"output,omitempty"`
+	Log                *string               `json:"log,omitempty"`
+}
+
+func newRun(r *pipeline.Run, withPayloads bool) *Run {
+	run := &Run{
+		ID:                 r.RunID,
+		JobID:              r.JobID,
+		JobVersionID:       r.JobVersionID,
+		Processor:          Config(r.ProcessorConfig),
+		Status:             r.Status,
+		StatusDetail:       r.StatusDetail,
+		ScheduledStartTime: r.ScheduledStartTime,
+		NotBefore:          r.NotBefore,
+		StartTime:          r.StartTime,
+		EndTime:            r.EndTime,
+		Attempt:            r.Attempt,
+		Pokes:              r.Pokes,
+		Success:            r.Success,
+		ParentRunID:        r.ParentRunID,
+		RootRunID:          r.RootRunID,
+		ExecutionID:        r.Root(),
+		MapCount:           r.MapCount,
+		BackfillID:         r.BackfillID,
+	}
+	if r.MapCount > 0 {
+		i := r.MapIndex
+		run.MapIndex = &i
+	}
+	if withPayloads {
+		in, out, log := string(r.Input), string(r.Output), string(r.Log)
+		run.Input, run.Output, run.Log = &in, &out, &log
+	}
+	return run
+}
+
+type RunList struct {
+	Runs       []*Run               `json:"runs"`
+	NextCursor *pipeline.RunsCursor `json:"next_cursor"`
+}
+
+// TriggerInput is the body of POST /jobs/{id}/run
+type TriggerInput struct {
+	//the input of the run, the job's template is only rendered without it
+	Input json.RawMessage `json:"input"`
+	//{{.Params}} of the job's template
+	Params map[string]interface{} `json:"params"`
+}
+
+// RerunInput is the body of POST /runs/{id}/rerun
+type RerunInput struct {
+	//"original" (the default) reruns with the run's processor config,
+	//"current" with the job's current config
+	ProcessorConfig string `json:"processor_config"`
+}
+
+const (
+	RerunProcessorConfigOriginal = "original"
+	RerunProcessorConfigCurrent  = "current"
+)
+
+// handleRuns serves /runs
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	in, err := getRunsInput(r.URL.Query())
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	runs, err := s.repo.GetRuns(in)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	resp := &RunList{
+		Runs:       make([]*Run, len(runs)),
+		NextCursor: in.NextCursor(runs),
+	}
+	for i, run := range runs {
+		resp.Runs[i] = newRun(run, false)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleRun serves /runs/{id}, /runs/{id}/rerun, /runs/{id}/cancel,
+// /runs/{id}/decisions, /runs/{id}/approval, /runs/{id}/approve and
+// /runs/{id}/reject
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/runs/")
+	if len(parts) == 0 || len(parts) > 2 {
+		s.writeError(w, errNotFound)
+		return
+	}
+	id, err := parseID(parts[0])
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	runID := pipeline.RunID(id)
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		run, err := s.getRun(runID)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, newRun(run, true))
+	case len(parts) == 2 && parts[1] == "rerun" && r.Method == http.MethodPost:
+		s.rerun(w, r, runID)
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		s.cancel(w, runID)
+	case len(parts) == 2 && parts[1] == "decisions" && r.Method == http.MethodGet:
+		s.decisions(w, runID)
+	case len(parts) == 2 && parts[1] == "approval" && r.Method == http.MethodGet:
+		s.approval(w, runID)
+	case len(parts) == 2 && parts[1] == "approve" && r.Method == http.MethodPost:
+		s.decide(w, r, runID, pipeline.ApprovalStatusApproved)
+	case len(parts) == 2 && parts[1] == "reject" && r.Method == http.MethodPost:
+		s.decide(w, r, runID, pipeline.ApprovalStatusRejected)
+	case len(parts) == 1 || parts[1] == "rerun" || parts[1] == "cancel" || parts[1] == "decisions" ||
+		parts[1] == "approval" || parts[1] == "approve" || parts[1] == "reject":
+		s.writeError(w, errMethodNotAllowed)
+	default:
+		s.writeError(w, errNotFound)
+	}
+}
+
+// getRun fetches a run with its payloads
+func (s *Server) getRun(id pipeline.RunID) (*pipeline.Run, error) {
+	runs, err := s.repo.GetRuns(&pipeline.GetRunsInput{RunID: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) != 1 {
+		return nil, pipeline.ErrRunNotFound
+	}
+	if err := runs[0].LoadPayloads(s.BlobStore); err != nil {
+		return nil, err
+	}
+	return runs[0], nil
+}
+
+// trigger serves POST /jobs/{id}/run, creating a run that starts immediately
+func (s *Server) trigger(w http.ResponseWriter, r *http.Request, id pipeline.JobID) {
+	in := &TriggerInput{}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, in); err != nil {
+			s.writeError(w, err)
+			return
+		}
+	}
+	job, err := s.getJob(id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if in.Input != nil {
+		//the template isn't rendered, it may need upstream fields
+		s.createRun(w, &pipeline.Run{
+			JobID:              job.ID,
+			JobVersionID:       job.VersionID,
+			ProcessorConfig:    job.ProcessorConfig,
+			ScheduledStartTime: time.Now(),
+			Attempt:            1,
+			Input:              in.Input,
+		})
+		return
+	}
+	run, err := job.MakeRun(pipeline.JobContext{
+		ScheduledStartTime: time.Now(),
+		Params:             in.Params,
+	})
+	if err != nil {
+		s.writeError(w, badRequestError("err rendering input: "+err.Error()))
+		return
+	}
+	s.createRun(w, run)
+}
+
+func (s *Server) rerun(w http.ResponseWriter, r *http.Request, id pipeline.RunID) {
+	in := &RerunInput{}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, in); err != nil {
+			s.writeError(w, err)
+			return
+		}
+	}
+	prev, err := s.getRun(id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	run := &pipeline.Run{
+		JobID:              prev.JobID,
+		JobVersionID:       prev.JobVersionID,
+		ProcessorConfig:    prev.ProcessorConfig,
+		ScheduledStartTime: time.Now(),
+		Attempt:            1,
+		Input:              prev.Input,
+	}
+	switch in.ProcessorConfig {
+	case "", RerunProcessorConfigOriginal:
+	case RerunProcessorConfigCurrent:
+		job, err := s.getJob(prev.JobID)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		run.JobVersionID = job.VersionID
+		run.ProcessorConfig = job.ProcessorConfig
+	default:
+		s.writeError(w, pipeline.ErrFieldInvalid{
+			FieldName: "processor_config",
+			Reason:    "must be " + RerunProcessorConfigOriginal + " or " + RerunProcessorConfigCurrent,
+		})
+		return
+	}
+	s.createRun(w, run)
+}
+
+// cancel serves POST /runs/{id}/cancel. Runs that haven't started, pending,
+// queued and waiting ones, can be cancelled. A run that has started,
+// including one the worker claims in the meantime, is left to finish.
+func (s *Server) cancel(w http.ResponseWriter, id pipeline.RunID) {
+	now := time.Now()
+	err := s.repo.UpdateRun(&pipeline.UpdateRunInput{
+		RunID:        id,
+		Status:       pipeline.RunStatusPtr(pipeline.RunStatusCancelled),
+		StatusDetail: pipeline.StringPtr("cancelled"),
+		EndTime:      &now,
+		IfStatus:     []pipeline.RunStatus{pipeline.RunStatusPending, pipeline.RunStatusQueued, pipeline.RunStatusWaiting},
+	})
+	if err == pipeline.ErrRunStatusChanged {
+		err = errRunStarted
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	run, err := s.getRun(id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newRun(run, true))
+}
+
+func (s *Server) createRun(w http.ResponseWriter, run *pipeline.Run) {
+	id, err := s.repo.CreateRun(run.CreateRunInput())
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	created, err := s.getRun(id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, newRun(created, true))
+}
+
+// getRunsInput maps the query string of GET /runs to a GetRunsInput. List
+// parameters are comma separated, times are RFC 3339.
+func getRunsInput(q url.Values) (*pipeline.GetRunsInput, error) {
+	p := &queryParser{q: q}
+	in := &pipeline.GetRunsInput{
+		JobIDs:             p.jobIDs("job_id"),
+		RunIDs:             p.runIDs("run_id"),
+		Statuses:           p.statuses("status"),
+		Success:            p.bool("success"),
+		Attempt:            p.int("attempt"),
+		Limit:              p.uint64("limit"),
+		Descending:         q.Get("order") == "desc",
+		Summary:            true,
+		ScheduledStartTime: p.timeRange("scheduled_after", "scheduled_before"),
+		StartTime:          p.timeRange("started_after", "started_before"),
+		EndTime:            p.timeRange("ended_after", "ended_before"),
+	}
+	if v := q.Get("order_by"); v != "" {
+		in.OrderBy = &v
+	}
+	if v := p.uint64("backfill_id"); v != nil {
+		id := pipeline.BackfillID(*v)
+		in.BackfillID = &id
+	}
+	if v := q.Get("cursor"); v != "" {
+		c := pipeline.RunsCursor(v)
+		in.Cursor = &c
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return in, nil
+}
+
+// queryParser parses query parameters, keeping the first error
+type queryParser struct {
+	q   url.Values
+	err error
+}
+
+func (p *queryParser) fail(name string) {
+	if p.err == nil {
+		p.err = badRequestError("invalid query parameter: " + name)
+	}
+}
+
+func (p *queryParser) list(name string) []string {
+	v := p.q.Get(name)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+func (p *queryParser) jobIDs(name string) pipeline.JobIDs {
+	var ids pipeline.JobIDs
+	for _, v := range p.list(name) {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			p.fail(name)
+			return nil
+		}
+		ids = append(ids, pipeline.JobID(id))
+	}
+	return ids
+}
+
+func (p *queryParser) runIDs(name string) pipeline.RunIDs {
+	var ids pipeline.RunIDs
+	for _, v := range p.list(name) {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			p.fail(name)
+			return nil
+		}
+		ids = append(ids, pipeline.RunID(id))
+	}
+	return ids
+}
+
+func (p *queryParser) statuses(name string) []pipeline.RunStatus {
+	var statuses []pipeline.RunStatus
+	for _, v := range p.list(name) {
+		st, err := pipeline.RunStatusFromString(v)
+		if err != nil {
+			p.fail(name)
+			return nil
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+func (p *queryParser) bool(name string) *bool {
+	v := p.q.Get(name)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(name)
+		return nil
+	}
+	return &b
+}
+
+func (p *queryParser) int(name string) *int {
+	v := p.q.Get(name)
+	if v == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		p.fail(name)
+		return nil
+	}
+	return &i
+}
+
+func (p *queryParser) uint64(name string) *uint64 {
+	v := p.q.Get(name)
+	if v == "" {
+		return nil
+	}
+	i, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		p.fail(name)
+		return nil
+	}
+	return &i
+}
+
+func (p *queryParser) time(name string) *time.Time {
+	v := p.q.Get(name)
+	if v == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		p.fail(name)
+		return nil
+	}
+	return &t
+}
+
+func (p *queryParser) timeRange(after, before string) *pipeline.TimeRange {
+	r := &pipeline.TimeRange{After: p.time(after), Before: p.time(before)}
+	if r.After == nil && r.Before == nil {
+		return nil
+	}
+	return r
+}
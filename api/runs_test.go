@@ -0,0 +1,238 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+func TestTriggerAndRerun(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	jobID, err := s.repo.CreateJob(&pipeline.CreateJobInput{
+		Name:                 "load",
+		Processor:            pipeline.ProcessorConfig{Type: "lambda", Config: map[string]string{"FunctionName": "v1"}},
+		InputPayloadTemplate: []byte(`{"table":{{index .Params "table" | default "events" | toJson}}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggered := &Run{}
+	if status := s.do("POST", "/jobs/1/run", nil, triggered); status != http.StatusCreated {
+		t.Fatalf("trigger: expected status 201, got %d", status)
+	}
+	if triggered.JobID != jobID || triggered.Status != pipeline.RunStatusPending || triggered.Attempt != 1 {
+		t.Errorf("trigger: unexpected run %+v", triggered)
+	}
+	if *triggered.Input != `{"table":"events"}` {
+		t.Errorf("trigger: expected rendered input, got %s", *triggered.Input)
+	}
+	if triggered.Processor.Config["FunctionName"] != "v1" {
+		t.Errorf("trigger: expected job processor, got %+v", triggered.Processor)
+	}
+
+	overridden := &Run{}
+	status := s.do("POST", "/jobs/1/run", map[string]interface{}{"input": map[string]string{"table": "users"}}, overridden)
+	if status != http.StatusCreated {
+		t.Fatalf("trigger with input: expected status 201, got %d", status)
+	}
+	if *overridden.Input != `{"table":"users"}` {
+		t.Errorf("trigger with input: expected override, got %s", *overridden.Input)
+	}
+
+	withParams := &Run{}
+	status = s.do("POST", "/jobs/1/run", map[string]interface{}{"params": map[string]string{"table": "orders"}}, withParams)
+	if status != http.StatusCreated || *withParams.Input != `{"table":"orders"}` {
+		t.Errorf("trigger with params: expected the params in the input, got %d and %+v", status, withParams)
+	}
+
+	//a template that needs upstream fields is only rendered without input
+	report, err := s.repo.CreateJob(&pipeline.CreateJobInput{
+		Name:                 "report",
+		Processor:            pipeline.ProcessorConfig{Type: "lambda"},
+		InputPayloadTemplate: []byte(`{"rows":{{.rows}}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamInput := &Run{}
+	status = s.do("POST", "/jobs/"+report.String()+"/run", map[string]interface{}{"input": map[string]int{"rows": 3}}, upstreamInput)
+	if status != http.StatusCreated || *upstreamInput.Input != `{"rows":3}` {
+		t.Errorf("trigger with input: expected the input of a template with upstream fields, got %d and %+v", status, upstreamInput)
+	}
+	errResp := &ErrorResponse{}
+	if status := s.do("POST", "/jobs/"+report.String()+"/run", nil, errResp); status != http.StatusBadRequest {
+		t.Errorf("trigger without input: expected status 400 rendering upstream fields, got %d", status)
+	}
+
+	//change the processor, reruns pick the original or current config
+	err = s.repo.UpdateJob(&pipeline.UpdateJobInput{
+		JobID:     jobID,
+		Processor: &pipeline.ProcessorConfig{Type: "lambda", Config: map[string]string{"FunctionName": "v2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		body     interface{}
+		function string
+	}{
+		{"default", nil, "v1"},
+		{"original", RerunInput{ProcessorConfig: "original"}, "v1"},
+		{"current", RerunInput{ProcessorConfig: "current"}, "v2"},
+	}
+	for _, test := range tests {
+		rerun := &Run{}
+		if status := s.do("POST", "/runs/2/rerun", test.body, rerun); status != http.StatusCreated {
+			t.Fatalf("rerun %s: expected status 201, got %d", test.name, status)
+		}
+		if *rerun.Input != `{"table":"users"}` {
+			t.Errorf("rerun %s: expected original input, got %s", test.name, *rerun.Input)
+		}
+		if rerun.Processor.Config["FunctionName"] != test.function {
+			t.Errorf("rerun %s: expected function %s, got %+v", test.name, test.function, rerun.Processor)
+		}
+	}
+
+	status = s.do("POST", "/runs/2/rerun", RerunInput{ProcessorConfig: "latest"}, errResp)
+	if status != http.StatusUnprocessableEntity || len(errResp.Fields) != 1 || errResp.Fields[0].Field != "processor_config" {
+		t.Errorf("rerun invalid config: expected 422 on processor_config, got %d %+v", status, errResp)
+	}
+	if status := s.do("POST", "/jobs/42/run", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("trigger unknown job: expected status 404, got %d", status)
+	}
+}
+
+func TestListRuns(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	for _, c := range []*pipeline.CreateRunInput{
+		{JobID: 1, Input: []byte("r1"), Status: pipeline.RunStatusPtr(pipeline.RunStatusComplete), Success: pipeline.BoolPtr(true)},
+		{JobID: 2, Input: []byte("r2"), Status: pipeline.RunStatusPtr(pipeline.RunStatusComplete)},
+		{JobID: 1, Input: []byte("r3"), Status: pipeline.RunStatusPtr(pipeline.RunStatusComplete), Success: pipeline.BoolPtr(true)},
+		{JobID: 1, Input: []byte("r4")},
+		{JobID: 1, Input: []byte("r5"), Status: pipeline.RunStatusPtr(pipeline.RunStatusQueued)},
+		{JobID: 1, Input: []byte("r6"), Status: pipeline.RunStatusPtr(pipeline.RunStatusWaiting)},
+	} {
+		if _, err := s.repo.CreateRun(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(l *RunList) pipeline.RunIDs {
+		got := pipeline.RunIDs{}
+		for _, r := range l.Runs {
+			got = append(got, r.ID)
+			if r.Input != nil {
+				t.Errorf("expected run list without input, got %s", *r.Input)
+			}
+		}
+		return got
+	}
+
+	page := &RunList{}
+	path := "/runs?job_id=1&status=complete&success=true&order_by=id&order=desc&limit=1"
+	if status := s.do("GET", path, nil, page); status != http.StatusOK {
+		t.Fatalf("list: expected status 200, got %d", status)
+	}
+	if expected := (pipeline.RunIDs{3}); !reflect.DeepEqual(expected, ids(page)) || page.NextCursor == nil {
+		t.Fatalf("list: expected %v with a next cursor, got %v", expected, ids(page))
+	}
+	next := &RunList{}
+	if status := s.do("GET", path+"&cursor="+string(*page.NextCursor), nil, next); status != http.StatusOK {
+		t.Fatalf("list next page: expected status 200, got %d", status)
+	}
+	if expected := (pipeline.RunIDs{1}); !reflect.DeepEqual(expected, ids(next)) {
+		t.Errorf("list next page: expected %v, got %v", expected, ids(next))
+	}
+
+	run := &Run{}
+	if status := s.do("GET", "/runs/4", nil, run); status != http.StatusOK {
+		t.Fatalf("get: expected status 200, got %d", status)
+	}
+	if run.Input == nil || *run.Input != "r4" {
+		t.Errorf("get: expected input r4, got %v", run.Input)
+	}
+
+	errResp := &ErrorResponse{}
+	if status := s.do("GET", "/runs?status=done", nil, errResp); status != http.StatusBadRequest {
+		t.Errorf("invalid status: expected 400, got %d", status)
+	}
+	if status := s.do("GET", "/runs?order_by=input", nil, errResp); status != http.StatusUnprocessableEntity {
+		t.Errorf("invalid order by: expected 422, got %d", status)
+	}
+	if status := s.do("GET", "/runs/42", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("unknown run: expected 404, got %d", status)
+	}
+
+	cancelled := &Run{}
+	if status := s.do("POST", "/runs/4/cancel", nil, cancelled); status != http.StatusOK {
+		t.Fatalf("cancel: expected status 200, got %d", status)
+	}
+	if cancelled.Status != pipeline.RunStatusCancelled || cancelled.EndTime == nil {
+		t.Errorf("cancel: expected a cancelled run with an end time, got %+v", cancelled)
+	}
+	if status := s.do("POST", "/runs/4/cancel", nil, errResp); status != http.StatusConflict {
+		t.Errorf("cancel cancelled run: expected 409, got %d", status)
+	}
+	for _, id := range []string{"5", "6"} {
+		if status := s.do("POST", "/runs/"+id+"/cancel", nil, cancelled); status != http.StatusOK || cancelled.Status != pipeline.RunStatusCancelled {
+			t.Errorf("cancel queued or waiting run %s: expected status 200, got %d and %+v", id, status, cancelled)
+		}
+	}
+	if status := s.do("POST", "/runs/1/cancel", nil, errResp); status != http.StatusConflict {
+		t.Errorf("cancel complete run: expected 409, got %d", status)
+	}
+}
+
+func TestRunDecisions(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	jobID, err := s.repo.CreateJob(&pipeline.CreateJobInput{Name: "extract", Processor: pipeline.ProcessorConfig{Type: "lambda"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runID, err := s.repo.CreateRun(&pipeline.CreateRunInput{
+		JobID:              jobID,
+		ProcessorConfig:    pipeline.ProcessorConfig{Type: "lambda"},
+		ScheduledStartTime: time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC),
+		Input:              []byte("{}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2017, 3, 1, 0, 5, 0, 0, time.UTC)
+	err = s.repo.CreateTriggerDecision(&pipeline.CreateTriggerDecisionInput{
+		RunID:     runID,
+		JobID:     jobID,
+		Condition: "$.new_files > 0",
+		CreatedAt: created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []*TriggerDecision{}
+	if status := s.do("GET", "/runs/1/decisions", nil, &got); status != http.StatusOK {
+		t.Fatalf("decisions: expected status 200, got %d", status)
+	}
+	expected := []*TriggerDecision{{RunID: runID, JobID: jobID, Condition: "$.new_files > 0", CreatedAt: created}}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("decisions: expected %+v, got %+v", expected, got)
+	}
+	errResp := &ErrorResponse{}
+	if status := s.do("GET", "/runs/2/decisions", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("decisions of unknown run: expected status 404, got %d", status)
+	}
+	if status := s.do("POST", "/runs/1/decisions", nil, errResp); status != http.StatusMethodNotAllowed {
+		t.Errorf("post decisions: expected status 405, got %d", status)
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the dashboard from the assets embedded in the binary
+func uiHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		//ui is embedded at compile time, it's always there
+		panic(err)
+	}
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(files)))
+}
+
+// handleRoot redirects / to the dashboard
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		s.writeError(w, errNotFound)
+		return
+	}
+	http.Redirect(w, r, "/ui/", http.StatusFound)
+}
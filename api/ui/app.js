@@ -0,0 +1,303 @@
+'use strict';
+
+// The dashboard is rendered from the JSON API, every view is rebuilt when a
+// run event arrives.
+
+var main = document.getElementById('main');
+var errorBox = document.getElementById('error');
+
+function el(tag, attrs) {
+  var node = document.createElement(tag);
+  Object.keys(attrs || {}).forEach(function (k) {
+    if (k === 'onclick') {
+      node.onclick = attrs[k];
+    } else {
+      node.setAttribute(k, attrs[k]);
+    }
+  });
+  function add(child) {
+    if (child === null || child === undefined) {
+      return;
+    }
+    if (Array.isArray(child)) {
+      child.forEach(add);
+      return;
+    }
+    node.appendChild(typeof child === 'object' ? child : document.createTextNode(String(child)));
+  }
+  for (var i = 2; i < arguments.length; i++) {
+    add(arguments[i]);
+  }
+  return node;
+}
+
+function api(method, path, body) {
+  var opts = {method: method, headers: {}};
+  if (body !== undefined) {
+    opts.headers['Content-Type'] = 'application/json';
+    opts.body = JSON.stringify(body);
+  }
+  return fetch('..' + path, opts).then(function (resp) {
+    if (resp.status === 204) {
+      return null;
+    }
+    return resp.json().then(function (data) {
+      if (!resp.ok) {
+        var msg = data.error;
+        (data.fields || []).forEach(function (f) {
+          msg += ', ' + f.field + ' ' + f.message;
+        });
+        throw new Error(msg);
+      }
+      return data;
+    });
+  });
+}
+
+function showError(err) {
+  errorBox.textContent = err.message;
+  errorBox.hidden = false;
+}
+
+function action(method, path, body) {
+  return function () {
+    errorBox.hidden = true;
+    api(method, path, body).then(render, showError);
+  };
+}
+
+function formatTime(t) {
+  if (!t || t.indexOf('0001-') === 0) {
+    return '';
+  }
+  return new Date(t).toLocaleString();
+}
+
+function duration(run) {
+  if (!run.start_time || !run.end_time) {
+    return '';
+  }
+  return ((new Date(run.end_time) - new Date(run.start_time)) / 1000).toFixed(1) + 's';
+}
+
+function runStatus(run) {
+  if (run.status === 'complete') {
+    return run.success ? 'succeeded' : 'failed';
+  }
+  return run.status;
+}
+
+function statusBadge(status) {
+  return el('span', {'class': 'status ' + status}, status);
+}
+
+function runButtons(run) {
+  return el('span', null,
+    run.status === 'pending' ? el('button', {onclick: action('POST', '/runs/' + run.id + '/cancel')}, 'Cancel') : null,
+    el('button', {onclick: action('POST', '/runs/' + run.id + '/rerun', {processor_config: 'original'})}, 'Re-run'));
+}
+
+function jobsTable(jobs) {
+  return el('table', null,
+    el('tr', null, el('th', null, 'Job'), el('th', null, 'Schedule'), el('th', null, 'Next fire times'),
+      el('th', null, 'State'), el('th', null, '')),
+    jobs.map(function (j) {
+      var next = j.next_fire_times.map(formatTime);
+      return el('tr', null,
+        el('td', null, j.name, el('span', {'class': 'muted'}, ' #' + j.id)),
+        el('td', null, j.triggers.cron_schedule || el('span', {'class': 'muted'}, 'none')),
+        el('td', {title: next.join('\n')}, next[0] || el('span', {'class': 'muted'}, '-'),
+          next.length > 1 ? el('span', {'class': 'muted'}, ' +' + (next.length - 1) + ' more') : null),
+        el('td', null, j.paused ? statusBadge('paused') : 'active'),
+        el('td', null,
+          el('button', {onclick: action('POST', '/jobs/' + j.id + '/run')}, 'Run now'),
+          j.paused ?
+            el('button', {onclick: action('POST', '/jobs/' + j.id + '/resume')}, 'Resume') :
+            el('button', {onclick: action('POST', '/jobs/' + j.id + '/pause')}, 'Pause')));
+    }));
+}
+
+// triggerGraph lays the jobs out in columns, each job one column right of the
+// jobs that trigger it
+function triggerGraph(jobs) {
+  var svgNS = 'http://www.w3.org/2000/svg';
+  function svg(tag, attrs) {
+    var node = document.createElementNS(svgNS, tag);
+    Object.keys(attrs).forEach(function (k) {
+      node.setAttribute(k, attrs[k]);
+    });
+    return node;
+  }
+  var byID = {};
+  jobs.forEach(function (j) {
+    byID[j.id] = j;
+  });
+  var edges = [];
+  jobs.forEach(function (j) {
+    j.triggers.job_success.forEach(function (up) {
+      edges.push({from: up, to: j.id, kind: 'success'});
+    });
+    j.triggers.job_failure.forEach(function (up) {
+      edges.push({from: up, to: j.id, kind: 'failure'});
+    });
+    j.triggers.job_map_complete.forEach(function (up) {
+      edges.push({from: up, to: j.id, kind: 'map_complete'});
+    });
+  });
+  edges = edges.filter(function (e) {
+    return byID[e.from] && byID[e.to];
+  });
+  if (edges.length === 0) {
+    return el('p', {'class': 'muted'}, 'No jobs are triggered by other jobs.');
+  }
+
+  //longest path from a root, bounded so cycles terminate
+  var depth = {};
+  jobs.forEach(function (j) {
+    depth[j.id] = 0;
+  });
+  for (var i = 0; i < jobs.length; i++) {
+    edges.forEach(function (e) {
+      if (e.from !== e.to && depth[e.to] < depth[e.from] + 1) {
+        depth[e.to] = Math.min(depth[e.from] + 1, jobs.length);
+      }
+    });
+  }
+  var columns = [];
+  var pos = {};
+  jobs.forEach(function (j) {
+    var c = depth[j.id];
+    columns[c] = columns[c] || [];
+    pos[j.id] = {x: 20 + c * 200, y: 20 + columns[c].length * 60};
+    columns[c].push(j);
+  });
+  var height = 0;
+  columns.forEach(function (c) {
+    height = Math.max(height, (c || []).length * 60 + 20);
+  });
+
+  var root = svg('svg', {width: columns.length * 200 + 20, height: height});
+  edges.forEach(function (e) {
+    var a = pos[e.from], b = pos[e.to];
+    var path = e.from === e.to ?
+      'M' + (a.x + 150) + ' ' + (a.y + 10) + ' c40 -30 40 50 0 20' :
+      'M' + (a.x + 150) + ' ' + (a.y + 15) + ' C' + (a.x + 175) + ' ' + (a.y + 15) + ' ' +
+        (b.x - 25) + ' ' + (b.y + 15) + ' ' + b.x + ' ' + (b.y + 15);
+    var line = svg('path', {d: path, 'class': 'edge ' + e.kind});
+    var title = svg('title', {});
+    title.textContent = byID[e.from].name + ' ' + e.kind + ' triggers ' + byID[e.to].name;
+    line.appendChild(title);
+    root.appendChild(line);
+  });
+  jobs.forEach(function (j) {
+    var g = svg('g', {'class': 'node' + (j.paused ? ' paused' : ''), transform: 'translate(' + pos[j.id].x + ',' + pos[j.id].y + ')'});
+    g.appendChild(svg('rect', {width: 150, height: 30, rx: 4}));
+    var text = svg('text', {x: 8, y: 20});
+    text.textContent = j.name;
+    g.appendChild(text);
+    root.appendChild(g);
+  });
+  return el('div', null, root,
+    el('p', {'class': 'muted'}, 'Green edges trigger on success, red edges on failure, blue edges once a fan-out finished.'));
+}
+
+function runsTable(runs, jobNames) {
+  if (runs.length === 0) {
+    return el('p', {'class': 'muted'}, 'No runs yet.');
+  }
+  return el('table', null,
+    el('tr', null, el('th', null, 'Run'), el('th', null, 'Job'), el('th', null, 'Status'), el('th', null, 'Attempt'),
+      el('th', null, 'Scheduled'), el('th', null, 'Started'), el('th', null, 'Duration'), el('th', null, '')),
+    runs.map(function (r) {
+      return el('tr', null,
+        el('td', null, el('a', {href: '#/runs/' + r.id}, '#' + r.id)),
+        el('td', null, jobNames[r.job_id] || '#' + r.job_id),
+        el('td', null, statusBadge(runStatus(r))),
+        el('td', null, r.attempt),
+        el('td', null, formatTime(r.scheduled_start_time)),
+        el('td', null, formatTime(r.start_time)),
+        el('td', null, duration(r)),
+        el('td', null, runButtons(r)));
+    }));
+}
+
+function renderOverview() {
+  return Promise.all([
+    api('GET', '/jobs'),
+    api('GET', '/runs?order_by=id&order=desc&limit=50')
+  ]).then(function (res) {
+    var jobs = res[0], runs = res[1].runs;
+    var jobNames = {};
+    jobs.forEach(function (j) {
+      jobNames[j.id] = j.name;
+    });
+    return el('div', null,
+      el('h2', null, 'Jobs'), jobsTable(jobs),
+      el('h2', null, 'Trigger graph'), triggerGraph(jobs),
+      el('h2', null, 'Recent runs'), runsTable(runs, jobNames));
+  });
+}
+
+function renderRun(id) {
+  return api('GET', '/runs/' + id).then(function (r) {
+    function payload(name, data) {
+      return el('div', null, el('h2', null, name), data ? el('pre', null, data) : el('p', {'class': 'muted'}, 'empty'));
+    }
+    return el('div', null,
+      el('h2', null, 'Run #' + r.id),
+      el('dl', null,
+        el('dt', null, 'Job'), el('dd', null, '#' + r.job_id + ' (version ' + r.job_version_id + ')'),
+        el('dt', null, 'Status'), el('dd', null, statusBadge(runStatus(r)), ' ', r.status_detail),
+        el('dt', null, 'Attempt'), el('dd', null, r.attempt),
+        el('dt', null, 'Processor'), el('dd', null, r.processor.type),
+        el('dt', null, 'Scheduled'), el('dd', null, formatTime(r.scheduled_start_time)),
+        el('dt', null, 'Started'), el('dd', null, formatTime(r.start_time)),
+        el('dt', null, 'Ended'), el('dd', null, formatTime(r.end_time)),
+        el('dt', null, 'Duration'), el('dd', null, duration(r))),
+      runButtons(r),
+      el('button', {onclick: action('POST', '/runs/' + r.id + '/rerun', {processor_config: 'current'})},
+        'Re-run with current config'),
+      payload('Input', r.input),
+      payload('Output', r.output),
+      payload('Log', r.log));
+  });
+}
+
+function render() {
+  var m = location.hash.match(/^#\/runs\/(\d+)$/);
+  var view = m ? renderRun(m[1]) : renderOverview();
+  return view.then(function (node) {
+    main.replaceChildren(node);
+  }, showError);
+}
+
+//re-render on run events, at most once a second
+function listen() {
+  var live = document.getElementById('live');
+  var pending = false;
+  var events = new EventSource('../events');
+  events.onopen = function () {
+    live.textContent = 'live';
+    live.className = 'live on';
+  };
+  events.onerror = function () {
+    live.textContent = 'offline';
+    live.className = 'live';
+  };
+  ['created', 'claimed', 'started', 'succeeded', 'failed', 'retried', 'triggered_downstream'].forEach(function (t) {
+    events.addEventListener(t, function () {
+      if (pending) {
+        return;
+      }
+      pending = true;
+      setTimeout(function () {
+        pending = false;
+        render();
+      }, 1000);
+    });
+  });
+}
+
+window.addEventListener('hashchange', render);
+render();
+listen();
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+// maxWebhookBody is the largest delivery accepted, in bytes
+const maxWebhookBody = 1 << 20
+
+// Webhook headers, see pipeline.WebhookAuth
+const (
+	HeaderWebhookSignature = "X-Pipeline-Signature" //"sha256=" and the hex HMAC-SHA256 of the body
+	HeaderIdempotencyKey   = "Idempotency-Key"
+)
+
+// Webhook is the JSON representation of a pipeline.Webhook. The secret is
+// only returned when the webhook is created.
+type Webhook struct {
+	JobID     pipeline.JobID       `json:"job_id"`
+	URL       string               `json:"url"`
+	Auth      pipeline.WebhookAuth `json:"auth"`
+	Secret    string               `json:"secret,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+func newWebhook(r *http.Request, w *pipeline.Webhook) *Webhook {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return &Webhook{
+		JobID:     w.JobID,
+		URL:       scheme + "://" + r.Host + "/hooks/" + w.Key,
+		Auth:      w.Auth,
+		CreatedAt: w.CreatedAt,
+	}
+}
+
+// WebhookInput is the body of POST /jobs/{id}/webhook
+type WebhookInput struct {
+	Auth pipeline.WebhookAuth `json:"auth"` //hmac by default
+}
+
+// webhook serves /jobs/{id}/webhook. POST gives the job a new webhook,
+// replacing its URL and secret.
+func (s *Server) webhook(w http.ResponseWriter, r *http.Request, id pipeline.JobID) {
+	switch r.Method {
+	case http.MethodGet:
+		webhooks, err := s.repo.GetWebhooks(&pipeline.GetWebhooksInput{JobIDs: pipeline.JobIDs{id}})
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if len(webhooks) != 1 {
+			s.writeError(w, pipeline.ErrWebhookNotFound)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, newWebhook(r, webhooks[0]))
+	case http.MethodPost:
+		in := &WebhookInput{}
+		if r.ContentLength != 0 {
+			if err := readJSON(r, in); err != nil {
+				s.writeError(w, err)
+				return
+			}
+		}
+		if in.Auth == "" {
+			in.Auth = pipeline.WebhookAuthHMAC
+		}
+		created, err := pipeline.NewWebhook(s.repo, id, in.Auth, s.now())
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		resp := newWebhook(r, created)
+		resp.Secret = created.Secret
+		s.writeJSON(w, http.StatusCreated, resp)
+	case http.MethodDelete:
+		if err := s.repo.DeleteWebhook(&pipeline.DeleteWebhookInput{JobID: id}); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleHook serves POST /hooks/{key}, the deliveries of webhooks. A new
+// delivery returns the run it created with 201, a repeated Idempotency-Key
+// the run of the first delivery with 200.
+func (s *Server) handleHook(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/hooks/")
+	if len(parts) != 1 {
+		s.writeError(w, errNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		s.writeError(w, badRequestError("err reading body: "+err.Error()))
+		return
+	}
+	id, err := pipeline.ReceiveWebhook(s.repo, &pipeline.WebhookDelivery{
+		Key:            parts[0],
+		Body:           body,
+		Signature:      r.Header.Get(HeaderWebhookSignature),
+		Token:          strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		IdempotencyKey: r.Header.Get(HeaderIdempotencyKey),
+		ReceivedAt:     s.now(),
+	})
+	status := http.StatusCreated
+	if err == pipeline.ErrDuplicateDelivery {
+		status = http.StatusOK
+	} else if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	run, err := s.getRun(id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, status, newRun(run, false))
+}
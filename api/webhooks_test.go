@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+func TestWebhooks(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	now := time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)
+	s.api.now = func() time.Time { return now }
+	_, err := s.repo.CreateJob(&pipeline.CreateJobInput{
+		Name:                 "import",
+		Processor:            pipeline.ProcessorConfig{Type: "lambda"},
+		InputPayloadTemplate: []byte(`{"file":"{{.file}}"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//deliver posts body to the webhook's URL with the given headers
+	deliver := func(hookURL string, body string, headers map[string]string, out interface{}) int {
+		u, err := url.Parse(hookURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", s.URL+u.Path, bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	errResp := &ErrorResponse{}
+	if status := s.do("GET", "/jobs/1/webhook", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("get missing: expected status 404, got %d", status)
+	}
+	if status := s.do("POST", "/jobs/2/webhook", nil, errResp); status != http.StatusNotFound {
+		t.Errorf("create for unknown job: expected status 404, got %d", status)
+	}
+	if status := s.do("POST", "/jobs/1/webhook", &WebhookInput{Auth: "basic"}, errResp); status != http.StatusUnprocessableEntity {
+		t.Errorf("create with unknown auth: expected status 422, got %d", status)
+	}
+
+	hook := &Webhook{}
+	if status := s.do("POST", "/jobs/1/webhook", nil, hook); status != http.StatusCreated {
+		t.Fatalf("create: expected status 201, got %d", status)
+	}
+	if hook.Auth != pipeline.WebhookAuthHMAC || hook.Secret == "" || !hook.CreatedAt.Equal(now) {
+		t.Fatalf("create: expected an hmac webhook with a secret, got %+v", hook)
+	}
+	got := &Webhook{}
+	if status := s.do("GET", "/jobs/1/webhook", nil, got); status != http.StatusOK || got.URL != hook.URL || got.Secret != "" {
+		t.Errorf("get: expected the webhook without its secret, got status %d and %+v", status, got)
+	}
+
+	body := `{"file":"a.csv"}`
+	signer := &pipeline.Webhook{Secret: hook.Secret}
+	signed := map[string]string{HeaderWebhookSignature: signer.Sign([]byte(body)), HeaderIdempotencyKey: "delivery-1"}
+	if status := deliver(hook.URL, body, map[string]string{HeaderWebhookSignature: "sha256=00"}, errResp); status != http.StatusUnauthorized {
+		t.Errorf("bad signature: expected status 401, got %d", status)
+	}
+	run := &Run{}
+	if status := deliver(hook.URL, body, signed, run); status != http.StatusCreated {
+		t.Fatalf("deliver: expected status 201, got %d", status)
+	}
+	if run.JobID != 1 || run.Status != pipeline.RunStatusPending || !run.ScheduledStartTime.Equal(now) {
+		t.Errorf("deliver: expected a pending run of the job, got %+v", run)
+	}
+	runs, err := s.repo.GetRuns(&pipeline.GetRunsInput{RunID: &run.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(runs[0].Input) != `{"file":"a.csv"}` {
+		t.Errorf("deliver: expected the body to render the input, got %s", runs[0].Input)
+	}
+	again := &Run{}
+	if status := deliver(hook.URL, body, signed, again); status != http.StatusOK || again.ID != run.ID {
+		t.Errorf("redeliver: expected status 200 and run %s, got %d and %+v", run.ID, status, again)
+	}
+	signed[HeaderIdempotencyKey] = "delivery-2"
+	if status := deliver(hook.URL, body, signed, again); status != http.StatusCreated || again.ID == run.ID {
+		t.Errorf("new delivery: expected a new run, got %d and %+v", status, again)
+	}
+
+	rotated := &Webhook{}
+	if status := s.do("POST", "/jobs/1/webhook", &WebhookInput{Auth: pipeline.WebhookAuthToken}, rotated); status != http.StatusCreated || rotated.URL == hook.URL {
+		t.Fatalf("rotate: expected a new URL, got status %d and %+v", status, rotated)
+	}
+	if status := deliver(hook.URL, body, signed, errResp); status != http.StatusNotFound {
+		t.Errorf("old URL: expected status 404, got %d", status)
+	}
+	if status := deliver(rotated.URL, body, map[string]string{"Authorization": "Bearer nope"}, errResp); status != http.StatusUnauthorized {
+		t.Errorf("bad token: expected status 401, got %d", status)
+	}
+	if status := deliver(rotated.URL, body, map[string]string{"Authorization": "Bearer " + rotated.Secret}, run); status != http.StatusCreated {
+		t.Errorf("token: expected status 201, got %d", status)
+	}
+	if status := deliver(rotated.URL, "not json", map[string]string{"Authorization": "Bearer " + rotated.Secret}, errResp); status != http.StatusUnprocessableEntity {
+		t.Errorf("invalid body: expected status 422, got %d", status)
+	}
+
+	if status := s.do("DELETE", "/jobs/1/webhook", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("delete: expected status 204, got %d", status)
+	}
+	if status := deliver(rotated.URL, "", map[string]string{"Authorization": "Bearer " + rotated.Secret}, errResp); status != http.StatusNotFound {
+		t.Errorf("deleted: expected status 404, got %d", status)
+	}
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ProcessorTypeApproval is the processor of approval jobs. A run of an
+// approval job doesn't process anything, it waits until someone approves or
+// rejects it, see ResolveApproval. An approved run succeeds with its input as
+// output, so the jobs it triggers get the data that was approved. A rejected
+// or expired run fails.
+const ProcessorTypeApproval = "approval"
+
+// DefaultApprovalExpiry is how long a run waits for a decision when the
+// processor config doesn't set Expiry
+const DefaultApprovalExpiry = 24 * time.Hour
+
+const (
+	ErrApprovalNotFound   = Err("approval not found")
+	ErrApprovalNotWaiting = Err("approval is already decided")
+)
+
+type ApprovalStatus string
+
+const (
+	ApprovalStatusWaiting  ApprovalStatus = "waiting"
+	ApprovalStatusApproved ApprovalStatus = "approved"
+	ApprovalStatusRejected ApprovalStatus = "rejected"
+	ApprovalStatusExpired  ApprovalStatus = "expired"
+)
+
+// Approval is the request for a decision on a run of an approval job
+type Approval struct {
+	RunID       RunID
+	JobID       JobID
+	Status      ApprovalStatus
+	RequestedAt time.Time
+	ExpiresAt   time.Time //the run fails if it isn't decided by then
+	DecidedBy   string    //who approved or rejected the run, empty if it expired
+	Comment     string
+	DecidedAt   *time.Time
+}
+
+// approvalExpiry reads the Expiry of an approval processor config, a
+// duration such as 72h
+func approvalExpiry(config map[string]string) (time.Duration, error) {
+	v, ok := config["Expiry"]
+	if !ok {
+		return DefaultApprovalExpiry, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("must be positive")
+	}
+	return d, nil
+}
+
+// ResolveApproval records the decision on a waiting run and makes the run
+// pending again, the service then completes it. Both change together when r
+// is a Transactor, a run that is no longer waiting, such as a cancelled one,
+// returns ErrApprovalNotWaiting and its approval is left undecided.
+func ResolveApproval(r Repository, in *DecideApprovalInput) error {
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return inTx(r, func(r Repository) error {
+		if err := r.DecideApproval(in); err != nil {
+			return err
+		}
+		detail := string(in.Status)
+		if in.DecidedBy != "" {
+			detail += " by " + in.DecidedBy
+		}
+		err := r.UpdateRun(&UpdateRunInput{
+			RunID:        in.RunID,
+			Status:       RunStatusPtr(RunStatusPending),
+			StatusDetail: &detail,
+			NotBefore:    &in.DecidedAt,
+			IfStatus:     []RunStatus{RunStatusWaiting},
+		})
+		if err == ErrRunStatusChanged {
+			return ErrApprovalNotWaiting
+		}
+		return err
+	})
+}
+
+// approvalResult returns the result of r, a run of an approval job, once its
+// approval is decided. The first time r is processed it requests the
+// approval and parks r in the waiting status, the result is then nil.
+func (s *Service) approvalResult(r *Run) (*RunResult, error) {
+	approvals, err := s.repo.GetApprovals(&GetApprovalsInput{RunIDs: RunIDs{r.RunID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(approvals) == 0 {
+		return nil, s.requestApproval(r)
+	}
+	a := approvals[0]
+	switch a.Status {
+	case ApprovalStatusApproved:
+		if err := r.LoadPayloads(s.BlobStore); err != nil {
+			return nil, err
+		}
+		return &RunResult{Output: json.RawMessage(r.Input), Detail: r.StatusDetail, Success: true}, nil
+	case ApprovalStatusRejected, ApprovalStatusExpired:
+		return &RunResult{Detail: r.StatusDetail}, nil
+	}
+	//made pending while still waiting, wait again
+	return nil, s.repo.UpdateRun(&UpdateRunInput{RunID: r.RunID, Status: RunStatusPtr(RunStatusWaiting)})
+}
+
+// requestApproval parks r in the waiting status before creating its
+// approval, so a decision can't make r pending before it waits
+func (s *Service) requestApproval(r *Run) error {
+	expiry, err := approvalExpiry(r.ProcessorConfig.Config)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	detail := "waiting for approval"
+	err = s.repo.UpdateRun(&UpdateRunInput{
+		RunID:        r.RunID,
+		Status:       RunStatusPtr(RunStatusWaiting),
+		StatusDetail: &detail,
+	})
+	if err != nil {
+		return err
+	}
+	err = s.repo.CreateApproval(&CreateApprovalInput{
+		RunID:       r.RunID,
+		JobID:       r.JobID,
+		RequestedAt: now,
+		ExpiresAt:   now.Add(expiry),
+	})
+	if err != nil {
+		return err
+	}
+	r.Status, r.StatusDetail = RunStatusWaiting, detail
+	s.publish(EventRunWaiting, r)
+	return nil
+}
+
+// expireApprovals expires the approvals that weren't decided in time, their
+// runs fail
+func (s *Service) expireApprovals(now time.Time) {
+	approvals, err := s.repo.GetApprovals(&GetApprovalsInput{
+		Statuses:      []ApprovalStatus{ApprovalStatusWaiting},
+		ExpiresBefore: &now,
+	})
+	if err != nil {
+		s.log.Printf("err getting expired approvals: %s", err)
+		return
+	}
+	for _, a := range approvals {
+		in := &DecideApprovalInput{
+			RunID:     a.RunID,
+			Status:    ApprovalStatusExpired,
+			DecidedAt: now,
+		}
+		err := ResolveApproval(s.repo, in)
+		if err == ErrApprovalNotWaiting {
+			//the run was cancelled while waiting, expire the approval alone so
+			//it isn't expired again on every tick
+			err = s.repo.DecideApproval(in)
+		}
+		if err != nil && err != ErrApprovalNotWaiting {
+			s.log.Printf("err expiring approval of run %s: %s", a.RunID, err)
+		}
+	}
+}
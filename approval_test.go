@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestServiceApproval(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func(in []byte) (*RunResult, error) {
+			return &RunResult{Success: true, Output: json.RawMessage(`{"rows":2}`)}, nil
+		}), nil
+	})
+	create := func(in *CreateJobInput) JobID {
+		if in.Processor.Type == "" {
+			in.Processor = ProcessorConfig{Type: "test"}
+		}
+		if in.InputPayloadTemplate == nil {
+			in.InputPayloadTemplate = []byte("{}")
+		}
+		id, err := r.CreateJob(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	extract := create(&CreateJobInput{Name: "extract"})
+	signOff := create(&CreateJobInput{
+		Name:                 "sign-off",
+		Processor:            ProcessorConfig{Type: ProcessorTypeApproval, Config: map[string]string{"Expiry": "1h"}},
+		InputPayloadTemplate: []byte(`{"rows":{{.rows}}}`),
+		Triggers:             &TriggerEventsInput{JobSuccess: JobIDs{extract}},
+	})
+	publish := create(&CreateJobInput{Name: "publish", Triggers: &TriggerEventsInput{JobSuccess: JobIDs{signOff}}})
+	alert := create(&CreateJobInput{Name: "alert", Triggers: &TriggerEventsInput{JobFailure: JobIDs{signOff}}})
+
+	runAll := func() {
+		for i := 0; i < 3; i++ {
+			//expired runs are due at the expiry time
+			s.startDueRuns(time.Now().Add(3 * time.Hour))
+			s.running.Wait()
+		}
+	}
+	waiting := func() *Run {
+		runs, err := r.GetRuns(&GetRunsInput{JobID: &signOff, Status: RunStatusPtr(RunStatusWaiting)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 1 {
+			t.Fatalf("expected a waiting sign-off run, got %d", len(runs))
+		}
+		return runs[0]
+	}
+	count := func(job JobID) int {
+		runs, err := r.GetRuns(&GetRunsInput{JobID: &job, Summary: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(runs)
+	}
+	start := func() {
+		_, err := s.Repository().CreateRun(&CreateRunInput{JobID: extract, ProcessorConfig: ProcessorConfig{Type: "test"}, Attempt: IntPtr(1), Input: []byte("{}"), ScheduledStartTime: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runAll()
+	}
+
+	//approved
+	start()
+	run := waiting()
+	if run.StatusDetail != "waiting for approval" || count(publish) != 0 {
+		t.Fatalf("expected the run to wait without triggering publish, got %+v", run)
+	}
+	approvals, err := r.GetApprovals(&GetApprovalsInput{RunIDs: RunIDs{run.RunID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 1 || approvals[0].Status != ApprovalStatusWaiting || approvals[0].ExpiresAt.Sub(approvals[0].RequestedAt) != time.Hour {
+		t.Fatalf("expected a waiting approval expiring in an hour, got %+v", approvals)
+	}
+	decision := &DecideApprovalInput{RunID: run.RunID, Status: ApprovalStatusApproved, DecidedBy: "alice", Comment: "ok", DecidedAt: time.Now()}
+	if err := ResolveApproval(s.Repository(), decision); err != nil {
+		t.Fatal(err)
+	}
+	decision.Status = ApprovalStatusRejected
+	if err := ResolveApproval(s.Repository(), decision); err != ErrApprovalNotWaiting {
+		t.Errorf("expected a second decision to fail with %q, got %v", ErrApprovalNotWaiting, err)
+	}
+	runAll()
+	runs, err := r.GetRuns(&GetRunsInput{RunID: &run.RunID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[0].Status != RunStatusComplete || !runs[0].Success || runs[0].StatusDetail != "approved by alice" || string(runs[0].Output) != `{"rows":2}` {
+		t.Errorf("expected the approved run to succeed with its input, got %+v", runs[0])
+	}
+	if !runs[0].ScheduledStartTime.Equal(run.ScheduledStartTime) || !runs[0].NotBefore.Equal(decision.DecidedAt) {
+		t.Errorf("expected the decision to make the run due without moving its scheduled start time, got %+v", runs[0])
+	}
+	if count(publish) != 1 || count(alert) != 0 {
+		t.Errorf("expected the approval to trigger publish only, got %d publish and %d alert runs", count(publish), count(alert))
+	}
+
+	//expired
+	start()
+	run = waiting()
+	s.expireApprovals(time.Now().Add(30 * time.Minute))
+	waiting()
+	s.expireApprovals(time.Now().Add(2 * time.Hour))
+	runAll()
+	runs, err = r.GetRuns(&GetRunsInput{RunID: &run.RunID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[0].Status != RunStatusComplete || runs[0].Success || runs[0].StatusDetail != "expired" {
+		t.Errorf("expected the expired run to fail, got %+v", runs[0])
+	}
+	if count(publish) != 1 || count(alert) != 1 {
+		t.Errorf("expected the expiry to trigger alert only, got %d publish and %d alert runs", count(publish), count(alert))
+	}
+	approvals, err = r.GetApprovals(&GetApprovalsInput{RunIDs: RunIDs{run.RunID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approvals[0].Status != ApprovalStatusExpired || approvals[0].DecidedBy != "" || approvals[0].DecidedAt == nil {
+		t.Errorf("expected an expired approval, got %+v", approvals[0])
+	}
+
+	//a run that stopped waiting keeps its approval undecided
+	start()
+	run = waiting()
+	err = r.UpdateRun(&UpdateRunInput{RunID: run.RunID, Status: RunStatusPtr(RunStatusComplete), IfStatus: []RunStatus{RunStatusWaiting}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ResolveApproval(s.Repository(), &DecideApprovalInput{RunID: run.RunID, Status: ApprovalStatusApproved, DecidedBy: "alice", DecidedAt: time.Now()})
+	if err != ErrApprovalNotWaiting {
+		t.Errorf("expected %q deciding a run that isn't waiting, got %v", ErrApprovalNotWaiting, err)
+	}
+	approvals, err = r.GetApprovals(&GetApprovalsInput{RunIDs: RunIDs{run.RunID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approvals[0].Status != ApprovalStatusWaiting || approvals[0].DecidedAt != nil {
+		t.Errorf("expected the decision rolled back, got %+v", approvals[0])
+	}
+	s.expireApprovals(time.Now().Add(2 * time.Hour))
+	approvals, err = r.GetApprovals(&GetApprovalsInput{RunIDs: RunIDs{run.RunID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err = r.GetRuns(&GetRunsInput{RunID: &run.RunID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approvals[0].Status != ApprovalStatusExpired || runs[0].Status != RunStatusComplete {
+		t.Errorf("expected the approval of the stopped run to expire alone, got %+v and %+v", approvals[0], runs[0])
+	}
+
+	err = ResolveApproval(s.Repository(), &DecideApprovalInput{RunID: 100, Status: ApprovalStatusRejected, DecidedBy: "bob", DecidedAt: time.Now()})
+	if err != ErrApprovalNotFound {
+		t.Errorf("expected %q deciding an unknown run, got %v", ErrApprovalNotFound, err)
+	}
+}
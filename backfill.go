@@ -0,0 +1,306 @@
+package pipeline
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MaxBackfillDates is the max number of logical dates of a backfill
+const MaxBackfillDates = 1000
+
+const (
+	ErrBackfillNotFound   = Err("backfill not found")
+	ErrBackfillNotRunning = Err("backfill is not running")
+)
+
+type BackfillID uint64
+type BackfillIDs []BackfillID
+
+func (b BackfillID) String() string {
+	return strconv.FormatUint(uint64(b), 10)
+}
+
+type BackfillStatus string
+
+const (
+	BackfillStatusRunning   BackfillStatus = "running"
+	BackfillStatusComplete  BackfillStatus = "complete"
+	BackfillStatusCancelled BackfillStatus = "cancelled"
+)
+
+// Backfill reprocesses past logical dates of a job, with a run of the job
+// per date whose ScheduledStartTime is the date. Runs created from them by
+// retries and, if IncludeDownstream is set, by triggers belong to the
+// backfill too.
+type Backfill struct {
+	ID                BackfillID
+	JobID             JobID
+	Status            BackfillStatus
+	IncludeDownstream bool
+	MaxParallel       int //max runs of the backfill pending or running at once, 0 is unlimited
+	Dates             int //number of logical dates
+	FirstDate         time.Time
+	LastDate          time.Time
+	CreatedAt         time.Time
+	EndTime           *time.Time     //set once the backfill is complete or cancelled
+	Runs              map[string]int //number of runs of the backfill by RunState
+}
+
+// BackfillInput describes a backfill, the logical dates are either listed or
+// the fire times of a cron schedule in a time range
+type BackfillInput struct {
+	JobID        JobID
+	LogicalDates []time.Time
+	Start        time.Time    //first date of the range, inclusive
+	End          time.Time    //end of the range, exclusive
+	CronSchedule CronSchedule //defaults to the job's schedule
+
+	IncludeDownstream bool
+	MaxParallel       int
+}
+
+// Validate checks the input without the job, see Dates
+func (in *BackfillInput) Validate() error {
+	var errs []error
+	if in.JobID == 0 {
+		errs = append(errs, ErrFieldRequired{"JobID"})
+	}
+	ranged := !in.Start.IsZero() || !in.End.IsZero()
+	switch {
+	case len(in.LogicalDates) > 0 && ranged:
+		errs = append(errs, ErrFieldInvalid{"LogicalDates", "can't be combined with Start and End"})
+	case len(in.LogicalDates) > MaxBackfillDates:
+		errs = append(errs, ErrFieldInvalid{"LogicalDates", "more than " + strconv.Itoa(MaxBackfillDates) + " dates"})
+	case len(in.LogicalDates) == 0 && !ranged:
+		errs = append(errs, ErrFieldRequired{"LogicalDates"})
+	case ranged && (in.Start.IsZero() || in.End.IsZero()):
+		errs = append(errs, ErrFieldInvalid{"End", "Start and End are both required"})
+	case ranged && !in.Start.Before(in.End):
+		errs = append(errs, ErrFieldInvalid{"End", "must be after Start"})
+	}
+	if in.CronSchedule != "" {
+		if _, err := in.CronSchedule.Expression(); err != nil {
+			errs = append(errs, ErrFieldInvalid{"CronSchedule", err.Error()})
+		}
+	}
+	if in.MaxParallel < 0 {
+		errs = append(errs, ErrFieldInvalid{"MaxParallel", "must not be negative"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+// Dates returns the logical dates of the backfill of j, sorted and without
+// duplicates
+func (in *BackfillInput) Dates(j *Job) ([]time.Time, error) {
+	if len(in.LogicalDates) > 0 {
+		dates := append([]time.Time{}, in.LogicalDates...)
+		sort.Slice(dates, func(i, k int) bool { return dates[i].Before(dates[k]) })
+		unique := dates[:0]
+		for i, d := range dates {
+			if i == 0 || !d.Equal(dates[i-1]) {
+				unique = append(unique, d)
+			}
+		}
+		return unique, nil
+	}
+	schedule := in.CronSchedule
+	if schedule == "" {
+		schedule = j.Triggers.CronSchedule
+	}
+	if schedule == "" {
+		return nil, ErrFieldInvalid{"CronSchedule", "required, the job has no cron schedule"}
+	}
+	expr, err := schedule.Expression()
+	if err != nil {
+		return nil, ErrFieldInvalid{"CronSchedule", err.Error()}
+	}
+	var dates []time.Time
+	//cron fires on whole seconds, the second before Start lets Start fire
+	for t := expr.Next(in.Start.Add(-time.Second)); !t.IsZero() && t.Before(in.End); t = expr.Next(t) {
+		if len(dates) == MaxBackfillDates {
+			return nil, ErrFieldInvalid{"End", "more than " + strconv.Itoa(MaxBackfillDates) + " dates in the range"}
+		}
+		dates = append(dates, t)
+	}
+	if len(dates) == 0 {
+		return nil, ErrFieldInvalid{"End", "the schedule doesn't fire in the range"}
+	}
+	return dates, nil
+}
+
+// StartBackfill creates a backfill and the runs of its job, one per logical
+// date. The runs beyond MaxParallel are queued, the service starts them as
+// earlier runs of the backfill finish.
+func StartBackfill(r Repository, in *BackfillInput, now time.Time) (BackfillID, error) {
+	if err := in.Validate(); err != nil {
+		return 0, err
+	}
+	jobs, err := r.GetJobs(&GetJobsInput{JobIDs: JobIDs{in.JobID}})
+	if err != nil {
+		return 0, err
+	}
+	if len(jobs) != 1 {
+		return 0, ErrJobNotFound
+	}
+	job := jobs[0]
+	dates, err := in.Dates(job)
+	if err != nil {
+		return 0, err
+	}
+	runs := make([]*CreateRunInput, len(dates))
+	for i, d := range dates {
+		run, err := job.MakeRun(JobContext{ScheduledStartTime: d, PreviousOutput: []byte("{}")})
+		if err != nil {
+			return 0, ErrFieldInvalid{"JobID", "err rendering input: " + err.Error()}
+		}
+		runs[i] = run.CreateRunInput()
+		if in.MaxParallel > 0 && i >= in.MaxParallel {
+			runs[i].Status = RunStatusPtr(RunStatusQueued)
+		}
+	}
+	return r.CreateBackfill(&CreateBackfillInput{
+		JobID:             in.JobID,
+		IncludeDownstream: in.IncludeDownstream,
+		MaxParallel:       in.MaxParallel,
+		Runs:              runs,
+		CreatedAt:         now,
+	})
+}
+
+// CancelBackfill stops a running backfill, cancelling its runs that aren't
+// running: queued, pending, joining and waiting runs. Runs that are running,
+// including the ones the service claims while the backfill is cancelled, are
+// left to finish but trigger nothing. The backfill and its runs change
+// together when r is a Transactor.
+func CancelBackfill(r Repository, id BackfillID, now time.Time) error {
+	return inTx(r, func(r Repository) error {
+		backfills, err := r.GetBackfills(&GetBackfillsInput{BackfillIDs: BackfillIDs{id}})
+		if err != nil {
+			return err
+		}
+		if len(backfills) != 1 {
+			return ErrBackfillNotFound
+		}
+		if backfills[0].Status != BackfillStatusRunning {
+			return ErrBackfillNotRunning
+		}
+		status := BackfillStatusCancelled
+		err = r.UpdateBackfill(&UpdateBackfillInput{BackfillID: id, Status: &status, EndTime: &now})
+		if err != nil {
+			return err
+		}
+		runs, err := r.GetRuns(&GetRunsInput{
+			BackfillID: &id,
+			Statuses:   []RunStatus{RunStatusQueued, RunStatusPending, RunStatusJoining, RunStatusWaiting},
+			Summary:    true,
+		})
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			err := r.UpdateRun(&UpdateRunInput{
+				RunID:        run.RunID,
+				Status:       RunStatusPtr(RunStatusCancelled),
+				StatusDetail: StringPtr("backfill cancelled"),
+				EndTime:      &now,
+				IfStatus:     []RunStatus{run.Status},
+			})
+			if err == ErrRunStatusChanged {
+				//claimed since, it finishes without triggering
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// holdBackfillSlot keeps the slot of r, a run of a backfill the service is
+// processing, until its downstream runs exist. Otherwise a run that was just
+// completed would free its slot for a queued run before its downstream runs
+// take it.
+func (s *Service) holdBackfillSlot(r *Run) {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	s.backfillSlots[r.RunID] = r.BackfillID
+}
+
+func (s *Service) releaseBackfillSlot(r *Run) {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	delete(s.backfillSlots, r.RunID)
+}
+
+// backfillRunFinished is called when r, a run of a backfill, has finished
+// for good, after its downstream runs were created, or waits for an
+// approval. It starts queued runs while fewer than MaxParallel runs of the
+// backfill are pending or running, and completes the backfill once none of
+// its runs are left.
+func (s *Service) backfillRunFinished(r *Run) error {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	delete(s.backfillSlots, r.RunID)
+
+	id := r.BackfillID
+	backfills, err := s.repo.GetBackfills(&GetBackfillsInput{BackfillIDs: BackfillIDs{id}})
+	if err != nil {
+		return err
+	}
+	if len(backfills) != 1 || backfills[0].Status != BackfillStatusRunning {
+		return nil
+	}
+	b := backfills[0]
+	runs, err := s.repo.GetRuns(&GetRunsInput{
+		BackfillID: &id,
+		Statuses:   []RunStatus{RunStatusQueued, RunStatusPending, RunStatusRunning, RunStatusJoining, RunStatusWaiting},
+		OrderBy:    StringPtr(RunsOrderByID),
+		Summary:    true,
+	})
+	if err != nil {
+		return err
+	}
+	active, joining := 0, 0
+	var queued []*Run
+	listed := map[RunID]bool{}
+	for _, run := range runs {
+		listed[run.RunID] = true
+		switch run.Status {
+		case RunStatusQueued:
+			queued = append(queued, run)
+		case RunStatusJoining, RunStatusWaiting:
+			//waits for other runs or an approval, it doesn't take a slot
+			joining++
+		default:
+			active++
+		}
+	}
+	for held, backfill := range s.backfillSlots {
+		if backfill == id && !listed[held] {
+			//completed, its downstream runs don't exist yet
+			active++
+		}
+	}
+	for _, q := range queued {
+		if b.MaxParallel > 0 && active >= b.MaxParallel {
+			break
+		}
+		//the scheduled start time stays the logical date
+		err := s.repo.UpdateRun(&UpdateRunInput{RunID: q.RunID, Status: RunStatusPtr(RunStatusPending)})
+		if err != nil {
+			return err
+		}
+		active++
+	}
+	if active > 0 || joining > 0 || len(queued) > 0 {
+		return nil
+	}
+	now := time.Now()
+	status := BackfillStatusComplete
+	return s.repo.UpdateBackfill(&UpdateBackfillInput{BackfillID: id, Status: &status, EndTime: &now})
+}
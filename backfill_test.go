@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackfillDates(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2017, 3, d, 2, 0, 0, 0, time.UTC) }
+	job := &Job{Triggers: TriggerEvents{CronSchedule: "0 2 * * *"}}
+	tests := []struct {
+		in       BackfillInput
+		expected []time.Time
+		err      string
+	}{
+		{in: BackfillInput{Start: day(1), End: day(4)}, expected: []time.Time{day(1), day(2), day(3)}},
+		{in: BackfillInput{Start: day(1), End: day(3), CronSchedule: "0 2 */2 * *"}, expected: []time.Time{day(1)}},
+		{in: BackfillInput{LogicalDates: []time.Time{day(3), day(1), day(3)}}, expected: []time.Time{day(1), day(3)}},
+		{in: BackfillInput{}, err: "LogicalDates"},
+		{in: BackfillInput{Start: day(1)}, err: "Start and End are both required"},
+		{in: BackfillInput{Start: day(2), End: day(1)}, err: "must be after Start"},
+		{in: BackfillInput{LogicalDates: []time.Time{day(1)}, Start: day(1), End: day(2)}, err: "can't be combined"},
+		{in: BackfillInput{Start: day(1), End: day(2), MaxParallel: -1}, err: "must not be negative"},
+		{in: BackfillInput{Start: day(1).Add(time.Hour), End: day(2)}, err: "doesn't fire in the range"},
+		{in: BackfillInput{Start: day(1), End: day(1).AddDate(10, 0, 0)}, err: "more than 1000 dates"},
+	}
+	for _, test := range tests {
+		test.in.JobID = 1
+		err := test.in.Validate()
+		var dates []time.Time
+		if err == nil {
+			dates, err = test.in.Dates(job)
+		}
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%+v: expected err %q, got %v", test.in, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %s", test.in, err)
+		} else if !reflect.DeepEqual(test.expected, dates) {
+			t.Errorf("%+v: expected dates %v, got %v", test.in, test.expected, dates)
+		}
+	}
+}
+
+func TestServiceBackfill(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func([]byte) (*RunResult, error) {
+			return &RunResult{Success: true}, nil
+		}), nil
+	})
+	extract, err := r.CreateJob(&CreateJobInput{
+		Name:                 "extract",
+		Processor:            ProcessorConfig{Type: "test"},
+		InputPayloadTemplate: []byte("{}"),
+		Triggers:             &TriggerEventsInput{CronSchedule: NewCronSchedule("0 2 * * *")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	load, err := r.CreateJob(&CreateJobInput{
+		Name:                 "load",
+		Processor:            ProcessorConfig{Type: "test"},
+		InputPayloadTemplate: []byte(`{"day":"{{scheduledStartTime | date "2006-01-02"}}"}`),
+		Triggers:             &TriggerEventsInput{JobSuccess: JobIDs{extract}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2017, 3, d, 2, 0, 0, 0, time.UTC) }
+	id, err := StartBackfill(r, &BackfillInput{
+		JobID:             extract,
+		Start:             day(1),
+		End:               day(4),
+		IncludeDownstream: true,
+		MaxParallel:       2,
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := r.GetRuns(&GetRunsInput{JobID: &extract, OrderBy: StringPtr(RunsOrderByID), Summary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("expected 3 runs of the backfilled job, got %d", len(runs))
+	}
+	for i, run := range runs {
+		expected := RunStatusPending
+		if i == 2 {
+			expected = RunStatusQueued
+		}
+		if run.Status != expected || run.BackfillID != id || !run.ScheduledStartTime.Equal(day(i+1)) {
+			t.Errorf("run %d: expected a %s run of backfill %s at %s, got %+v", i, expected, id, day(i+1), run)
+		}
+	}
+
+	tick := func() {
+		s.startDueRuns(time.Now().Add(time.Minute))
+		s.running.Wait()
+	}
+	tick()
+	//the runs of load take the slots, the last date waits
+	queued, err := r.GetRuns(&GetRunsInput{BackfillID: &id, Status: RunStatusPtr(RunStatusQueued), Summary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 {
+		t.Errorf("expected the last date to be queued while the downstream runs are pending, got %d queued runs", len(queued))
+	}
+	for i := 0; i < 5; i++ {
+		tick()
+	}
+
+	backfills, err := r.GetBackfills(&GetBackfillsInput{BackfillIDs: BackfillIDs{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backfills) != 1 {
+		t.Fatalf("expected backfill %s, got %d backfills", id, len(backfills))
+	}
+	b := backfills[0]
+	if b.Status != BackfillStatusComplete || b.EndTime == nil {
+		t.Errorf("expected a complete backfill, got %+v", b)
+	}
+	if b.Dates != 3 || !b.FirstDate.Equal(day(1)) || !b.LastDate.Equal(day(3)) {
+		t.Errorf("expected 3 dates from %s to %s, got %+v", day(1), day(3), b)
+	}
+	if !reflect.DeepEqual(map[string]int{"succeeded": 6}, b.Runs) {
+		t.Errorf("expected 6 succeeded runs, got %v", b.Runs)
+	}
+	loads, err := r.GetRuns(&GetRunsInput{JobID: &load, BackfillID: &id, OrderBy: StringPtr(RunsOrderByScheduledStartTime)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loads) != 3 {
+		t.Fatalf("expected a downstream run per date, got %d", len(loads))
+	}
+	//downstream runs keep the logical date of the backfilled run
+	for i, run := range loads {
+		input := `{"day":"` + day(i+1).Format("2006-01-02") + `"}`
+		if !run.ScheduledStartTime.Equal(day(i+1)) || string(run.Input) != input {
+			t.Errorf("load %d: expected the logical date %s, got %s with input %s", i, day(i+1), run.ScheduledStartTime, run.Input)
+		}
+	}
+}
+
+func TestCancelBackfill(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	job, err := r.CreateJob(&CreateJobInput{Name: "extract", Processor: ProcessorConfig{Type: "test"}, InputPayloadTemplate: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dates := []time.Time{
+		time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2017, 3, 3, 0, 0, 0, 0, time.UTC),
+	}
+	id, err := StartBackfill(r, &BackfillInput{JobID: job, LogicalDates: dates, MaxParallel: 2}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the first run waits for an approval, the second one is running
+	runs, err := r.GetRuns(&GetRunsInput{BackfillID: &id, OrderBy: StringPtr(RunsOrderByID), Summary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range []RunStatus{RunStatusWaiting, RunStatusRunning} {
+		if err := r.UpdateRun(&UpdateRunInput{RunID: runs[i].RunID, Status: RunStatusPtr(status)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CancelBackfill(r, id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	backfills, err := r.GetBackfills(&GetBackfillsInput{BackfillIDs: BackfillIDs{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backfills) != 1 || backfills[0].Status != BackfillStatusCancelled {
+		t.Fatalf("expected a cancelled backfill, got %+v", backfills)
+	}
+	if !reflect.DeepEqual(map[string]int{"cancelled": 2, "running": 1}, backfills[0].Runs) {
+		t.Errorf("expected the waiting and the queued run to be cancelled, got %v", backfills[0].Runs)
+	}
+	if err := CancelBackfill(r, id, time.Now()); err != ErrBackfillNotRunning {
+		t.Errorf("expected %q cancelling again, got %v", ErrBackfillNotRunning, err)
+	}
+	if err := CancelBackfill(r, id+1, time.Now()); err != ErrBackfillNotFound {
+		t.Errorf("expected %q cancelling an unknown backfill, got %v", ErrBackfillNotFound, err)
+	}
+}
@@ -0,0 +1,233 @@
+package pipeline
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+)
+
+// DefaultBlobInlineLimit is the largest payload, in bytes, stored in the runs
+// table by BlobWrapper
+const DefaultBlobInlineLimit = 64 * 1024
+
+const ErrBlobNotFound = Err("blob not found")
+
+// BlobStore stores run payloads outside of the repository
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error) //returns ErrBlobNotFound for unknown keys
+	Delete(key string) error
+}
+
+// BlobRef is the key of a payload in a BlobStore, empty if the payload is
+// stored inline
+type BlobRef string
+
+func (b BlobRef) String() string {
+	return string(b)
+}
+
+func BlobRefPtr(b BlobRef) *BlobRef {
+	return &b
+}
+
+// BlobWrapper moves run inputs, outputs and logs larger than InlineLimit out
+// of the wrapped repository and into Store. Runs returned by GetRuns carry
+// the references, call Run.LoadPayloads to fetch them.
+type BlobWrapper struct {
+	Repository
+	Store       BlobStore
+	InlineLimit int
+}
+
+func NewBlobWrapper(r Repository, s BlobStore, inlineLimit int) *BlobWrapper {
+	return &BlobWrapper{
+		Repository:  r,
+		Store:       s,
+		InlineLimit: inlineLimit,
+	}
+}
+
+// InTx calls f with the transaction of the wrapped repository, wrapped too
+func (b *BlobWrapper) InTx(f func(Repository) error) error {
+	return inTx(b.Repository, func(r Repository) error {
+		return f(NewBlobWrapper(r, b.Store, b.InlineLimit))
+	})
+}
+
+func (b *BlobWrapper) CreateRun(in *CreateRunInput) (RunID, error) {
+	c, refs, err := b.offloadRun(in)
+	if err != nil {
+		return 0, err
+	}
+	id, err := b.Repository.CreateRun(c)
+	if err != nil {
+		b.deleteBlobs(refs)
+		return 0, err
+	}
+	return id, nil
+}
+
+// CreateWebhookRun offloads the payloads of the run like CreateRun, a
+// duplicate delivery creates no run and keeps no blobs
+func (b *BlobWrapper) CreateWebhookRun(in *CreateWebhookRunInput) (RunID, error) {
+	c, refs, err := b.offloadRun(in.Run)
+	if err != nil {
+		return 0, err
+	}
+	w := *in
+	w.Run = c
+	id, err := b.Repository.CreateWebhookRun(&w)
+	if err != nil {
+		b.deleteBlobs(refs)
+	}
+	return id, err
+}
+
+// offloadRun returns a copy of in whose large payloads are in the store, and
+// the refs of the blobs written
+func (b *BlobWrapper) offloadRun(in *CreateRunInput) (*CreateRunInput, []BlobRef, error) {
+	c := *in
+	prefix, err := newBlobPrefix(in.JobID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var refs []BlobRef
+	for _, p := range []struct {
+		name string
+		data *[]byte
+		ref  *BlobRef
+	}{
+		{"input", &c.Input, &c.InputRef},
+		{"output", &c.Output, &c.OutputRef},
+		{"log", &c.Log, &c.LogRef},
+	} {
+		ref, err := b.offload(path.Join(prefix, p.name), p.data)
+		if err != nil {
+			b.deleteBlobs(refs)
+			return nil, nil, err
+		}
+		if ref != "" {
+			*p.ref = ref
+			refs = append(refs, ref)
+		}
+	}
+	return &c, refs, nil
+}
+
+func (b *BlobWrapper) UpdateRun(in *UpdateRunInput) error {
+	if in.Input == nil && in.Output == nil && in.Log == nil {
+		return b.Repository.UpdateRun(in)
+	}
+	runs, err := b.Repository.GetRuns(&GetRunsInput{RunID: &in.RunID, Summary: true})
+	if err != nil {
+		return err
+	}
+	if len(runs) != 1 {
+		return ErrRunNotFound
+	}
+	prev := runs[0]
+	prefix, err := newBlobPrefix(prev.JobID)
+	if err != nil {
+		return err
+	}
+
+	u := *in
+	var added, replaced []BlobRef
+	for _, p := range []struct {
+		name string
+		data *[]byte
+		ref  **BlobRef
+		prev BlobRef
+	}{
+		{"input", &u.Input, &u.InputRef, prev.InputRef},
+		{"output", &u.Output, &u.OutputRef, prev.OutputRef},
+		{"log", &u.Log, &u.LogRef, prev.LogRef},
+	} {
+		if *p.data == nil {
+			continue
+		}
+		ref, err := b.offload(path.Join(prefix, p.name), p.data)
+		if err != nil {
+			b.deleteBlobs(added)
+			return err
+		}
+		//always set the ref so an inline value clears a previous blob
+		*p.ref = &ref
+		if ref != "" {
+			added = append(added, ref)
+		}
+		if p.prev != "" {
+			replaced = append(replaced, p.prev)
+		}
+	}
+	if err := b.Repository.UpdateRun(&u); err != nil {
+		b.deleteBlobs(added)
+		return err
+	}
+	b.deleteBlobs(replaced)
+	return nil
+}
+
+func (b *BlobWrapper) PruneRuns(in *PruneRunsInput) (*PruneRunsOutput, error) {
+	out, err := b.Repository.PruneRuns(in)
+	if err != nil {
+		return nil, err
+	}
+	b.deleteBlobs(out.Refs)
+	return out, nil
+}
+
+// offload writes data to the store if it is over the inline limit, leaving an
+// empty inline value behind
+func (b *BlobWrapper) offload(key string, data *[]byte) (BlobRef, error) {
+	if len(*data) <= b.InlineLimit {
+		return "", nil
+	}
+	if err := b.Store.Put(key, *data); err != nil {
+		return "", err
+	}
+	*data = []byte{}
+	return BlobRef(key), nil
+}
+
+// deleteBlobs is best effort, an orphaned blob is preferable to a failed run
+func (b *BlobWrapper) deleteBlobs(refs []BlobRef) {
+	for _, r := range refs {
+		_ = b.Store.Delete(string(r))
+	}
+}
+
+func newBlobPrefix(j JobID) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return path.Join("runs", j.String(), hex.EncodeToString(id)), nil
+}
+
+// LoadPayloads fetches the input, output and log of the run that are stored
+// out of line. s can be nil if the run is known to be stored inline.
+func (r *Run) LoadPayloads(s BlobStore) error {
+	for _, p := range []struct {
+		data *[]byte
+		ref  *BlobRef
+	}{
+		{&r.Input, &r.InputRef},
+		{&r.Output, &r.OutputRef},
+		{&r.Log, &r.LogRef},
+	} {
+		if *p.ref == "" || len(*p.data) > 0 {
+			continue
+		}
+		if s == nil {
+			return Err("run " + r.RunID.String() + " has external payloads but no blob store")
+		}
+		d, err := s.Get(string(*p.ref))
+		if err != nil {
+			return err
+		}
+		*p.data = d
+	}
+	return nil
+}
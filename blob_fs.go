@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FSBlobStore stores blobs as files below Dir
+type FSBlobStore struct {
+	Dir string
+}
+
+func NewFSBlobStore(dir string) (*FSBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FSBlobStore{Dir: dir}, nil
+}
+
+func (f *FSBlobStore) path(key string) (string, error) {
+	p := filepath.Join(f.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(f.Dir)+string(filepath.Separator)) {
+		return "", Err("invalid blob key: " + key)
+	}
+	return p, nil
+}
+
+func (f *FSBlobStore) Put(key string, data []byte) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	//write to a temp file first so readers never see a partial blob
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (f *FSBlobStore) Get(key string) ([]byte, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return d, err
+}
+
+func (f *FSBlobStore) Delete(key string) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Client is the subset of *s3.S3 used by S3BlobStore. Point the client's
+// Endpoint at any S3 compatible server (minio, localstack...) to store blobs
+// outside of AWS.
+type S3Client interface {
+	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	DeleteObject(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+}
+
+// S3BlobStore stores blobs as objects in Bucket, with keys below Prefix
+type S3BlobStore struct {
+	Client S3Client
+	Bucket string
+	Prefix string
+}
+
+func NewS3BlobStore(c S3Client, bucket, prefix string) *S3BlobStore {
+	return &S3BlobStore{
+		Client: c,
+		Bucket: bucket,
+		Prefix: prefix,
+	}
+}
+
+func (s *S3BlobStore) key(k string) *string {
+	return aws.String(path.Join(s.Prefix, k))
+}
+
+func (s *S3BlobStore) Put(key string, data []byte) error {
+	_, err := s.Client.PutObject(&s3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           s.key(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	return err
+}
+
+func (s *S3BlobStore) Get(key string) ([]byte, error) {
+	out, err := s.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    s.key(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (s *S3BlobStore) Delete(key string) error {
+	_, err := s.Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    s.key(key),
+	})
+	return err
+}
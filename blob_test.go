@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// memS3 stands in for an S3 compatible server
+type memS3 struct {
+	objects map[string][]byte
+}
+
+func newMemS3() *memS3 {
+	return &memS3{objects: map[string][]byte{}}
+}
+
+func (m *memS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	d, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)] = d
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *memS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	d, ok := m.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(d))}, nil
+}
+
+func (m *memS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(m.objects, aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func newTestFSBlobStore(t *testing.T) (*FSBlobStore, func()) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFSBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestBlobStores(t *testing.T) {
+	fs, cleanup := newTestFSBlobStore(t)
+	defer cleanup()
+	stores := map[string]BlobStore{
+		"fs": fs,
+		"s3": NewS3BlobStore(newMemS3(), "bucket", "pipeline"),
+	}
+	for name, s := range stores {
+		if err := s.Put("runs/1/abc/input", []byte("data")); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		d, err := s.Get("runs/1/abc/input")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if string(d) != "data" {
+			t.Errorf("%s: expected 'data', got '%s'", name, d)
+		}
+		if err := s.Delete("runs/1/abc/input"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := s.Get("runs/1/abc/input"); err != ErrBlobNotFound {
+			t.Errorf("%s: expected ErrBlobNotFound, got %v", name, err)
+		}
+		if err := s.Delete("runs/1/abc/input"); err != nil {
+			t.Errorf("%s: deleting missing blob: %s", name, err)
+		}
+	}
+
+	if err := fs.Put("../escape", []byte("data")); err == nil {
+		t.Error("fs: expected err for key outside of dir")
+	}
+}
+
+func TestBlobWrapper(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	store, cleanup := newTestFSBlobStore(t)
+	defer cleanup()
+	b := NewBlobWrapper(r, store, 4)
+
+	id, err := b.CreateRun(&CreateRunInput{
+		JobID:  1,
+		Input:  []byte("large input"),
+		Output: []byte("out"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := b.GetRuns(&GetRunsInput{RunID: &id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := runs[0]
+	if run.InputRef == "" || len(run.Input) != 0 {
+		t.Fatalf("expected input to be stored out of line, got ref '%s' and input '%s'", run.InputRef, run.Input)
+	}
+	if run.OutputRef != "" || string(run.Output) != "out" {
+		t.Errorf("expected output to be inline, got ref '%s' and output '%s'", run.OutputRef, run.Output)
+	}
+	if err := run.LoadPayloads(store); err != nil {
+		t.Fatal(err)
+	}
+	if string(run.Input) != "large input" {
+		t.Errorf("expected loaded input 'large input', got '%s'", run.Input)
+	}
+	if err := run.LoadPayloads(nil); err != nil {
+		t.Errorf("expected no err reloading loaded payloads, got %s", err)
+	}
+
+	//replacing the input with a small value removes the blob
+	prevRef := run.InputRef
+	err = b.UpdateRun(&UpdateRunInput{RunID: id, Input: []byte("in"), Log: []byte("large log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(prevRef.String()); err != ErrBlobNotFound {
+		t.Errorf("expected replaced blob to be deleted, got %v", err)
+	}
+	runs, err = b.GetRuns(&GetRunsInput{RunID: &id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run = runs[0]
+	if err := run.LoadPayloads(store); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]byte{"input": []byte("in"), "output": []byte("out"), "log": []byte("large log")}
+	got := map[string][]byte{"input": run.Input, "output": run.Output, "log": run.Log}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if run.InputRef != "" || run.LogRef == "" {
+		t.Errorf("expected only log to be out of line, got input ref '%s', log ref '%s'", run.InputRef, run.LogRef)
+	}
+}
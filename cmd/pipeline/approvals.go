@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/robstrong/pipeline/api"
+)
+
+func (c *cli) listApprovals(args []string) error {
+	fs := newFlagSet("approvals list")
+	jobs := fs.String("job", "", "comma separated job ids")
+	status := fs.String("status", "waiting", "comma separated statuses: waiting, approved, rejected, expired, empty for all")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	q := url.Values{}
+	if *jobs != "" {
+		q.Set("job_id", *jobs)
+	}
+	if *status != "" {
+		q.Set("status", *status)
+	}
+	approvals := []*api.Approval{}
+	if err := cl.do("GET", "/approvals?"+q.Encode(), nil, &approvals); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tJOB\tSTATUS\tREQUESTED\tEXPIRES\tDECIDED BY\tCOMMENT")
+	for _, a := range approvals {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			a.RunID, a.JobID, a.Status, formatTime(&a.RequestedAt), formatTime(&a.ExpiresAt), a.DecidedBy, a.Comment)
+	}
+	return w.Flush()
+}
+
+func (c *cli) approve(args []string) error {
+	return c.decide("approvals approve", "/approve", args)
+}
+
+func (c *cli) reject(args []string) error {
+	return c.decide("approvals reject", "/reject", args)
+}
+
+func (c *cli) decide(name, suffix string, args []string) error {
+	fs := newFlagSet(name)
+	by := fs.String("by", os.Getenv("USER"), "who decides, defaults to $USER")
+	comment := fs.String("comment", "", "reason for the decision")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	a := &api.Approval{}
+	if err := cl.do("POST", "/runs/"+pos[0]+suffix, &api.DecisionInput{By: *by, Comment: *comment}, a); err != nil {
+		return err
+	}
+	return c.printJSON(a)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/api"
+)
+
+func (c *cli) createBackfill(args []string) error {
+	fs := newFlagSet("backfills create")
+	start := fs.String("start", "", "first logical date of the range, inclusive")
+	end := fs.String("end", "", "end of the range, exclusive")
+	cron := fs.String("cron", "", "schedule whose fire times in the range are the dates, defaults to the job's")
+	dates := fs.String("dates", "", "comma separated logical dates, instead of a range")
+	downstream := fs.Bool("downstream", false, "trigger the downstream jobs of the backfilled runs")
+	maxParallel := fs.Int("max-parallel", 0, "max runs of the backfill pending or running at once, 0 is unlimited")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	in := &api.BackfillInput{
+		CronSchedule:      pipeline.CronSchedule(*cron),
+		IncludeDownstream: *downstream,
+		MaxParallel:       *maxParallel,
+	}
+	if *start != "" {
+		if in.Start, err = parseDate(*start); err != nil {
+			return err
+		}
+	}
+	if *end != "" {
+		if in.End, err = parseDate(*end); err != nil {
+			return err
+		}
+	}
+	for _, d := range strings.Split(*dates, ",") {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		t, err := parseDate(d)
+		if err != nil {
+			return err
+		}
+		in.LogicalDates = append(in.LogicalDates, t)
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	b := &api.Backfill{}
+	if err := cl.do("POST", "/jobs/"+pos[0]+"/backfill", in, b); err != nil {
+		return err
+	}
+	return c.printJSON(b)
+}
+
+func (c *cli) listBackfills(args []string) error {
+	fs := newFlagSet("backfills list")
+	jobs := fs.String("job", "", "comma separated job ids")
+	status := fs.String("status", "", "comma separated statuses: running, complete, cancelled")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	q := url.Values{}
+	if *jobs != "" {
+		q.Set("job_id", *jobs)
+	}
+	if *status != "" {
+		q.Set("status", *status)
+	}
+	backfills := []*api.Backfill{}
+	if err := cl.do("GET", "/backfills?"+q.Encode(), nil, &backfills); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tJOB\tSTATUS\tDATES\tFIRST\tLAST\tDOWNSTREAM\tRUNS")
+	for _, b := range backfills {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\t%t\t%s\n",
+			b.ID, b.JobID, b.Status, b.Dates, formatTime(&b.FirstDate), formatTime(&b.LastDate),
+			b.IncludeDownstream, formatCounts(b.Runs))
+	}
+	return w.Flush()
+}
+
+func (c *cli) describeBackfill(args []string) error {
+	return c.backfillRequest("backfills describe", "GET", "", args)
+}
+
+func (c *cli) cancelBackfill(args []string) error {
+	return c.backfillRequest("backfills cancel", "POST", "/cancel", args)
+}
+
+func (c *cli) backfillRequest(name, method, suffix string, args []string) error {
+	pos, err := parseArgs(newFlagSet(name), args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	b := &api.Backfill{}
+	if err := cl.do(method, "/backfills/"+pos[0]+suffix, nil, b); err != nil {
+		return err
+	}
+	return c.printJSON(b)
+}
+
+// parseDate parses an RFC 3339 time or a UTC date such as 2017-03-01
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected 2006-01-02 or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// formatCounts formats counts as "failed=1 succeeded=3"
+func formatCounts(counts map[string]int) string {
+	var pairs []string
+	for k, n := range counts {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", k, n))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
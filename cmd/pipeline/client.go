@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/api"
+)
+
+// client sends requests to the REST API. For a SQLite database the API is
+// served in process, so both backends behave the same.
+type client struct {
+	base string
+	http *http.Client
+	db   *sql.DB //set for SQLite databases
+}
+
+func newAPIClient(base string) *client {
+	return &client{base: base, http: &http.Client{}}
+}
+
+func newSQLiteClient(path, blobDir string) (*client, error) {
+	db, err := openDB(path)
+	if err != nil {
+		return nil, err
+	}
+	repo, store, err := openRepo(db, blobDir)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	srv := api.NewServer(repo)
+	srv.BlobStore = store
+	return &client{
+		base: "http://sqlite",
+		http: &http.Client{Transport: handlerTransport{srv}},
+		db:   db,
+	}, nil
+}
+
+func openDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("err opening %s: %s", path, err)
+	}
+	return db, nil
+}
+
+// openRepo returns the repository of db, it stores payloads larger than
+// pipeline.DefaultBlobInlineLimit in blobDir if it's set. serve and the
+// commands reading runs must agree on blobDir.
+func openRepo(db *sql.DB, blobDir string) (pipeline.Repository, pipeline.BlobStore, error) {
+	var repo pipeline.Repository = pipeline.NewSQLiteRepo(db)
+	if blobDir == "" {
+		return repo, nil, nil
+	}
+	store, err := pipeline.NewFSBlobStore(blobDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pipeline.NewBlobWrapper(repo, store, pipeline.DefaultBlobInlineLimit), store, nil
+}
+
+func (c *client) Close() error {
+	if c.db != nil {
+		return c.db.Close()
+	}
+	return nil
+}
+
+// handlerTransport serves requests with a handler instead of the network
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, r)
+	return rec.Result(), nil
+}
+
+// do sends body as JSON and decodes the response into out. Error responses
+// are returned as errors.
+func (c *client) do(method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		d, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(d)
+	}
+	req, err := http.NewRequest(method, c.base+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return responseError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func responseError(resp *http.Response) error {
+	e := &api.ErrorResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(e); err != nil || e.Error == "" {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	if len(e.Fields) == 0 {
+		return errors.New(e.Error)
+	}
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.Field + " " + f.Message
+	}
+	return fmt.Errorf("%s: %s", e.Error, strings.Join(fields, ", "))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robstrong/pipeline/api"
+)
+
+func (c *cli) listExecutions(args []string) error {
+	fs := newFlagSet("executions list")
+	jobs := fs.String("job", "", "comma separated ids of the jobs that started the executions")
+	limit := fs.Uint64("limit", 20, "max number of executions")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	q := url.Values{"limit": {strconv.FormatUint(*limit, 10)}}
+	if *jobs != "" {
+		q.Set("job_id", *jobs)
+	}
+	list := &api.ExecutionList{}
+	if err := cl.do("GET", "/executions?"+q.Encode(), nil, list); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tJOB\tSTATUS\tSTARTED\tDURATION\tRUNS\tSUCCEEDED\tFAILED\tCANCELLED")
+	for _, e := range list.Executions {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n",
+			e.ID, e.JobID, e.Status, formatTime(e.StartTime),
+			time.Duration(e.Duration).Round(time.Millisecond), e.Runs, e.Succeeded, e.Failed, e.Cancelled)
+	}
+	return w.Flush()
+}
+
+func (c *cli) describeExecution(args []string) error {
+	pos, err := parseArgs(newFlagSet("executions describe"), args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	e := &api.Execution{}
+	if err := cl.do("GET", "/executions/"+pos[0], nil, e); err != nil {
+		return err
+	}
+	return c.printJSON(e)
+}
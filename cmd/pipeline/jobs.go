@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/api"
+)
+
+func (c *cli) listJobs(args []string) error {
+	if _, err := parseArgs(newFlagSet("jobs list"), args, 0); err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	jobs := []*api.Job{}
+	if err := cl.do("GET", "/jobs", nil, &jobs); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPROCESSOR\tSCHEDULE\tNEXT\tON SUCCESS OF\tON FAILURE OF\tPAUSED")
+	for _, j := range jobs {
+		next := ""
+		if len(j.NextFireTimes) > 0 {
+			next = j.NextFireTimes[0].Local().Format(timeFormat)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%t\n",
+			j.ID, j.Name, j.Processor.Type, j.Triggers.CronSchedule, next,
+			joinIDs(j.Triggers.JobSuccess), joinIDs(j.Triggers.JobFailure), j.Paused)
+	}
+	return w.Flush()
+}
+
+func (c *cli) describeJob(args []string) error {
+	pos, err := parseArgs(newFlagSet("jobs describe"), args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	job := &api.Job{}
+	if err := cl.do("GET", "/jobs/"+pos[0], nil, job); err != nil {
+		return err
+	}
+	return c.printJSON(job)
+}
+
+func (c *cli) createJob(args []string) error {
+	fs := newFlagSet("jobs create")
+	jf := addJobFlags(fs)
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	in, err := jf.input(fs)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	job := &api.Job{}
+	if err := cl.do("POST", "/jobs", in, job); err != nil {
+		return err
+	}
+	return c.printJSON(job)
+}
+
+func (c *cli) updateJob(args []string) error {
+	fs := newFlagSet("jobs update")
+	jf := addJobFlags(fs)
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	in, err := jf.input(fs)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	job := &api.Job{}
+	if err := cl.do("PATCH", "/jobs/"+pos[0], in, job); err != nil {
+		return err
+	}
+	return c.printJSON(job)
+}
+
+// jobFlags are the flags of jobs create and update. Only the flags that are
+// set are sent, on top of the -f file if there is one.
+type jobFlags struct {
+	file            string
+	name            string
+	processor       string
+	processorConfig keyValues
+	template        string
+	retries         int
+	cron            string
+	onSuccess       string
+	onFailure       string
+	onSuccessIf     keyValues
+	onFailureIf     keyValues
+	onSuccessAfter  keyValues
+	onFailureAfter  keyValues
+	loopLimit       int
+	join            bool
+	joinTimeout     time.Duration
+	mapPath         string
+	mapMaxParallel  int
+	onMapComplete   string
+	sensorReadyIf   string
+	sensorInterval  time.Duration
+	sensorTimeout   time.Duration
+	watchDir        string
+	watchPattern    string
+	watchRecursive  bool
+	watchStableFor  time.Duration
+	watchDebounce   time.Duration
+}
+
+func addJobFlags(fs *flag.FlagSet) *jobFlags {
+	f := &jobFlags{processorConfig: keyValues{}, onSuccessIf: keyValues{}, onFailureIf: keyValues{},
+		onSuccessAfter: keyValues{}, onFailureAfter: keyValues{}}
+	fs.StringVar(&f.file, "f", "", "JSON job definition, as sent to the API, - reads stdin")
+	fs.StringVar(&f.name, "name", "", "job name")
+	fs.StringVar(&f.processor, "processor", "", "processor type, e.g. lambda")
+	fs.Var(f.processorConfig, "config", "processor config `key=value`, repeatable")
+	fs.StringVar(&f.template, "template", "", "input payload template, @file reads a file")
+	fs.IntVar(&f.retries, "retries", 0, "number of times a failed run is retried")
+	fs.StringVar(&f.cron, "cron", "", "cron schedule, empty to remove")
+	fs.StringVar(&f.onSuccess, "on-success", "", "comma separated ids of the jobs whose success triggers this job")
+	fs.StringVar(&f.onFailure, "on-failure", "", "comma separated ids of the jobs whose failure triggers this job")
+	fs.Var(f.onSuccessIf, "on-success-if", "condition on the output of an -on-success job `id=expression`, repeatable")
+	fs.Var(f.onFailureIf, "on-failure-if", "condition on the output of an -on-failure job `id=expression`, repeatable")
+	fs.Var(f.onSuccessAfter, "on-success-after", "delay after the success of an -on-success job `id=duration`, repeatable")
+	fs.Var(f.onFailureAfter, "on-failure-after", "delay after the failure of an -on-failure job `id=duration`, repeatable")
+	fs.BoolVar(&f.join, "join", false, "run once all -on-success jobs succeeded in the same execution")
+	fs.DurationVar(&f.joinTimeout, "join-timeout", 0, "fail a join that isn't complete after this long")
+	fs.StringVar(&f.mapPath, "map-path", "", "run once per element of this array in the triggering output, e.g. $.files, empty to remove")
+	fs.IntVar(&f.mapMaxParallel, "map-max-parallel", 0, "max mapped runs of a fan-out running at once, 0 is unlimited")
+	fs.StringVar(&f.onMapComplete, "on-map-complete", "", "comma separated ids of the mapped jobs whose finished fan-outs trigger this job")
+	fs.StringVar(&f.sensorReadyIf, "sensor-ready-if", "", "poll until the output meets this condition, e.g. '$.exists', empty to remove")
+	fs.DurationVar(&f.sensorInterval, "sensor-interval", 0, "time between the pokes of a sensor, 1m by default")
+	fs.DurationVar(&f.sensorTimeout, "sensor-timeout", 0, "fail a sensor run still not ready after this long, 0 pokes forever")
+	fs.StringVar(&f.watchDir, "watch-dir", "", "run once per new or changed file of this absolute directory, empty to stop watching")
+	fs.StringVar(&f.watchPattern, "watch-pattern", "", "glob the names of watched files must match, e.g. '*.csv'")
+	fs.BoolVar(&f.watchRecursive, "watch-recursive", false, "watch the subdirectories of -watch-dir too")
+	fs.DurationVar(&f.watchStableFor, "watch-stable-for", 0, "wait until a file hasn't changed for this long")
+	fs.DurationVar(&f.watchDebounce, "watch-debounce", 0, "min time between two runs for the same file")
+	fs.IntVar(&f.loopLimit, "loop-limit", 0, "allow the job in a trigger cycle, running at most this many times per chain")
+	return f
+}
+
+func (f *jobFlags) input(fs *flag.FlagSet) (*api.JobInput, error) {
+	in := &api.JobInput{}
+	if f.file != "" {
+		d, err := readFile(f.file)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(d))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(in); err != nil {
+			return nil, fmt.Errorf("err reading %s: %s", f.file, err)
+		}
+	}
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch fl.Name {
+		case "name":
+			in.Name = &f.name
+		case "processor", "config":
+			config := in.Processor
+			if config == nil {
+				config = &api.Config{}
+			}
+			if f.processor != "" {
+				config.Type = f.processor
+			}
+			if len(f.processorConfig) > 0 {
+				config.Config = f.processorConfig
+			}
+			in.Processor = config
+		case "template":
+			var d []byte
+			if d, err = readValue(f.template); err == nil {
+				s := string(d)
+				in.InputPayloadTemplate = &s
+			}
+		case "retries":
+			in.Retryer = &api.Config{
+				Type:   pipeline.RetryerTypeDefault,
+				Config: map[string]string{"NumRetries": strconv.Itoa(f.retries)},
+			}
+		case "loop-limit":
+			in.LoopLimit = &f.loopLimit
+		case "sensor-ready-if", "sensor-interval", "sensor-timeout":
+			if in.Sensor == nil {
+				in.Sensor = &api.Sensor{}
+			}
+			switch fl.Name {
+			case "sensor-ready-if":
+				in.Sensor.ReadyIf = pipeline.Condition(f.sensorReadyIf)
+			case "sensor-interval":
+				in.Sensor.Interval = api.Duration(f.sensorInterval)
+			case "sensor-timeout":
+				in.Sensor.Timeout = api.Duration(f.sensorTimeout)
+			}
+		case "cron", "on-success", "on-failure", "on-success-if", "on-failure-if",
+			"on-success-after", "on-failure-after", "join", "join-timeout",
+			"map-path", "map-max-parallel", "on-map-complete",
+			"watch-dir", "watch-pattern", "watch-recursive", "watch-stable-for", "watch-debounce":
+			if in.Triggers == nil {
+				in.Triggers = &api.TriggersInput{}
+			}
+			switch fl.Name {
+			case "cron":
+				in.Triggers.CronSchedule = &f.cron
+			case "on-success":
+				in.Triggers.JobSuccess, err = parseIDs(f.onSuccess)
+			case "on-failure":
+				in.Triggers.JobFailure, err = parseIDs(f.onFailure)
+			case "on-success-if":
+				in.Triggers.JobSuccessConditions, err = parseConditions(f.onSuccessIf)
+			case "on-failure-if":
+				in.Triggers.JobFailureConditions, err = parseConditions(f.onFailureIf)
+			case "on-success-after":
+				in.Triggers.JobSuccessDelays, err = parseDelays(f.onSuccessAfter)
+			case "on-failure-after":
+				in.Triggers.JobFailureDelays, err = parseDelays(f.onFailureAfter)
+			case "join":
+				in.Triggers.JoinSuccess = &f.join
+			case "join-timeout":
+				d := api.Duration(f.joinTimeout)
+				in.Triggers.JoinTimeout = &d
+			case "map-path":
+				in.Triggers.MapPath = &f.mapPath
+			case "map-max-parallel":
+				in.Triggers.MapMaxParallel = &f.mapMaxParallel
+			case "on-map-complete":
+				in.Triggers.JobMapComplete, err = parseIDs(f.onMapComplete)
+			case "watch-dir", "watch-pattern", "watch-recursive", "watch-stable-for", "watch-debounce":
+				in.Triggers.FileWatch = &api.FileWatch{
+					Dir:       f.watchDir,
+					Pattern:   f.watchPattern,
+					Recursive: f.watchRecursive,
+					StableFor: api.Duration(f.watchStableFor),
+					Debounce:  api.Duration(f.watchDebounce),
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// keyValues is a repeatable key=value flag
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	var pairs []string
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	kv[parts[0]] = parts[1]
+	return nil
+}
+
+// parseIDs parses comma separated job ids, an empty string is no jobs
+func parseIDs(s string) (pipeline.JobIDs, error) {
+	ids := pipeline.JobIDs{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job id %q", p)
+		}
+		ids = append(ids, pipeline.JobID(id))
+	}
+	return ids, nil
+}
+
+// parseConditions keys the conditions of id=expression flags by job id
+func parseConditions(kv keyValues) (api.Conditions, error) {
+	conditions := api.Conditions{}
+	for k, v := range kv {
+		id, err := strconv.ParseUint(strings.TrimSpace(k), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job id %q", k)
+		}
+		conditions[pipeline.JobID(id)] = pipeline.Condition(v)
+	}
+	return conditions, nil
+}
+
+// parseDelays keys the delays of id=duration flags by job id
+func parseDelays(kv keyValues) (api.Delays, error) {
+	delays := api.Delays{}
+	for k, v := range kv {
+		id, err := strconv.ParseUint(strings.TrimSpace(k), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job id %q", k)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay %q", v)
+		}
+		delays[pipeline.JobID(id)] = api.Duration(d)
+	}
+	return delays, nil
+}
+
+func joinIDs(ids pipeline.JobIDs) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// readValue returns s, or the contents of the file if s is @file
+func readValue(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "@") {
+		return readFile(s[1:])
+	}
+	return []byte(s), nil
+}
+
+// readFile reads a file, - is stdin
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	d, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.out, string(d))
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/jobspec"
+)
+
+// plan prints the changes apply would make
+func (c *cli) plan(args []string) error {
+	repo, jobs, closeDB, err := c.loadSpecs("plan", args)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	plan, err := jobspec.MakePlan(repo, jobs)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(c.out, plan)
+	return err
+}
+
+// apply makes the database match the job files in a single transaction
+func (c *cli) apply(args []string) error {
+	repo, jobs, closeDB, err := c.loadSpecs("apply", args)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	plan, err := jobspec.Apply(repo, jobs)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(c.out, plan); err != nil {
+		return err
+	}
+	if !plan.Empty() {
+		_, err = fmt.Fprintln(c.out, "applied")
+	}
+	return err
+}
+
+// loadSpecs reads the job files of the directory argument. Plans are made
+// against the database directly, the API has no transactions.
+func (c *cli) loadSpecs(name string, args []string) (*pipeline.SQLiteRepo, []*jobspec.Job, func(), error) {
+	pos, err := parseArgs(newFlagSet(name), args, 1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if c.dbPath == "" {
+		return nil, nil, nil, fmt.Errorf("%s: -db is required", name)
+	}
+	jobs, err := jobspec.LoadDir(pos[0])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	db, err := openDB(c.dbPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return pipeline.NewSQLiteRepo(db), jobs, func() { db.Close() }, nil
+}
@@ -0,0 +1,194 @@
+// Command pipeline runs the pipeline service and manages its jobs and runs,
+// either directly in a SQLite database or through the REST API.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const usage = `usage: pipeline [-db file [-blob-dir dir] | -api url] <command> [arguments]
+
+commands:
+  serve                   run the worker and the REST API
+  migrate                 create or upgrade the SQLite database
+  jobs list               list jobs
+  jobs describe <id>      print a job as JSON
+  jobs create             create a job
+  jobs update <id>        change the given fields of a job
+  runs list               list recent runs
+  runs describe <id>      print a run with its input, output and log
+  runs tail               print runs as they change
+  executions list         list recent executions
+  executions describe <id>
+                          print an execution with its runs
+  trigger <job id>        start a run of a job now
+  backfills create <job id>
+                          run a job for past dates, see -h for the flags
+  backfills list          list backfills
+  backfills describe|cancel <id>
+                          print or cancel a backfill
+  approvals list          list runs waiting for approval
+  approvals approve|reject <run id>
+                          decide a run of an approval job
+  webhooks describe|create|delete <job id>
+                          print, replace or remove the webhook of a job
+  plan <dir>              show how the jobs differ from the job files in dir
+  apply <dir>             make the jobs match the job files in dir
+  next <cron expression>  print the next fire times of a schedule
+
+Run 'pipeline <command> -h' for the flags of a command. -db, -blob-dir
+and -api default to $PIPELINE_DB, $PIPELINE_BLOB_DIR and $PIPELINE_API.
+-blob-dir is the directory of the run payloads too large for the database,
+the same as the one of serve.
+`
+
+func main() {
+	c := &cli{out: os.Stdout, now: time.Now}
+	err := c.run(os.Args[1:])
+	if err != nil && err != flag.ErrHelp {
+		fmt.Fprintln(os.Stderr, "pipeline:", err)
+		os.Exit(1)
+	}
+}
+
+// cli holds the global flags, commands reach the repository through client
+type cli struct {
+	dbPath  string
+	blobDir string
+	apiURL  string
+	out     io.Writer
+	now     func() time.Time
+	done    <-chan struct{} //stops runs tail, nil runs until killed
+}
+
+func (c *cli) run(args []string) error {
+	fs := flag.NewFlagSet("pipeline", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), usage) }
+	fs.StringVar(&c.dbPath, "db", os.Getenv("PIPELINE_DB"), "SQLite database file")
+	fs.StringVar(&c.blobDir, "blob-dir", os.Getenv("PIPELINE_BLOB_DIR"), "directory of large run payloads, with -db")
+	fs.StringVar(&c.apiURL, "api", os.Getenv("PIPELINE_API"), "URL of the REST API")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	switch args[0] {
+	case "serve":
+		return c.serve(args[1:])
+	case "migrate":
+		return c.migrate(args[1:])
+	case "jobs":
+		return c.subcommand("jobs", args[1:], map[string]func([]string) error{
+			"list":     c.listJobs,
+			"describe": c.describeJob,
+			"create":   c.createJob,
+			"update":   c.updateJob,
+		})
+	case "runs":
+		return c.subcommand("runs", args[1:], map[string]func([]string) error{
+			"list":     c.listRuns,
+			"describe": c.describeRun,
+			"tail":     c.tailRuns,
+		})
+	case "executions":
+		return c.subcommand("executions", args[1:], map[string]func([]string) error{
+			"list":     c.listExecutions,
+			"describe": c.describeExecution,
+		})
+	case "backfills":
+		return c.subcommand("backfills", args[1:], map[string]func([]string) error{
+			"create":   c.createBackfill,
+			"list":     c.listBackfills,
+			"describe": c.describeBackfill,
+			"cancel":   c.cancelBackfill,
+		})
+	case "approvals":
+		return c.subcommand("approvals", args[1:], map[string]func([]string) error{
+			"list":    c.listApprovals,
+			"approve": c.approve,
+			"reject":  c.reject,
+		})
+	case "webhooks":
+		return c.subcommand("webhooks", args[1:], map[string]func([]string) error{
+			"describe": c.describeWebhook,
+			"create":   c.createWebhook,
+			"delete":   c.deleteWebhook,
+		})
+	case "trigger":
+		return c.trigger(args[1:])
+	case "next":
+		return c.next(args[1:])
+	case "plan":
+		return c.plan(args[1:])
+	case "apply":
+		return c.apply(args[1:])
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+var errUsage = errors.New("no command given")
+
+func (c *cli) subcommand(name string, args []string, cmds map[string]func([]string) error) error {
+	if len(args) > 0 {
+		if cmd, ok := cmds[args[0]]; ok {
+			return cmd(args[1:])
+		}
+	}
+	var names []string
+	for n := range cmds {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%s: expected one of %s", name, strings.Join(names, ", "))
+}
+
+// client connects to the API if -api is set, otherwise it serves requests
+// from the database in process
+func (c *cli) client() (*client, error) {
+	switch {
+	case c.apiURL != "" && c.dbPath != "":
+		return nil, fmt.Errorf("set only one of -db and -api")
+	case c.apiURL != "":
+		return newAPIClient(strings.TrimSuffix(c.apiURL, "/")), nil
+	case c.dbPath != "":
+		return newSQLiteClient(c.dbPath, c.blobDir)
+	}
+	return nil, fmt.Errorf("one of -db or -api is required")
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// parseArgs parses flags, which may be mixed with n positional arguments. If n
+// is negative any number of arguments is accepted.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if n >= 0 && len(positional) != n {
+		return nil, fmt.Errorf("%s: expected %d argument(s), got %d", fs.Name(), n, len(positional))
+	}
+	return positional, nil
+}
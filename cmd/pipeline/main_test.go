@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/api"
+)
+
+// syncBuffer is written by runs tail while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+type testCLI struct {
+	t   *testing.T
+	dir string
+	db  string
+}
+
+func newTestCLI(t *testing.T) *testCLI {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCLI{t: t, dir: dir, db: filepath.Join(dir, "pipeline.db")}
+}
+
+func (tc *testCLI) Close() {
+	os.RemoveAll(tc.dir)
+}
+
+// run runs the command against the test database and returns its output
+func (tc *testCLI) run(args ...string) (string, error) {
+	out := &syncBuffer{}
+	c := &cli{
+		out: out,
+		now: func() time.Time { return time.Date(2017, 3, 1, 0, 0, 0, 0, time.Local) },
+	}
+	err := c.run(append([]string{"-db", tc.db}, args...))
+	return out.String(), err
+}
+
+func (tc *testCLI) mustRun(args ...string) string {
+	out, err := tc.run(args...)
+	if err != nil {
+		tc.t.Fatalf("%s: %s", strings.Join(args, " "), err)
+	}
+	return out
+}
+
+func TestCLIJobsAndRuns(t *testing.T) {
+	tc := newTestCLI(t)
+	defer tc.Close()
+
+	tc.mustRun("migrate")
+	tc.mustRun("jobs", "create", "-name", "extract", "-processor", "lambda", "-config", "FunctionName=extract",
+		"-cron", "0 2 * * *", "-template", `{"day":"{{.day}}"}`, "-retries", "2")
+	tc.mustRun("jobs", "create", "-name", "load", "-processor", "lambda", "-on-success", "1")
+	out := tc.mustRun("jobs", "update", "2", "-name", "load-v2", "-on-failure", "1")
+	if !strings.Contains(out, `"name": "load-v2"`) || !strings.Contains(out, `"job_failure": [`) {
+		t.Errorf("update: unexpected output\n%s", out)
+	}
+
+	out = tc.mustRun("jobs", "list")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "extract") || !strings.Contains(lines[2], "load-v2") {
+		t.Errorf("list: unexpected output\n%s", out)
+	}
+	if fields := strings.Fields(lines[2]); fields[len(fields)-3] != "1" || fields[len(fields)-2] != "1" {
+		t.Errorf("list: expected job 2 to be triggered by job 1, got %s", lines[2])
+	}
+
+	out = tc.mustRun("jobs", "describe", "1")
+	for _, expected := range []string{`"FunctionName": "extract"`, `"NumRetries": "2"`, `"cron_schedule": "0 2 * * *"`} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("describe: expected %s in\n%s", expected, out)
+		}
+	}
+
+	tc.mustRun("trigger", "2", "-input", `{"rows":3}`)
+	out = tc.mustRun("runs", "list", "-job", "2")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "pending") {
+		t.Errorf("runs list: unexpected output\n%s", out)
+	}
+	if out := tc.mustRun("runs", "describe", "1"); !strings.Contains(out, `"input": "{\"rows\":3}"`) {
+		t.Errorf("runs describe: unexpected output\n%s", out)
+	}
+
+	out = tc.mustRun("next", "-n", "2", "-job", "1")
+	expected := "2017-03-01 02:00:00 " + time.Date(2017, 3, 1, 2, 0, 0, 0, time.Local).Format("MST") + "\n" +
+		"2017-03-02 02:00:00 " + time.Date(2017, 3, 2, 2, 0, 0, 0, time.Local).Format("MST") + "\n"
+	if out != expected {
+		t.Errorf("next: expected\n%s\ngot\n%s", expected, out)
+	}
+	if out := tc.mustRun("next", "-n", "1", "30 * * * *"); !strings.HasPrefix(out, "2017-03-01 00:30:00") {
+		t.Errorf("next expression: unexpected output %s", out)
+	}
+
+	for _, test := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"jobs", "create", "-processor", "lambda"}, "validation failed: Name is required"},
+		{[]string{"jobs", "describe", "42"}, "job not found"},
+		{[]string{"trigger", "1", "-input", "{"}, "trigger: input is not valid JSON"},
+		{[]string{"next", "-job", "2"}, "next: job 2 has no cron schedule"},
+		{[]string{"jobs", "remove"}, "jobs: expected one of create, describe, list, update"},
+	} {
+		if _, err := tc.run(test.args...); err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", strings.Join(test.args, " "), test.err, err)
+		}
+	}
+}
+
+func TestCLIBlobDir(t *testing.T) {
+	tc := newTestCLI(t)
+	defer tc.Close()
+	blobs := filepath.Join(tc.dir, "blobs")
+
+	tc.mustRun("migrate")
+	tc.mustRun("jobs", "create", "-name", "load", "-processor", "lambda")
+	rows := strings.Repeat(`"row",`, pipeline.DefaultBlobInlineLimit/6)
+	tc.mustRun("-blob-dir", blobs, "trigger", "1", "-input", `{"rows":[`+rows+`"last"]}`)
+	out := tc.mustRun("-blob-dir", blobs, "runs", "describe", "1")
+	if !strings.Contains(out, `\"last\"]}"`) {
+		t.Errorf("describe: expected the input read from the blob directory\n%.200s", out)
+	}
+	if files, err := ioutil.ReadDir(blobs); err != nil || len(files) == 0 {
+		t.Errorf("expected the input in the blob directory, got %v %v", files, err)
+	}
+	if _, err := tc.run("runs", "describe", "1"); err == nil {
+		t.Error("describe without -blob-dir: expected an error reading the input")
+	}
+}
+
+func TestCLIAPI(t *testing.T) {
+	tc := newTestCLI(t)
+	defer tc.Close()
+	tc.mustRun("migrate")
+	tc.mustRun("jobs", "create", "-name", "extract")
+
+	db, err := openDB(tc.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	srv := httptest.NewServer(api.NewServer(pipeline.NewSQLiteRepo(db)))
+	defer srv.Close()
+
+	out := &bytes.Buffer{}
+	c := &cli{out: out, now: time.Now}
+	if err := c.run([]string{"-api", srv.URL, "jobs", "list"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "extract") {
+		t.Errorf("expected the job listed through the API, got\n%s", out)
+	}
+	if err := c.run([]string{"-api", srv.URL, "-db", tc.db, "jobs", "list"}); err == nil {
+		t.Error("expected an error with both -api and -db")
+	}
+}
+
+func TestCLITailSQLite(t *testing.T) {
+	tc := newTestCLI(t)
+	defer tc.Close()
+	tc.mustRun("migrate")
+	tc.mustRun("jobs", "create", "-name", "extract")
+
+	tailPollInterval = 10 * time.Millisecond
+	out := &syncBuffer{}
+	done := make(chan struct{})
+	c := &cli{out: out, now: time.Now, done: done}
+	errs := make(chan error)
+	go func() {
+		errs <- c.run([]string{"-db", tc.db, "runs", "tail"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	tc.mustRun("trigger", "1")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "run 1  pending") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "job 1  run 1  pending") {
+		t.Errorf("expected the new run, got\n%s", out)
+	}
+}
+
+func TestCLIPlanApply(t *testing.T) {
+	tc := newTestCLI(t)
+	defer tc.Close()
+
+	tc.mustRun("migrate")
+	specs := filepath.Join(tc.dir, "jobs")
+	if err := os.Mkdir(specs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := ioutil.WriteFile(filepath.Join(specs, "etl.yaml"), []byte(`
+jobs:
+  - name: extract
+    processor: {type: lambda}
+    cron_schedule: "0 2 * * *"
+  - name: load
+    processor: {type: lambda}
+    on_success: [extract]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out := tc.mustRun("plan", specs); out != "+ create extract\n+ create load\n2 to create, 0 to update, 0 to delete\n" {
+		t.Errorf("plan: unexpected output\n%s", out)
+	}
+	if out := tc.mustRun("apply", specs); !strings.HasSuffix(out, "applied\n") {
+		t.Errorf("apply: unexpected output\n%s", out)
+	}
+	if out := tc.mustRun("plan", specs); out != "no changes\n" {
+		t.Errorf("plan after apply: unexpected output\n%s", out)
+	}
+	if out := tc.mustRun("jobs", "describe", "2"); !strings.Contains(out, `"job_success": [
+      1
+    ]`) {
+		t.Errorf("describe: expected load to be triggered by extract\n%s", out)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/api"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+// tailPollInterval is how often runs tail polls a SQLite database
+var tailPollInterval = time.Second
+
+func (c *cli) listRuns(args []string) error {
+	fs := newFlagSet("runs list")
+	jobs := fs.String("job", "", "comma separated job ids")
+	status := fs.String("status", "", "comma separated statuses: pending, running, complete, cancelled")
+	limit := fs.Uint64("limit", 20, "max number of runs")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	q := url.Values{
+		"order_by": {"id"},
+		"order":    {"desc"},
+		"limit":    {strconv.FormatUint(*limit, 10)},
+	}
+	if *jobs != "" {
+		q.Set("job_id", *jobs)
+	}
+	if *status != "" {
+		q.Set("status", *status)
+	}
+	list := &api.RunList{}
+	if err := cl.do("GET", "/runs?"+q.Encode(), nil, list); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tJOB\tSTATUS\tATTEMPT\tSCHEDULED\tSTARTED\tDURATION\tDETAIL")
+	for _, r := range list.Runs {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			r.ID, r.JobID, runStatus(r), r.Attempt, formatTime(&r.ScheduledStartTime),
+			formatTime(r.StartTime), duration(r), r.StatusDetail)
+	}
+	return w.Flush()
+}
+
+func (c *cli) describeRun(args []string) error {
+	pos, err := parseArgs(newFlagSet("runs describe"), args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	run := &api.Run{}
+	if err := cl.do("GET", "/runs/"+pos[0], nil, run); err != nil {
+		return err
+	}
+	return c.printJSON(run)
+}
+
+func (c *cli) trigger(args []string) error {
+	fs := newFlagSet("trigger")
+	input := fs.String("input", "", "JSON input replacing the job's template, @file reads a file")
+	params := keyValues{}
+	fs.Var(params, "param", "template parameter `key=value`, {{.Params.key}}, repeatable")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	in := &api.TriggerInput{}
+	if len(params) > 0 {
+		in.Params = map[string]interface{}{}
+		for k, v := range params {
+			in.Params[k] = v
+		}
+	}
+	if *input != "" {
+		d, err := readValue(*input)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(d) {
+			return fmt.Errorf("trigger: input is not valid JSON")
+		}
+		in.Input = d
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	run := &api.Run{}
+	if err := cl.do("POST", "/jobs/"+pos[0]+"/run", in, run); err != nil {
+		return err
+	}
+	return c.printJSON(run)
+}
+
+// tailRuns prints run events from the API's event stream. The events of a
+// SQLite database are only known to the service, so the database is polled
+// for status changes instead.
+func (c *cli) tailRuns(args []string) error {
+	fs := newFlagSet("runs tail")
+	jobs := fs.String("job", "", "comma separated job ids")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	if cl.db != nil {
+		return c.pollRuns(cl, *jobs)
+	}
+	return c.streamEvents(cl, *jobs)
+}
+
+func (c *cli) streamEvents(cl *client, jobs string) error {
+	q := url.Values{}
+	if jobs != "" {
+		q.Set("job_id", jobs)
+	}
+	lastID := ""
+	for {
+		req, err := http.NewRequest("GET", cl.base+"/events?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := cl.http.Do(req)
+		if err != nil {
+			return err
+		}
+		switch {
+		case resp.StatusCode == http.StatusGone:
+			resp.Body.Close()
+			fmt.Fprintln(c.out, "# some events were missed")
+			lastID = ""
+			continue
+		case resp.StatusCode >= 400:
+			err := responseError(resp)
+			resp.Body.Close()
+			return err
+		}
+		lastID = c.printEvents(resp, lastID)
+		resp.Body.Close()
+		//the server dropped the stream, resume after the last event
+		time.Sleep(time.Second)
+	}
+}
+
+// printEvents prints the server-sent events of resp until the stream ends,
+// returning the id of the last one
+func (c *cli) printEvents(resp *http.Response, lastID string) string {
+	s := bufio.NewScanner(resp.Body)
+	id, data := "", ""
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			e := &api.Event{}
+			if err := json.Unmarshal([]byte(data), e); err == nil {
+				c.printEvent(e)
+			}
+			lastID, id, data = id, "", ""
+		}
+	}
+	return lastID
+}
+
+func (c *cli) printEvent(e *api.Event) {
+	line := fmt.Sprintf("%s  job %d  run %d  %s", e.Time.Local().Format(timeFormat), e.JobID, e.RunID, e.Type)
+	if e.NextRunID != 0 {
+		line += fmt.Sprintf(" -> job %d run %d", e.NextJobID, e.NextRunID)
+	}
+	if e.Detail != "" {
+		line += "  " + e.Detail
+	}
+	fmt.Fprintln(c.out, line)
+}
+
+// pollRuns prints the runs whose status changed since the previous poll
+func (c *cli) pollRuns(cl *client, jobs string) error {
+	q := url.Values{
+		"order_by": {"id"},
+		"order":    {"desc"},
+		"limit":    {"100"},
+	}
+	if jobs != "" {
+		q.Set("job_id", jobs)
+	}
+	seen := map[pipeline.RunID]string{}
+	first := true
+	for {
+		list := &api.RunList{}
+		if err := cl.do("GET", "/runs?"+q.Encode(), nil, list); err != nil {
+			return err
+		}
+		//oldest first
+		for i := len(list.Runs) - 1; i >= 0; i-- {
+			r := list.Runs[i]
+			status := runStatus(r)
+			if seen[r.ID] != status && !first {
+				fmt.Fprintf(c.out, "%s  job %d  run %d  %s", time.Now().Format(timeFormat), r.JobID, r.ID, status)
+				if r.StatusDetail != "" {
+					fmt.Fprintf(c.out, "  %s", r.StatusDetail)
+				}
+				fmt.Fprintln(c.out)
+			}
+			seen[r.ID] = status
+		}
+		first = false
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
+
+// runStatus splits complete runs into succeeded and failed
+func runStatus(r *api.Run) string {
+	if r.Status != pipeline.RunStatusComplete {
+		return r.Status.String()
+	}
+	if r.Success {
+		return "succeeded"
+	}
+	return "failed"
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(timeFormat)
+}
+
+func duration(r *api.Run) string {
+	if r.StartTime == nil || r.EndTime == nil {
+		return ""
+	}
+	return r.EndTime.Sub(*r.StartTime).Round(time.Millisecond).String()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/api"
+)
+
+func (c *cli) serve(args []string) error {
+	fs := newFlagSet("serve")
+	addr := fs.String("addr", ":8080", "address the API listens on")
+	blobDir := fs.String("blob-dir", c.blobDir, "directory for large run payloads, kept in the database if empty")
+	keepLast := fs.Int("keep-last", 0, "runs of each job kept by the retention policy")
+	maxAge := fs.Duration("max-age", 0, "keep successful runs that ended more recently, with 0 only -keep-last deletes them")
+	failureMaxAge := fs.Duration("failure-max-age", 0, "keep failed runs that ended more recently, defaults to -max-age")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if c.dbPath == "" {
+		return fmt.Errorf("serve: -db is required")
+	}
+	db, err := openDB(c.dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repo, store, err := openRepo(db, *blobDir)
+	if err != nil {
+		return err
+	}
+
+	svc := pipeline.NewService(repo)
+	svc.BlobStore = store
+	if *keepLast > 0 || *maxAge > 0 || *failureMaxAge > 0 {
+		svc.Retention = &pipeline.RetentionPolicy{
+			KeepLast:      *keepLast,
+			MaxAge:        *maxAge,
+			FailureMaxAge: *failureMaxAge,
+			Rollup:        true,
+		}
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	svc.AddProcessor(pipeline.ProcessorTypeLambda, pipeline.NewLambdaProcessorMaker(lambda.New(sess)))
+	svc.AddProcessor("debug", func(map[string]string) (pipeline.RunProcessor, error) {
+		return pipeline.NewDebugProcessor(os.Stderr), nil
+	})
+	if err := svc.ListenAndServe(); err != nil {
+		return err
+	}
+
+	srv := api.NewServer(svc.Repository())
+	srv.BlobStore = store
+	srv.Events = svc.Events()
+	log.Printf("listening on %s", *addr)
+	return http.ListenAndServe(*addr, srv)
+}
+
+func (c *cli) migrate(args []string) error {
+	if _, err := parseArgs(newFlagSet("migrate"), args, 0); err != nil {
+		return err
+	}
+	if c.dbPath == "" {
+		return fmt.Errorf("migrate: -db is required")
+	}
+	db, err := openDB(c.dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := pipeline.NewSQLiteRepo(db).MigrateDB(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.out, "migrated", c.dbPath)
+	return err
+}
+
+func (c *cli) next(args []string) error {
+	fs := newFlagSet("next")
+	n := fs.Uint("n", 5, "number of fire times")
+	job := fs.String("job", "", "print the fire times of this job's schedule instead")
+	pos, err := parseArgs(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	if (*job == "" && len(pos) != 1) || (*job != "" && len(pos) != 0) {
+		return fmt.Errorf("next: expected a cron expression or -job")
+	}
+	var schedule pipeline.CronSchedule
+	if *job != "" {
+		cl, err := c.client()
+		if err != nil {
+			return err
+		}
+		defer cl.Close()
+		j := &api.Job{}
+		if err := cl.do("GET", "/jobs/"+*job, nil, j); err != nil {
+			return err
+		}
+		if j.Triggers.CronSchedule == "" {
+			return fmt.Errorf("next: job %s has no cron schedule", *job)
+		}
+		schedule = pipeline.CronSchedule(j.Triggers.CronSchedule)
+	} else {
+		schedule = pipeline.CronSchedule(pos[0])
+	}
+	times, err := schedule.Next(c.now(), *n)
+	if err != nil {
+		return fmt.Errorf("next: invalid cron schedule %q: %s", schedule, err)
+	}
+	for _, t := range times {
+		fmt.Fprintln(c.out, t.Format(timeFormat+" MST"))
+	}
+	return nil
+}
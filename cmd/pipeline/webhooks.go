@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/robstrong/pipeline"
+	"github.com/robstrong/pipeline/api"
+)
+
+func (c *cli) describeWebhook(args []string) error {
+	pos, err := parseArgs(newFlagSet("webhooks describe"), args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	w := &api.Webhook{}
+	if err := cl.do("GET", "/jobs/"+pos[0]+"/webhook", nil, w); err != nil {
+		return err
+	}
+	return c.printJSON(w)
+}
+
+func (c *cli) createWebhook(args []string) error {
+	fs := newFlagSet("webhooks create")
+	auth := fs.String("auth", string(pipeline.WebhookAuthHMAC), "how deliveries authenticate, hmac or token")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	w := &api.Webhook{}
+	in := &api.WebhookInput{Auth: pipeline.WebhookAuth(*auth)}
+	if err := cl.do("POST", "/jobs/"+pos[0]+"/webhook", in, w); err != nil {
+		return err
+	}
+	return c.printJSON(w)
+}
+
+func (c *cli) deleteWebhook(args []string) error {
+	pos, err := parseArgs(newFlagSet("webhooks delete"), args, 1)
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	return cl.do("DELETE", "/jobs/"+pos[0]+"/webhook", nil, nil)
+}
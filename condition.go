@@ -0,0 +1,515 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition is an expression on the output of an upstream run that decides
+// whether a trigger fires. Paths select values of the output, "$" is the
+// whole output:
+//
+//	$.new_files > 0
+//	$.status == "ok" && !$.dry_run
+//	$.partitions[0].name != 'tmp' || ($.force)
+//
+// Comparisons are ==, !=, <, <=, > and >=, ordering only applies to numbers
+// and strings. A path that doesn't exist is null. An expression without a
+// comparison is true unless its value is false, null, 0, "" or empty.
+type Condition string
+
+// Validate parses the condition
+func (c Condition) Validate() error {
+	_, err := parseCondition(string(c))
+	return err
+}
+
+// Eval evaluates the condition against a JSON output. An empty condition is
+// always true, an empty output is an empty object.
+func (c Condition) Eval(output []byte) (bool, error) {
+	if c == "" {
+		return true, nil
+	}
+	expr, err := parseCondition(string(c))
+	if err != nil {
+		return false, err
+	}
+	var data interface{} = map[string]interface{}{}
+	if len(output) > 0 {
+		if err := json.Unmarshal(output, &data); err != nil {
+			return false, fmt.Errorf("output isn't JSON: %s", err)
+		}
+	}
+	v, err := expr.eval(data)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// OutputPath selects a value of a JSON output, with the path syntax of
+// conditions: "$.partitions", "$.tables[0]['name']" or "$"
+type OutputPath string
+
+// Validate parses the path
+func (p OutputPath) Validate() error {
+	_, err := p.parse()
+	return err
+}
+
+// Select returns the value at the path, nil if it doesn't exist
+func (p OutputPath) Select(output []byte) (interface{}, error) {
+	path, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if len(output) > 0 {
+		if err := json.Unmarshal(output, &data); err != nil {
+			return nil, fmt.Errorf("output isn't JSON: %s", err)
+		}
+	}
+	return path.eval(data)
+}
+
+func (p OutputPath) parse() (condPath, error) {
+	s := strings.TrimSpace(string(p))
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("path %q must start with $", p)
+	}
+	path, n, err := lexPath(s)
+	if err == nil && n != len(s) {
+		err = fmt.Errorf("unexpected %q", s[n:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("path %q: %s", p, err)
+	}
+	return path, nil
+}
+
+type condExpr interface {
+	eval(data interface{}) (interface{}, error)
+}
+
+type condLiteral struct {
+	value interface{}
+}
+
+func (l condLiteral) eval(interface{}) (interface{}, error) {
+	return l.value, nil
+}
+
+// condPath is a path of object keys (strings) and array indexes (ints)
+type condPath []interface{}
+
+func (p condPath) eval(data interface{}) (interface{}, error) {
+	v := data
+	for _, step := range p {
+		switch s := step.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, nil
+			}
+			v = m[s]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || s < 0 || s >= len(a) {
+				return nil, nil
+			}
+			v = a[s]
+		}
+	}
+	return v, nil
+}
+
+type condNot struct {
+	expr condExpr
+}
+
+func (n condNot) eval(data interface{}) (interface{}, error) {
+	v, err := n.expr.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type condBinary struct {
+	op          string
+	left, right condExpr
+}
+
+func (b condBinary) eval(data interface{}) (interface{}, error) {
+	l, err := b.left.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	//short circuit
+	switch {
+	case b.op == "&&" && !truthy(l):
+		return false, nil
+	case b.op == "||" && truthy(l):
+		return true, nil
+	}
+	r, err := b.right.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "&&", "||":
+		return truthy(r), nil
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	}
+	//a missing value is never ordered, it doesn't fail the trigger
+	if l == nil || r == nil {
+		return false, nil
+	}
+	cmp, err := compare(l, r)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func compare(l, r interface{}) (int, error) {
+	switch lv := l.(type) {
+	case float64:
+		if rv, ok := r.(float64); ok {
+			switch {
+			case lv < rv:
+				return -1, nil
+			case lv > rv:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if rv, ok := r.(string); ok {
+			return strings.Compare(lv, rv), nil
+		}
+	}
+	return 0, fmt.Errorf("can't order %s and %s", jsonType(l), jsonType(r))
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return true
+}
+
+// condition tokens
+type condToken struct {
+	kind  string //op, path, literal or end
+	text  string
+	value interface{}
+	pos   int
+}
+
+type condParser struct {
+	src    string
+	tokens []condToken
+	i      int
+}
+
+func parseCondition(s string) (condExpr, error) {
+	p := &condParser{src: s}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "end" {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return expr, nil
+}
+
+func (p *condParser) errorf(t condToken, format string, args ...interface{}) error {
+	return fmt.Errorf("condition %q: position %d: %s", p.src, t.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *condParser) peek() condToken {
+	return p.tokens[p.i]
+}
+
+func (p *condParser) next() condToken {
+	t := p.tokens[p.i]
+	if t.kind != "end" {
+		p.i++
+	}
+	return t
+}
+
+func (p *condParser) or() (condExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "||" && p.peek().kind == "op" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = condBinary{"||", left, right}
+	}
+	return left, nil
+}
+
+func (p *condParser) and() (condExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "&&" && p.peek().kind == "op" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = condBinary{"&&", left, right}
+	}
+	return left, nil
+}
+
+func (p *condParser) unary() (condExpr, error) {
+	if t := p.peek(); t.kind == "op" && t.text == "!" {
+		p.next()
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return condNot{expr}, nil
+	}
+	return p.comparison()
+}
+
+func (p *condParser) comparison() (condExpr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		if t.kind != "op" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return condBinary{t.text, left, right}, nil
+	}
+	return left, nil
+}
+
+func (p *condParser) operand() (condExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case "path":
+		return t.value.(condPath), nil
+	case "literal":
+		return condLiteral{t.value}, nil
+	case "op":
+		if t.text == "(" {
+			expr, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if c := p.next(); c.kind != "op" || c.text != ")" {
+				return nil, p.errorf(c, "expected )")
+			}
+			return expr, nil
+		}
+	case "end":
+		return nil, p.errorf(t, "unexpected end")
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
+
+func (p *condParser) lex() error {
+	s := p.src
+	i := 0
+	for {
+		for i < len(s) && unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+		if i == len(s) {
+			p.tokens = append(p.tokens, condToken{kind: "end", pos: i})
+			return nil
+		}
+		start := i
+		tok := condToken{pos: start}
+		switch c := s[i]; {
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||") ||
+			strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!=") ||
+			strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			tok.kind, tok.text = "op", s[i:i+2]
+			i += 2
+		case strings.ContainsRune("!<>()", rune(c)):
+			tok.kind, tok.text = "op", s[i:i+1]
+			i++
+		case c == '$':
+			path, n, err := lexPath(s[i:])
+			if err != nil {
+				return p.errorf(tok, "%s", err)
+			}
+			tok.kind, tok.text, tok.value = "path", s[i:i+n], path
+			i += n
+		case c == '"' || c == '\'':
+			str, n, err := lexString(s[i:])
+			if err != nil {
+				return p.errorf(tok, "%s", err)
+			}
+			tok.kind, tok.text, tok.value = "literal", s[i:i+n], str
+			i += n
+		case c == '-' || (c >= '0' && c <= '9'):
+			n := 1
+			for i+n < len(s) && strings.ContainsRune("0123456789.eE+-", rune(s[i+n])) {
+				n++
+			}
+			f, err := strconv.ParseFloat(s[i:i+n], 64)
+			if err != nil {
+				return p.errorf(tok, "invalid number %q", s[i:i+n])
+			}
+			tok.kind, tok.text, tok.value = "literal", s[i:i+n], f
+			i += n
+		default:
+			n := 0
+			for i+n < len(s) && isIdent(s[i+n]) {
+				n++
+			}
+			word := s[i : i+n]
+			switch word {
+			case "true", "false":
+				tok.value = word == "true"
+			case "null":
+				tok.value = nil
+			default:
+				if word == "" {
+					word = s[i : i+1]
+				}
+				return p.errorf(tok, "unexpected %q", word)
+			}
+			tok.kind, tok.text = "literal", word
+			i += n
+		}
+		p.tokens = append(p.tokens, tok)
+	}
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// lexPath reads a path such as $.a.b[0]['c d'], returning its steps and
+// length
+func lexPath(s string) (condPath, int, error) {
+	path := condPath{}
+	i := 1
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			n := 0
+			for i+1+n < len(s) && isIdent(s[i+1+n]) {
+				n++
+			}
+			if n == 0 {
+				return nil, 0, fmt.Errorf("expected a key after .")
+			}
+			path = append(path, s[i+1:i+1+n])
+			i += 1 + n
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, 0, fmt.Errorf("missing ]")
+			}
+			inner := strings.TrimSpace(s[i+1 : i+end])
+			if inner != "" && (inner[0] == '"' || inner[0] == '\'') {
+				key, n, err := lexString(inner)
+				if err != nil || n != len(inner) {
+					return nil, 0, fmt.Errorf("invalid key %s", inner)
+				}
+				path = append(path, key)
+			} else {
+				idx, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid index %q", inner)
+				}
+				path = append(path, idx)
+			}
+			i += end + 1
+		default:
+			return path, i, nil
+		}
+	}
+	return path, i, nil
+}
+
+// lexString reads a single or double quoted string, returning its value and
+// length
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			if quote == '\'' {
+				return strings.Replace(s[1:i], `\'`, "'", -1), i + 1, nil
+			}
+			v, err := strconv.Unquote(s[:i+1])
+			return v, i + 1, err
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
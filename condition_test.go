@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConditionEval(t *testing.T) {
+	output := []byte(`{"new_files": 2, "status": "ok", "dry_run": false, "tables": [{"name": "a b"}], "empty": []}`)
+	tests := []struct {
+		condition Condition
+		expected  bool
+		err       bool
+	}{
+		{condition: "", expected: true},
+		{condition: "$.new_files > 0", expected: true},
+		{condition: "$.new_files >= 3", expected: false},
+		{condition: "$.new_files == 2 && $.status == 'ok'", expected: true},
+		{condition: `$.status != "ok" || !$.dry_run`, expected: true},
+		{condition: "!($.new_files > 1 && $.dry_run)", expected: true},
+		{condition: `$.tables[0].name == "a b"`, expected: true},
+		{condition: `$.tables[0]["name"] < "b"`, expected: true},
+		{condition: "$.tables[1].name", expected: false},
+		{condition: "$.missing > 0", expected: false},
+		{condition: "$.missing == null", expected: true},
+		{condition: "$.empty", expected: false},
+		{condition: "$", expected: true},
+		{condition: "$.status > 1", err: true},
+		{condition: "$.new_files >", err: true},
+		{condition: "$.new_files = 2", err: true},
+		{condition: "($.new_files", err: true},
+		{condition: "'open", err: true},
+		{condition: "new_files > 0", err: true},
+	}
+	for _, test := range tests {
+		got, err := test.condition.Eval(output)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %t", test.condition, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected err %s", test.condition, err)
+		} else if got != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.condition, test.expected, got)
+		}
+	}
+	if ok, err := Condition("$.a == 1").Eval(nil); err != nil || ok {
+		t.Errorf("empty output: expected false, got %t %v", ok, err)
+	}
+	if _, err := Condition("$.a == 1").Eval([]byte("not json")); err == nil {
+		t.Error("invalid output: expected an error")
+	}
+}
+
+func TestServiceConditions(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.AddProcessor("echo", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func(input []byte) (*RunResult, error) {
+			return &RunResult{Success: true, Output: input}, nil
+		}), nil
+	})
+	create := func(in *CreateJobInput) JobID {
+		in.Processor = ProcessorConfig{Type: "echo"}
+		in.InputPayloadTemplate = []byte("{}")
+		id, err := s.Repository().CreateJob(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	extract := create(&CreateJobInput{Name: "extract"})
+	load := create(&CreateJobInput{Name: "load", Triggers: &TriggerEventsInput{
+		JobSuccess:           JobIDs{extract},
+		JobSuccessConditions: map[JobID]Condition{extract: "$.new_files > 0"},
+	}})
+	broken := create(&CreateJobInput{Name: "broken", Triggers: &TriggerEventsInput{
+		JobSuccess:           JobIDs{extract},
+		JobSuccessConditions: map[JobID]Condition{extract: `$.new_files > "a"`},
+	}})
+	always := create(&CreateJobInput{Name: "always", Triggers: &TriggerEventsInput{JobSuccess: JobIDs{extract}}})
+
+	var extractRuns RunIDs
+	for _, input := range []string{`{"new_files": 0}`, `{"new_files": 2}`} {
+		id, err := r.CreateRun(&CreateRunInput{
+			JobID:              extract,
+			ProcessorConfig:    ProcessorConfig{Type: "echo"},
+			Attempt:            IntPtr(1),
+			Input:              []byte(input),
+			ScheduledStartTime: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		extractRuns = append(extractRuns, id)
+		s.startDueRuns(time.Now().Add(time.Minute))
+		s.running.Wait()
+	}
+
+	for _, c := range []struct {
+		job  JobID
+		runs int
+	}{{load, 1}, {broken, 0}, {always, 2}} {
+		job := c.job
+		runs, err := r.GetRuns(&GetRunsInput{JobID: &job, Summary: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != c.runs {
+			t.Errorf("job %s: expected %d runs, got %d", job, c.runs, len(runs))
+		}
+	}
+	runs, err := r.GetRuns(&GetRunsInput{JobID: &load, Summary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) == 1 && runs[0].ParentRunID != extractRuns[1] {
+		t.Errorf("expected load to be triggered by run %s, got %s", extractRuns[1], runs[0].ParentRunID)
+	}
+
+	decisions, err := r.GetTriggerDecisions(&GetTriggerDecisionsInput{RunIDs: extractRuns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TriggerDecision{
+		{RunID: extractRuns[0], JobID: load, Condition: "$.new_files > 0"},
+		{RunID: extractRuns[0], JobID: broken, Condition: `$.new_files > "a"`, Error: "can't order number and string"},
+		{RunID: extractRuns[1], JobID: load, Condition: "$.new_files > 0", Triggered: true},
+		{RunID: extractRuns[1], JobID: broken, Condition: `$.new_files > "a"`, Error: "can't order number and string"},
+	}
+	if len(decisions) != len(expected) {
+		t.Fatalf("expected %d decisions, got %+v", len(expected), decisions)
+	}
+	for i, d := range decisions {
+		e := expected[i]
+		if d.RunID != e.RunID || d.JobID != e.JobID || d.Condition != e.Condition || d.Triggered != e.Triggered || d.Error != e.Error {
+			t.Errorf("decision %d: expected %+v, got %+v", i, e, d)
+		}
+		if d.CreatedAt.IsZero() {
+			t.Errorf("decision %d: created at isn't set", i)
+		}
+	}
+}
@@ -82,6 +82,9 @@ func (c *CronScheduler) writeErr(e error) {
 func (c *CronScheduler) addNextJobsToChan() {
 	endTime := time.Now().Add(c.lookAheadTime)
 	for jID, j := range c.jobs {
+		if j.Paused {
+			continue
+		}
 		t := c.crons[jID].Next(c.lastJobTime)
 		//get all the runs for this job in the time range
 		for !t.IsZero() && (t.Before(endTime) || t.Equal(endTime)) {
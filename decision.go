@@ -0,0 +1,15 @@
+package pipeline
+
+import "time"
+
+// TriggerDecision records the evaluation of a trigger condition when an
+// upstream run completed, explaining why a downstream job did or didn't run
+type TriggerDecision struct {
+	ID        int64
+	RunID     RunID //the upstream run
+	JobID     JobID //the downstream job
+	Condition Condition
+	Triggered bool
+	Error     string //set if the condition couldn't be evaluated, the job isn't triggered
+	CreatedAt time.Time
+}
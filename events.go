@@ -0,0 +1,286 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventRunCreated             EventType = "created"
+	EventRunClaimed             EventType = "claimed"
+	EventRunStarted             EventType = "started"
+	EventRunWaiting             EventType = "waiting"
+	EventRunPoked               EventType = "poked"
+	EventRunSucceeded           EventType = "succeeded"
+	EventRunFailed              EventType = "failed"
+	EventRunRetried             EventType = "retried"
+	EventRunTriggeredDownstream EventType = "triggered_downstream"
+)
+
+// EventTypes lists every EventType
+var EventTypes = []EventType{
+	EventRunCreated,
+	EventRunClaimed,
+	EventRunStarted,
+	EventRunWaiting,
+	EventRunPoked,
+	EventRunSucceeded,
+	EventRunFailed,
+	EventRunRetried,
+	EventRunTriggeredDownstream,
+}
+
+func (t EventType) Valid() bool {
+	for _, et := range EventTypes {
+		if t == et {
+			return true
+		}
+	}
+	return false
+}
+
+// EventID increases by one with every published event. IDs start over when
+// the process restarts.
+type EventID uint64
+
+// Event is a change in the lifecycle of a run
+type Event struct {
+	ID      EventID
+	Type    EventType
+	Time    time.Time
+	JobID   JobID
+	RunID   RunID
+	Attempt int
+	Detail  string //status detail of failed runs
+
+	//the run created by a retried or triggered_downstream event
+	NextJobID JobID
+	NextRunID RunID
+}
+
+// EventFilter selects events, empty fields match every event
+type EventFilter struct {
+	JobIDs JobIDs
+	Types  []EventType
+}
+
+func (f EventFilter) Match(e *Event) bool {
+	if len(f.JobIDs) > 0 && !jobIDsContain(f.JobIDs, e.JobID) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func jobIDsContain(ids JobIDs, id JobID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// DefaultEventHistory is the number of events kept for resuming
+	// subscriptions
+	DefaultEventHistory = 1000
+	//events buffered for a subscriber before it's dropped
+	subscriptionBuffer = 100
+)
+
+const (
+	ErrEventsExpired      = Err("events since the last event id are no longer available")
+	ErrSubscriptionLagged = Err("subscription dropped, events weren't read fast enough")
+)
+
+// EventBus publishes run events to subscribers. The most recent events are
+// kept so a subscriber can resume from the last event it received.
+type EventBus struct {
+	mu      sync.Mutex
+	lastID  EventID
+	history []*Event //ring buffer, the oldest event is at next once full
+	next    int
+	subs    map[*Subscription]struct{}
+}
+
+func NewEventBus(history int) *EventBus {
+	if history <= 0 {
+		history = DefaultEventHistory
+	}
+	return &EventBus{
+		history: make([]*Event, 0, history),
+		subs:    map[*Subscription]struct{}{},
+	}
+}
+
+// Publish assigns e an ID and sends it to every matching subscriber. A
+// subscriber whose buffer is full is dropped rather than blocking the
+// publisher, it can resubscribe from its last event.
+func (b *EventBus) Publish(e Event) *Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, &e)
+	} else {
+		b.history[b.next] = &e
+		b.next = (b.next + 1) % len(b.history)
+	}
+	for sub := range b.subs {
+		if !sub.filter.Match(&e) {
+			continue
+		}
+		select {
+		case sub.events <- &e:
+		default:
+			sub.err = ErrSubscriptionLagged
+			b.remove(sub)
+		}
+	}
+	return &e
+}
+
+// Subscribe returns the events matching f. If after is set, the retained
+// events published after it are sent first. ErrEventsExpired is returned if
+// some of those events have already been discarded. IDs start over when the
+// service restarts, an after the bus hasn't assigned yet, such as the last
+// ID a client received before a restart, starts from now.
+func (b *EventBus) Subscribe(f EventFilter, after EventID) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var replay []*Event
+	if after > 0 && after <= b.lastID {
+		oldest := b.lastID - EventID(len(b.history)) + 1
+		if after+1 < oldest {
+			return nil, ErrEventsExpired
+		}
+		for i := range b.history {
+			e := b.history[(b.next+i)%len(b.history)]
+			if e.ID > after && f.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+	sub := &Subscription{
+		bus:    b,
+		filter: f,
+		events: make(chan *Event, subscriptionBuffer+len(replay)),
+	}
+	for _, e := range replay {
+		sub.events <- e
+	}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// remove must be called with the lock held
+func (b *EventBus) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.events)
+}
+
+// Subscription receives events until it is closed or dropped
+type Subscription struct {
+	bus    *EventBus
+	filter EventFilter
+	events chan *Event
+	err    error
+}
+
+// Events is closed when the subscription ends, check Err for the reason
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Err returns ErrSubscriptionLagged if the subscription was dropped
+func (s *Subscription) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// EventWrapper publishes a created event for every run created through the
+// wrapped repository
+type EventWrapper struct {
+	Repository
+	Events *EventBus
+}
+
+func NewEventWrapper(r Repository, b *EventBus) *EventWrapper {
+	return &EventWrapper{
+		Repository: r,
+		Events:     b,
+	}
+}
+
+// InTx calls f with the transaction of the wrapped repository, wrapped too
+func (w *EventWrapper) InTx(f func(Repository) error) error {
+	return inTx(w.Repository, func(r Repository) error {
+		return f(NewEventWrapper(r, w.Events))
+	})
+}
+
+func (w *EventWrapper) CreateRun(in *CreateRunInput) (RunID, error) {
+	id, err := w.Repository.CreateRun(in)
+	if err != nil {
+		return 0, err
+	}
+	w.publishCreated(in, id)
+	return id, nil
+}
+
+func (w *EventWrapper) CreateWebhookRun(in *CreateWebhookRunInput) (RunID, error) {
+	id, err := w.Repository.CreateWebhookRun(in)
+	if err != nil {
+		return id, err
+	}
+	w.publishCreated(in.Run, id)
+	return id, nil
+}
+
+// CreateBackfill publishes a created event for each run of the backfill
+func (w *EventWrapper) CreateBackfill(in *CreateBackfillInput) (BackfillID, error) {
+	id, err := w.Repository.CreateBackfill(in)
+	if err != nil {
+		return 0, err
+	}
+	runs, err := w.Repository.GetRuns(&GetRunsInput{BackfillID: &id, OrderBy: StringPtr(RunsOrderByID), Summary: true})
+	if err != nil {
+		return id, err
+	}
+	for _, r := range runs {
+		w.publishCreated(r.CreateRunInput(), r.RunID)
+	}
+	return id, nil
+}
+
+func (w *EventWrapper) publishCreated(in *CreateRunInput, id RunID) {
+	e := Event{Type: EventRunCreated, JobID: in.JobID, RunID: id}
+	if in.Attempt != nil {
+		e.Attempt = *in.Attempt
+	}
+	w.Events.Publish(e)
+}
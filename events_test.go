@@ -0,0 +1,247 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	b := NewEventBus(3)
+	all, err := b.Subscribe(EventFilter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer all.Close()
+	for _, e := range []Event{
+		{Type: EventRunCreated, JobID: 1, RunID: 1},
+		{Type: EventRunCreated, JobID: 2, RunID: 2},
+		{Type: EventRunClaimed, JobID: 1, RunID: 1},
+		{Type: EventRunStarted, JobID: 1, RunID: 1},
+	} {
+		b.Publish(e)
+	}
+	for i := EventID(1); i <= 4; i++ {
+		if e := <-all.Events(); e.ID != i {
+			t.Errorf("expected event %d, got %d", i, e.ID)
+		}
+	}
+
+	//resume after event 2, only events 3 and 4 of job 1 match
+	resumed, err := b.Subscribe(EventFilter{JobIDs: JobIDs{1}, Types: []EventType{EventRunClaimed, EventRunStarted}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Publish(Event{Type: EventRunStarted, JobID: 2, RunID: 2})
+	b.Publish(Event{Type: EventRunSucceeded, JobID: 1, RunID: 1})
+	b.Publish(Event{Type: EventRunStarted, JobID: 1, RunID: 3})
+	resumed.Close()
+	got := []EventID{}
+	for e := range resumed.Events() {
+		got = append(got, e.ID)
+	}
+	if expected := []EventID{3, 4, 7}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected resumed events %v, got %v", expected, got)
+	}
+
+	//only the last 3 events are kept
+	if _, err := b.Subscribe(EventFilter{}, 3); err != ErrEventsExpired {
+		t.Errorf("expected %s resuming after a discarded event, got %v", ErrEventsExpired, err)
+	}
+	if _, err := b.Subscribe(EventFilter{}, 4); err != nil {
+		t.Errorf("expected to resume after the oldest kept event, got %s", err)
+	}
+	//an ID from before a restart starts from now
+	restarted, err := b.Subscribe(EventFilter{}, 8)
+	if err != nil {
+		t.Fatalf("expected to resume after an unknown event, got %s", err)
+	}
+	b.Publish(Event{Type: EventRunCreated, JobID: 1, RunID: 4})
+	restarted.Close()
+	got = []EventID{}
+	for e := range restarted.Events() {
+		got = append(got, e.ID)
+	}
+	if expected := []EventID{8}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected only the events published since, %v, got %v", expected, got)
+	}
+
+	//subscribers that fall behind are dropped
+	slow, err := b.Subscribe(EventFilter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= subscriptionBuffer; i++ {
+		b.Publish(Event{Type: EventRunCreated})
+	}
+	n := 0
+	for range slow.Events() {
+		n++
+	}
+	if n != subscriptionBuffer || slow.Err() != ErrSubscriptionLagged {
+		t.Errorf("expected %d events and %s, got %d and %v", subscriptionBuffer, ErrSubscriptionLagged, n, slow.Err())
+	}
+}
+
+type processorFunc func(in []byte) (*RunResult, error)
+
+func (f processorFunc) Process(in []byte) (*RunResult, error) {
+	return f(in)
+}
+
+func TestServiceEvents(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+
+	//fails the first attempt
+	attempts := 0
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func([]byte) (*RunResult, error) {
+			attempts++
+			if attempts == 1 {
+				return &RunResult{Detail: "timeout"}, nil
+			}
+			return &RunResult{Success: true, Output: json.RawMessage(`{"rows":3}`)}, nil
+		}), nil
+	})
+	extract, err := r.CreateJob(&CreateJobInput{
+		Name:      "extract",
+		Processor: ProcessorConfig{Type: "test"},
+		Retryer:   RetryerConfig{Type: RetryerTypeDefault, Config: map[string]string{"NumRetries": "1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	load, err := r.CreateJob(&CreateJobInput{
+		Name:                 "load",
+		Processor:            ProcessorConfig{Type: "test"},
+		InputPayloadTemplate: []byte(`{"rows":{{.rows}}}`),
+		Triggers:             &TriggerEventsInput{JobSuccess: JobIDs{extract}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := s.Events().Subscribe(EventFilter{JobIDs: JobIDs{extract}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Repository().CreateRun(&CreateRunInput{JobID: extract, ProcessorConfig: ProcessorConfig{Type: "test"}, Attempt: IntPtr(1), Input: []byte("{}"), ScheduledStartTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		s.startDueRuns(time.Now().Add(time.Minute))
+		s.running.Wait()
+	}
+	sub.Close()
+
+	expected := []Event{
+		{Type: EventRunCreated, JobID: extract, RunID: 1, Attempt: 1},
+		{Type: EventRunClaimed, JobID: extract, RunID: 1, Attempt: 1},
+		{Type: EventRunStarted, JobID: extract, RunID: 1, Attempt: 1},
+		{Type: EventRunFailed, JobID: extract, RunID: 1, Attempt: 1, Detail: "timeout"},
+		{Type: EventRunCreated, JobID: extract, RunID: 2, Attempt: 2},
+		{Type: EventRunRetried, JobID: extract, RunID: 1, Attempt: 1, NextJobID: extract, NextRunID: 2},
+		{Type: EventRunClaimed, JobID: extract, RunID: 2, Attempt: 2},
+		{Type: EventRunStarted, JobID: extract, RunID: 2, Attempt: 2},
+		{Type: EventRunSucceeded, JobID: extract, RunID: 2, Attempt: 2},
+		{Type: EventRunTriggeredDownstream, JobID: extract, RunID: 2, Attempt: 2, NextJobID: load, NextRunID: 3},
+	}
+	got := []Event{}
+	for e := range sub.Events() {
+		c := *e
+		c.ID, c.Time = 0, time.Time{}
+		got = append(got, c)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected events\n%+v\ngot\n%+v", expected, got)
+	}
+
+	runs, err := r.GetRuns(&GetRunsInput{JobID: &load})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || string(runs[0].Input) != `{"rows":3}` || runs[0].Status != RunStatusPending {
+		t.Errorf("expected a pending run of the downstream job with the upstream output, got %+v", runs)
+	}
+	if runs[0].ParentRunID != 2 {
+		t.Errorf("expected the downstream run's parent to be run 2, got %s", runs[0].ParentRunID)
+	}
+	//the retry and the downstream run belong to the execution of the first attempt
+	if runs[0].RootRunID != 1 {
+		t.Errorf("expected the downstream run's root to be run 1, got %s", runs[0].RootRunID)
+	}
+
+	//runs of a backfill are created too
+	report, err := r.CreateJob(&CreateJobInput{Name: "report", Processor: ProcessorConfig{Type: "test"}, InputPayloadTemplate: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err = s.Events().Subscribe(EventFilter{JobIDs: JobIDs{report}, Types: []EventType{EventRunCreated}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dates := []time.Time{time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC)}
+	if _, err := StartBackfill(s.Repository(), &BackfillInput{JobID: report, LogicalDates: dates, MaxParallel: 1}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	sub.Close()
+	got = []Event{}
+	for e := range sub.Events() {
+		got = append(got, Event{Type: e.Type, RunID: e.RunID})
+	}
+	if expected := []Event{{Type: EventRunCreated, RunID: 4}, {Type: EventRunCreated, RunID: 5}}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected a created event for each run of the backfill, got %+v", got)
+	}
+}
+
+func TestServiceLoopLimit(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func([]byte) (*RunResult, error) {
+			return &RunResult{Success: true}, nil
+		}), nil
+	})
+	poll, err := r.CreateJob(&CreateJobInput{Name: "poll", Processor: ProcessorConfig{Type: "test"}, InputPayloadTemplate: []byte("{}"), LoopLimit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Repository().UpdateJob(&UpdateJobInput{JobID: poll, Triggers: &TriggerEventsInput{JobSuccess: JobIDs{poll}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Repository().CreateRun(&CreateRunInput{JobID: poll, ProcessorConfig: ProcessorConfig{Type: "test"}, Attempt: IntPtr(1), Input: []byte("{}"), ScheduledStartTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		s.startDueRuns(time.Now().Add(time.Minute))
+		s.running.Wait()
+	}
+	runs, err := r.GetRuns(&GetRunsInput{JobID: &poll, OrderBy: StringPtr(RunsOrderByID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("expected the loop to stop after 3 runs, got %d", len(runs))
+	}
+	for i, run := range runs {
+		if run.ParentRunID != RunID(i) {
+			t.Errorf("run %s: expected parent %d, got %s", run.RunID, i, run.ParentRunID)
+		}
+	}
+}
+
+// testWriter sends log output to t.Log
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(string(p))
+	return len(p), nil
+}
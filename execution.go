@@ -0,0 +1,105 @@
+package pipeline
+
+import "time"
+
+const ErrExecutionNotFound = Err("execution not found")
+
+type ExecutionStatus string
+
+const (
+	ExecutionStatusRunning         ExecutionStatus = "running"
+	ExecutionStatusSucceeded       ExecutionStatus = "succeeded"
+	ExecutionStatusPartiallyFailed ExecutionStatus = "partially_failed"
+	ExecutionStatusFailed          ExecutionStatus = "failed"
+)
+
+// Execution is everything that happened because a run was started by a
+// schedule or by hand: that root run and every run created from it by
+// triggers and retries. The execution is identified by the ID of its root
+// run, which every other run of it records as RootRunID.
+type Execution struct {
+	ID        RunID //the root run
+	JobID     JobID //job of the root run
+	Status    ExecutionStatus
+	StartTime *time.Time //earliest start of its runs, nil until one started
+	EndTime   *time.Time //latest end of its runs, nil while it's running
+	Runs      int        //every run, retried attempts included
+
+	//runs that weren't retried by the end of the execution
+	Succeeded int
+	Failed    int
+	Cancelled int
+}
+
+// Duration is the time from the first run starting to the last run ending,
+// or to now while the execution is running
+func (e *Execution) Duration(now time.Time) time.Duration {
+	if e.StartTime == nil {
+		return 0
+	}
+	if e.EndTime == nil {
+		return now.Sub(*e.StartTime)
+	}
+	return e.EndTime.Sub(*e.StartTime)
+}
+
+// NewExecution aggregates the runs of the execution started by root, root
+// included. An execution is running until none of its runs are left to
+// process. It then succeeded if every run that wasn't retried succeeded,
+// failed if none of them did and partially failed otherwise; cancelled runs
+// count as failures.
+func NewExecution(root *Run, runs []*Run) *Execution {
+	e := &Execution{ID: root.RunID, JobID: root.JobID, Runs: len(runs)}
+
+	//a failed attempt that was retried doesn't count, its retry does
+	type attemptKey struct {
+		job      JobID
+		parent   RunID
+		mapIndex int
+	}
+	lastAttempt := map[attemptKey]int{}
+	for _, r := range runs {
+		k := attemptKey{r.JobID, r.ParentRunID, r.MapIndex}
+		if r.Attempt > lastAttempt[k] {
+			lastAttempt[k] = r.Attempt
+		}
+	}
+
+	running := false
+	for _, r := range runs {
+		if r.StartTime != nil && (e.StartTime == nil || r.StartTime.Before(*e.StartTime)) {
+			e.StartTime = r.StartTime
+		}
+		if r.EndTime != nil && (e.EndTime == nil || r.EndTime.After(*e.EndTime)) {
+			e.EndTime = r.EndTime
+		}
+		switch r.Status {
+		case RunStatusComplete:
+			if r.Attempt < lastAttempt[attemptKey{r.JobID, r.ParentRunID, r.MapIndex}] {
+				continue
+			}
+			if r.Success {
+				e.Succeeded++
+			} else {
+				e.Failed++
+			}
+		case RunStatusCancelled:
+			e.Cancelled++
+		default:
+			running = true
+		}
+	}
+
+	switch {
+	case running:
+		e.Status = ExecutionStatusRunning
+		e.EndTime = nil
+	case e.Failed+e.Cancelled == 0:
+		e.Status = ExecutionStatusSucceeded
+	case e.Succeeded == 0:
+		e.Status = ExecutionStatusFailed
+	default:
+		e.Status = ExecutionStatusPartiallyFailed
+	}
+	return e
+}
@@ -0,0 +1,154 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewExecution(t *testing.T) {
+	at := func(min int) *time.Time {
+		t := time.Date(2017, 3, 1, 2, min, 0, 0, time.UTC)
+		return &t
+	}
+	run := func(id, job, parent RunID, attempt int, status RunStatus, success bool, start, end *time.Time) *Run {
+		return &Run{
+			RunID:       id,
+			JobID:       JobID(job),
+			ParentRunID: parent,
+			RootRunID:   1,
+			Attempt:     attempt,
+			Status:      status,
+			Success:     success,
+			StartTime:   start,
+			EndTime:     end,
+		}
+	}
+	root := run(1, 1, 0, 1, RunStatusComplete, true, at(0), at(5))
+	root.RootRunID = 0
+
+	tests := []struct {
+		name     string
+		runs     []*Run
+		expected *Execution
+	}{
+		{
+			name: "running",
+			runs: []*Run{root, run(2, 2, 1, 1, RunStatusRunning, false, at(6), nil)},
+			expected: &Execution{
+				ID: 1, JobID: 1, Status: ExecutionStatusRunning, StartTime: at(0), Runs: 2, Succeeded: 1,
+			},
+		},
+		{
+			name: "succeeded after a retry",
+			runs: []*Run{
+				root,
+				run(2, 2, 1, 1, RunStatusComplete, false, at(6), at(7)),
+				run(3, 2, 1, 2, RunStatusComplete, true, at(8), at(9)),
+			},
+			expected: &Execution{
+				ID: 1, JobID: 1, Status: ExecutionStatusSucceeded, StartTime: at(0), EndTime: at(9), Runs: 3, Succeeded: 2,
+			},
+		},
+		{
+			name: "partially failed",
+			runs: []*Run{
+				root,
+				run(2, 2, 1, 1, RunStatusComplete, false, at(6), at(7)),
+				run(3, 3, 1, 1, RunStatusCancelled, false, nil, at(6)),
+			},
+			expected: &Execution{
+				ID: 1, JobID: 1, Status: ExecutionStatusPartiallyFailed, StartTime: at(0), EndTime: at(7),
+				Runs: 3, Succeeded: 1, Failed: 1, Cancelled: 1,
+			},
+		},
+		{
+			name: "failed",
+			runs: []*Run{
+				{RunID: 1, JobID: 1, Attempt: 1, Status: RunStatusComplete, StartTime: at(0), EndTime: at(1)},
+				run(2, 1, 0, 2, RunStatusComplete, false, at(2), at(3)),
+			},
+			expected: &Execution{
+				ID: 1, JobID: 1, Status: ExecutionStatusFailed, StartTime: at(0), EndTime: at(3), Runs: 2, Failed: 1,
+			},
+		},
+	}
+	for _, test := range tests {
+		got := NewExecution(test.runs[0], test.runs)
+		if !reflect.DeepEqual(test.expected, got) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, got)
+		}
+	}
+
+	e := &Execution{StartTime: at(0), EndTime: at(5)}
+	if d := e.Duration(*at(30)); d != 5*time.Minute {
+		t.Errorf("expected duration of 5m, got %s", d)
+	}
+	e.EndTime = nil
+	if d := e.Duration(*at(30)); d != 30*time.Minute {
+		t.Errorf("expected duration of 30m while running, got %s", d)
+	}
+}
+
+func TestSQLiteGetExecutions(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	create := func(job JobID, parent, root RunID, attempt int) RunID {
+		id, err := r.CreateRun(&CreateRunInput{
+			JobID:              job,
+			ScheduledStartTime: time.Date(2017, 3, 1, 2, 0, 0, 0, time.UTC),
+			Attempt:            &attempt,
+			Input:              []byte("{}"),
+			ParentRunID:        parent,
+			RootRunID:          root,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	complete := func(id RunID, success bool) {
+		err := r.UpdateRun(&UpdateRunInput{RunID: id, Status: RunStatusPtr(RunStatusComplete), Success: &success})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := create(1, 0, 0, 1)
+	complete(first, false)
+	retry := create(1, 0, first, 2)
+	complete(retry, true)
+	downstream := create(2, retry, first, 1)
+	complete(downstream, false)
+	second := create(2, 0, 0, 1)
+
+	got, err := r.GetExecutions(&GetExecutionsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != second || got[1].ID != first {
+		t.Fatalf("expected executions %d and %d, got %+v", second, first, got)
+	}
+	if got[0].Status != ExecutionStatusRunning || got[0].Runs != 1 {
+		t.Errorf("expected a running execution of 1 run, got %+v", got[0])
+	}
+	if got[1].Status != ExecutionStatusPartiallyFailed || got[1].Runs != 3 || got[1].Succeeded != 1 || got[1].Failed != 1 {
+		t.Errorf("expected a partially failed execution of 3 runs, got %+v", got[1])
+	}
+
+	limit := uint64(1)
+	got, err = r.GetExecutions(&GetExecutionsInput{Before: &second, Limit: &limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != first {
+		t.Errorf("expected execution %d before %d, got %+v", first, second, got)
+	}
+	got, err = r.GetExecutions(&GetExecutionsInput{JobIDs: JobIDs{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != second {
+		t.Errorf("expected execution %d of job 2, got %+v", second, got)
+	}
+}
@@ -0,0 +1,278 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// fanOut creates a run of j for each element of the array at j's MapPath in
+// the output of r, delayed as the trigger says. Runs beyond MapMaxParallel
+// are queued until a slot frees up. If the path doesn't select an array a
+// single failed run of j records why.
+func (s *Service) fanOut(j *Job, r *Run, output []byte) error {
+	now := time.Now()
+	start := now.Add(j.Triggers.Delay(r.JobID, r.Success))
+	v, err := j.Triggers.MapPath.Select(output)
+	elements, ok := v.([]interface{})
+	if err == nil && !ok {
+		err = fmt.Errorf("%s is %s, not an array", j.Triggers.MapPath, jsonType(v))
+	}
+	runs := make([]*Run, len(elements))
+	for i, e := range elements {
+		if err != nil {
+			break
+		}
+		var data []byte
+		if data, err = json.Marshal(e); err != nil {
+			break
+		}
+		if runs[i], err = j.MakeRun(downstreamContext(r, start, data)); err != nil {
+			err = fmt.Errorf("element %d: err rendering input: %s", i, err)
+		}
+	}
+	if err != nil {
+		return s.failDownstream(j, r, "err fanning out: "+err.Error(), now)
+	}
+
+	s.mapMu.Lock()
+	defer s.mapMu.Unlock()
+	for i, next := range runs {
+		next.ParentRunID = r.RunID
+		next.RootRunID = r.Root()
+		next.BackfillID = r.BackfillID
+		next.MapIndex = i
+		next.MapCount = len(runs)
+		if j.Triggers.MapMaxParallel > 0 && i >= j.Triggers.MapMaxParallel {
+			next.Status = RunStatusQueued
+		}
+		id, err := s.repo.CreateRun(next.CreateRunInput())
+		if err != nil {
+			return err
+		}
+		s.publishNext(EventRunTriggeredDownstream, r, j.ID, id)
+	}
+	if len(runs) == 0 {
+		//nothing to wait for
+		return s.reduce(j.ID, r, r, nil)
+	}
+	return nil
+}
+
+// failDownstream records a run of j triggered by r that couldn't be made,
+// such as a fan-out that couldn't start or an input that didn't render, as a
+// failed run of j so it shows up in the execution and triggers on failure
+func (s *Service) failDownstream(j *Job, r *Run, detail string, now time.Time) error {
+	failed, err := s.createFailedRun(j, r, detail, now)
+	if err != nil {
+		return err
+	}
+	return s.triggerDownstream(failed)
+}
+
+// createFailedRun records the failed run of failDownstream without
+// triggering its downstream jobs
+func (s *Service) createFailedRun(j *Job, r *Run, detail string, now time.Time) (*Run, error) {
+	s.log.Printf("job %s triggered by run %s: %s", j.ID, r.RunID, detail)
+	failed := &Run{
+		JobID:              j.ID,
+		JobVersionID:       j.VersionID,
+		ProcessorConfig:    j.ProcessorConfig,
+		Status:             RunStatusComplete,
+		StatusDetail:       detail,
+		ScheduledStartTime: downstreamContext(r, now, nil).ScheduledStartTime,
+		NotBefore:          now,
+		EndTime:            &now,
+		Attempt:            1,
+		ParentRunID:        r.RunID,
+		RootRunID:          r.Root(),
+		BackfillID:         r.BackfillID,
+		Input:              []byte("{}"),
+	}
+	id, err := s.repo.CreateRun(failed.CreateRunInput())
+	if err != nil {
+		return nil, err
+	}
+	failed.RunID = id
+	s.publishNext(EventRunTriggeredDownstream, r, j.ID, id)
+	s.publish(EventRunFailed, failed)
+	return failed, nil
+}
+
+// mapRunFinished is called when the mapped run r has finished, it won't be
+// retried. It starts the next queued run of the fan-out and triggers the
+// reduce jobs once every run of the fan-out has finished.
+func (s *Service) mapRunFinished(r *Run) error {
+	s.mapMu.Lock()
+	defer s.mapMu.Unlock()
+
+	job, err := s.getJob(r.JobID)
+	if err != nil {
+		return err
+	}
+	latest, err := s.fanOutRuns(r, true)
+	if err != nil {
+		return err
+	}
+	active := 0
+	var queued []*Run
+	for _, run := range latest {
+		switch run.Status {
+		case RunStatusComplete, RunStatusCancelled:
+		case RunStatusQueued:
+			queued = append(queued, run)
+		default:
+			active++
+		}
+	}
+	for _, q := range queued {
+		if job.Triggers.MapMaxParallel > 0 && active >= job.Triggers.MapMaxParallel {
+			break
+		}
+		now := time.Now()
+		err := s.repo.UpdateRun(&UpdateRunInput{
+			RunID:     q.RunID,
+			Status:    RunStatusPtr(RunStatusPending),
+			NotBefore: &now,
+			IfStatus:  []RunStatus{RunStatusQueued},
+		})
+		if err == ErrRunStatusChanged {
+			//cancelled since
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		active++
+	}
+	if active > 0 || len(queued) > 0 || len(latest) < r.MapCount {
+		return nil
+	}
+
+	runs, err := s.repo.GetRuns(&GetRunsInput{RunID: &r.ParentRunID, Summary: true})
+	if err != nil {
+		return err
+	}
+	if len(runs) != 1 {
+		s.log.Printf("run %s that fanned out to job %s not found, not reducing", r.ParentRunID, r.JobID)
+		return nil
+	}
+	mapped, err := s.fanOutRuns(r, false)
+	if err != nil {
+		return err
+	}
+	return s.reduce(r.JobID, runs[0], r, mapped)
+}
+
+// fanOutRuns returns the latest attempt of each run of the fan-out r belongs
+// to, ordered by element
+func (s *Service) fanOutRuns(r *Run, summary bool) ([]*Run, error) {
+	runs, err := s.repo.GetRuns(&GetRunsInput{
+		JobID:       &r.JobID,
+		ParentRunID: &r.ParentRunID,
+		OrderBy:     StringPtr(RunsOrderByID),
+		Summary:     summary,
+	})
+	if err != nil {
+		return nil, err
+	}
+	latest := map[int]*Run{}
+	for _, run := range runs {
+		if run.MapCount > 0 {
+			latest[run.MapIndex] = run
+		}
+	}
+	mapped := make([]*Run, 0, len(latest))
+	for _, run := range latest {
+		mapped = append(mapped, run)
+	}
+	sort.Slice(mapped, func(i, k int) bool { return mapped[i].MapIndex < mapped[k].MapIndex })
+	return mapped, nil
+}
+
+// reduce creates a run of each job triggered by the completion of the
+// fan-out of mapJob from source, unless it already has one. The template
+// data is
+//
+//	{"outputs": [...], "succeeded": 2, "failed": 1}
+//
+// with the outputs in element order, null for the runs that failed. last is
+// the run that completed the fan-out.
+func (s *Service) reduce(mapJob JobID, source, last *Run, mapped []*Run) error {
+	jobs, err := s.repo.GetJobs(&GetJobsInput{All: true})
+	if err != nil {
+		return err
+	}
+	var reducers []*Job
+	for _, j := range jobs {
+		if !j.Paused && jobIDsContain(j.Triggers.JobMapComplete, mapJob) {
+			reducers = append(reducers, j)
+		}
+	}
+	if len(reducers) == 0 {
+		return nil
+	}
+
+	data := struct {
+		Outputs   []json.RawMessage `json:"outputs"`
+		Succeeded int               `json:"succeeded"`
+		Failed    int               `json:"failed"`
+	}{Outputs: make([]json.RawMessage, len(mapped))}
+	for i, run := range mapped {
+		if !run.Success {
+			data.Outputs[i] = json.RawMessage("null")
+			data.Failed++
+			continue
+		}
+		if err := run.LoadPayloads(s.BlobStore); err != nil {
+			return err
+		}
+		data.Outputs[i] = json.RawMessage(run.Output)
+		if len(run.Output) == 0 {
+			data.Outputs[i] = json.RawMessage("{}")
+		}
+		data.Succeeded++
+	}
+	output, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range reducers {
+		existing, err := s.repo.GetRuns(&GetRunsInput{JobID: &j.ID, ParentRunID: &source.RunID, Summary: true})
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			continue
+		}
+		next, err := j.MakeRun(downstreamContext(last, time.Now(), output))
+		if err != nil {
+			failed, err := s.createFailedRun(j, source, "err rendering input: "+err.Error(), time.Now())
+			if err != nil {
+				return err
+			}
+			//mapMu is held and the jobs triggered by the failure may fan out
+			s.running.Add(1)
+			go func() {
+				defer s.running.Done()
+				if err := s.triggerDownstream(failed); err != nil {
+					s.log.Printf("err triggering jobs downstream of run %s: %s", failed.RunID, err)
+				}
+			}()
+			continue
+		}
+		//the reduce run descends from the run that fanned out, like the
+		//mapped runs
+		next.ParentRunID = source.RunID
+		next.RootRunID = source.Root()
+		next.BackfillID = source.BackfillID
+		id, err := s.repo.CreateRun(next.CreateRunInput())
+		if err != nil {
+			return err
+		}
+		s.publishNext(EventRunTriggeredDownstream, last, j.ID, id)
+	}
+	return nil
+}
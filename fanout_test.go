@@ -0,0 +1,163 @@
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceFanOut(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.AddProcessor("echo", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func(input []byte) (*RunResult, error) {
+			return &RunResult{Success: !bytes.Contains(input, []byte(`"partition": "b"`)), Output: input}, nil
+		}), nil
+	})
+	create := func(in *CreateJobInput) JobID {
+		in.Processor = ProcessorConfig{Type: "echo"}
+		if in.InputPayloadTemplate == nil {
+			in.InputPayloadTemplate = []byte("{}")
+		}
+		id, err := s.Repository().CreateJob(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	list := create(&CreateJobInput{Name: "list"})
+	process := create(&CreateJobInput{
+		Name:                 "process",
+		InputPayloadTemplate: []byte(`{"partition": "{{.Upstream.Output}}", "from": {{.Upstream.RunID}}}`),
+		Triggers: &TriggerEventsInput{
+			JobSuccess:     JobIDs{list},
+			MapPath:        (*OutputPath)(StringPtr("$.partitions")),
+			MapMaxParallel: IntPtr(2),
+		},
+	})
+	reduce := create(&CreateJobInput{
+		Name:                 "reduce",
+		InputPayloadTemplate: []byte(`{"succeeded": {{.succeeded}}, "failed": {{.failed}}, "first": {{toJson (index .outputs 0 "partition")}}}`),
+		Triggers:             &TriggerEventsInput{JobMapComplete: JobIDs{process}},
+	})
+	broken := create(&CreateJobInput{
+		Name: "broken",
+		Triggers: &TriggerEventsInput{
+			JobSuccess: JobIDs{list},
+			MapPath:    (*OutputPath)(StringPtr("$.count")),
+		},
+	})
+	//inputs that don't render are failed runs, the jobs they trigger on
+	//failure may fan out themselves
+	report := create(&CreateJobInput{
+		Name:                 "report",
+		InputPayloadTemplate: []byte(`{"rows": {{.rows}}}`),
+		Triggers:             &TriggerEventsInput{JobSuccess: JobIDs{list}},
+	})
+	badReduce := create(&CreateJobInput{
+		Name:                 "bad reduce",
+		InputPayloadTemplate: []byte(`{"rows": {{.rows}}}`),
+		Triggers:             &TriggerEventsInput{JobMapComplete: JobIDs{process}},
+	})
+	alert := create(&CreateJobInput{
+		Name: "alert",
+		Triggers: &TriggerEventsInput{
+			JobFailure: JobIDs{report, badReduce},
+			MapPath:    (*OutputPath)(StringPtr("$.recipients")),
+		},
+	})
+
+	listRun, err := r.CreateRun(&CreateRunInput{
+		JobID:              list,
+		ProcessorConfig:    ProcessorConfig{Type: "echo"},
+		Attempt:            IntPtr(1),
+		Input:              []byte(`{"partitions": ["a", "b", "c"], "count": 3}`),
+		ScheduledStartTime: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tick := func() {
+		s.startDueRuns(time.Now().Add(time.Minute))
+		s.running.Wait()
+	}
+	tick()
+
+	mapped, err := r.GetRuns(&GetRunsInput{JobID: &process, OrderBy: StringPtr(RunsOrderByID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapped) != 3 {
+		t.Fatalf("expected 3 mapped runs, got %d", len(mapped))
+	}
+	for i, run := range mapped {
+		expected := RunStatusPending
+		if i == 2 {
+			expected = RunStatusQueued
+		}
+		if run.Status != expected || run.MapIndex != i || run.MapCount != 3 || run.ParentRunID != listRun {
+			t.Errorf("mapped run %d: unexpected %+v", i, run)
+		}
+		if input := `{"partition": "` + []string{"a", "b", "c"}[i] + `", "from": ` + listRun.String() + `}`; string(run.Input) != input {
+			t.Errorf("mapped run %d: expected input %s, got %s", i, input, run.Input)
+		}
+	}
+	runs, err := r.GetRuns(&GetRunsInput{JobID: &broken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Success || runs[0].Status != RunStatusComplete ||
+		!strings.Contains(runs[0].StatusDetail, "$.count is number, not an array") {
+		t.Errorf("expected a failed run of broken, got %+v", runs)
+	}
+	runs, err = r.GetRuns(&GetRunsInput{JobID: &report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Success || runs[0].Status != RunStatusComplete || runs[0].ParentRunID != listRun ||
+		!strings.Contains(runs[0].StatusDetail, "err rendering input") {
+		t.Errorf("expected a failed run of report, got %+v", runs)
+	}
+
+	//the first two mapped runs finish, the queued one takes a slot
+	tick()
+	runs, err = r.GetRuns(&GetRunsInput{RunID: &mapped[2].RunID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[0].Status != RunStatusPending {
+		t.Errorf("expected the queued run to be pending, got %s", runs[0].Status)
+	}
+	if runs, _ := r.GetRuns(&GetRunsInput{JobID: &reduce}); len(runs) != 0 {
+		t.Errorf("expected no reduce run before the fan-out finished, got %+v", runs)
+	}
+
+	tick()
+	tick()
+	runs, err = r.GetRuns(&GetRunsInput{JobID: &reduce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("expected 1 reduce run, got %d", len(runs))
+	}
+	if input := `{"succeeded": 2, "failed": 1, "first": "a"}`; string(runs[0].Input) != input {
+		t.Errorf("reduce: expected input %s, got %s", input, runs[0].Input)
+	}
+	if runs[0].ParentRunID != listRun || runs[0].RootRunID != listRun {
+		t.Errorf("reduce: expected to descend from run %s, got %+v", listRun, runs[0])
+	}
+	runs, err = r.GetRuns(&GetRunsInput{JobID: &badReduce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Success || runs[0].ParentRunID != listRun || !strings.Contains(runs[0].StatusDetail, "err rendering input") {
+		t.Errorf("expected a failed run of bad reduce, got %+v", runs)
+	}
+	if runs, _ := r.GetRuns(&GetRunsInput{JobID: &alert}); len(runs) != 2 {
+		t.Errorf("expected a run of alert for each failure, got %+v", runs)
+	}
+}
@@ -0,0 +1,316 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type EdgeType string
+
+const (
+	EdgeTypeSuccess EdgeType = "success"
+	EdgeTypeFailure EdgeType = "failure"
+	//every mapped run of a fan-out of From has finished, see
+	//TriggerEvents.JobMapComplete
+	EdgeTypeMapComplete EdgeType = "map_complete"
+)
+
+// JobGraph is the network of jobs formed by their success and failure
+// triggers
+type JobGraph struct {
+	Nodes []*GraphNode //ordered by job ID
+	Edges []*GraphEdge //ordered by From, To and Type
+}
+
+type GraphNode struct {
+	Job     *Job
+	LastRun *Run //latest run of the job, nil if the graph has no statuses or the job never ran
+}
+
+// GraphEdge means a run of From that ends with Type creates a run of To
+type GraphEdge struct {
+	From      JobID
+	To        JobID
+	Type      EdgeType
+	Condition Condition     //must hold on the output of From, empty if there is none
+	Delay     time.Duration //the run of To is scheduled this long after the run of From completed
+}
+
+func (e *GraphEdge) label() string {
+	label := string(e.Type)
+	if e.Condition != "" {
+		label += " if " + string(e.Condition)
+	}
+	if e.Delay != 0 {
+		label += " after " + e.Delay.String()
+	}
+	return label
+}
+
+// RunState is the status of a run, with completed runs reported as
+// "succeeded" or "failed"
+func RunState(r *Run) string {
+	if r.Status != RunStatusComplete {
+		return r.Status.String()
+	}
+	if r.Success {
+		return "succeeded"
+	}
+	return "failed"
+}
+
+// BuildJobGraph builds the graph of every job. If withStatus is set the
+// latest run of each job is loaded, without payloads. Triggers by jobs that
+// no longer exist are left out.
+func BuildJobGraph(r Repository, withStatus bool) (*JobGraph, error) {
+	jobs, err := r.GetJobs(&GetJobsInput{All: true})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ID < jobs[k].ID })
+	g := &JobGraph{Nodes: make([]*GraphNode, len(jobs))}
+	exists := map[JobID]bool{}
+	for i, j := range jobs {
+		g.Nodes[i] = &GraphNode{Job: j}
+		exists[j.ID] = true
+	}
+	for _, j := range jobs {
+		for _, up := range j.Triggers.JobSuccess {
+			if exists[up] {
+				g.Edges = append(g.Edges, &GraphEdge{
+					From:      up,
+					To:        j.ID,
+					Type:      EdgeTypeSuccess,
+					Condition: j.Triggers.JobSuccessConditions[up],
+					Delay:     j.Triggers.JobSuccessDelays[up],
+				})
+			}
+		}
+		for _, up := range j.Triggers.JobFailure {
+			if exists[up] {
+				g.Edges = append(g.Edges, &GraphEdge{
+					From:      up,
+					To:        j.ID,
+					Type:      EdgeTypeFailure,
+					Condition: j.Triggers.JobFailureConditions[up],
+					Delay:     j.Triggers.JobFailureDelays[up],
+				})
+			}
+		}
+		for _, up := range j.Triggers.JobMapComplete {
+			if exists[up] {
+				g.Edges = append(g.Edges, &GraphEdge{From: up, To: j.ID, Type: EdgeTypeMapComplete})
+			}
+		}
+	}
+	sort.Slice(g.Edges, func(i, k int) bool {
+		a, b := g.Edges[i], g.Edges[k]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Type < b.Type
+	})
+
+	if !withStatus {
+		return g, nil
+	}
+	limit := uint64(1)
+	orderBy := RunsOrderByID
+	for _, n := range g.Nodes {
+		id := n.Job.ID
+		runs, err := r.GetRuns(&GetRunsInput{
+			JobID:      &id,
+			OrderBy:    &orderBy,
+			Descending: true,
+			Limit:      &limit,
+			Summary:    true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			n.LastRun = runs[0]
+		}
+	}
+	return g, nil
+}
+
+// Downstream returns the edges leaving job id
+func (g *JobGraph) Downstream(id JobID) []*GraphEdge {
+	var edges []*GraphEdge
+	for _, e := range g.Edges {
+		if e.From == id {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// Upstream returns the edges entering job id
+func (g *JobGraph) Upstream(id JobID) []*GraphEdge {
+	var edges []*GraphEdge
+	for _, e := range g.Edges {
+		if e.To == id {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// graphStateColors fills nodes by the state of their latest run, see
+// graphStateColor
+var graphStateColors = map[string]string{
+	"succeeded":                 "#c8e6c9",
+	"failed":                    "#ffcdd2",
+	RunStatusRunning.String():   "#bbdefb",
+	RunStatusPending.String():   "#fff9c4",
+	RunStatusQueued.String():    "#fff9c4",
+	RunStatusCancelled.String(): "#e0e0e0",
+	RunStatusJoining.String():   "#fff9c4",
+	RunStatusWaiting.String():   "#ffe0b2",
+}
+
+// graphDefaultColor fills nodes in a state without a color of their own
+const graphDefaultColor = "#ffffff"
+
+func graphStateColor(state string) string {
+	if c, ok := graphStateColors[state]; ok {
+		return c
+	}
+	return graphDefaultColor
+}
+
+// label names a node, with the state of its latest run and whether it's
+// paused
+func (n *GraphNode) label() string {
+	var notes []string
+	if n.LastRun != nil {
+		notes = append(notes, RunState(n.LastRun))
+	}
+	if n.Job.Triggers.MapPath != "" {
+		notes = append(notes, "map "+string(n.Job.Triggers.MapPath))
+	}
+	if n.Job.Paused {
+		notes = append(notes, "paused")
+	}
+	if len(notes) == 0 {
+		return n.Job.Name
+	}
+	return n.Job.Name + " (" + strings.Join(notes, ", ") + ")"
+}
+
+// DOT formats the graph for Graphviz. Success edges are green, failure edges
+// red and dashed, map complete edges blue and bold, nodes are filled by the
+// state of their latest run.
+func (g *JobGraph) DOT() string {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	b := &bytes.Buffer{}
+	b.WriteString("digraph jobs {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, n := range g.Nodes {
+		attrs := fmt.Sprintf(`label="%s"`, quote.Replace(n.label()))
+		if n.LastRun != nil {
+			attrs += fmt.Sprintf(`, style=filled, fillcolor="%s"`, graphStateColor(RunState(n.LastRun)))
+		}
+		fmt.Fprintf(b, "\tjob%d [%s];\n", n.Job.ID, attrs)
+	}
+	for _, e := range g.Edges {
+		attrs := fmt.Sprintf(`label="%s", color="green"`, quote.Replace(e.label()))
+		switch e.Type {
+		case EdgeTypeFailure:
+			attrs = fmt.Sprintf(`label="%s", color="red", style=dashed`, quote.Replace(e.label()))
+		case EdgeTypeMapComplete:
+			attrs = fmt.Sprintf(`label="%s", color="blue", style=bold`, quote.Replace(e.label()))
+		}
+		fmt.Fprintf(b, "\tjob%d -> job%d [%s];\n", e.From, e.To, attrs)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid formats the graph as a Mermaid flowchart. Failure edges are
+// dotted, map complete edges thick, nodes get a class named after the state
+// of their latest run.
+func (g *JobGraph) Mermaid() string {
+	quote := strings.NewReplacer(`"`, "#quot;", "\n", " ")
+	b := &bytes.Buffer{}
+	b.WriteString("flowchart LR\n")
+	classes := map[string][]string{}
+	for _, n := range g.Nodes {
+		node := fmt.Sprintf("job%d", n.Job.ID)
+		fmt.Fprintf(b, "\t%s[\"%s\"]\n", node, quote.Replace(n.label()))
+		if n.LastRun != nil {
+			state := RunState(n.LastRun)
+			classes[state] = append(classes[state], node)
+		}
+	}
+	for _, e := range g.Edges {
+		arrow := "-->"
+		switch e.Type {
+		case EdgeTypeFailure:
+			arrow = "-.->"
+		case EdgeTypeMapComplete:
+			arrow = "==>"
+		}
+		label := string(e.Type)
+		if e.Condition != "" || e.Delay != 0 {
+			label = `"` + quote.Replace(e.label()) + `"`
+		}
+		fmt.Fprintf(b, "\tjob%d %s|%s| job%d\n", e.From, arrow, label, e.To)
+	}
+	states := make([]string, 0, len(classes))
+	for s := range classes {
+		states = append(states, s)
+	}
+	sort.Strings(states)
+	for _, s := range states {
+		fmt.Fprintf(b, "\tclassDef %s fill:%s\n", s, graphStateColor(s))
+		fmt.Fprintf(b, "\tclass %s %s\n", strings.Join(classes[s], ","), s)
+	}
+	return b.String()
+}
+
+// FindTriggerCycle returns a path of triggers from start back to itself that
+// doesn't pass a job with a LoopLimit, or nil if there's none. Such a cycle
+// would trigger runs forever.
+func FindTriggerCycle(jobs []*Job, start JobID) []JobID {
+	byID := map[JobID]*Job{}
+	downstream := map[JobID]JobIDs{}
+	for _, j := range jobs {
+		byID[j.ID] = j
+		for _, up := range j.Triggers.upstream() {
+			if !jobIDsContain(downstream[up], j.ID) {
+				downstream[up] = append(downstream[up], j.ID)
+			}
+		}
+	}
+	if byID[start] == nil || byID[start].LoopLimit > 0 {
+		return nil
+	}
+	visited := map[JobID]bool{}
+	var visit func(id JobID, path []JobID) []JobID
+	visit = func(id JobID, path []JobID) []JobID {
+		path = append(path, id)
+		next := downstream[id]
+		sort.Slice(next, func(i, k int) bool { return next[i] < next[k] })
+		for _, d := range next {
+			if d == start {
+				return append(path, d)
+			}
+			if visited[d] || byID[d] == nil || byID[d].LoopLimit > 0 {
+				continue
+			}
+			visited[d] = true
+			if cycle := visit(d, path); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return visit(start, nil)
+}
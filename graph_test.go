@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobGraph(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	create := func(in *CreateJobInput) JobID {
+		in.Processor = ProcessorConfig{Type: "lambda"}
+		id, err := r.CreateJob(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	extract := create(&CreateJobInput{Name: "extract"})
+	load := create(&CreateJobInput{Name: "load", Triggers: &TriggerEventsInput{
+		JobSuccess:           JobIDs{extract},
+		JobSuccessConditions: map[JobID]Condition{extract: "$.rows > 0"},
+		MapPath:              (*OutputPath)(StringPtr("$.files")),
+	}})
+	create(&CreateJobInput{Name: `"alert"`, Triggers: &TriggerEventsInput{JobFailure: JobIDs{extract, load}}})
+	create(&CreateJobInput{Name: "summary", Triggers: &TriggerEventsInput{JobMapComplete: JobIDs{load}}})
+	paused := true
+	if err := r.UpdateJob(&UpdateJobInput{JobID: load, Paused: &paused}); err != nil {
+		t.Fatal(err)
+	}
+	for _, success := range []bool{false, true} {
+		_, err := r.CreateRun(&CreateRunInput{
+			JobID:              extract,
+			ProcessorConfig:    ProcessorConfig{Type: "lambda"},
+			Status:             RunStatusPtr(RunStatusComplete),
+			Success:            BoolPtr(success),
+			ScheduledStartTime: time.Now(),
+			Input:              []byte("{}"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := BuildJobGraph(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Nodes) != 4 || g.Nodes[0].LastRun != nil {
+		t.Fatalf("nodes = %+v", g.Nodes)
+	}
+	if down := g.Downstream(extract); len(down) != 2 || *down[0] != (GraphEdge{extract, load, EdgeTypeSuccess, "$.rows > 0", 0}) ||
+		*down[1] != (GraphEdge{extract, 3, EdgeTypeFailure, "", 0}) {
+		t.Errorf("downstream of extract = %+v", down)
+	}
+	if up := g.Upstream(3); len(up) != 2 || up[0].From != extract || up[1].From != load {
+		t.Errorf("upstream of alert = %+v", up)
+	}
+
+	g, err = BuildJobGraph(r, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Nodes[0].LastRun == nil || g.Nodes[0].LastRun.RunID != 2 || g.Nodes[1].LastRun != nil {
+		t.Fatalf("last runs = %+v, %+v", g.Nodes[0].LastRun, g.Nodes[1].LastRun)
+	}
+
+	expected := `digraph jobs {
+	rankdir=LR;
+	node [shape=box];
+	job1 [label="extract (succeeded)", style=filled, fillcolor="#c8e6c9"];
+	job2 [label="load (map $.files, paused)"];
+	job3 [label="\"alert\""];
+	job4 [label="summary"];
+	job1 -> job2 [label="success if $.rows > 0", color="green"];
+	job1 -> job3 [label="failure", color="red", style=dashed];
+	job2 -> job3 [label="failure", color="red", style=dashed];
+	job2 -> job4 [label="map_complete", color="blue", style=bold];
+}
+`
+	if dot := g.DOT(); dot != expected {
+		t.Errorf("DOT:\n%s\nexpected:\n%s", dot, expected)
+	}
+
+	expected = `flowchart LR
+	job1["extract (succeeded)"]
+	job2["load (map $.files, paused)"]
+	job3["#quot;alert#quot;"]
+	job4["summary"]
+	job1 -->|"success if $.rows > 0"| job2
+	job1 -.->|failure| job3
+	job2 -.->|failure| job3
+	job2 ==>|map_complete| job4
+	classDef succeeded fill:#c8e6c9
+	class job1 succeeded
+`
+	if mermaid := g.Mermaid(); mermaid != expected {
+		t.Errorf("Mermaid:\n%s\nexpected:\n%s", mermaid, expected)
+	}
+}
+
+func TestGraphStateColor(t *testing.T) {
+	for _, status := range []RunStatus{RunStatusPending, RunStatusRunning, RunStatusCancelled, RunStatusJoining, RunStatusQueued, RunStatusWaiting} {
+		if _, ok := graphStateColors[status.String()]; !ok {
+			t.Errorf("expected a color for %s", status)
+		}
+	}
+	if c := graphStateColor("unknown"); c != graphDefaultColor {
+		t.Errorf("expected the default color for an unknown state, got %q", c)
+	}
+}
@@ -27,3 +27,19 @@ func BoolPtr(b bool) *bool {
 func IntPtr(i int) *int {
 	return &i
 }
+
+func Uint64Ptr(i uint64) *uint64 {
+	return &i
+}
+
+// scanBytes normalises the TEXT values returned by the sqlite driver, which
+// can be either []byte or string depending on the column type
+func scanBytes(src interface{}) ([]byte, bool) {
+	switch v := src.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
@@ -1,11 +1,10 @@
 package pipeline
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
+	"sort"
 	"strconv"
 	"time"
 
@@ -20,7 +19,7 @@ func (js *JobIDs) Scan(src interface{}) error {
 		*js = JobIDs{}
 		return nil
 	}
-	srcBytes, ok := src.([]byte)
+	srcBytes, ok := scanBytes(src)
 	if !ok {
 		return fmt.Errorf("job ids: unexpected type: %T", src)
 	}
@@ -50,11 +49,17 @@ func MakeInts(js JobIDs) []int64 {
 
 type Job struct {
 	ID                   JobID
+	VersionID            JobVersionID //version of the definition, changes on every update
 	Name                 string
 	ProcessorConfig      ProcessorConfig
 	InputPayloadTemplate []byte
 	RetryerConfig        RetryerConfig
 	Triggers             TriggerEvents
+	Paused               bool //runs of paused jobs aren't started and triggers don't create runs
+	//allows the job in a trigger cycle, it's triggered at most LoopLimit times
+	//in one chain of triggered runs. 0 forbids cycles through the job
+	LoopLimit int
+	Sensor    Sensor //polls until the output is ready, see Sensor
 	//DoNotOverlap         bool //if true, another run won't be started until the previous runs have completed
 }
 
@@ -62,12 +67,83 @@ type TriggerEvents struct {
 	CronSchedule CronSchedule
 	JobSuccess   JobIDs
 	JobFailure   JobIDs
+	//if set, the job runs once every job in JobSuccess has succeeded in the
+	//same execution rather than on each success. The input template gets the
+	//latest output of each upstream job keyed by job name. Only runs sharing
+	//a root run join, upstream jobs started by their own schedules or by hand
+	//are separate executions and never join, whatever their logical date.
+	JoinSuccess bool
+	//fails a join not complete this long after it began. With 0 a join whose
+	//other upstream jobs never succeed in the execution waits forever.
+	JoinTimeout time.Duration
+	//conditions on the output of an upstream job that must hold for its run
+	//to trigger the job, keyed by the upstream job. Jobs without a condition
+	//always trigger.
+	JobSuccessConditions map[JobID]Condition
+	JobFailureConditions map[JobID]Condition
+	//delays of the triggers from upstream jobs, keyed by the upstream job.
+	//The run of the job is scheduled this long after the upstream run
+	//completed instead of right away.
+	JobSuccessDelays map[JobID]time.Duration
+	JobFailureDelays map[JobID]time.Duration
+	//if set, a success or failure trigger fans out: the job gets one run per
+	//element of the array at this path of the upstream output ("$" is the
+	//whole output), with the element as template data. Joins don't fan out.
+	MapPath        OutputPath
+	MapMaxParallel int //mapped runs of one fan-out running at once, 0 for no limit
+	//jobs whose fan-outs trigger this job, the reduce step, once every mapped
+	//run has finished. The template gets the outputs of the mapped runs in
+	//element order, see reduce.
+	JobMapComplete JobIDs
+	FileWatch      FileWatch //runs the job for the files of a directory, see FileWatch
+}
+
+// Condition returns the condition of the trigger from job id, empty if there
+// is none
+func (t *TriggerEvents) Condition(id JobID, success bool) Condition {
+	if success {
+		return t.JobSuccessConditions[id]
+	}
+	return t.JobFailureConditions[id]
+}
+
+// Delay returns the delay of the trigger from job id, 0 if there is none
+func (t *TriggerEvents) Delay(id JobID, success bool) time.Duration {
+	if success {
+		return t.JobSuccessDelays[id]
+	}
+	return t.JobFailureDelays[id]
+}
+
+// upstream returns the jobs that trigger the job, in any way
+func (t *TriggerEvents) upstream() JobIDs {
+	ids := append(JobIDs{}, t.JobSuccess...)
+	ids = append(ids, t.JobFailure...)
+	return append(ids, t.JobMapComplete...)
+}
+
+func sortedConditionIDs(conditions map[JobID]Condition) JobIDs {
+	ids := make(JobIDs, 0, len(conditions))
+	for id := range conditions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func sortedDelayIDs(delays map[JobID]time.Duration) JobIDs {
+	ids := make(JobIDs, 0, len(delays))
+	for id := range delays {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
 }
 
 type CronSchedule string
 
 func (c *CronSchedule) Scan(src interface{}) error {
-	srcBytes, ok := src.([]byte)
+	srcBytes, ok := scanBytes(src)
 	if !ok {
 		return fmt.Errorf("cron schedule: unexpected type: %T", src)
 	}
@@ -84,16 +160,37 @@ func (c CronSchedule) Expression() (*cronexpr.Expression, error) {
 	return cronexpr.Parse(string(c))
 }
 
+// Next returns the next n times after from that the schedule fires, none if
+// the schedule is empty
+func (c CronSchedule) Next(from time.Time, n uint) ([]time.Time, error) {
+	if c == "" {
+		return nil, nil
+	}
+	expr, err := c.Expression()
+	if err != nil {
+		return nil, err
+	}
+	return expr.NextN(from, n), nil
+}
+
 func (j *Job) MakeRun(jc JobContext) (*Run, error) {
-	in, err := renderInput(j.InputPayloadTemplate, jc.PreviousOutput)
+	in, err := renderInput(j, jc)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := attemptContext(j, jc)
 	if err != nil {
 		return nil, err
 	}
 	return &Run{
 		JobID:              j.ID,
+		JobVersionID:       j.VersionID,
+		ProcessorConfig:    j.ProcessorConfig,
 		Attempt:            jc.Attempt + 1,
 		ScheduledStartTime: jc.ScheduledStartTime,
+		NotBefore:          jc.NotBefore,
 		Input:              in,
+		TemplateContext:    ctx,
 	}, nil
 }
 
@@ -105,26 +202,10 @@ func (j *Job) String() string {
 	return string(d)
 }
 
-func renderInput(tmpl []byte, data []byte) ([]byte, error) {
-	var f interface{}
-	err := json.Unmarshal(data, &f)
-	if err != nil {
-		return nil, err
-	}
-
-	t := template.Must(template.New("test").Parse(string(tmpl)))
-	out := &bytes.Buffer{}
-	err = t.Execute(out, f)
-	if err != nil {
-		return nil, err
-	}
-	return out.Bytes(), nil
-}
-
 type RunStatus string
 
 func (r *RunStatus) Scan(src interface{}) error {
-	srcBytes, ok := src.([]byte)
+	srcBytes, ok := scanBytes(src)
 	if !ok {
 		return fmt.Errorf("job ids: unexpected type: %T", src)
 	}
@@ -141,9 +222,13 @@ func (r RunStatus) String() string {
 }
 
 const (
-	RunStatusPending  RunStatus = "pending"
-	RunStatusRunning  RunStatus = "running"
-	RunStatusComplete RunStatus = "complete"
+	RunStatusPending   RunStatus = "pending"
+	RunStatusRunning   RunStatus = "running"
+	RunStatusComplete  RunStatus = "complete"
+	RunStatusCancelled RunStatus = "cancelled"
+	RunStatusJoining   RunStatus = "joining" //waiting for the upstream jobs of a join
+	RunStatusQueued    RunStatus = "queued"  //mapped run waiting for a slot, see TriggerEvents.MapMaxParallel
+	RunStatusWaiting   RunStatus = "waiting" //waiting for an approval or the end of a wait, see ProcessorTypeApproval and ProcessorTypeWait
 )
 
 func RunStatusPtr(r RunStatus) *RunStatus {
@@ -158,11 +243,20 @@ func RunStatusFromString(s string) (RunStatus, error) {
 		return RunStatusComplete, nil
 	case RunStatusRunning.String():
 		return RunStatusRunning, nil
+	case RunStatusCancelled.String():
+		return RunStatusCancelled, nil
+	case RunStatusJoining.String():
+		return RunStatusJoining, nil
+	case RunStatusQueued.String():
+		return RunStatusQueued, nil
+	case RunStatusWaiting.String():
+		return RunStatusWaiting, nil
 	}
 	return "", errors.New("invalid run status: " + s)
 }
 
 type RunID uint64
+type RunIDs []RunID
 
 func (r RunID) String() string {
 	return strconv.FormatInt(int64(r), 10)
@@ -172,20 +266,49 @@ func (r RunID) Bytes() []byte {
 	return []byte(strconv.FormatInt(int64(r), 10))
 }
 
+func MakeRunInts(rs RunIDs) []int64 {
+	is := make([]int64, len(rs))
+	for i, r := range rs {
+		is[i] = int64(r)
+	}
+	return is
+}
+
 type Run struct {
 	RunID              RunID
 	JobID              JobID
+	JobVersionID       JobVersionID //version of the job the run was created from
 	ProcessorConfig    ProcessorConfig
 	Status             RunStatus
 	StatusDetail       string
-	ScheduledStartTime time.Time
+	ScheduledStartTime time.Time //logical date of the run, kept when the run is held back
+	NotBefore          time.Time //time the run is due, see GetDueRunsInput
 	StartTime          *time.Time
 	EndTime            *time.Time
 	Attempt            int
+	Pokes              int //number of times a run of a sensor job was processed, see Sensor
 	Success            bool
+	ParentRunID        RunID      //run whose success or failure triggered this one, 0 if none
+	RootRunID          RunID      //first run of the chain of triggered runs, 0 if this run is the first
+	MapIndex           int        //element of the fan-out the run processes, if MapCount is set
+	MapCount           int        //number of runs in the fan-out, 0 if the run isn't mapped
+	BackfillID         BackfillID //backfill the run belongs to, 0 if none
 	Input              []byte
 	Output             []byte
 	Log                []byte
+	TemplateContext    []byte  //data Input was rendered from, see templateContext
+	InputRef           BlobRef //set if Input is in a BlobStore, see LoadPayloads
+	OutputRef          BlobRef
+	LogRef             BlobRef
+}
+
+// Root returns the ID of the first run of r's chain of triggered runs. Runs
+// with the same root belong to the same execution.
+func (r *Run) Root() RunID {
+	if r.RootRunID == 0 {
+		return r.RunID
+	}
+	return r.RootRunID
 }
 
 func (r *Run) String() string {
@@ -208,8 +331,31 @@ func (r DefaultRetryer) ShouldRetry(c JobContext) bool {
 	return c.Attempt < r.NumRetries
 }
 
+const RetryerTypeDefault = "default"
+
+// NewRetryer makes the Retryer described by c. Jobs without a retryer are
+// never retried, the default retryer reads NumRetries from the config.
+func NewRetryer(c RetryerConfig) (Retryer, error) {
+	switch c.Type {
+	case "":
+		return DefaultRetryer{}, nil
+	case RetryerTypeDefault:
+		n, err := strconv.Atoi(c.Config["NumRetries"])
+		if err != nil {
+			return nil, errors.New("invalid NumRetries: " + c.Config["NumRetries"])
+		}
+		return DefaultRetryer{NumRetries: n}, nil
+	}
+	return nil, errors.New("unknown retryer type: " + c.Type)
+}
+
 type JobContext struct {
+	RunID              RunID           //0 until the run is created
 	Attempt            int             //starts at 0
 	ScheduledStartTime time.Time       //time job is scheduled to start
+	NotBefore          time.Time       //time the run is due, ScheduledStartTime if zero
 	PreviousOutput     json.RawMessage //output from previous job
+	UpstreamJobID      JobID           //job of the run that triggered this one, 0 if none
+	UpstreamRunID      RunID
+	Params             map[string]interface{} //parameters of a run started by hand
 }
@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+type JobVersionID uint64
+
+func (v JobVersionID) String() string {
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+// JobVersion is an immutable snapshot of a job's definition. A new version is
+// stored every time a job is created, updated or restored.
+type JobVersion struct {
+	ID        JobVersionID
+	JobID     JobID
+	Version   int //1 for the definition the job was created with
+	CreatedAt time.Time
+	Job       Job
+}
+
+// JobDiff is a field that differs between two job versions
+type JobDiff struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// DiffJobVersions lists the fields that changed from version a to version b
+func DiffJobVersions(a, b *JobVersion) []JobDiff {
+	return diffJobs(&a.Job, &b.Job)
+}
+
+func diffJobs(a, b *Job) []JobDiff {
+	fields := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"Name", a.Name, b.Name},
+		{"ProcessorConfig", a.ProcessorConfig, b.ProcessorConfig},
+		{"InputPayloadTemplate", string(a.InputPayloadTemplate), string(b.InputPayloadTemplate)},
+		{"RetryerConfig", a.RetryerConfig, b.RetryerConfig},
+		{"Triggers.CronSchedule", a.Triggers.CronSchedule, b.Triggers.CronSchedule},
+		{"Triggers.JobSuccess", a.Triggers.JobSuccess, b.Triggers.JobSuccess},
+		{"Triggers.JobFailure", a.Triggers.JobFailure, b.Triggers.JobFailure},
+		{"Triggers.JoinSuccess", a.Triggers.JoinSuccess, b.Triggers.JoinSuccess},
+		{"Triggers.JoinTimeout", a.Triggers.JoinTimeout.String(), b.Triggers.JoinTimeout.String()},
+		{"Triggers.JobSuccessConditions", a.Triggers.JobSuccessConditions, b.Triggers.JobSuccessConditions},
+		{"Triggers.JobFailureConditions", a.Triggers.JobFailureConditions, b.Triggers.JobFailureConditions},
+		{"Triggers.JobSuccessDelays", a.Triggers.JobSuccessDelays, b.Triggers.JobSuccessDelays},
+		{"Triggers.JobFailureDelays", a.Triggers.JobFailureDelays, b.Triggers.JobFailureDelays},
+		{"Triggers.MapPath", string(a.Triggers.MapPath), string(b.Triggers.MapPath)},
+		{"Triggers.MapMaxParallel", a.Triggers.MapMaxParallel, b.Triggers.MapMaxParallel},
+		{"Triggers.JobMapComplete", a.Triggers.JobMapComplete, b.Triggers.JobMapComplete},
+		{"Triggers.FileWatch.Dir", a.Triggers.FileWatch.Dir, b.Triggers.FileWatch.Dir},
+		{"Triggers.FileWatch.Pattern", a.Triggers.FileWatch.Pattern, b.Triggers.FileWatch.Pattern},
+		{"Triggers.FileWatch.Recursive", a.Triggers.FileWatch.Recursive, b.Triggers.FileWatch.Recursive},
+		{"Triggers.FileWatch.StableFor", a.Triggers.FileWatch.StableFor.String(), b.Triggers.FileWatch.StableFor.String()},
+		{"Triggers.FileWatch.Debounce", a.Triggers.FileWatch.Debounce.String(), b.Triggers.FileWatch.Debounce.String()},
+		{"LoopLimit", a.LoopLimit, b.LoopLimit},
+		{"Sensor.ReadyIf", string(a.Sensor.ReadyIf), string(b.Sensor.ReadyIf)},
+		{"Sensor.Interval", a.Sensor.Interval.String(), b.Sensor.Interval.String()},
+		{"Sensor.Timeout", a.Sensor.Timeout.String(), b.Sensor.Timeout.String()},
+	}
+	diffs := []JobDiff{}
+	for _, f := range fields {
+		old, new := diffString(f.old), diffString(f.new)
+		if old != new {
+			diffs = append(diffs, JobDiff{Field: f.name, Old: old, New: new})
+		}
+	}
+	return diffs
+}
+
+func diffString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if ids, ok := v.(JobIDs); ok && len(ids) == 0 {
+		//nil and empty trigger lists are the same
+		return "[]"
+	}
+	if c, ok := v.(map[JobID]Condition); ok && len(c) == 0 {
+		return "{}"
+	}
+	if delays, ok := v.(map[JobID]time.Duration); ok {
+		//durations as "30m" rather than nanoseconds
+		strs := map[JobID]string{}
+		for id, d := range delays {
+			strs[id] = d.String()
+		}
+		v = strs
+	}
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err.Error()
+	}
+	return string(d)
+}
+
+// RestoreJobVersion makes version the current definition of its job. The
+// restore is stored as a new version, history is never rewritten.
+func RestoreJobVersion(r Repository, jobID JobID, version JobVersionID) error {
+	versions, err := r.GetJobVersions(&GetJobVersionsInput{
+		JobID:      jobID,
+		VersionIDs: []JobVersionID{version},
+	})
+	if err != nil {
+		return err
+	}
+	if len(versions) != 1 {
+		return ErrJobVersionNotFound
+	}
+	j := versions[0].Job
+	successes, failures, mapComplete := j.Triggers.JobSuccess, j.Triggers.JobFailure, j.Triggers.JobMapComplete
+	//non nil so the current triggers are replaced
+	if successes == nil {
+		successes = JobIDs{}
+	}
+	if failures == nil {
+		failures = JobIDs{}
+	}
+	if mapComplete == nil {
+		mapComplete = JobIDs{}
+	}
+	return r.UpdateJob(&UpdateJobInput{
+		JobID:                jobID,
+		Name:                 &j.Name,
+		Processor:            &j.ProcessorConfig,
+		InputPayloadTemplate: nonNilBytes(j.InputPayloadTemplate),
+		Retryer:              &j.RetryerConfig,
+		Triggers: &TriggerEventsInput{
+			CronSchedule:         &j.Triggers.CronSchedule,
+			JobSuccess:           successes,
+			JobFailure:           failures,
+			JoinSuccess:          &j.Triggers.JoinSuccess,
+			JoinTimeout:          &j.Triggers.JoinTimeout,
+			JobSuccessConditions: j.Triggers.JobSuccessConditions,
+			JobFailureConditions: j.Triggers.JobFailureConditions,
+			JobSuccessDelays:     j.Triggers.JobSuccessDelays,
+			JobFailureDelays:     j.Triggers.JobFailureDelays,
+			MapPath:              &j.Triggers.MapPath,
+			MapMaxParallel:       &j.Triggers.MapMaxParallel,
+			JobMapComplete:       mapComplete,
+			FileWatch:            &j.Triggers.FileWatch,
+		},
+		LoopLimit: &j.LoopLimit,
+		Sensor:    &j.Sensor,
+	})
+}
+
+const ErrJobVersionNotFound = Err("job version not found")
+
+func nonNilBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// jobDefinition is the stored form of a version, identifiers and the paused
+// state are not part of the definition
+func jobDefinition(j *Job) ([]byte, error) {
+	def := *j
+	def.ID = 0
+	def.VersionID = 0
+	def.Paused = false
+	return json.Marshal(def)
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLiteJobVersions(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	id, err := r.CreateJob(&CreateJobInput{
+		Name:                 "extract",
+		Processor:            ProcessorConfig{Type: "lambda", Config: map[string]string{"FunctionName": "extract"}},
+		InputPayloadTemplate: []byte(`{"v":1}`),
+		Triggers:             &TriggerEventsInput{CronSchedule: NewCronSchedule("0 * * * *")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	joinTimeout := 30 * time.Minute
+	err = r.UpdateJob(&UpdateJobInput{
+		JobID:                id,
+		InputPayloadTemplate: []byte(`{"v":2}`),
+		Triggers: &TriggerEventsInput{
+			JobSuccess:  JobIDs{7},
+			JoinSuccess: BoolPtr(true),
+			JoinTimeout: &joinTimeout,
+		},
+		LoopLimit: IntPtr(3),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//no changes, no new version
+	if err := r.UpdateJob(&UpdateJobInput{JobID: id, Name: StringPtr("extract")}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := r.GetJobVersions(&GetJobVersionsInput{JobID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(versions))
+	}
+	if versions[0].Version != 1 || versions[1].Version != 2 {
+		t.Errorf("expected versions 1 and 2, got %d and %d", versions[0].Version, versions[1].Version)
+	}
+
+	expectedDiff := []JobDiff{
+		{Field: "InputPayloadTemplate", Old: `{"v":1}`, New: `{"v":2}`},
+		{Field: "Triggers.JobSuccess", Old: "[]", New: "[7]"},
+		{Field: "Triggers.JoinSuccess", Old: "false", New: "true"},
+		{Field: "Triggers.JoinTimeout", Old: "0s", New: "30m0s"},
+		{Field: "LoopLimit", Old: "0", New: "3"},
+	}
+	if diff := DiffJobVersions(versions[0], versions[1]); !reflect.DeepEqual(expectedDiff, diff) {
+		t.Errorf("expected diff %+v, got %+v", expectedDiff, diff)
+	}
+
+	if err := RestoreJobVersion(r, id, versions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := r.GetJobs(&GetJobsInput{JobIDs: JobIDs{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err = r.GetJobVersions(&GetJobVersionsInput{JobID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("expected restore to add a version, got %d versions", len(versions))
+	}
+	if jobs[0].VersionID != versions[2].ID {
+		t.Errorf("expected job to be at version %s, got %s", versions[2].ID, jobs[0].VersionID)
+	}
+	if diff := DiffJobVersions(versions[0], versions[2]); len(diff) != 0 {
+		t.Errorf("expected restored version to match version 1, got diff %+v", diff)
+	}
+
+	//runs record the version they were made from
+	run, err := jobs[0].MakeRun(JobContext{PreviousOutput: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.JobVersionID != jobs[0].VersionID {
+		t.Errorf("expected run version %s, got %s", jobs[0].VersionID, run.JobVersionID)
+	}
+	runID, err := r.CreateRun(&CreateRunInput{JobID: id, JobVersionID: run.JobVersionID, Input: run.Input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := r.GetRuns(&GetRunsInput{RunID: &runID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[0].JobVersionID != jobs[0].VersionID {
+		t.Errorf("expected stored run version %s, got %s", jobs[0].VersionID, runs[0].JobVersionID)
+	}
+}
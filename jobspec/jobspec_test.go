@@ -0,0 +1,283 @@
+package jobspec
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/robstrong/pipeline"
+)
+
+func newTestRepo(t *testing.T) *pipeline.SQLiteRepo {
+	dir, err := ioutil.TempDir("", "jobspec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	r := pipeline.NewSQLiteRepo(db)
+	if err := r.MigrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// writeFiles creates a directory with the given files
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "jobspec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"etl.yaml": `
+jobs:
+  - name: load
+    processor:
+      type: lambda
+      config:
+        FunctionName: load
+    on_success: [extract]
+  - name: extract
+    processor: {type: lambda}
+    retryer:
+      type: default
+      config:
+        NumRetries: 2
+    cron_schedule: "0 2 * * *"
+`,
+		"reports/report.json": `{"jobs": [{"name": "report", "processor": {"type": "lambda"}, "on_success": ["load"], "on_failure": ["report"], "loop_limit": 1}]}`,
+		"README.md":           "not a job file",
+	})
+	jobs, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, j := range jobs {
+		names = append(names, j.Name)
+	}
+	if strings.Join(names, ",") != "extract,load,report" {
+		t.Fatalf("jobs = %v", names)
+	}
+	if jobs[0].Retryer.Config["NumRetries"] != "2" || jobs[1].Processor.Config["FunctionName"] != "load" {
+		t.Errorf("configs = %+v, %+v", jobs[0].Retryer, jobs[1].Processor)
+	}
+
+	tests := []struct {
+		file string
+		err  string
+	}{
+		{"jobs:\n  - name: a\n    unknown: 1\n", "field unknown not found"},
+		{"jobs:\n  - processor: {type: lambda}\n", `job "": name is required`},
+		{"jobs:\n  - name: a\n  - name: a\n", `job "a": already defined in`},
+		{"jobs:\n  - name: a\n    on_success: [b]\n", `job "a": triggered by undefined job "b"`},
+		{"jobs:\n  - name: a\n    cron_schedule: nope\n", `job "a": invalid cron_schedule`},
+		{"jobs:\n  - name: b\n    on_success: [a]\n  - name: a\n    on_failure: [b]\n", `job "b": trigger cycle b -> a -> b`},
+		{"jobs:\n  - name: a\n    on_success: [a]\n    loop_limit: -1\n", `job "a": loop_limit must not be negative`},
+		{"jobs:\n  - name: a\n    join_timeout: soon\n", `job "a": invalid join_timeout "soon"`},
+		{"jobs:\n  - name: a\n    on_success_if: {b: $.ok}\n", `job "a": on_success_if has a condition for "b", which isn't in on_success`},
+		{"jobs:\n  - name: a\n  - name: b\n    on_failure: [a]\n    on_failure_if: {a: $.ok ==}\n", `job "b": invalid on_failure_if`},
+		{"jobs:\n  - name: a\n    map_path: files\n", `job "a": invalid map_path`},
+		{"jobs:\n  - name: a\n    on_map_complete: [b]\n", `job "a": triggered by undefined job "b"`},
+		{"jobs:\n  - name: a\n    sensor: {interval: 5m}\n", `job "a": sensor.ready_if is required`},
+		{"jobs:\n  - name: a\n    sensor: {ready_if: $.exists, timeout: -1h}\n", `job "a": invalid sensor.timeout "-1h"`},
+		{"jobs:\n  - name: a\n    input_payload_template: '{{.day'\n", `job "a": invalid input_payload_template: template: input:1: unclosed action`},
+		{"jobs:\n  - name: a\n    file_watch: {dir: data}\n", `job "a": file_watch.dir must be an absolute path`},
+		{"jobs:\n  - name: a\n    file_watch: {dir: /data, stable_for: soon}\n", `job "a": invalid file_watch.stable_for "soon"`},
+	}
+	for _, test := range tests {
+		_, err := LoadDir(writeFiles(t, map[string]string{"jobs.yml": test.file}))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: err = %v, want %q", test.file, err, test.err)
+		}
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	r := newTestRepo(t)
+	oldID, err := r.CreateJob(&pipeline.CreateJobInput{Name: "old", Processor: pipeline.ProcessorConfig{Type: "lambda"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := LoadDir(writeFiles(t, map[string]string{"jobs.yaml": `
+jobs:
+  - name: extract
+    processor: {type: lambda, config: {FunctionName: extract}}
+    cron_schedule: "0 2 * * *"
+    on_failure: [load]
+    loop_limit: 2
+  - name: load
+    processor: {type: lambda}
+    input_payload_template: '{"rows": {{.rows}}}'
+    on_success: [extract]
+    join_success: true
+    join_timeout: 90m
+  - name: cleanup
+    processor: {type: lambda}
+    on_success: [cleanup, load]
+    on_success_if: {load: "$.rows > 0"}
+    loop_limit: 3
+`}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := MakePlan(r, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "+ create cleanup\n+ create extract\n+ create load\n- delete old (#1)\n3 to create, 0 to update, 1 to delete\n"
+	if plan.String() != want {
+		t.Fatalf("plan =\n%s\nwant\n%s", plan, want)
+	}
+	if _, err := Apply(r, jobs); err != nil {
+		t.Fatal(err)
+	}
+	byName := jobsByName(t, r)
+	if byName["old"] != nil {
+		t.Errorf("old job #%d wasn't deleted", oldID)
+	}
+	extract, load, cleanup := byName["extract"], byName["load"], byName["cleanup"]
+	if extract == nil || load == nil || cleanup == nil {
+		t.Fatalf("jobs = %v", byName)
+	}
+	if !sameIDs(extract.Triggers.JobFailure, load.ID) || !sameIDs(load.Triggers.JobSuccess, extract.ID) ||
+		!sameIDs(cleanup.Triggers.JobSuccess, cleanup.ID, load.ID) {
+		t.Errorf("triggers not resolved: extract %+v, load %+v, cleanup %+v", extract.Triggers, load.Triggers, cleanup.Triggers)
+	}
+	if c := cleanup.Triggers.JobSuccessConditions; len(c) != 1 || c[load.ID] != "$.rows > 0" {
+		t.Errorf("conditions not resolved: %+v", c)
+	}
+	if string(load.InputPayloadTemplate) != `{"rows": {{.rows}}}` || extract.ProcessorConfig.Config["FunctionName"] != "extract" {
+		t.Errorf("fields not applied: %+v, %+v", load, extract)
+	}
+
+	//applied specs plan no changes
+	if plan, err = MakePlan(r, jobs); err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Fatalf("plan after apply =\n%s", plan)
+	}
+
+	//pausing isn't part of the spec
+	paused := true
+	if err := r.UpdateJob(&pipeline.UpdateJobInput{JobID: extract.ID, Paused: &paused}); err != nil {
+		t.Fatal(err)
+	}
+	jobs[1].CronSchedule = "0 3 * * *"
+	jobs[1].OnFailure = nil
+	jobs = append(jobs[:0], jobs[1:]...) //drop cleanup
+	plan, err = Apply(r, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "~ update extract (#3)\n" +
+		"    cron_schedule: \"0 2 * * *\" -> \"0 3 * * *\"\n" +
+		"    on_failure: [load] -> []\n" +
+		"- delete cleanup (#2)\n" +
+		"0 to create, 1 to update, 1 to delete\n"
+	if plan.String() != want {
+		t.Fatalf("plan =\n%s\nwant\n%s", plan, want)
+	}
+	byName = jobsByName(t, r)
+	if e := byName["extract"]; e.Triggers.CronSchedule != "0 3 * * *" || len(e.Triggers.JobFailure) != 0 || !e.Paused {
+		t.Errorf("extract = %+v", e)
+	}
+	if byName["cleanup"] != nil {
+		t.Error("cleanup wasn't deleted")
+	}
+}
+
+func TestApplyRollsBack(t *testing.T) {
+	r := newTestRepo(t)
+	jobs := []*Job{
+		{Name: "a", Processor: Config{Type: "lambda"}},
+		{Name: "b", Processor: Config{Type: "lambda"}},
+	}
+	if _, err := Apply(&failingRepo{r, "b"}, jobs); err == nil {
+		t.Fatal("expected err")
+	}
+	if byName := jobsByName(t, r); len(byName) != 0 {
+		t.Errorf("jobs created by failed apply: %v", byName)
+	}
+
+	wrapped := pipeline.NewValidationWrapper(r)
+	if _, err := Apply(wrapped, jobs); err != ErrNotTransactional {
+		t.Errorf("err = %v, want %v", err, ErrNotTransactional)
+	}
+}
+
+// failingRepo fails to create the job named fail
+type failingRepo struct {
+	*pipeline.SQLiteRepo
+	fail string
+}
+
+func (r *failingRepo) InTx(f func(pipeline.Repository) error) error {
+	return r.SQLiteRepo.InTx(func(tx pipeline.Repository) error {
+		return f(&failingTx{tx, r.fail})
+	})
+}
+
+type failingTx struct {
+	pipeline.Repository
+	fail string
+}
+
+func (r *failingTx) CreateJob(in *pipeline.CreateJobInput) (pipeline.JobID, error) {
+	if in.Name == r.fail {
+		return 0, pipeline.Err("create failed")
+	}
+	return r.Repository.CreateJob(in)
+}
+
+func jobsByName(t *testing.T, r pipeline.Repository) map[string]*pipeline.Job {
+	jobs, err := r.GetJobs(&pipeline.GetJobsInput{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]*pipeline.Job{}
+	for _, j := range jobs {
+		byName[j.Name] = j
+	}
+	return byName
+}
+
+func sameIDs(ids pipeline.JobIDs, want ...pipeline.JobID) bool {
+	if len(ids) != len(want) {
+		return false
+	}
+	for _, id := range want {
+		found := false
+		for _, i := range ids {
+			found = found || i == id
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
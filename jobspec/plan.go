@@ -0,0 +1,504 @@
+package jobspec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robstrong/pipeline"
+)
+
+const (
+	ErrDuplicateJobName = pipeline.Err("jobs in the repository share a name, job specs can't reference them")
+	ErrNotTransactional = pipeline.Err("repository doesn't support transactions")
+)
+
+// Plan is the set of changes that makes a repository match the job specs.
+// Repository jobs are matched to specs by name, jobs without a spec are
+// deleted.
+type Plan struct {
+	Create []*Job
+	Update []*Update
+	Delete []*pipeline.Job
+}
+
+// Update changes the repository job Current to match Job
+type Update struct {
+	Current *pipeline.Job
+	Job     *Job
+	Diffs   []pipeline.JobDiff
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// String formats the plan for review, one line per job prefixed with "+" for
+// creates, "~" for updates and "-" for deletes. Updates are followed by the
+// changed fields as "field: old -> new".
+func (p *Plan) String() string {
+	if p.Empty() {
+		return "no changes\n"
+	}
+	b := &bytes.Buffer{}
+	for _, j := range p.Create {
+		fmt.Fprintf(b, "+ create %s\n", j.Name)
+	}
+	for _, u := range p.Update {
+		fmt.Fprintf(b, "~ update %s (#%d)\n", u.Job.Name, u.Current.ID)
+		for _, d := range u.Diffs {
+			fmt.Fprintf(b, "    %s: %s -> %s\n", d.Field, d.Old, d.New)
+		}
+	}
+	for _, j := range p.Delete {
+		fmt.Fprintf(b, "- delete %s (#%d)\n", j.Name, j.ID)
+	}
+	fmt.Fprintf(b, "%d to create, %d to update, %d to delete\n", len(p.Create), len(p.Update), len(p.Delete))
+	return b.String()
+}
+
+// MakePlan compares the jobs in r with jobs, which must be valid
+func MakePlan(r pipeline.Repository, jobs []*Job) (*Plan, error) {
+	current, err := r.GetJobs(&pipeline.GetJobsInput{All: true})
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*pipeline.Job{}
+	names := map[pipeline.JobID]string{}
+	for _, j := range current {
+		if _, ok := byName[j.Name]; ok {
+			return nil, fmt.Errorf("%s: %q", ErrDuplicateJobName, j.Name)
+		}
+		byName[j.Name] = j
+		names[j.ID] = j.Name
+	}
+
+	plan := &Plan{}
+	specified := map[string]bool{}
+	for _, spec := range jobs {
+		specified[spec.Name] = true
+		cur, ok := byName[spec.Name]
+		if !ok {
+			plan.Create = append(plan.Create, spec)
+			continue
+		}
+		if diffs := diff(fromJob(cur, names), spec); len(diffs) > 0 {
+			plan.Update = append(plan.Update, &Update{Current: cur, Job: spec, Diffs: diffs})
+		}
+	}
+	for _, j := range current {
+		if !specified[j.Name] {
+			plan.Delete = append(plan.Delete, j)
+		}
+	}
+	return plan, nil
+}
+
+// Apply makes r match jobs in a single transaction, either every change of
+// the returned plan is made or none is. r must implement
+// pipeline.Transactor.
+func Apply(r pipeline.Repository, jobs []*Job) (*Plan, error) {
+	t, ok := r.(pipeline.Transactor)
+	if !ok {
+		return nil, ErrNotTransactional
+	}
+	if err := Validate(jobs); err != nil {
+		return nil, err
+	}
+	var plan *Plan
+	//the specs were validated as a whole, the ValidationWrapper would reject
+	//intermediate states such as a cycle whose loop limit is set by a later
+	//update
+	err := t.InTx(func(tx pipeline.Repository) error {
+		var err error
+		//plan again inside the transaction so nothing changes in between
+		if plan, err = MakePlan(tx, jobs); err != nil {
+			return err
+		}
+		return execute(tx, plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func execute(r pipeline.Repository, plan *Plan) error {
+	ids := map[string]pipeline.JobID{}
+	for _, u := range plan.Update {
+		ids[u.Job.Name] = u.Current.ID
+	}
+	deleted := map[pipeline.JobID]bool{}
+	for _, j := range plan.Delete {
+		deleted[j.ID] = true
+	}
+	//unchanged jobs can be referenced too
+	current, err := r.GetJobs(&pipeline.GetJobsInput{All: true})
+	if err != nil {
+		return err
+	}
+	for _, j := range current {
+		if !deleted[j.ID] {
+			ids[j.Name] = j.ID
+		}
+	}
+
+	//create upstream jobs first so most triggers resolve on creation, jobs in
+	//a cycle or triggered by themselves get their triggers afterwards
+	var unresolved []*Job
+	pending := plan.Create
+	for len(pending) > 0 {
+		var next []*Job
+		for _, j := range pending {
+			if !resolvable(j, ids) {
+				next = append(next, j)
+			}
+		}
+		if len(next) == len(pending) {
+			//cycle, create the rest without triggers
+			unresolved = next
+			next = nil
+		}
+		for _, j := range pending {
+			if contains(next, j) {
+				continue
+			}
+			id, err := r.CreateJob(createInput(j, ids))
+			if err != nil {
+				return fmt.Errorf("err creating job %q: %s", j.Name, err)
+			}
+			ids[j.Name] = id
+		}
+		pending = next
+	}
+	for _, j := range unresolved {
+		if err := r.UpdateJob(updateInput(ids[j.Name], j, ids)); err != nil {
+			return fmt.Errorf("err setting triggers of job %q: %s", j.Name, err)
+		}
+	}
+	for _, u := range plan.Update {
+		if err := r.UpdateJob(updateInput(u.Current.ID, u.Job, ids)); err != nil {
+			return fmt.Errorf("err updating job %q: %s", u.Job.Name, err)
+		}
+	}
+	for _, j := range plan.Delete {
+		if err := r.DeleteJob(&pipeline.DeleteJobInput{JobID: j.ID}); err != nil {
+			return fmt.Errorf("err deleting job %q: %s", j.Name, err)
+		}
+	}
+	return nil
+}
+
+// resolvable reports whether every job triggering j, other than j itself,
+// already has an ID
+func resolvable(j *Job, ids map[string]pipeline.JobID) bool {
+	for _, name := range j.triggeredBy() {
+		if _, ok := ids[name]; !ok && name != j.Name {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(jobs []*Job, j *Job) bool {
+	for _, o := range jobs {
+		if o == j {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve maps names to IDs, names without an ID are skipped
+func resolve(names []string, ids map[string]pipeline.JobID) pipeline.JobIDs {
+	resolved := pipeline.JobIDs{}
+	for _, name := range names {
+		if id, ok := ids[name]; ok {
+			resolved = append(resolved, id)
+		}
+	}
+	return resolved
+}
+
+// resolveConditions keys conditions by job ID, names without an ID are
+// skipped
+func resolveConditions(conditions map[string]string, ids map[string]pipeline.JobID) map[pipeline.JobID]pipeline.Condition {
+	if len(conditions) == 0 {
+		return nil
+	}
+	resolved := map[pipeline.JobID]pipeline.Condition{}
+	for name, c := range conditions {
+		if id, ok := ids[name]; ok {
+			resolved[id] = pipeline.Condition(c)
+		}
+	}
+	return resolved
+}
+
+// resolveDelays keys delays by job ID like resolveConditions
+func resolveDelays(delays map[string]string, ids map[string]pipeline.JobID) map[pipeline.JobID]time.Duration {
+	if len(delays) == 0 {
+		return nil
+	}
+	resolved := map[pipeline.JobID]time.Duration{}
+	for name, d := range delays {
+		if id, ok := ids[name]; ok {
+			resolved[id], _ = time.ParseDuration(d) //validated
+		}
+	}
+	return resolved
+}
+
+func createInput(j *Job, ids map[string]pipeline.JobID) *pipeline.CreateJobInput {
+	cron := pipeline.CronSchedule(j.CronSchedule)
+	join := j.JoinSuccess
+	joinTimeout, _ := j.joinTimeout() //validated
+	mapPath := pipeline.OutputPath(j.MapPath)
+	mapMaxParallel := j.MapMaxParallel
+	watch := j.fileWatch()
+	return &pipeline.CreateJobInput{
+		Name:                 j.Name,
+		Processor:            pipeline.ProcessorConfig(j.Processor),
+		InputPayloadTemplate: []byte(j.InputPayloadTemplate),
+		Retryer:              pipeline.RetryerConfig(j.Retryer),
+		Triggers: &pipeline.TriggerEventsInput{
+			CronSchedule:         &cron,
+			JobSuccess:           resolve(j.OnSuccess, ids),
+			JobFailure:           resolve(j.OnFailure, ids),
+			JoinSuccess:          &join,
+			JoinTimeout:          &joinTimeout,
+			JobSuccessConditions: resolveConditions(j.OnSuccessIf, ids),
+			JobFailureConditions: resolveConditions(j.OnFailureIf, ids),
+			JobSuccessDelays:     resolveDelays(j.OnSuccessAfter, ids),
+			JobFailureDelays:     resolveDelays(j.OnFailureAfter, ids),
+			MapPath:              &mapPath,
+			MapMaxParallel:       &mapMaxParallel,
+			JobMapComplete:       resolve(j.OnMapComplete, ids),
+			FileWatch:            &watch,
+		},
+		LoopLimit: j.LoopLimit,
+		Sensor:    j.sensor(),
+	}
+}
+
+// updateInput sets every field of the job, so it matches j afterwards
+func updateInput(id pipeline.JobID, j *Job, ids map[string]pipeline.JobID) *pipeline.UpdateJobInput {
+	in := createInput(j, ids)
+	return &pipeline.UpdateJobInput{
+		JobID:                id,
+		Name:                 &in.Name,
+		Processor:            &in.Processor,
+		InputPayloadTemplate: in.InputPayloadTemplate,
+		Retryer:              &in.Retryer,
+		Triggers:             in.Triggers,
+		LoopLimit:            &in.LoopLimit,
+		Sensor:               &in.Sensor,
+	}
+}
+
+// fromJob converts a repository job to a spec, triggers by jobs that don't
+// exist are named by ID
+func fromJob(j *pipeline.Job, names map[pipeline.JobID]string) *Job {
+	jobNames := func(ids pipeline.JobIDs) []string {
+		var n []string
+		for _, id := range ids {
+			if name, ok := names[id]; ok {
+				n = append(n, name)
+			} else {
+				n = append(n, fmt.Sprintf("#%d", id))
+			}
+		}
+		return n
+	}
+	jobName := func(id pipeline.JobID) string {
+		if name, ok := names[id]; ok {
+			return name
+		}
+		return fmt.Sprintf("#%d", id)
+	}
+	conditions := func(c map[pipeline.JobID]pipeline.Condition) map[string]string {
+		if len(c) == 0 {
+			return nil
+		}
+		byName := map[string]string{}
+		for id, cond := range c {
+			byName[jobName(id)] = string(cond)
+		}
+		return byName
+	}
+	delays := func(d map[pipeline.JobID]time.Duration) map[string]string {
+		if len(d) == 0 {
+			return nil
+		}
+		byName := map[string]string{}
+		for id, delay := range d {
+			byName[jobName(id)] = durationString(delay)
+		}
+		return byName
+	}
+	var sensor *Sensor
+	if j.Sensor.Enabled() {
+		sensor = &Sensor{
+			ReadyIf:  string(j.Sensor.ReadyIf),
+			Interval: durationString(j.Sensor.Interval),
+			Timeout:  durationString(j.Sensor.Timeout),
+		}
+	}
+	var watch *FileWatch
+	if w := j.Triggers.FileWatch; w.Enabled() {
+		watch = &FileWatch{
+			Dir:       w.Dir,
+			Pattern:   w.Pattern,
+			Recursive: w.Recursive,
+			StableFor: durationString(w.StableFor),
+			Debounce:  durationString(w.Debounce),
+		}
+	}
+	return &Job{
+		Name:                 j.Name,
+		Processor:            Config(j.ProcessorConfig),
+		InputPayloadTemplate: string(j.InputPayloadTemplate),
+		Retryer:              Config(j.RetryerConfig),
+		CronSchedule:         string(j.Triggers.CronSchedule),
+		OnSuccess:            jobNames(j.Triggers.JobSuccess),
+		OnFailure:            jobNames(j.Triggers.JobFailure),
+		OnSuccessIf:          conditions(j.Triggers.JobSuccessConditions),
+		OnFailureIf:          conditions(j.Triggers.JobFailureConditions),
+		OnSuccessAfter:       delays(j.Triggers.JobSuccessDelays),
+		OnFailureAfter:       delays(j.Triggers.JobFailureDelays),
+		LoopLimit:            j.LoopLimit,
+		JoinSuccess:          j.Triggers.JoinSuccess,
+		JoinTimeout:          durationString(j.Triggers.JoinTimeout),
+		MapPath:              string(j.Triggers.MapPath),
+		MapMaxParallel:       j.Triggers.MapMaxParallel,
+		OnMapComplete:        jobNames(j.Triggers.JobMapComplete),
+		Sensor:               sensor,
+		FileWatch:            watch,
+	}
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// diff lists the fields that differ between a and b, using the spec's keys
+func diff(a, b *Job) []pipeline.JobDiff {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"processor", configString(a.Processor), configString(b.Processor)},
+		{"input_payload_template", quote(a.InputPayloadTemplate), quote(b.InputPayloadTemplate)},
+		{"retryer", configString(a.Retryer), configString(b.Retryer)},
+		{"cron_schedule", quote(a.CronSchedule), quote(b.CronSchedule)},
+		{"on_success", namesString(a.OnSuccess), namesString(b.OnSuccess)},
+		{"on_failure", namesString(a.OnFailure), namesString(b.OnFailure)},
+		{"on_success_if", conditionsString(a.OnSuccessIf), conditionsString(b.OnSuccessIf)},
+		{"on_failure_if", conditionsString(a.OnFailureIf), conditionsString(b.OnFailureIf)},
+		{"on_success_after", delaysString(a.OnSuccessAfter), delaysString(b.OnSuccessAfter)},
+		{"on_failure_after", delaysString(a.OnFailureAfter), delaysString(b.OnFailureAfter)},
+		{"loop_limit", fmt.Sprint(a.LoopLimit), fmt.Sprint(b.LoopLimit)},
+		{"join_success", fmt.Sprint(a.JoinSuccess), fmt.Sprint(b.JoinSuccess)},
+		{"join_timeout", quote(normalizeDuration(a.JoinTimeout)), quote(normalizeDuration(b.JoinTimeout))},
+		{"map_path", quote(a.MapPath), quote(b.MapPath)},
+		{"map_max_parallel", fmt.Sprint(a.MapMaxParallel), fmt.Sprint(b.MapMaxParallel)},
+		{"on_map_complete", namesString(a.OnMapComplete), namesString(b.OnMapComplete)},
+		{"sensor", sensorString(a.Sensor), sensorString(b.Sensor)},
+		{"file_watch", fileWatchString(a.FileWatch), fileWatchString(b.FileWatch)},
+	}
+	var diffs []pipeline.JobDiff
+	for _, f := range fields {
+		if f.old != f.new {
+			diffs = append(diffs, pipeline.JobDiff{Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	return diffs
+}
+
+// normalizeDuration formats valid durations the same way, "90m" is "1h30m0s"
+func normalizeDuration(s string) string {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return s
+	}
+	return durationString(d)
+}
+
+func quote(s string) string {
+	d, _ := json.Marshal(s)
+	return string(d)
+}
+
+// sensorString formats a sensor as JSON with normalized durations, nil is
+// no sensor
+func sensorString(s *Sensor) string {
+	if s == nil {
+		return "null"
+	}
+	d, _ := json.Marshal(map[string]string{
+		"ready_if": s.ReadyIf,
+		"interval": normalizeDuration(s.Interval),
+		"timeout":  normalizeDuration(s.Timeout),
+	})
+	return string(d)
+}
+
+// fileWatchString formats a file watch as JSON with normalized durations,
+// nil is no file watch
+func fileWatchString(w *FileWatch) string {
+	if w == nil {
+		return "null"
+	}
+	d, _ := json.Marshal(map[string]interface{}{
+		"dir":        w.Dir,
+		"pattern":    w.Pattern,
+		"recursive":  w.Recursive,
+		"stable_for": normalizeDuration(w.StableFor),
+		"debounce":   normalizeDuration(w.Debounce),
+	})
+	return string(d)
+}
+
+// conditionsString formats conditions as JSON, nil and empty maps are the
+// same
+func conditionsString(c map[string]string) string {
+	if len(c) == 0 {
+		return "{}"
+	}
+	d, _ := json.Marshal(c)
+	return string(d)
+}
+
+// delaysString formats delays as JSON with normalized durations
+func delaysString(d map[string]string) string {
+	normalized := map[string]string{}
+	for name, delay := range d {
+		normalized[name] = normalizeDuration(delay)
+	}
+	return conditionsString(normalized)
+}
+
+// configString formats c as JSON, nil and empty config maps are the same
+func configString(c Config) string {
+	if len(c.Config) == 0 {
+		c.Config = nil
+	}
+	d, _ := json.Marshal(struct {
+		Type   string            `json:"type"`
+		Config map[string]string `json:"config,omitempty"`
+	}{c.Type, c.Config})
+	return string(d)
+}
+
+// namesString formats the names sorted, trigger order doesn't matter
+func namesString(names []string) string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	return "[" + strings.Join(sorted, ", ") + "]"
+}
@@ -0,0 +1,375 @@
+// Package jobspec defines jobs in YAML or JSON files and reconciles a
+// repository with them, so a directory of files is the source of truth for
+// the jobs of a pipeline.
+package jobspec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/robstrong/pipeline"
+	"gopkg.in/yaml.v2"
+)
+
+// File is the format of a job file:
+//
+//	jobs:
+//	  - name: extract
+//	    processor:
+//	      type: lambda
+//	      config:
+//	        FunctionName: extract
+//	    input_payload_template: '{"day": "{{.day}}"}'
+//	    retryer:
+//	      type: default
+//	      config:
+//	        NumRetries: 2
+//	    cron_schedule: "0 2 * * *"
+//	  - name: load
+//	    processor:
+//	      type: lambda
+//	      config:
+//	        FunctionName: load
+//	    on_success: [extract]
+//	    on_success_if:
+//	      extract: $.new_files > 0
+//
+// JSON files use the same keys.
+type File struct {
+	Jobs []*Job `yaml:"jobs"`
+}
+
+// Job is the definition of a job. Jobs reference each other by name, names
+// are unique.
+type Job struct {
+	Name                 string            `yaml:"name"`
+	Processor            Config            `yaml:"processor"`
+	InputPayloadTemplate string            `yaml:"input_payload_template"`
+	Retryer              Config            `yaml:"retryer"`
+	CronSchedule         string            `yaml:"cron_schedule"`
+	OnSuccess            []string          `yaml:"on_success"`       //jobs whose success triggers this job
+	OnFailure            []string          `yaml:"on_failure"`       //jobs whose failure triggers this job
+	OnSuccessIf          map[string]string `yaml:"on_success_if"`    //conditions of on_success triggers by job name, see pipeline.Condition
+	OnFailureIf          map[string]string `yaml:"on_failure_if"`    //conditions of on_failure triggers by job name
+	OnSuccessAfter       map[string]string `yaml:"on_success_after"` //delays of on_success triggers by job name, durations such as "30m"
+	OnFailureAfter       map[string]string `yaml:"on_failure_after"` //delays of on_failure triggers by job name
+	LoopLimit            int               `yaml:"loop_limit"`       //allows the job in a trigger cycle, see pipeline.Job
+	JoinSuccess          bool              `yaml:"join_success"`     //runs once all of on_success succeeded in one execution, see pipeline.TriggerEvents
+	JoinTimeout          string            `yaml:"join_timeout"`     //duration such as "2h", empty waits forever
+	MapPath              string            `yaml:"map_path"`         //runs once per element of this array in the triggering output, see pipeline.OutputPath
+	MapMaxParallel       int               `yaml:"map_max_parallel"`
+	OnMapComplete        []string          `yaml:"on_map_complete"` //mapped jobs whose fan-outs trigger this job once they finish
+	Sensor               *Sensor           `yaml:"sensor"`          //polls until the output is ready, see pipeline.Sensor
+	FileWatch            *FileWatch        `yaml:"file_watch"`      //runs once per new or changed file, see pipeline.FileWatch
+
+	source string //file the job was read from
+}
+
+// Sensor is the sensor of a job:
+//
+//	sensor:
+//	  ready_if: $.exists
+//	  interval: 5m
+//	  timeout: 6h
+type Sensor struct {
+	ReadyIf  string `yaml:"ready_if"`
+	Interval string `yaml:"interval"` //duration such as "5m", pipeline.DefaultSensorInterval if empty
+	Timeout  string `yaml:"timeout"`  //empty pokes forever
+}
+
+// FileWatch is the directory watched by a job:
+//
+//	file_watch:
+//	  dir: /data/incoming
+//	  pattern: "*.csv"
+//	  recursive: true
+//	  stable_for: 30s
+//	  debounce: 5m
+type FileWatch struct {
+	Dir       string `yaml:"dir"`
+	Pattern   string `yaml:"pattern"`
+	Recursive bool   `yaml:"recursive"`
+	StableFor string `yaml:"stable_for"` //duration such as "30s"
+	Debounce  string `yaml:"debounce"`
+}
+
+type Config struct {
+	Type   string            `yaml:"type"`
+	Config map[string]string `yaml:"config"`
+}
+
+// Parse reads the jobs of a YAML or JSON file
+func Parse(data []byte) ([]*Job, error) {
+	f := &File{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, err
+	}
+	return f.Jobs, nil
+}
+
+// LoadDir reads the .yaml, .yml and .json files in dir and its
+// subdirectories. The jobs are validated and sorted by name.
+func LoadDir(dir string) ([]*Job, error) {
+	var jobs []*Job
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fileJobs, err := Parse(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		for _, j := range fileJobs {
+			j.source = path
+		}
+		jobs = append(jobs, fileJobs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(jobs); err != nil {
+		return nil, err
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// Validate checks that names are set and unique, that triggers reference
+// defined jobs, that cron schedules parse and that trigger cycles have a loop
+// limit
+func Validate(jobs []*Job) error {
+	var errs []error
+	fail := func(j *Job, format string, args ...interface{}) {
+		prefix := ""
+		if j.source != "" {
+			prefix = j.source + ": "
+		}
+		errs = append(errs, fmt.Errorf(prefix+"job %q: "+format, append([]interface{}{j.Name}, args...)...))
+	}
+	byName := map[string]*Job{}
+	for _, j := range jobs {
+		if j.Name == "" {
+			fail(j, "name is required")
+			continue
+		}
+		if other, ok := byName[j.Name]; ok {
+			fail(j, "already defined in %s", other.source)
+			continue
+		}
+		byName[j.Name] = j
+	}
+	for _, j := range jobs {
+		if j.CronSchedule != "" {
+			if _, err := pipeline.CronSchedule(j.CronSchedule).Expression(); err != nil {
+				fail(j, "invalid cron_schedule: %s", err)
+			}
+		}
+		if err := pipeline.ValidateTemplate([]byte(j.InputPayloadTemplate)); err != nil {
+			fail(j, "invalid input_payload_template: %s", err)
+		}
+		if j.LoopLimit < 0 {
+			fail(j, "loop_limit must not be negative")
+		}
+		if d, err := j.joinTimeout(); err != nil || d < 0 {
+			fail(j, "invalid join_timeout %q", j.JoinTimeout)
+		}
+		if j.MapPath != "" {
+			if err := pipeline.OutputPath(j.MapPath).Validate(); err != nil {
+				fail(j, "invalid map_path: %s", err)
+			}
+			if j.JoinSuccess {
+				fail(j, "map_path can't be combined with join_success")
+			}
+		}
+		if j.MapMaxParallel < 0 {
+			fail(j, "map_max_parallel must not be negative")
+		}
+		if s := j.Sensor; s != nil {
+			if s.ReadyIf == "" {
+				fail(j, "sensor.ready_if is required")
+			} else if err := pipeline.Condition(s.ReadyIf).Validate(); err != nil {
+				fail(j, "invalid sensor.ready_if: %s", err)
+			}
+			if d, err := parseDuration(s.Interval); err != nil || d < 0 {
+				fail(j, "invalid sensor.interval %q", s.Interval)
+			}
+			if d, err := parseDuration(s.Timeout); err != nil || d < 0 {
+				fail(j, "invalid sensor.timeout %q", s.Timeout)
+			}
+		}
+		if w := j.FileWatch; w != nil {
+			if w.Dir == "" {
+				fail(j, "file_watch.dir is required")
+			} else if !filepath.IsAbs(w.Dir) {
+				fail(j, "file_watch.dir must be an absolute path")
+			}
+			if _, err := filepath.Match(w.Pattern, ""); err != nil {
+				fail(j, "invalid file_watch.pattern: %s", err)
+			}
+			if d, err := parseDuration(w.StableFor); err != nil || d < 0 {
+				fail(j, "invalid file_watch.stable_for %q", w.StableFor)
+			}
+			if d, err := parseDuration(w.Debounce); err != nil || d < 0 {
+				fail(j, "invalid file_watch.debounce %q", w.Debounce)
+			}
+		}
+		for _, name := range j.triggeredBy() {
+			if _, ok := byName[name]; !ok {
+				fail(j, "triggered by undefined job %q", name)
+			}
+		}
+		for _, c := range []struct {
+			key        string
+			names      []string
+			conditions map[string]string
+			delays     map[string]string
+		}{
+			{"on_success", j.OnSuccess, j.OnSuccessIf, j.OnSuccessAfter},
+			{"on_failure", j.OnFailure, j.OnFailureIf, j.OnFailureAfter},
+		} {
+			for _, name := range sortedKeys(c.conditions) {
+				if !containsName(c.names, name) {
+					fail(j, "%s_if has a condition for %q, which isn't in %s", c.key, name, c.key)
+				} else if err := pipeline.Condition(c.conditions[name]).Validate(); err != nil {
+					fail(j, "invalid %s_if: %s", c.key, err)
+				}
+			}
+			for _, name := range sortedKeys(c.delays) {
+				if !containsName(c.names, name) {
+					fail(j, "%s_after has a delay for %q, which isn't in %s", c.key, name, c.key)
+				} else if d, err := time.ParseDuration(c.delays[name]); err != nil || d < 0 {
+					fail(j, "invalid %s_after %q", c.key, c.delays[name])
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return pipeline.ValidationErrors(errs)
+	}
+
+	//number the jobs to reuse the cycle search of the repository validation
+	graph := make([]*pipeline.Job, len(jobs))
+	ids := map[string]pipeline.JobID{}
+	for i, j := range jobs {
+		ids[j.Name] = pipeline.JobID(i + 1)
+	}
+	for i, j := range jobs {
+		graph[i] = &pipeline.Job{ID: ids[j.Name], LoopLimit: j.LoopLimit}
+		graph[i].Triggers.JobSuccess = resolve(j.OnSuccess, ids)
+		graph[i].Triggers.JobFailure = resolve(j.OnFailure, ids)
+		graph[i].Triggers.JobMapComplete = resolve(j.OnMapComplete, ids)
+	}
+	for i, j := range jobs {
+		path := pipeline.FindTriggerCycle(graph, ids[j.Name])
+		if path == nil || !lowest(path, ids[j.Name]) {
+			//every job in a cycle finds it, report it once
+			continue
+		}
+		names := make([]string, len(path))
+		for k, id := range path {
+			names[k] = jobs[id-1].Name
+		}
+		fail(jobs[i], "%s", pipeline.ErrTriggerCycle{Path: names})
+	}
+	if len(errs) > 0 {
+		return pipeline.ValidationErrors(errs)
+	}
+	return nil
+}
+
+func lowest(ids []pipeline.JobID, id pipeline.JobID) bool {
+	for _, i := range ids {
+		if i < id {
+			return false
+		}
+	}
+	return true
+}
+
+// triggeredBy names the jobs of every trigger of j
+func (j *Job) triggeredBy() []string {
+	names := append([]string{}, j.OnSuccess...)
+	names = append(names, j.OnFailure...)
+	return append(names, j.OnMapComplete...)
+}
+
+func (j *Job) joinTimeout() (time.Duration, error) {
+	return parseDuration(j.JoinTimeout)
+}
+
+// sensor converts the validated sensor of j, jobs without one aren't
+// sensors
+func (j *Job) sensor() pipeline.Sensor {
+	if j.Sensor == nil {
+		return pipeline.Sensor{}
+	}
+	interval, _ := parseDuration(j.Sensor.Interval)
+	timeout, _ := parseDuration(j.Sensor.Timeout)
+	return pipeline.Sensor{ReadyIf: pipeline.Condition(j.Sensor.ReadyIf), Interval: interval, Timeout: timeout}
+}
+
+// fileWatch converts the validated file watch of j, a zero FileWatch if j
+// doesn't watch a directory
+func (j *Job) fileWatch() pipeline.FileWatch {
+	if j.FileWatch == nil {
+		return pipeline.FileWatch{}
+	}
+	stableFor, _ := parseDuration(j.FileWatch.StableFor)
+	debounce, _ := parseDuration(j.FileWatch.Debounce)
+	return pipeline.FileWatch{
+		Dir:       j.FileWatch.Dir,
+		Pattern:   j.FileWatch.Pattern,
+		Recursive: j.FileWatch.Recursive,
+		StableFor: stableFor,
+		Debounce:  debounce,
+	}
+}
+
+// parseDuration parses optional durations, empty is 0
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortJobs(jobs []*Job) {
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Name < jobs[k].Name
+	})
+}
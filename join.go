@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// join records the success of r for job j, whose JobSuccess triggers are
+// joined. The first upstream success of an execution creates a run of j in
+// the joining status, it becomes pending once every upstream job has
+// succeeded in the execution, delayed by the trigger of the last one. A join
+// fires once per execution.
+func (s *Service) join(j *Job, r *Run, jobs []*Job) error {
+	s.joinMu.Lock()
+	defer s.joinMu.Unlock()
+
+	root := r.Root()
+	existing, err := s.repo.GetRuns(&GetRunsInput{JobID: &j.ID, RootRunID: &root, Summary: true})
+	if err != nil {
+		return err
+	}
+	var joining *Run
+	for _, run := range existing {
+		if run.Status != RunStatusJoining {
+			//already fired
+			return nil
+		}
+		joining = run
+	}
+
+	outputs, missing, err := s.upstreamOutputs(j, root, jobs)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if len(missing) > 0 {
+		if joining != nil {
+			return nil
+		}
+		id, err := s.repo.CreateRun(&CreateRunInput{
+			JobID:              j.ID,
+			JobVersionID:       j.VersionID,
+			ProcessorConfig:    j.ProcessorConfig,
+			Status:             RunStatusPtr(RunStatusJoining),
+			StatusDetail:       StringPtr("waiting for " + strings.Join(missing, ", ")),
+			ScheduledStartTime: downstreamContext(r, now, nil).ScheduledStartTime,
+			NotBefore:          &now,
+			Attempt:            IntPtr(1),
+			ParentRunID:        r.RunID,
+			RootRunID:          root,
+			BackfillID:         r.BackfillID,
+			Input:              []byte("{}"),
+		})
+		if err != nil {
+			return err
+		}
+		s.publishNext(EventRunTriggeredDownstream, r, j.ID, id)
+		return nil
+	}
+
+	data, err := json.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+	start := now.Add(j.Triggers.Delay(r.JobID, true))
+	next, err := j.MakeRun(downstreamContext(r, start, data))
+	if err != nil {
+		if joining != nil {
+			s.log.Printf("err making run of job %s: %s", j.ID, err)
+			return s.failJoin(joining, "err rendering input: "+err.Error(), now)
+		}
+		return s.failDownstream(j, r, "err rendering input: "+err.Error(), now)
+	}
+	if joining == nil {
+		next.ParentRunID = r.RunID
+		next.RootRunID = root
+		next.BackfillID = r.BackfillID
+		id, err := s.repo.CreateRun(next.CreateRunInput())
+		if err != nil {
+			return err
+		}
+		s.publishNext(EventRunTriggeredDownstream, r, j.ID, id)
+		return nil
+	}
+	err = s.repo.UpdateRun(&UpdateRunInput{
+		RunID:              joining.RunID,
+		Status:             RunStatusPtr(RunStatusPending),
+		StatusDetail:       StringPtr(""),
+		ScheduledStartTime: &next.ScheduledStartTime,
+		NotBefore:          &start,
+		ProcessorConfig:    &next.ProcessorConfig,
+		Input:              next.Input,
+	})
+	if err != nil {
+		return err
+	}
+	s.publishNext(EventRunTriggeredDownstream, r, j.ID, joining.RunID)
+	return nil
+}
+
+// upstreamOutputs returns the output of the latest successful run of each
+// upstream job of j in the execution, keyed by job name, and the names of
+// the upstream jobs that haven't succeeded yet. A run whose output doesn't
+// meet the trigger's condition hasn't succeeded for the join.
+func (s *Service) upstreamOutputs(j *Job, root RunID, jobs []*Job) (map[string]json.RawMessage, []string, error) {
+	names := map[JobID]string{}
+	for _, job := range jobs {
+		names[job.ID] = job.Name
+	}
+	outputs := map[string]json.RawMessage{}
+	var missing []string
+	limit := uint64(1)
+	for _, up := range j.Triggers.JobSuccess {
+		up := up
+		runs, err := s.repo.GetRuns(&GetRunsInput{
+			JobID:      &up,
+			RootRunID:  &root,
+			Status:     RunStatusPtr(RunStatusComplete),
+			Success:    BoolPtr(true),
+			OrderBy:    StringPtr(RunsOrderByID),
+			Descending: true,
+			Limit:      &limit,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		name, ok := names[up]
+		if !ok {
+			name = up.String()
+		}
+		if len(runs) == 0 {
+			missing = append(missing, name)
+			continue
+		}
+		if err := runs[0].LoadPayloads(s.BlobStore); err != nil {
+			return nil, nil, err
+		}
+		if ok, _ := j.Triggers.Condition(up, true).Eval(runs[0].Output); !ok {
+			missing = append(missing, name)
+			continue
+		}
+		outputs[name] = json.RawMessage(runs[0].Output)
+		if len(runs[0].Output) == 0 {
+			outputs[name] = json.RawMessage("{}")
+		}
+	}
+	return outputs, missing, nil
+}
+
+// expireJoins fails the joining runs whose job's JoinTimeout has passed, the
+// job's failure triggers fire as for any failed run
+func (s *Service) expireJoins(now time.Time) {
+	s.joinMu.Lock()
+	defer s.joinMu.Unlock()
+
+	runs, err := s.repo.GetRuns(&GetRunsInput{Status: RunStatusPtr(RunStatusJoining), Summary: true})
+	if err != nil {
+		s.log.Printf("err getting joining runs: %s", err)
+		return
+	}
+	for _, r := range runs {
+		job, err := s.getJob(r.JobID)
+		if err != nil {
+			s.log.Printf("err getting job of joining run %s: %s", r.RunID, err)
+			continue
+		}
+		timeout := job.Triggers.JoinTimeout
+		//the not before time of a joining run is its creation
+		if timeout <= 0 || now.Sub(r.NotBefore) < timeout {
+			continue
+		}
+		if err := s.failJoin(r, "join timed out, "+r.StatusDetail, now); err != nil {
+			s.log.Printf("err expiring joining run %s: %s", r.RunID, err)
+		}
+	}
+}
+
+// failJoin completes a joining run as failed, it must be called with joinMu
+// held
+func (s *Service) failJoin(r *Run, detail string, now time.Time) error {
+	err := s.repo.UpdateRun(&UpdateRunInput{
+		RunID:        r.RunID,
+		Status:       RunStatusPtr(RunStatusComplete),
+		StatusDetail: &detail,
+		EndTime:      &now,
+		Success:      BoolPtr(false),
+	})
+	if err != nil {
+		return err
+	}
+	r.Status, r.StatusDetail, r.EndTime, r.Success = RunStatusComplete, detail, &now, false
+	s.publish(EventRunFailed, r)
+	//failures never feed a join, so this doesn't take joinMu again
+	return s.triggerDownstream(r)
+}
@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestServiceJoin(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func(in []byte) (*RunResult, error) {
+			return &RunResult{Success: true, Output: json.RawMessage(`{"rows":2}`)}, nil
+		}), nil
+	})
+	create := func(in *CreateJobInput) JobID {
+		in.Processor = ProcessorConfig{Type: "test"}
+		if in.InputPayloadTemplate == nil {
+			in.InputPayloadTemplate = []byte("{}")
+		}
+		id, err := r.CreateJob(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	start := create(&CreateJobInput{Name: "start"})
+	a := create(&CreateJobInput{Name: "a", Triggers: &TriggerEventsInput{JobSuccess: JobIDs{start}}})
+	b := create(&CreateJobInput{Name: "b", Triggers: &TriggerEventsInput{JobSuccess: JobIDs{start}}})
+	never := create(&CreateJobInput{Name: "never"})
+	report := create(&CreateJobInput{
+		Name:                 "report",
+		InputPayloadTemplate: []byte(`{"a":{{.a.rows}},"b":{{.b.rows}}}`),
+		Triggers:             &TriggerEventsInput{JobSuccess: JobIDs{a, b}, JoinSuccess: BoolPtr(true)},
+	})
+	timeout := time.Hour
+	stuck := create(&CreateJobInput{
+		Name:     "stuck",
+		Triggers: &TriggerEventsInput{JobSuccess: JobIDs{a, never}, JoinSuccess: BoolPtr(true), JoinTimeout: &timeout},
+	})
+	alert := create(&CreateJobInput{Name: "alert", Triggers: &TriggerEventsInput{JobFailure: JobIDs{stuck}}})
+
+	runAll := func() {
+		for i := 0; i < 4; i++ {
+			s.startDueRuns(time.Now().Add(time.Minute))
+			s.running.Wait()
+		}
+	}
+	for i := 0; i < 2; i++ {
+		_, err := s.Repository().CreateRun(&CreateRunInput{JobID: start, ProcessorConfig: ProcessorConfig{Type: "test"}, Attempt: IntPtr(1), Input: []byte("{}"), ScheduledStartTime: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runAll()
+	}
+
+	runs, err := r.GetRuns(&GetRunsInput{JobID: &report, OrderBy: StringPtr(RunsOrderByID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("expected one report run per execution, got %d", len(runs))
+	}
+	for i, run := range runs {
+		if run.Status != RunStatusComplete || string(run.Input) != `{"a":2,"b":2}` {
+			t.Errorf("report run %d: expected a complete run with the joined outputs, got %+v", i, run)
+		}
+	}
+	if runs[0].Root() == runs[1].Root() {
+		t.Errorf("expected the report runs to belong to different executions")
+	}
+
+	runs, err = r.GetRuns(&GetRunsInput{JobID: &stuck})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Status != RunStatusJoining || runs[0].StatusDetail != "waiting for never" {
+		t.Fatalf("expected 2 joining runs waiting for never, got %+v", runs)
+	}
+	s.expireJoins(time.Now().Add(30 * time.Minute))
+	s.expireJoins(time.Now().Add(2 * time.Hour))
+	runs, err = r.GetRuns(&GetRunsInput{JobID: &stuck})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range runs {
+		if run.Status != RunStatusComplete || run.Success || run.StatusDetail != "join timed out, waiting for never" {
+			t.Errorf("expected the join to time out, got %+v", run)
+		}
+	}
+	runs, err = r.GetRuns(&GetRunsInput{JobID: &alert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Errorf("expected the timed out joins to trigger alert, got %d runs", len(runs))
+	}
+}
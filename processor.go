@@ -19,7 +19,7 @@ type RetryerConfig struct {
 }
 
 func (r *RetryerConfig) Scan(src interface{}) error {
-	srcBytes, ok := src.([]byte)
+	srcBytes, ok := scanBytes(src)
 	if !ok {
 		return fmt.Errorf("retryer config: unexpected src type: %T", src)
 	}
@@ -37,7 +37,7 @@ type ProcessorConfig struct {
 }
 
 func (p *ProcessorConfig) Scan(src interface{}) error {
-	srcBytes, ok := src.([]byte)
+	srcBytes, ok := scanBytes(src)
 	if !ok {
 		return fmt.Errorf("processor config: unexpected src type: %T", src)
 	}
@@ -76,6 +76,8 @@ type RunResult struct {
 	Detail  string
 	Success bool
 	Log     []byte
+
+	final bool //a failure that isn't retried, such as a sensor timing out
 }
 
 type DebugProcessor struct {
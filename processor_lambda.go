@@ -2,11 +2,28 @@ package pipeline
 
 import (
 	"encoding/json"
+	"errors"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/lambda"
 )
 
+const ProcessorTypeLambda = "lambda"
+
+// NewLambdaProcessorMaker makes processors that invoke the function named by
+// the FunctionName config
+func NewLambdaProcessorMaker(client *lambda.Lambda) ProcessorMaker {
+	return func(config map[string]string) (RunProcessor, error) {
+		if config["FunctionName"] == "" {
+			return nil, errors.New("lambda processor: FunctionName is required")
+		}
+		return &LambdaProcessor{
+			FunctionName: config["FunctionName"],
+			LambdaClient: client,
+		}, nil
+	}
+}
+
 type LambdaProcessor struct {
 	FunctionName string
 	LambdaClient *lambda.Lambda
@@ -1,19 +1,84 @@
 package pipeline
 
-import "time"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
 
 type Repository interface {
 	GetJobs(*GetJobsInput) ([]*Job, error)
 	CreateJob(j *CreateJobInput) (JobID, error)
 	UpdateJob(j *UpdateJobInput) error
+	DeleteJob(*DeleteJobInput) error
+	GetJobVersions(*GetJobVersionsInput) ([]*JobVersion, error)
 
 	GetRuns(*GetRunsInput) ([]*Run, error)
+	GetDueRuns(*GetDueRunsInput) ([]*Run, error)
 	CreateRun(*CreateRunInput) (RunID, error)
 	UpdateRun(*UpdateRunInput) error
+	PruneRuns(*PruneRunsInput) (*PruneRunsOutput, error)
+	GetRunRollups(*GetRunRollupsInput) ([]*RunRollup, error)
+
+	CreateTriggerDecision(*CreateTriggerDecisionInput) error
+	GetTriggerDecisions(*GetTriggerDecisionsInput) ([]*TriggerDecision, error)
+
+	GetExecutions(*GetExecutionsInput) ([]*Execution, error)
+
+	CreateBackfill(*CreateBackfillInput) (BackfillID, error)
+	GetBackfills(*GetBackfillsInput) ([]*Backfill, error)
+	UpdateBackfill(*UpdateBackfillInput) error
+
+	CreateApproval(*CreateApprovalInput) error
+	GetApprovals(*GetApprovalsInput) ([]*Approval, error)
+	DecideApproval(*DecideApprovalInput) error
+
+	CreateWebhook(*CreateWebhookInput) error
+	GetWebhooks(*GetWebhooksInput) ([]*Webhook, error)
+	DeleteWebhook(*DeleteWebhookInput) error
+	CreateWebhookRun(*CreateWebhookRunInput) (RunID, error)
+
+	GetWatchedFiles(*GetWatchedFilesInput) ([]*WatchedFile, error)
+	SaveWatchedFile(*WatchedFile) error
+	DeleteWatchedFiles(*DeleteWatchedFilesInput) error
+}
+
+// Transactor is implemented by repositories that can make several changes
+// atomically
+type Transactor interface {
+	InTx(func(Repository) error) error
+}
+
+// inTx calls f in a transaction of r, or with r itself if r isn't a
+// Transactor
+func inTx(r Repository, f func(Repository) error) error {
+	if t, ok := r.(Transactor); ok {
+		return t.InTx(f)
+	}
+	return f(r)
 }
 
+const (
+	ErrJobNotFound = Err("job not found")
+	ErrRunNotFound = Err("run not found")
+	//the run isn't in a status of UpdateRunInput.IfStatus
+	ErrRunStatusChanged = Err("run status changed")
+)
+
 type GetJobsInput struct {
 	JobIDs JobIDs
+	All    bool //if true, JobIDs is ignored and every job is returned
+}
+
+type DeleteJobInput struct {
+	JobID JobID
+}
+
+// GetJobVersionsInput selects versions of a job, oldest first. If VersionIDs
+// is empty every version of the job is returned.
+type GetJobVersionsInput struct {
+	JobID      JobID
+	VersionIDs []JobVersionID
 }
 
 type GetRunsInput struct {
@@ -21,22 +86,178 @@ type GetRunsInput struct {
 	RunID           *RunID
 	Status          *RunStatus
 	StartTimeBefore *time.Time //causes OrderBy to be set to 'startTime'
-	OrderBy         *string    //start_time or created_time
+	OrderBy         *string    //id, scheduled_start_time, not_before, start_time or end_time
+
+	//multi-value filters, combined with the single value filters above
+	JobIDs   JobIDs
+	RunIDs   RunIDs
+	Statuses []RunStatus
+
+	ScheduledStartTime *TimeRange
+	NotBefore          *TimeRange
+	StartTime          *TimeRange
+	EndTime            *TimeRange
+	Success            *bool
+	Attempt            *int
+	ActiveJobsOnly     bool   //excludes runs of paused jobs
+	RootRunID          *RunID //runs of the execution started by this run, the run included
+	RootRunIDs         RunIDs //runs of these executions, like RootRunID
+	ParentRunID        *RunID
+	BackfillID         *BackfillID
+
+	Descending bool
+	Limit      *uint64
+	Cursor     *RunsCursor //returned by NextCursor to fetch the following page
+	Summary    bool        //if true, Input, Output and Log are not loaded, refs still are
+}
+
+const (
+	RunsOrderByID                 = "id"
+	RunsOrderByScheduledStartTime = "scheduled_start_time"
+	RunsOrderByNotBefore          = "not_before"
+	RunsOrderByStartTime          = "start_time"
+	RunsOrderByEndTime            = "end_time"
+)
+
+// SortColumn returns the column the runs are ordered by
+func (in *GetRunsInput) SortColumn() string {
+	if in.StartTimeBefore == nil && in.OrderBy != nil {
+		return *in.OrderBy
+	}
+	return RunsOrderByStartTime
+}
+
+// NextCursor returns the cursor for the page following runs, or nil if runs
+// was the last page
+func (in *GetRunsInput) NextCursor(runs []*Run) *RunsCursor {
+	if in.Limit == nil || len(runs) == 0 || uint64(len(runs)) < *in.Limit {
+		return nil
+	}
+	last := runs[len(runs)-1]
+	c := &runsCursor{
+		OrderBy: in.SortColumn(),
+		RunID:   last.RunID,
+		Value:   runSortValue(last, in.SortColumn()),
+	}
+	return c.encode()
+}
+
+func runSortValue(r *Run, column string) *time.Time {
+	switch column {
+	case RunsOrderByScheduledStartTime:
+		return &r.ScheduledStartTime
+	case RunsOrderByNotBefore:
+		return &r.NotBefore
+	case RunsOrderByStartTime:
+		return r.StartTime
+	case RunsOrderByEndTime:
+		return r.EndTime
+	}
+	return nil
+}
+
+// RunsCursor is an opaque position in a list of runs
+type RunsCursor string
+
+type runsCursor struct {
+	OrderBy string
+	RunID   RunID
+	Value   *time.Time //value of the OrderBy column for RunID
+}
+
+func (c *runsCursor) encode() *RunsCursor {
+	d, _ := json.Marshal(c) //can't fail, all fields are marshallable
+	rc := RunsCursor(base64.RawURLEncoding.EncodeToString(d))
+	return &rc
+}
+
+func (c RunsCursor) decode() (*runsCursor, error) {
+	d, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return nil, err
+	}
+	rc := &runsCursor{}
+	if err := json.Unmarshal(d, rc); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// GetDueRunsInput selects pending runs whose not before time has passed,
+// oldest first. Runs of paused jobs are held back. The not before time is the
+// scheduled start time of a run unless the run was held back, by a trigger
+// delay, a sensor or a wait for example.
+type GetDueRunsInput struct {
+	Now   time.Time
+	Limit uint64
+}
+
+// TimeRange matches times in [After, Before), either bound can be left open
+type TimeRange struct {
+	After  *time.Time
+	Before *time.Time
 }
 
 type CreateRunInput struct {
 	JobID              JobID
+	JobVersionID       JobVersionID
 	ProcessorConfig    ProcessorConfig
 	Status             *RunStatus
 	StatusDetail       *string
 	ScheduledStartTime time.Time
+	NotBefore          *time.Time //defaults to ScheduledStartTime
 	Attempt            *int
 	StartTime          *time.Time
 	EndTime            *time.Time
 	Success            *bool
+	ParentRunID        RunID
+	RootRunID          RunID
+	MapIndex           int
+	MapCount           int
+	BackfillID         BackfillID
 	Input              []byte
 	Output             []byte
 	Log                []byte
+	TemplateContext    []byte
+	InputRef           BlobRef
+	OutputRef          BlobRef
+	LogRef             BlobRef
+}
+
+// CreateRunInput returns the input that stores r as a new run. Blob refs are
+// not copied so runs never share a blob, load the payloads first.
+func (r *Run) CreateRunInput() *CreateRunInput {
+	in := &CreateRunInput{
+		JobID:              r.JobID,
+		JobVersionID:       r.JobVersionID,
+		ProcessorConfig:    r.ProcessorConfig,
+		ScheduledStartTime: r.ScheduledStartTime,
+		Attempt:            IntPtr(r.Attempt),
+		StartTime:          r.StartTime,
+		EndTime:            r.EndTime,
+		ParentRunID:        r.ParentRunID,
+		RootRunID:          r.RootRunID,
+		MapIndex:           r.MapIndex,
+		MapCount:           r.MapCount,
+		BackfillID:         r.BackfillID,
+		Input:              r.Input,
+		Output:             r.Output,
+		Log:                r.Log,
+		TemplateContext:    r.TemplateContext,
+	}
+	if !r.NotBefore.IsZero() {
+		in.NotBefore = TimePtr(r.NotBefore)
+	}
+	if r.Status != "" {
+		in.Status = RunStatusPtr(r.Status)
+	}
+	if r.StatusDetail != "" {
+		in.StatusDetail = StringPtr(r.StatusDetail)
+	}
+	if r.Success {
+		in.Success = BoolPtr(true)
+	}
+	return in
 }
 
 type UpdateRunInput struct {
@@ -45,13 +266,174 @@ type UpdateRunInput struct {
 	Status             *RunStatus
 	StatusDetail       *string
 	ScheduledStartTime *time.Time
+	NotBefore          *time.Time
 	Attempt            *int
+	Pokes              *int
 	StartTime          *time.Time
 	EndTime            *time.Time
 	Success            *bool
 	Input              []byte
 	Output             []byte
 	Log                []byte
+	InputRef           *BlobRef
+	OutputRef          *BlobRef
+	LogRef             *BlobRef
+	//only updates the run if it is in one of these statuses, so of two
+	//concurrent changes of a run's status one fails with ErrRunStatusChanged
+	IfStatus []RunStatus
+}
+
+// PruneRunsInput deletes up to Limit completed runs that are older than the
+// KeepLast most recent runs of their job and that ended before the cutoff for
+// their outcome. A nil cutoff puts no age limit on runs with that outcome.
+// Cancelled runs are pruned and rolled up as failures.
+type PruneRunsInput struct {
+	KeepLast           int
+	SuccessEndedBefore *time.Time
+	FailureEndedBefore *time.Time
+	KeepSuccesses      bool //if true, successful runs are never deleted
+	KeepFailures       bool //if true, failed runs are never deleted
+	Limit              uint64
+	Rollup             bool //if true, deleted runs are added to the run_rollups
+	//runs started by webhook deliveries received after this are kept, so
+	//repeated deliveries still find them
+	DeliveredAfter *time.Time
+}
+
+type PruneRunsOutput struct {
+	Deleted int
+	Refs    []BlobRef //blobs referenced by the deleted runs
+}
+
+type GetRunRollupsInput struct {
+	JobIDs JobIDs
+	Days   *TimeRange
+}
+
+// RunRollup aggregates the pruned runs of a job that ended on Day (UTC)
+type RunRollup struct {
+	JobID         JobID
+	Day           time.Time
+	Successes     int
+	Failures      int
+	TotalDuration time.Duration
+}
+
+type CreateTriggerDecisionInput struct {
+	RunID     RunID
+	JobID     JobID
+	Condition Condition
+	Triggered bool
+	Error     string
+	CreatedAt time.Time
+}
+
+// GetTriggerDecisionsInput selects decisions, oldest first. Empty filters
+// match every decision.
+type GetTriggerDecisionsInput struct {
+	RunIDs RunIDs //upstream runs
+	JobIDs JobIDs //downstream jobs
+}
+
+// GetExecutionsInput selects executions, newest first. Empty filters match
+// every execution.
+type GetExecutionsInput struct {
+	ExecutionIDs RunIDs //IDs of the root runs
+	JobIDs       JobIDs //jobs of the root runs
+	Before       *RunID //executions started before this one, to fetch the following page
+	Limit        *uint64
+}
+
+// CreateBackfillInput creates a backfill with its runs, which are created
+// with its ID
+type CreateBackfillInput struct {
+	JobID             JobID
+	IncludeDownstream bool
+	MaxParallel       int
+	Runs              []*CreateRunInput //one per logical date, ordered by date
+	CreatedAt         time.Time
+}
+
+// GetBackfillsInput selects backfills, newest first. Empty filters match
+// every backfill.
+type GetBackfillsInput struct {
+	BackfillIDs BackfillIDs
+	JobIDs      JobIDs
+	Statuses    []BackfillStatus
+}
+
+type UpdateBackfillInput struct {
+	BackfillID BackfillID
+	Status     *BackfillStatus
+	EndTime    *time.Time
+}
+
+type CreateApprovalInput struct {
+	RunID       RunID
+	JobID       JobID
+	RequestedAt time.Time
+	ExpiresAt   time.Time
+}
+
+// GetApprovalsInput selects approvals, oldest first. Empty filters match
+// every approval.
+type GetApprovalsInput struct {
+	RunIDs        RunIDs
+	JobIDs        JobIDs
+	Statuses      []ApprovalStatus
+	ExpiresBefore *time.Time
+}
+
+// DecideApprovalInput decides a waiting approval, DecideApproval returns
+// ErrApprovalNotWaiting if it was already decided
+type DecideApprovalInput struct {
+	RunID     RunID
+	Status    ApprovalStatus //approved, rejected or expired
+	DecidedBy string
+	Comment   string
+	DecidedAt time.Time
+}
+
+// CreateWebhookInput creates the webhook of a job, replacing its previous
+// webhook
+type CreateWebhookInput struct {
+	JobID     JobID
+	Key       string
+	Secret    string
+	Auth      WebhookAuth
+	CreatedAt time.Time
+}
+
+// GetWebhooksInput selects webhooks by job or key, empty filters match every
+// webhook
+type GetWebhooksInput struct {
+	JobIDs JobIDs
+	Keys   []string
+}
+
+type DeleteWebhookInput struct {
+	JobID JobID
+}
+
+// CreateWebhookRunInput creates the run of a webhook delivery. If another
+// delivery of the job had the same IdempotencyKey, no run is created and
+// CreateWebhookRun returns the run of that delivery with
+// ErrDuplicateDelivery.
+type CreateWebhookRunInput struct {
+	JobID          JobID
+	IdempotencyKey string
+	Run            *CreateRunInput
+	ReceivedAt     time.Time
+}
+
+// GetWatchedFilesInput selects the state of the files watched for a job
+type GetWatchedFilesInput struct {
+	JobID JobID
+}
+
+type DeleteWatchedFilesInput struct {
+	JobID JobID
+	Paths []string
 }
 
 type CreateJobInput struct {
@@ -60,12 +442,28 @@ type CreateJobInput struct {
 	InputPayloadTemplate []byte
 	Retryer              RetryerConfig
 	Triggers             *TriggerEventsInput
+	LoopLimit            int
+	Sensor               Sensor
 }
 
 type TriggerEventsInput struct {
 	CronSchedule *CronSchedule
 	JobSuccess   JobIDs
 	JobFailure   JobIDs
+	JoinSuccess  *bool
+	JoinTimeout  *time.Duration
+	//conditions of the triggers in JobSuccess and JobFailure, they are
+	//replaced along with the lists they belong to
+	JobSuccessConditions map[JobID]Condition
+	JobFailureConditions map[JobID]Condition
+	//delays of the triggers in JobSuccess and JobFailure, replaced the same
+	//way
+	JobSuccessDelays map[JobID]time.Duration
+	JobFailureDelays map[JobID]time.Duration
+	MapPath          *OutputPath
+	MapMaxParallel   *int
+	JobMapComplete   JobIDs
+	FileWatch        *FileWatch //a FileWatch without Dir stops watching
 }
 
 type UpdateJobInput struct {
@@ -75,4 +473,7 @@ type UpdateJobInput struct {
 	InputPayloadTemplate []byte
 	Retryer              *RetryerConfig
 	Triggers             *TriggerEventsInput
+	Paused               *bool
+	LoopLimit            *int
+	Sensor               *Sensor //a Sensor without ReadyIf makes the job a plain job again
 }
@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	sq "github.com/Masterminds/squirrel"
@@ -8,21 +9,103 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	JobTriggerEventTypeSuccess = "success"
 	JobTriggerEventTypeFailure = "failure"
+	//the fan-out of the triggering job finished, see TriggerEvents.JobMapComplete
+	JobTriggerEventTypeMapComplete = "map_complete"
 )
 
 type SQLiteRepo struct {
 	DB *sql.DB
+	tx *sql.Tx //set on the repository passed to InTx
 }
 
 func NewSQLiteRepo(c *sql.DB) *SQLiteRepo {
 	return &SQLiteRepo{DB: c}
 }
 
+// sqlConn is implemented by *sql.DB and *sql.Tx
+type sqlConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction if the repository is in one
+func (s *SQLiteRepo) conn() sqlConn {
+	if s.tx != nil {
+		return utcConn{s.tx}
+	}
+	return utcConn{s.DB}
+}
+
+// utcConn binds times in UTC. go-sqlite3 stores a time as text with its
+// offset, times of different zones would compare as strings rather than as
+// instants.
+type utcConn struct {
+	c sqlConn
+}
+
+func (u utcConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return u.c.Exec(query, utcArgs(args)...)
+}
+
+func (u utcConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return u.c.Query(query, utcArgs(args)...)
+}
+
+func (u utcConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return u.c.QueryRow(query, utcArgs(args)...)
+}
+
+func utcArgs(args []interface{}) []interface{} {
+	utc := make([]interface{}, len(args))
+	for i, a := range args {
+		switch t := a.(type) {
+		case time.Time:
+			utc[i] = t.UTC()
+		case *time.Time:
+			if t != nil {
+				utc[i] = t.UTC()
+				continue
+			}
+			utc[i] = a
+		default:
+			utc[i] = a
+		}
+	}
+	return utc
+}
+
+// InTx calls f with a repository whose changes are committed together when f
+// returns nil and rolled back when it returns an error
+func (s *SQLiteRepo) InTx(f func(Repository) error) error {
+	return s.inTx(func(r *SQLiteRepo) error {
+		return f(r)
+	})
+}
+
+func (s *SQLiteRepo) inTx(f func(*SQLiteRepo) error) error {
+	if s.tx != nil {
+		return f(s)
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "err starting transaction")
+	}
+	if err := f(&SQLiteRepo{DB: s.DB, tx: tx}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("err rolling back: %s", rbErr)
+		}
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "err committing transaction")
+}
+
 func (s *SQLiteRepo) MigrateDB() error {
 	_, err := s.DB.Exec(`
 	CREATE TABLE IF NOT EXISTS jobs (
@@ -64,7 +147,238 @@ func (s *SQLiteRepo) MigrateDB() error {
 		FOREIGN KEY (job_id) REFERENCES jobs(id)
 		FOREIGN KEY (job_id_to_trigger) REFERENCES jobs(id)
 	)`)
-	return err
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS job_versions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id INT NOT NULL,
+		version INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		definition TEXT NOT NULL,
+		UNIQUE (job_id, version),
+		FOREIGN KEY (job_id) REFERENCES jobs(id)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS run_rollups (
+		job_id INT NOT NULL,
+		day TEXT NOT NULL,
+		successes INT NOT NULL,
+		failures INT NOT NULL,
+		total_duration_ms INT NOT NULL,
+		PRIMARY KEY (job_id, day),
+		FOREIGN KEY (job_id) REFERENCES jobs(id)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS trigger_decisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id INT NOT NULL,
+		job_id INT NOT NULL,
+		condition TEXT NOT NULL,
+		triggered BOOL NOT NULL,
+		error TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS backfills (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id INT NOT NULL,
+		status TEXT NOT NULL,
+		include_downstream BOOLEAN NOT NULL,
+		max_parallel INT NOT NULL,
+		dates INT NOT NULL,
+		first_date DATETIME NOT NULL,
+		last_date DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		end_time DATETIME
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS approvals (
+		run_id INTEGER PRIMARY KEY,
+		job_id INT NOT NULL,
+		status TEXT NOT NULL,
+		requested_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		decided_by TEXT NOT NULL,
+		comment TEXT NOT NULL,
+		decided_at DATETIME
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS webhooks (
+		job_id INTEGER PRIMARY KEY,
+		key TEXT NOT NULL UNIQUE,
+		secret TEXT NOT NULL,
+		auth TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		job_id INT NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		run_id INT NOT NULL,
+		received_at DATETIME NOT NULL,
+		PRIMARY KEY (job_id, idempotency_key)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS watched_files (
+		job_id INT NOT NULL,
+		path TEXT NOT NULL,
+		size INT NOT NULL,
+		mod_time DATETIME NOT NULL,
+		changed_at DATETIME NOT NULL,
+		triggered_size INT NOT NULL DEFAULT 0,
+		triggered_mod_time DATETIME NOT NULL,
+		triggered_at DATETIME NULL,
+		PRIMARY KEY (job_id, path)
+	)`)
+	if err != nil {
+		return err
+	}
+	if err := s.addColumns(); err != nil {
+		return err
+	}
+	return s.createIndexes()
+}
+
+// columnMigrations are columns added after a table was first released, they
+// are added to existing databases by MigrateDB
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"runs", "input_ref", "TEXT NOT NULL DEFAULT ''"},
+	{"runs", "output_ref", "TEXT NOT NULL DEFAULT ''"},
+	{"runs", "log_ref", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "version_id", "INT NOT NULL DEFAULT 0"},
+	{"runs", "job_version_id", "INT NOT NULL DEFAULT 0"},
+	{"jobs", "paused", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"jobs", "loop_limit", "INT NOT NULL DEFAULT 0"},
+	{"runs", "parent_run_id", "INT NOT NULL DEFAULT 0"},
+	{"jobs", "join_success", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"jobs", "join_timeout", "INT NOT NULL DEFAULT 0"},
+	{"runs", "root_run_id", "INT NOT NULL DEFAULT 0"},
+	{"job_triggers", "condition", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "map_path", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "map_max_parallel", "INT NOT NULL DEFAULT 0"},
+	{"runs", "map_index", "INT NOT NULL DEFAULT 0"},
+	{"runs", "map_count", "INT NOT NULL DEFAULT 0"},
+	{"runs", "backfill_id", "INT NOT NULL DEFAULT 0"},
+	{"job_triggers", "delay", "INT NOT NULL DEFAULT 0"},
+	{"jobs", "sensor_ready_if", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "sensor_interval", "INT NOT NULL DEFAULT 0"},
+	{"jobs", "sensor_timeout", "INT NOT NULL DEFAULT 0"},
+	{"runs", "pokes", "INT NOT NULL DEFAULT 0"},
+	{"jobs", "watch_dir", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "watch_pattern", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "watch_recursive", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"jobs", "watch_stable_for", "INT NOT NULL DEFAULT 0"},
+	{"jobs", "watch_debounce", "INT NOT NULL DEFAULT 0"},
+	{"runs", "not_before", "DATETIME"},
+	{"runs", "template_context", "TEXT NOT NULL DEFAULT ''"},
+	{"watched_files", "failures", "INT NOT NULL DEFAULT 0"},
+	{"watched_files", "retry_at", "DATETIME"},
+}
+
+// columnFills set the values of existing rows when a column of
+// columnMigrations is added, keyed by table.column
+var columnFills = map[string]string{
+	"runs.not_before": "UPDATE runs SET not_before = scheduled_start_time",
+}
+
+func (s *SQLiteRepo) addColumns() error {
+	for _, m := range columnMigrations {
+		exists, err := s.columnExists(m.table, m.column)
+		if err != nil {
+			return errors.Wrap(err, "migrate db: err reading table info")
+		}
+		if exists {
+			continue
+		}
+		_, err = s.DB.Exec("ALTER TABLE " + m.table + " ADD COLUMN " + m.column + " " + m.definition)
+		if err != nil {
+			return errors.Wrapf(err, "migrate db: err adding column %s.%s", m.table, m.column)
+		}
+		if fill, ok := columnFills[m.table+"."+m.column]; ok {
+			if _, err := s.DB.Exec(fill); err != nil {
+				return errors.Wrapf(err, "migrate db: err filling column %s.%s", m.table, m.column)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteRepo) columnExists(table, column string) (bool, error) {
+	rows, err := s.DB.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("column exists: err closing rows: %s", err)
+		}
+	}()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func (s *SQLiteRepo) createIndexes() error {
+	indexes := []string{
+		//used by GetDueRuns, id breaks ties so the scan never needs a sort
+		`CREATE INDEX IF NOT EXISTS runs_status_not_before
+			ON runs (status, not_before, id)`,
+		`DROP INDEX IF EXISTS runs_status_scheduled_start_time`,
+		`CREATE INDEX IF NOT EXISTS runs_job_id_scheduled_start_time
+			ON runs (job_id, scheduled_start_time)`,
+		`CREATE INDEX IF NOT EXISTS job_triggers_job_id
+			ON job_triggers (job_id, event_type)`,
+		`CREATE INDEX IF NOT EXISTS trigger_decisions_run_id
+			ON trigger_decisions (run_id)`,
+		//used to find the runs of executions
+		`CREATE INDEX IF NOT EXISTS runs_root_run_id
+			ON runs (root_run_id)`,
+		`CREATE INDEX IF NOT EXISTS runs_backfill_id
+			ON runs (backfill_id, status)`,
+		//used to expire approvals
+		`CREATE INDEX IF NOT EXISTS approvals_status_expires_at
+			ON approvals (status, expires_at)`,
+	}
+	for _, idx := range indexes {
+		if _, err := s.DB.Exec(idx); err != nil {
+			return errors.Wrap(err, "migrate db: err creating index")
+		}
+	}
+	return nil
 }
 
 func (s *SQLiteRepo) GetJobs(in *GetJobsInput) ([]*Job, error) {
@@ -76,19 +390,36 @@ func (s *SQLiteRepo) GetJobs(in *GetJobsInput) ([]*Job, error) {
 		"processor_config",
 		"retryer_config",
 		"cron_schedule",
-		"group_concat(sucesses.job_id_to_trigger) AS success_job_ids",
-		"group_concat(failures.job_id_to_trigger) AS failure_job_ids",
+		"version_id",
+		"paused",
+		"loop_limit",
+		"join_success",
+		"join_timeout",
+		"map_path",
+		"map_max_parallel",
+		"sensor_ready_if",
+		"sensor_interval",
+		"sensor_timeout",
+		"watch_dir",
+		"watch_pattern",
+		"watch_recursive",
+		"watch_stable_for",
+		"watch_debounce",
 	).
+		Column(groupedTriggers("success_job_ids", JobTriggerEventTypeSuccess)).
+		Column(groupedTriggers("failure_job_ids", JobTriggerEventTypeFailure)).
+		Column(groupedTriggers("map_complete_job_ids", JobTriggerEventTypeMapComplete)).
 		From("jobs").
-		LeftJoin("job_triggers sucesses ON jobs.id = sucesses.job_id AND sucesses.event_type = ?", JobTriggerEventTypeSuccess).
-		LeftJoin("job_triggers failures ON jobs.id = failures.job_id AND failures.event_type = ?", JobTriggerEventTypeFailure).
-		Where(sq.Eq{"jobs.id": MakeInts(in.JobIDs)})
+		OrderBy("id")
+	if !in.All {
+		sqQuery = sqQuery.Where(sq.Eq{"jobs.id": MakeInts(in.JobIDs)})
+	}
 	query, args, err := sqQuery.ToSql()
 	if err != nil {
 		return nil, err
 	}
 	//run query
-	rows, err := s.DB.Query(query, args...)
+	rows, err := s.conn().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -107,20 +438,104 @@ func (s *SQLiteRepo) GetJobs(in *GetJobsInput) ([]*Job, error) {
 			&job.ProcessorConfig,
 			&job.RetryerConfig,
 			&job.Triggers.CronSchedule,
+			&job.VersionID,
+			&job.Paused,
+			&job.LoopLimit,
+			&job.Triggers.JoinSuccess,
+			&job.Triggers.JoinTimeout,
+			&job.Triggers.MapPath,
+			&job.Triggers.MapMaxParallel,
+			&job.Sensor.ReadyIf,
+			&job.Sensor.Interval,
+			&job.Sensor.Timeout,
+			&job.Triggers.FileWatch.Dir,
+			&job.Triggers.FileWatch.Pattern,
+			&job.Triggers.FileWatch.Recursive,
+			&job.Triggers.FileWatch.StableFor,
+			&job.Triggers.FileWatch.Debounce,
 			&job.Triggers.JobSuccess,
 			&job.Triggers.JobFailure,
+			&job.Triggers.JobMapComplete,
 		)
 		if err != nil {
 			return nil, err
 		}
 		jobs = append(jobs, &job)
 	}
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if err := s.getTriggerSettings(jobs); err != nil {
+		return nil, errors.Wrap(err, "get jobs: err getting trigger settings")
+	}
 	return jobs, nil
 }
 
+// getTriggerSettings sets the conditions and delays of the jobs' triggers
+func (s *SQLiteRepo) getTriggerSettings(jobs []*Job) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	byID := map[JobID]*Job{}
+	ids := make(JobIDs, len(jobs))
+	for i, j := range jobs {
+		byID[j.ID] = j
+		ids[i] = j.ID
+	}
+	query, args, err := sq.Select("job_id", "job_id_to_trigger", "event_type", "condition", "delay").
+		From("job_triggers").
+		Where(sq.Eq{"job_id": MakeInts(ids)}).
+		Where(sq.Or{sq.NotEq{"condition": ""}, sq.NotEq{"delay": 0}}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get trigger settings: err closing rows: %s", err)
+		}
+	}()
+	for rows.Next() {
+		var jobID, upstream JobID
+		var eventType string
+		var condition Condition
+		var delay time.Duration
+		if err := rows.Scan(&jobID, &upstream, &eventType, &condition, &delay); err != nil {
+			return err
+		}
+		t := &byID[jobID].Triggers
+		conditions, delays := &t.JobSuccessConditions, &t.JobSuccessDelays
+		if eventType == JobTriggerEventTypeFailure {
+			conditions, delays = &t.JobFailureConditions, &t.JobFailureDelays
+		}
+		if condition != "" {
+			if *conditions == nil {
+				*conditions = map[JobID]Condition{}
+			}
+			(*conditions)[upstream] = condition
+		}
+		if delay != 0 {
+			if *delays == nil {
+				*delays = map[JobID]time.Duration{}
+			}
+			(*delays)[upstream] = delay
+		}
+	}
+	return rows.Err()
+}
+
+// groupedTriggers selects the comma separated ids of a job's triggers
+func groupedTriggers(alias, eventType string) sq.Sqlizer {
+	return sq.Expr(`(
+		SELECT group_concat(job_id_to_trigger) FROM job_triggers
+		WHERE job_triggers.job_id = jobs.id AND job_triggers.event_type = ?
+	) AS `+alias, eventType)
+}
+
 func parseGroupedJobIDs(s *string) (JobIDs, error) {
 	ids := JobIDs{}
 	if s == nil {
@@ -140,7 +555,18 @@ func parseGroupedJobIDs(s *string) (JobIDs, error) {
 	return ids, nil
 }
 
+// CreateJob inserts the job, its triggers and its first version together
 func (s *SQLiteRepo) CreateJob(j *CreateJobInput) (JobID, error) {
+	var id JobID
+	err := s.inTx(func(tx *SQLiteRepo) error {
+		var err error
+		id, err = tx.createJob(j)
+		return err
+	})
+	return id, err
+}
+
+func (s *SQLiteRepo) createJob(j *CreateJobInput) (JobID, error) {
 	processor, err := json.Marshal(j.Processor)
 	if err != nil {
 		return 0, err
@@ -152,55 +578,111 @@ func (s *SQLiteRepo) CreateJob(j *CreateJobInput) (JobID, error) {
 	cronSchedule := ""
 	var jobSuccess JobIDs
 	var jobFailure JobIDs
+	var successConditions, failureConditions map[JobID]Condition
+	var successDelays, failureDelays map[JobID]time.Duration
+	var mapComplete JobIDs
+	var join bool
+	var joinTimeout time.Duration
+	var mapPath OutputPath
+	var mapMaxParallel int
+	var watch FileWatch
 	if j.Triggers != nil {
 		if j.Triggers.CronSchedule != nil {
 			cronSchedule = string(*j.Triggers.CronSchedule)
 		}
+		if j.Triggers.JoinSuccess != nil {
+			join = *j.Triggers.JoinSuccess
+		}
+		if j.Triggers.JoinTimeout != nil {
+			joinTimeout = *j.Triggers.JoinTimeout
+		}
 		if len(j.Triggers.JobSuccess) > 0 {
 			jobSuccess = j.Triggers.JobSuccess
 		}
 		if len(j.Triggers.JobFailure) > 0 {
 			jobFailure = j.Triggers.JobFailure
 		}
+		successConditions = j.Triggers.JobSuccessConditions
+		failureConditions = j.Triggers.JobFailureConditions
+		successDelays = j.Triggers.JobSuccessDelays
+		failureDelays = j.Triggers.JobFailureDelays
+		mapComplete = j.Triggers.JobMapComplete
+		if j.Triggers.MapPath != nil {
+			mapPath = *j.Triggers.MapPath
+		}
+		if j.Triggers.MapMaxParallel != nil {
+			mapMaxParallel = *j.Triggers.MapMaxParallel
+		}
+		if j.Triggers.FileWatch != nil {
+			watch = *j.Triggers.FileWatch
+		}
 	}
 	//insert job
-	id, err := s.insertJob(j.Name, cronSchedule, processor, j.InputPayloadTemplate, retryer)
+	id, err := s.insertJob(map[string]interface{}{
+		"name":                   j.Name,
+		"processor_config":       processor,
+		"input_payload_template": j.InputPayloadTemplate,
+		"retryer_config":         retryer,
+		"cron_schedule":          cronSchedule,
+		"loop_limit":             j.LoopLimit,
+		"join_success":           join,
+		"join_timeout":           int64(joinTimeout),
+		"map_path":               string(mapPath),
+		"map_max_parallel":       mapMaxParallel,
+		"sensor_ready_if":        string(j.Sensor.ReadyIf),
+		"sensor_interval":        int64(j.Sensor.Interval),
+		"sensor_timeout":         int64(j.Sensor.Timeout),
+		"watch_dir":              watch.Dir,
+		"watch_pattern":          watch.Pattern,
+		"watch_recursive":        watch.Recursive,
+		"watch_stable_for":       int64(watch.StableFor),
+		"watch_debounce":         int64(watch.Debounce),
+	})
+	if err != nil {
+		return 0, err
+	}
 	//insert job triggers
-	err = s.insertJobTriggers(id, jobSuccess, jobFailure)
+	err = s.insertJobTriggers(id, JobTriggerEventTypeSuccess, jobSuccess, successConditions, successDelays)
+	if err != nil {
+		return 0, err
+	}
+	err = s.insertJobTriggers(id, JobTriggerEventTypeFailure, jobFailure, failureConditions, failureDelays)
+	if err != nil {
+		return 0, err
+	}
+	err = s.insertJobTriggers(id, JobTriggerEventTypeMapComplete, mapComplete, nil, nil)
 	if err != nil {
 		return 0, err
 	}
+	if err := s.snapshotJob(JobID(id)); err != nil {
+		return 0, err
+	}
 	return JobID(id), nil
 }
 
-func (s *SQLiteRepo) insertJobTriggers(jobID int64, jobSuccess, jobFailure JobIDs) error {
-	if len(jobSuccess) == 0 && len(jobFailure) == 0 {
+func (s *SQLiteRepo) insertJobTriggers(jobID int64, eventType string, ids JobIDs, conditions map[JobID]Condition, delays map[JobID]time.Duration) error {
+	if len(ids) == 0 {
 		return nil
 	}
-	insert := sq.Insert("job_triggers").Columns("job_id", "job_id_to_trigger", "event_type")
-	for _, j := range jobSuccess {
-		insert = insert.Values(jobID, uint64(j), JobTriggerEventTypeSuccess)
-	}
-	for _, j := range jobFailure {
-		insert = insert.Values(jobID, uint64(j), JobTriggerEventTypeFailure)
+	insert := sq.Insert("job_triggers").Columns("job_id", "job_id_to_trigger", "event_type", "condition", "delay")
+	for _, j := range ids {
+		insert = insert.Values(jobID, uint64(j), eventType, string(conditions[j]), int64(delays[j]))
 	}
 	insertSQL, args, err := insert.ToSql()
 	if err != nil {
 		return err
 	}
-	_, err = s.DB.Exec(insertSQL, args...)
+	_, err = s.conn().Exec(insertSQL, args...)
 	return err
 }
 
-func (s *SQLiteRepo) insertJob(name, cronSchedule string, processor, inputPayload, retryer []byte) (int64, error) {
-	insert := sq.Insert("jobs").
-		Columns("name", "processor_config", "input_payload_template", "retryer_config", "cron_schedule").
-		Values(name, processor, inputPayload, retryer, cronSchedule)
+func (s *SQLiteRepo) insertJob(values map[string]interface{}) (int64, error) {
+	insert := sq.Insert("jobs").SetMap(values)
 	insertSQL, args, err := insert.ToSql()
 	if err != nil {
 		return 0, err
 	}
-	res, err := s.DB.Exec(insertSQL, args...)
+	res, err := s.conn().Exec(insertSQL, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -208,7 +690,15 @@ func (s *SQLiteRepo) insertJob(name, cronSchedule string, processor, inputPayloa
 	return id, err
 }
 
+// UpdateJob updates the job, replaces its triggers and snapshots the new
+// version together, a failure leaves the job as it was
 func (s *SQLiteRepo) UpdateJob(j *UpdateJobInput) error {
+	return s.inTx(func(tx *SQLiteRepo) error {
+		return tx.updateJob(j)
+	})
+}
+
+func (s *SQLiteRepo) updateJob(j *UpdateJobInput) error {
 	//update jobs table
 	update := sq.Update("jobs").Where(sq.Eq{"id": uint64(j.JobID)})
 	fieldChanged := false
@@ -240,25 +730,68 @@ func (s *SQLiteRepo) UpdateJob(j *UpdateJobInput) error {
 		update = update.Set("cron_schedule", string(*j.Triggers.CronSchedule))
 		fieldChanged = true
 	}
+	if j.Triggers != nil && j.Triggers.JoinSuccess != nil {
+		update = update.Set("join_success", *j.Triggers.JoinSuccess)
+		fieldChanged = true
+	}
+	if j.Triggers != nil && j.Triggers.JoinTimeout != nil {
+		update = update.Set("join_timeout", int64(*j.Triggers.JoinTimeout))
+		fieldChanged = true
+	}
+	if j.Triggers != nil && j.Triggers.MapPath != nil {
+		update = update.Set("map_path", string(*j.Triggers.MapPath))
+		fieldChanged = true
+	}
+	if j.Triggers != nil && j.Triggers.MapMaxParallel != nil {
+		update = update.Set("map_max_parallel", *j.Triggers.MapMaxParallel)
+		fieldChanged = true
+	}
+	if j.Triggers != nil && j.Triggers.FileWatch != nil {
+		w := j.Triggers.FileWatch
+		update = update.Set("watch_dir", w.Dir).
+			Set("watch_pattern", w.Pattern).
+			Set("watch_recursive", w.Recursive).
+			Set("watch_stable_for", int64(w.StableFor)).
+			Set("watch_debounce", int64(w.Debounce))
+		fieldChanged = true
+	}
+	if j.Paused != nil {
+		update = update.Set("paused", *j.Paused)
+		fieldChanged = true
+	}
+	if j.LoopLimit != nil {
+		update = update.Set("loop_limit", *j.LoopLimit)
+		fieldChanged = true
+	}
+	if j.Sensor != nil {
+		update = update.Set("sensor_ready_if", string(j.Sensor.ReadyIf)).
+			Set("sensor_interval", int64(j.Sensor.Interval)).
+			Set("sensor_timeout", int64(j.Sensor.Timeout))
+		fieldChanged = true
+	}
 	if fieldChanged {
 		updateSQL, args, err := update.ToSql()
 		if err != nil {
 			return errors.Wrap(err, "update job: err generating sql")
 		}
-		_, err = s.DB.Exec(updateSQL, args...)
+		_, err = s.conn().Exec(updateSQL, args...)
 		if err != nil {
 			return errors.Wrap(err, "update job: err running query")
 		}
 	}
 
 	//update job_triggers table
-	var successes, failures []JobID
+	var successes, failures, mapComplete JobIDs
+	var successConditions, failureConditions map[JobID]Condition
+	var successDelays, failureDelays map[JobID]time.Duration
 	if j.Triggers != nil && j.Triggers.JobSuccess != nil {
 		//delete previous success triggers
 		if err := s.deleteJobTriggers(j.JobID, JobTriggerEventTypeSuccess); err != nil {
 			return errors.Wrap(err, "update job: err deleting failure triggers")
 		}
 		successes = j.Triggers.JobSuccess
+		successConditions = j.Triggers.JobSuccessConditions
+		successDelays = j.Triggers.JobSuccessDelays
 	}
 	if j.Triggers != nil && j.Triggers.JobFailure != nil {
 		//delete previous failure triggers
@@ -266,11 +799,71 @@ func (s *SQLiteRepo) UpdateJob(j *UpdateJobInput) error {
 			return errors.Wrap(err, "update job: err deleting failure triggers")
 		}
 		failures = j.Triggers.JobFailure
+		failureConditions = j.Triggers.JobFailureConditions
+		failureDelays = j.Triggers.JobFailureDelays
+	}
+	if j.Triggers != nil && j.Triggers.JobMapComplete != nil {
+		if err := s.deleteJobTriggers(j.JobID, JobTriggerEventTypeMapComplete); err != nil {
+			return errors.Wrap(err, "update job: err deleting map complete triggers")
+		}
+		mapComplete = j.Triggers.JobMapComplete
 	}
-	err := s.insertJobTriggers(int64(j.JobID), successes, failures)
+	err := s.insertJobTriggers(int64(j.JobID), JobTriggerEventTypeSuccess, successes, successConditions, successDelays)
 	if err != nil {
 		return errors.Wrap(err, "update job: error inserting job triggers")
 	}
+	err = s.insertJobTriggers(int64(j.JobID), JobTriggerEventTypeFailure, failures, failureConditions, failureDelays)
+	if err != nil {
+		return errors.Wrap(err, "update job: error inserting job triggers")
+	}
+	err = s.insertJobTriggers(int64(j.JobID), JobTriggerEventTypeMapComplete, mapComplete, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "update job: error inserting job triggers")
+	}
+	return s.snapshotJob(j.JobID)
+}
+
+// DeleteJob removes the job and every trigger from or to it. Runs and versions
+// of the job are kept as history.
+func (s *SQLiteRepo) DeleteJob(in *DeleteJobInput) error {
+	res, err := s.conn().Exec("DELETE FROM jobs WHERE id = ?", uint64(in.JobID))
+	if err != nil {
+		return errors.Wrap(err, "delete job: err deleting job")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	//jobs triggered by the deleted job change definition, version them
+	var affected JobIDs
+	err = s.conn().QueryRow(
+		"SELECT group_concat(DISTINCT job_id) FROM job_triggers WHERE job_id_to_trigger = ? AND job_id != ?",
+		uint64(in.JobID), uint64(in.JobID),
+	).Scan(&affected)
+	if err != nil {
+		return errors.Wrap(err, "delete job: err getting dependent jobs")
+	}
+	_, err = s.conn().Exec(
+		"DELETE FROM job_triggers WHERE job_id = ? OR job_id_to_trigger = ?",
+		uint64(in.JobID), uint64(in.JobID),
+	)
+	if err != nil {
+		return errors.Wrap(err, "delete job: err deleting triggers")
+	}
+	if _, err := s.conn().Exec("DELETE FROM webhooks WHERE job_id = ?", uint64(in.JobID)); err != nil {
+		return errors.Wrap(err, "delete job: err deleting webhook")
+	}
+	if _, err := s.conn().Exec("DELETE FROM watched_files WHERE job_id = ?", uint64(in.JobID)); err != nil {
+		return errors.Wrap(err, "delete job: err deleting watched files")
+	}
+	for _, id := range affected {
+		if err := s.snapshotJob(id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -282,7 +875,7 @@ func (s *SQLiteRepo) deleteJobTriggers(id JobID, eventType string) error {
 	if err != nil {
 		return errors.Wrap(err, "delete job triggers: err creating sql")
 	}
-	_, err = s.DB.Exec(sql, args...)
+	_, err = s.conn().Exec(sql, args...)
 	if err != nil {
 		return errors.Wrap(err, "delete job triggers: err executing query")
 	}
@@ -291,46 +884,108 @@ func (s *SQLiteRepo) deleteJobTriggers(id JobID, eventType string) error {
 
 func (s *SQLiteRepo) GetRuns(in *GetRunsInput) ([]*Run, error) {
 	//build SQL
-	runsQuery := sq.Select(
+	columns := []string{
 		"id",
 		"job_id",
+		"job_version_id",
 		"status",
 		"status_detail",
 		"scheduled_start_time",
+		"not_before",
 		"start_time",
 		"end_time",
 		"attempt",
 		"success",
-		"input",
-		"output",
-		"log",
+		"parent_run_id",
+		"root_run_id",
+		"map_index",
+		"map_count",
+		"backfill_id",
+		"pokes",
 		"processor_config",
-	).
-		From("runs")
+		"input_ref",
+		"output_ref",
+		"log_ref",
+	}
+	if !in.Summary {
+		columns = append(columns, "input", "output", "log", "template_context")
+	}
+	runsQuery := sq.Select(columns...).From("runs")
 	if in.JobID != nil {
 		runsQuery = runsQuery.Where(sq.Eq{"job_id": *in.JobID})
 	}
+	if len(in.JobIDs) > 0 {
+		runsQuery = runsQuery.Where(sq.Eq{"job_id": MakeInts(in.JobIDs)})
+	}
 	if in.RunID != nil {
 		runsQuery = runsQuery.Where(sq.Eq{"id": *in.RunID})
 	}
+	if len(in.RunIDs) > 0 {
+		runsQuery = runsQuery.Where(sq.Eq{"id": MakeRunInts(in.RunIDs)})
+	}
 	if in.Status != nil {
 		runsQuery = runsQuery.Where(sq.Eq{"status": *in.Status})
 	}
+	if len(in.Statuses) > 0 {
+		statuses := make([]string, len(in.Statuses))
+		for i, st := range in.Statuses {
+			statuses[i] = st.String()
+		}
+		runsQuery = runsQuery.Where(sq.Eq{"status": statuses})
+	}
 	if in.StartTimeBefore != nil {
 		runsQuery = runsQuery.Where(sq.Lt{"start_time": *in.StartTimeBefore})
-		runsQuery = runsQuery.OrderBy("start_time")
 	}
-	if in.OrderBy != nil && in.StartTimeBefore == nil {
-		runsQuery = runsQuery.OrderBy(*in.OrderBy)
-	} else {
-		runsQuery = runsQuery.OrderBy("start_time")
+	runsQuery = whereTimeRange(runsQuery, "scheduled_start_time", in.ScheduledStartTime)
+	runsQuery = whereTimeRange(runsQuery, "not_before", in.NotBefore)
+	runsQuery = whereTimeRange(runsQuery, "start_time", in.StartTime)
+	runsQuery = whereTimeRange(runsQuery, "end_time", in.EndTime)
+	if in.Success != nil {
+		runsQuery = runsQuery.Where(sq.Eq{"success": *in.Success})
+	}
+	if in.Attempt != nil {
+		runsQuery = runsQuery.Where(sq.Eq{"attempt": *in.Attempt})
+	}
+	if in.ParentRunID != nil {
+		runsQuery = runsQuery.Where(sq.Eq{"parent_run_id": *in.ParentRunID})
+	}
+	if in.BackfillID != nil {
+		runsQuery = runsQuery.Where(sq.Eq{"backfill_id": uint64(*in.BackfillID)})
+	}
+	if in.RootRunID != nil {
+		runsQuery = runsQuery.Where(sq.Or{sq.Eq{"id": *in.RootRunID}, sq.Eq{"root_run_id": *in.RootRunID}})
+	}
+	if len(in.RootRunIDs) > 0 {
+		ids := MakeRunInts(in.RootRunIDs)
+		runsQuery = runsQuery.Where(sq.Or{sq.Eq{"id": ids}, sq.Eq{"root_run_id": ids}})
+	}
+	if in.ActiveJobsOnly {
+		runsQuery = runsQuery.Where("NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.id = runs.job_id AND jobs.paused)")
+	}
+
+	orderBy := in.SortColumn()
+	if in.Cursor != nil {
+		c, err := in.Cursor.decode()
+		if err != nil {
+			return nil, errors.Wrap(err, "get runs: err decoding cursor")
+		}
+		runsQuery = runsQuery.Where(cursorCondition(orderBy, c, in.Descending))
+	}
+	direction := " ASC"
+	if in.Descending {
+		direction = " DESC"
 	}
+	runsQuery = runsQuery.OrderBy(orderBy+direction, "id"+direction)
+	if in.Limit != nil {
+		runsQuery = runsQuery.Limit(*in.Limit)
+	}
+
 	query, args, err := runsQuery.ToSql()
 	if err != nil {
 		return nil, err
 	}
 	//run query
-	rows, err := s.DB.Query(query, args...)
+	rows, err := s.conn().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -342,33 +997,102 @@ func (s *SQLiteRepo) GetRuns(in *GetRunsInput) ([]*Run, error) {
 	runs := []*Run{}
 	for rows.Next() {
 		run := Run{}
-		err := rows.Scan(
+		dest := []interface{}{
 			&run.RunID,
 			&run.JobID,
+			&run.JobVersionID,
 			&run.Status,
 			&run.StatusDetail,
 			&run.ScheduledStartTime,
+			&run.NotBefore,
 			&run.StartTime,
 			&run.EndTime,
 			&run.Attempt,
 			&run.Success,
-			&run.Input,
-			&run.Output,
-			&run.Log,
+			&run.ParentRunID,
+			&run.RootRunID,
+			&run.MapIndex,
+			&run.MapCount,
+			&run.BackfillID,
+			&run.Pokes,
 			&run.ProcessorConfig,
-		)
-		if err != nil {
+			&run.InputRef,
+			&run.OutputRef,
+			&run.LogRef,
+		}
+		if !in.Summary {
+			dest = append(dest, &run.Input, &run.Output, &run.Log, &run.TemplateContext)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, err
 		}
+		if len(run.TemplateContext) == 0 {
+			run.TemplateContext = nil
+		}
 
 		runs = append(runs, &run)
 	}
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return runs, nil
 }
 
+func (s *SQLiteRepo) GetDueRuns(in *GetDueRunsInput) ([]*Run, error) {
+	return s.GetRuns(&GetRunsInput{
+		Status:         RunStatusPtr(RunStatusPending),
+		NotBefore:      &TimeRange{Before: &in.Now},
+		OrderBy:        StringPtr(RunsOrderByNotBefore),
+		Limit:          &in.Limit,
+		ActiveJobsOnly: true,
+	})
+}
+
+func whereTimeRange(q sq.SelectBuilder, column string, r *TimeRange) sq.SelectBuilder {
+	if r == nil {
+		return q
+	}
+	if r.After != nil {
+		q = q.Where(sq.GtOrEq{column: *r.After})
+	}
+	if r.Before != nil {
+		q = q.Where(sq.Lt{column: *r.Before})
+	}
+	return q
+}
+
+// cursorCondition selects the rows after the cursor position when ordering by
+// (column, id). sqlite sorts NULLs first, so they come before every value when
+// ascending and after every value when descending
+func cursorCondition(column string, c *runsCursor, desc bool) sq.Sqlizer {
+	if column == RunsOrderByID {
+		if desc {
+			return sq.Lt{"id": c.RunID}
+		}
+		return sq.Gt{"id": c.RunID}
+	}
+	if c.Value == nil {
+		if desc {
+			return sq.And{sq.Eq{column: nil}, sq.Lt{"id": c.RunID}}
+		}
+		return sq.Or{
+			sq.And{sq.Eq{column: nil}, sq.Gt{"id": c.RunID}},
+			sq.NotEq{column: nil},
+		}
+	}
+	if desc {
+		return sq.Or{
+			sq.Lt{column: *c.Value},
+			sq.And{sq.Eq{column: *c.Value}, sq.Lt{"id": c.RunID}},
+			sq.Eq{column: nil},
+		}
+	}
+	return sq.Or{
+		sq.Gt{column: *c.Value},
+		sq.And{sq.Eq{column: *c.Value}, sq.Gt{"id": c.RunID}},
+	}
+}
+
 func (s *SQLiteRepo) CreateRun(in *CreateRunInput) (RunID, error) {
 	procConfig, err := json.Marshal(in.ProcessorConfig)
 	if err != nil {
@@ -376,8 +1100,13 @@ func (s *SQLiteRepo) CreateRun(in *CreateRunInput) (RunID, error) {
 	}
 	valMap := map[string]interface{}{}
 	valMap["job_id"] = uint64(in.JobID)
+	valMap["job_version_id"] = uint64(in.JobVersionID)
 	valMap["processor_config"] = procConfig
 	valMap["scheduled_start_time"] = in.ScheduledStartTime
+	valMap["not_before"] = in.ScheduledStartTime
+	if in.NotBefore != nil {
+		valMap["not_before"] = *in.NotBefore
+	}
 
 	valMap["status_detail"] = ""
 	if in.StatusDetail != nil {
@@ -409,8 +1138,22 @@ func (s *SQLiteRepo) CreateRun(in *CreateRunInput) (RunID, error) {
 		valMap["log"] = in.Log
 	}
 
-	valMap["attempt"] = 0
-	if in.Attempt != nil {
+	if in.TemplateContext != nil {
+		valMap["template_context"] = in.TemplateContext
+	}
+
+	valMap["parent_run_id"] = uint64(in.ParentRunID)
+	valMap["root_run_id"] = uint64(in.RootRunID)
+	valMap["map_index"] = in.MapIndex
+	valMap["map_count"] = in.MapCount
+	valMap["backfill_id"] = uint64(in.BackfillID)
+
+	valMap["input_ref"] = in.InputRef
+	valMap["output_ref"] = in.OutputRef
+	valMap["log_ref"] = in.LogRef
+
+	valMap["attempt"] = 0
+	if in.Attempt != nil {
 		valMap["attempt"] = *in.Attempt
 	}
 
@@ -423,7 +1166,7 @@ func (s *SQLiteRepo) CreateRun(in *CreateRunInput) (RunID, error) {
 	if err != nil {
 		return 0, errors.Wrap(err, "create run: err creating sql")
 	}
-	res, err := s.DB.Exec(insertSQL, args...)
+	res, err := s.conn().Exec(insertSQL, args...)
 	if err != nil {
 		return 0, errors.Wrap(err, "create run: err executing query")
 	}
@@ -452,9 +1195,15 @@ func (s *SQLiteRepo) UpdateRun(in *UpdateRunInput) error {
 	if in.ScheduledStartTime != nil {
 		update = update.Set("scheduled_start_time", *in.ScheduledStartTime)
 	}
+	if in.NotBefore != nil {
+		update = update.Set("not_before", *in.NotBefore)
+	}
 	if in.Attempt != nil {
 		update = update.Set("attempt", *in.Attempt)
 	}
+	if in.Pokes != nil {
+		update = update.Set("pokes", *in.Pokes)
+	}
 	if in.StartTime != nil {
 		update = update.Set("start_time", *in.StartTime)
 	}
@@ -473,10 +1222,917 @@ func (s *SQLiteRepo) UpdateRun(in *UpdateRunInput) error {
 	if in.Log != nil {
 		update = update.Set("log", in.Log)
 	}
+	if in.InputRef != nil {
+		update = update.Set("input_ref", *in.InputRef)
+	}
+	if in.OutputRef != nil {
+		update = update.Set("output_ref", *in.OutputRef)
+	}
+	if in.LogRef != nil {
+		update = update.Set("log_ref", *in.LogRef)
+	}
+	if len(in.IfStatus) > 0 {
+		statuses := make([]string, len(in.IfStatus))
+		for i, st := range in.IfStatus {
+			statuses[i] = st.String()
+		}
+		update = update.Where(sq.Eq{"status": statuses})
+	}
 	updateSQL, args, err := update.ToSql()
 	if err != nil {
 		return err
 	}
-	_, err = s.DB.Exec(updateSQL, args...)
+	res, err := s.conn().Exec(updateSQL, args...)
+	if err != nil || len(in.IfStatus) == 0 {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return nil
+	}
+	runs, err := s.GetRuns(&GetRunsInput{RunID: &in.RunID, Summary: true})
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return ErrRunNotFound
+	}
+	return ErrRunStatusChanged
+}
+
+func (s *SQLiteRepo) PruneRuns(in *PruneRunsInput) (*PruneRunsOutput, error) {
+	//rank each job's completed and cancelled runs, newest first
+	ranked := sq.Select(
+		"id",
+		"job_id",
+		"success",
+		"start_time",
+		"end_time",
+		"input_ref",
+		"output_ref",
+		"log_ref",
+		"ROW_NUMBER() OVER (PARTITION BY job_id ORDER BY scheduled_start_time DESC, id DESC) AS job_rank",
+	).
+		From("runs").
+		Where(sq.Eq{"status": []string{RunStatusComplete.String(), RunStatusCancelled.String()}}).
+		//joins and fan-outs read the outputs of their execution's runs, keep
+		//every run of an execution until all of them finished
+		Where(`NOT EXISTS (
+			SELECT 1 FROM runs AS live
+			WHERE live.status NOT IN (?, ?)
+			AND (live.id = runs.root_run_id OR live.root_run_id = CASE WHEN runs.root_run_id = 0 THEN runs.id ELSE runs.root_run_id END)
+		)`, RunStatusComplete.String(), RunStatusCancelled.String())
+	if in.DeliveredAfter != nil {
+		ranked = ranked.Where(`NOT EXISTS (
+			SELECT 1 FROM webhook_deliveries
+			WHERE webhook_deliveries.run_id = runs.id AND webhook_deliveries.received_at > ?
+		)`, *in.DeliveredAfter)
+	}
+	outcomes := sq.Or{}
+	if !in.KeepSuccesses {
+		success := sq.And{sq.Eq{"success": true}}
+		if in.SuccessEndedBefore != nil {
+			success = append(success, sq.Lt{"end_time": *in.SuccessEndedBefore})
+		}
+		outcomes = append(outcomes, success)
+	}
+	if !in.KeepFailures {
+		failure := sq.And{sq.Eq{"success": false}}
+		if in.FailureEndedBefore != nil {
+			failure = append(failure, sq.Lt{"end_time": *in.FailureEndedBefore})
+		}
+		outcomes = append(outcomes, failure)
+	}
+	if len(outcomes) == 0 {
+		return &PruneRunsOutput{}, nil
+	}
+	query, args, err := sq.Select("id", "job_id", "success", "start_time", "end_time", "input_ref", "output_ref", "log_ref").
+		FromSelect(ranked, "ranked").
+		Where(sq.Gt{"job_rank": in.KeepLast}).
+		Where(outcomes).
+		Limit(in.Limit).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "prune runs: err creating sql")
+	}
+
+	var out *PruneRunsOutput
+	err = s.inTx(func(r *SQLiteRepo) error {
+		var err error
+		out, err = pruneRuns(r.conn(), query, args, in.Rollup)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func pruneRuns(tx sqlConn, query string, args []interface{}, rollup bool) (*PruneRunsOutput, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "prune runs: err selecting runs")
+	}
+	var ids []int64
+	rollups := map[rollupKey]*RunRollup{}
+	out := &PruneRunsOutput{}
+	for rows.Next() {
+		var id int64
+		var jobID JobID
+		var success bool
+		var start, end *time.Time
+		var refs [3]BlobRef
+		if err := rows.Scan(&id, &jobID, &success, &start, &end, &refs[0], &refs[1], &refs[2]); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "prune runs: err scanning run")
+		}
+		ids = append(ids, id)
+		for _, r := range refs {
+			if r != "" {
+				out.Refs = append(out.Refs, r)
+			}
+		}
+		if rollup && end != nil {
+			addToRollup(rollups, jobID, success, start, *end)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "prune runs: err selecting runs")
+	}
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	for _, r := range rollups {
+		_, err := tx.Exec(`
+		INSERT INTO run_rollups (job_id, day, successes, failures, total_duration_ms)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (job_id, day) DO UPDATE SET
+			successes = successes + excluded.successes,
+			failures = failures + excluded.failures,
+			total_duration_ms = total_duration_ms + excluded.total_duration_ms`,
+			uint64(r.JobID), r.Day.Format(rollupDayFormat), r.Successes, r.Failures, int64(r.TotalDuration/time.Millisecond),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "prune runs: err saving rollup")
+		}
+	}
+
+	deleteSQL, args, err := sq.Delete("trigger_decisions").Where(sq.Eq{"run_id": ids}).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "prune runs: err creating sql")
+	}
+	if _, err := tx.Exec(deleteSQL, args...); err != nil {
+		return nil, errors.Wrap(err, "prune runs: err deleting trigger decisions")
+	}
+	deleteSQL, args, err = sq.Delete("approvals").Where(sq.Eq{"run_id": ids}).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "prune runs: err creating sql")
+	}
+	if _, err := tx.Exec(deleteSQL, args...); err != nil {
+		return nil, errors.Wrap(err, "prune runs: err deleting approvals")
+	}
+	deleteSQL, args, err = sq.Delete("webhook_deliveries").Where(sq.Eq{"run_id": ids}).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "prune runs: err creating sql")
+	}
+	if _, err := tx.Exec(deleteSQL, args...); err != nil {
+		return nil, errors.Wrap(err, "prune runs: err deleting webhook deliveries")
+	}
+	deleteSQL, args, err = sq.Delete("runs").Where(sq.Eq{"id": ids}).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "prune runs: err creating sql")
+	}
+	res, err := tx.Exec(deleteSQL, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "prune runs: err deleting runs")
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	out.Deleted = int(deleted)
+	return out, nil
+}
+
+const rollupDayFormat = "2006-01-02"
+
+type rollupKey struct {
+	jobID JobID
+	day   string
+}
+
+func addToRollup(rollups map[rollupKey]*RunRollup, jobID JobID, success bool, start *time.Time, end time.Time) {
+	day := end.UTC().Format(rollupDayFormat)
+	k := rollupKey{jobID, day}
+	r, ok := rollups[k]
+	if !ok {
+		d, _ := time.Parse(rollupDayFormat, day)
+		r = &RunRollup{JobID: jobID, Day: d}
+		rollups[k] = r
+	}
+	if success {
+		r.Successes++
+	} else {
+		r.Failures++
+	}
+	if start != nil {
+		r.TotalDuration += end.Sub(*start)
+	}
+}
+
+func (s *SQLiteRepo) GetRunRollups(in *GetRunRollupsInput) ([]*RunRollup, error) {
+	q := sq.Select("job_id", "day", "successes", "failures", "total_duration_ms").
+		From("run_rollups").
+		OrderBy("job_id", "day")
+	if len(in.JobIDs) > 0 {
+		q = q.Where(sq.Eq{"job_id": MakeInts(in.JobIDs)})
+	}
+	if in.Days != nil && in.Days.After != nil {
+		q = q.Where(sq.GtOrEq{"day": in.Days.After.UTC().Format(rollupDayFormat)})
+	}
+	if in.Days != nil && in.Days.Before != nil {
+		q = q.Where(sq.Lt{"day": in.Days.Before.UTC().Format(rollupDayFormat)})
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get run rollups: err closing rows: %s", err)
+		}
+	}()
+	rollups := []*RunRollup{}
+	for rows.Next() {
+		r := RunRollup{}
+		var day string
+		var durationMS int64
+		if err := rows.Scan(&r.JobID, &day, &r.Successes, &r.Failures, &durationMS); err != nil {
+			return nil, err
+		}
+		r.Day, err = time.Parse(rollupDayFormat, day)
+		if err != nil {
+			return nil, err
+		}
+		r.TotalDuration = time.Duration(durationMS) * time.Millisecond
+		rollups = append(rollups, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
+
+func (s *SQLiteRepo) CreateTriggerDecision(in *CreateTriggerDecisionInput) error {
+	query, args, err := sq.Insert("trigger_decisions").
+		Columns("run_id", "job_id", "condition", "triggered", "error", "created_at").
+		Values(uint64(in.RunID), uint64(in.JobID), string(in.Condition), in.Triggered, in.Error, in.CreatedAt).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "create trigger decision: err creating sql")
+	}
+	_, err = s.conn().Exec(query, args...)
+	return errors.Wrap(err, "create trigger decision: err running query")
+}
+
+func (s *SQLiteRepo) GetTriggerDecisions(in *GetTriggerDecisionsInput) ([]*TriggerDecision, error) {
+	q := sq.Select("id", "run_id", "job_id", "condition", "triggered", "error", "created_at").
+		From("trigger_decisions").
+		OrderBy("id")
+	if len(in.RunIDs) > 0 {
+		q = q.Where(sq.Eq{"run_id": MakeRunInts(in.RunIDs)})
+	}
+	if len(in.JobIDs) > 0 {
+		q = q.Where(sq.Eq{"job_id": MakeInts(in.JobIDs)})
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get trigger decisions: err closing rows: %s", err)
+		}
+	}()
+	decisions := []*TriggerDecision{}
+	for rows.Next() {
+		d := TriggerDecision{}
+		if err := rows.Scan(&d.ID, &d.RunID, &d.JobID, &d.Condition, &d.Triggered, &d.Error, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+// GetExecutions finds the root runs, runs that weren't created by another
+// run, and aggregates the runs of their executions
+func (s *SQLiteRepo) GetExecutions(in *GetExecutionsInput) ([]*Execution, error) {
+	q := sq.Select("id").
+		From("runs").
+		Where(sq.Eq{"root_run_id": 0}).
+		OrderBy("id DESC")
+	if len(in.ExecutionIDs) > 0 {
+		q = q.Where(sq.Eq{"id": MakeRunInts(in.ExecutionIDs)})
+	}
+	if len(in.JobIDs) > 0 {
+		q = q.Where(sq.Eq{"job_id": MakeInts(in.JobIDs)})
+	}
+	if in.Before != nil {
+		q = q.Where(sq.Lt{"id": uint64(*in.Before)})
+	}
+	if in.Limit != nil {
+		q = q.Limit(*in.Limit)
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get executions: err closing rows: %s", err)
+		}
+	}()
+	var roots RunIDs
+	for rows.Next() {
+		var id RunID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		roots = append(roots, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	executions := []*Execution{}
+	if len(roots) == 0 {
+		return executions, nil
+	}
+
+	orderBy := RunsOrderByID
+	runs, err := s.GetRuns(&GetRunsInput{RootRunIDs: roots, OrderBy: &orderBy, Summary: true})
+	if err != nil {
+		return nil, err
+	}
+	byRoot := map[RunID][]*Run{}
+	for _, r := range runs {
+		byRoot[r.Root()] = append(byRoot[r.Root()], r)
+	}
+	for _, id := range roots {
+		members := byRoot[id]
+		if len(members) == 0 {
+			//pruned since the roots were read
+			continue
+		}
+		//ordered by ID, the root run comes first
+		executions = append(executions, NewExecution(members[0], members))
+	}
+	return executions, nil
+}
+
+func (s *SQLiteRepo) CreateBackfill(in *CreateBackfillInput) (BackfillID, error) {
+	var id BackfillID
+	err := s.inTx(func(tx *SQLiteRepo) error {
+		first, last := in.Runs[0].ScheduledStartTime, in.Runs[len(in.Runs)-1].ScheduledStartTime
+		query, args, err := sq.Insert("backfills").
+			Columns("job_id", "status", "include_downstream", "max_parallel", "dates", "first_date", "last_date", "created_at").
+			Values(uint64(in.JobID), string(BackfillStatusRunning), in.IncludeDownstream, in.MaxParallel, len(in.Runs), first, last, in.CreatedAt).
+			ToSql()
+		if err != nil {
+			return errors.Wrap(err, "create backfill: err creating sql")
+		}
+		res, err := tx.conn().Exec(query, args...)
+		if err != nil {
+			return errors.Wrap(err, "create backfill: err running query")
+		}
+		inserted, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = BackfillID(inserted)
+		for _, r := range in.Runs {
+			run := *r
+			run.BackfillID = id
+			if _, err := tx.CreateRun(&run); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetBackfills loads the backfills with the number of their runs by state
+func (s *SQLiteRepo) GetBackfills(in *GetBackfillsInput) ([]*Backfill, error) {
+	q := sq.Select("id", "job_id", "status", "include_downstream", "max_parallel", "dates", "first_date", "last_date", "created_at", "end_time").
+		From("backfills").
+		OrderBy("id DESC")
+	if len(in.BackfillIDs) > 0 {
+		ids := make([]uint64, len(in.BackfillIDs))
+		for i, id := range in.BackfillIDs {
+			ids[i] = uint64(id)
+		}
+		q = q.Where(sq.Eq{"id": ids})
+	}
+	if len(in.JobIDs) > 0 {
+		q = q.Where(sq.Eq{"job_id": MakeInts(in.JobIDs)})
+	}
+	if len(in.Statuses) > 0 {
+		statuses := make([]string, len(in.Statuses))
+		for i, st := range in.Statuses {
+			statuses[i] = string(st)
+		}
+		q = q.Where(sq.Eq{"status": statuses})
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get backfills: err closing rows: %s", err)
+		}
+	}()
+	backfills := []*Backfill{}
+	byID := map[BackfillID]*Backfill{}
+	for rows.Next() {
+		b := Backfill{Runs: map[string]int{}}
+		err := rows.Scan(&b.ID, &b.JobID, &b.Status, &b.IncludeDownstream, &b.MaxParallel, &b.Dates,
+			&b.FirstDate, &b.LastDate, &b.CreatedAt, &b.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		backfills = append(backfills, &b)
+		byID[b.ID] = &b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(backfills) == 0 {
+		return backfills, nil
+	}
+
+	ids := make([]uint64, len(backfills))
+	for i, b := range backfills {
+		ids[i] = uint64(b.ID)
+	}
+	query, args, err = sq.Select("backfill_id", "status", "success", "COUNT(*)").
+		From("runs").
+		Where(sq.Eq{"backfill_id": ids}).
+		GroupBy("backfill_id", "status", "success").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := counts.Close(); err != nil {
+			log.Printf("get backfills: err closing rows: %s", err)
+		}
+	}()
+	for counts.Next() {
+		var id BackfillID
+		run := &Run{}
+		var n int
+		if err := counts.Scan(&id, &run.Status, &run.Success, &n); err != nil {
+			return nil, err
+		}
+		byID[id].Runs[RunState(run)] += n
+	}
+	if err := counts.Err(); err != nil {
+		return nil, err
+	}
+	return backfills, nil
+}
+
+func (s *SQLiteRepo) UpdateBackfill(in *UpdateBackfillInput) error {
+	update := sq.Update("backfills").Where(sq.Eq{"id": uint64(in.BackfillID)})
+	if in.Status != nil {
+		update = update.Set("status", string(*in.Status))
+	}
+	if in.EndTime != nil {
+		update = update.Set("end_time", *in.EndTime)
+	}
+	query, args, err := update.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().Exec(query, args...)
 	return err
 }
+
+func (s *SQLiteRepo) CreateApproval(in *CreateApprovalInput) error {
+	query, args, err := sq.Insert("approvals").
+		Columns("run_id", "job_id", "status", "requested_at", "expires_at", "decided_by", "comment").
+		Values(uint64(in.RunID), uint64(in.JobID), string(ApprovalStatusWaiting), in.RequestedAt, in.ExpiresAt, "", "").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "create approval: err creating sql")
+	}
+	_, err = s.conn().Exec(query, args...)
+	return errors.Wrap(err, "create approval: err running query")
+}
+
+func (s *SQLiteRepo) GetApprovals(in *GetApprovalsInput) ([]*Approval, error) {
+	q := sq.Select("run_id", "job_id", "status", "requested_at", "expires_at", "decided_by", "comment", "decided_at").
+		From("approvals").
+		OrderBy("run_id")
+	if len(in.RunIDs) > 0 {
+		q = q.Where(sq.Eq{"run_id": MakeRunInts(in.RunIDs)})
+	}
+	if len(in.JobIDs) > 0 {
+		q = q.Where(sq.Eq{"job_id": MakeInts(in.JobIDs)})
+	}
+	if len(in.Statuses) > 0 {
+		statuses := make([]string, len(in.Statuses))
+		for i, st := range in.Statuses {
+			statuses[i] = string(st)
+		}
+		q = q.Where(sq.Eq{"status": statuses})
+	}
+	if in.ExpiresBefore != nil {
+		q = q.Where(sq.Lt{"expires_at": *in.ExpiresBefore})
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get approvals: err closing rows: %s", err)
+		}
+	}()
+	approvals := []*Approval{}
+	for rows.Next() {
+		a := Approval{}
+		err := rows.Scan(&a.RunID, &a.JobID, &a.Status, &a.RequestedAt, &a.ExpiresAt, &a.DecidedBy, &a.Comment, &a.DecidedAt)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return approvals, nil
+}
+
+// DecideApproval only updates a waiting approval, so of concurrent decisions
+// the first one wins
+func (s *SQLiteRepo) DecideApproval(in *DecideApprovalInput) error {
+	query, args, err := sq.Update("approvals").
+		Set("status", string(in.Status)).
+		Set("decided_by", in.DecidedBy).
+		Set("comment", in.Comment).
+		Set("decided_at", in.DecidedAt).
+		Where(sq.Eq{"run_id": uint64(in.RunID), "status": string(ApprovalStatusWaiting)}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "decide approval: err creating sql")
+	}
+	res, err := s.conn().Exec(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "decide approval: err running query")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return nil
+	}
+	approvals, err := s.GetApprovals(&GetApprovalsInput{RunIDs: RunIDs{in.RunID}})
+	if err != nil {
+		return err
+	}
+	if len(approvals) == 0 {
+		return ErrApprovalNotFound
+	}
+	return ErrApprovalNotWaiting
+}
+
+// CreateWebhook replaces the webhook of the job, if it has one
+func (s *SQLiteRepo) CreateWebhook(in *CreateWebhookInput) error {
+	query, args, err := sq.Insert("webhooks").
+		Options("OR REPLACE").
+		Columns("job_id", "key", "secret", "auth", "created_at").
+		Values(uint64(in.JobID), in.Key, in.Secret, string(in.Auth), in.CreatedAt).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "create webhook: err creating sql")
+	}
+	_, err = s.conn().Exec(query, args...)
+	return errors.Wrap(err, "create webhook: err running query")
+}
+
+func (s *SQLiteRepo) GetWebhooks(in *GetWebhooksInput) ([]*Webhook, error) {
+	q := sq.Select("job_id", "key", "secret", "auth", "created_at").
+		From("webhooks").
+		OrderBy("job_id")
+	if len(in.JobIDs) > 0 {
+		q = q.Where(sq.Eq{"job_id": MakeInts(in.JobIDs)})
+	}
+	if len(in.Keys) > 0 {
+		q = q.Where(sq.Eq{"key": in.Keys})
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get webhooks: err closing rows: %s", err)
+		}
+	}()
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		w := Webhook{}
+		if err := rows.Scan(&w.JobID, &w.Key, &w.Secret, &w.Auth, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (s *SQLiteRepo) DeleteWebhook(in *DeleteWebhookInput) error {
+	res, err := s.conn().Exec("DELETE FROM webhooks WHERE job_id = ?", uint64(in.JobID))
+	if err != nil {
+		return errors.Wrap(err, "delete webhook: err running query")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// CreateWebhookRun records the delivery before creating its run, in one
+// transaction, so of concurrent deliveries with the same key only one
+// creates a run
+func (s *SQLiteRepo) CreateWebhookRun(in *CreateWebhookRunInput) (RunID, error) {
+	var id RunID
+	err := s.inTx(func(tx *SQLiteRepo) error {
+		if in.IdempotencyKey != "" {
+			res, err := tx.conn().Exec(
+				"INSERT OR IGNORE INTO webhook_deliveries (job_id, idempotency_key, run_id, received_at) VALUES (?, ?, 0, ?)",
+				uint64(in.JobID), in.IdempotencyKey, in.ReceivedAt,
+			)
+			if err != nil {
+				return errors.Wrap(err, "create webhook run: err recording delivery")
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				err := tx.conn().QueryRow(
+					"SELECT run_id FROM webhook_deliveries WHERE job_id = ? AND idempotency_key = ?",
+					uint64(in.JobID), in.IdempotencyKey,
+				).Scan(&id)
+				if err != nil {
+					return errors.Wrap(err, "create webhook run: err getting delivery")
+				}
+				return ErrDuplicateDelivery
+			}
+		}
+		var err error
+		if id, err = tx.CreateRun(in.Run); err != nil {
+			return err
+		}
+		if in.IdempotencyKey == "" {
+			return nil
+		}
+		_, err = tx.conn().Exec(
+			"UPDATE webhook_deliveries SET run_id = ? WHERE job_id = ? AND idempotency_key = ?",
+			uint64(id), uint64(in.JobID), in.IdempotencyKey,
+		)
+		return errors.Wrap(err, "create webhook run: err recording delivery")
+	})
+	if err == ErrDuplicateDelivery {
+		return id, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *SQLiteRepo) GetWatchedFiles(in *GetWatchedFilesInput) ([]*WatchedFile, error) {
+	query, args, err := sq.Select(
+		"job_id",
+		"path",
+		"size",
+		"mod_time",
+		"changed_at",
+		"triggered_size",
+		"triggered_mod_time",
+		"triggered_at",
+		"failures",
+		"retry_at",
+	).
+		From("watched_files").
+		Where(sq.Eq{"job_id": uint64(in.JobID)}).
+		OrderBy("path").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get watched files: err closing rows: %s", err)
+		}
+	}()
+	files := []*WatchedFile{}
+	for rows.Next() {
+		f := WatchedFile{}
+		err := rows.Scan(
+			&f.JobID,
+			&f.Path,
+			&f.Size,
+			&f.ModTime,
+			&f.ChangedAt,
+			&f.TriggeredSize,
+			&f.TriggeredModTime,
+			&f.TriggeredAt,
+			&f.Failures,
+			&f.RetryAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// SaveWatchedFile creates or replaces the state of the file
+func (s *SQLiteRepo) SaveWatchedFile(f *WatchedFile) error {
+	query, args, err := sq.Insert("watched_files").
+		Options("OR REPLACE").
+		Columns("job_id", "path", "size", "mod_time", "changed_at", "triggered_size", "triggered_mod_time", "triggered_at", "failures", "retry_at").
+		Values(uint64(f.JobID), f.Path, f.Size, f.ModTime, f.ChangedAt, f.TriggeredSize, f.TriggeredModTime, f.TriggeredAt, f.Failures, f.RetryAt).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "save watched file: err creating sql")
+	}
+	_, err = s.conn().Exec(query, args...)
+	return errors.Wrap(err, "save watched file: err running query")
+}
+
+func (s *SQLiteRepo) DeleteWatchedFiles(in *DeleteWatchedFilesInput) error {
+	query, args, err := sq.Delete("watched_files").
+		Where(sq.Eq{"job_id": uint64(in.JobID), "path": in.Paths}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "delete watched files: err creating sql")
+	}
+	_, err = s.conn().Exec(query, args...)
+	return errors.Wrap(err, "delete watched files: err running query")
+}
+
+// snapshotJob stores the current definition of the job as a new version, if
+// it differs from the latest version
+func (s *SQLiteRepo) snapshotJob(id JobID) error {
+	jobs, err := s.GetJobs(&GetJobsInput{JobIDs: JobIDs{id}})
+	if err != nil {
+		return errors.Wrap(err, "snapshot job: err getting job")
+	}
+	if len(jobs) != 1 {
+		return errors.Errorf("snapshot job: job %s not found", id)
+	}
+	def, err := jobDefinition(jobs[0])
+	if err != nil {
+		return errors.Wrap(err, "snapshot job: err marshalling job")
+	}
+
+	var latest int
+	var latestDef []byte
+	err = s.conn().QueryRow(
+		"SELECT version, definition FROM job_versions WHERE job_id = ? ORDER BY version DESC LIMIT 1",
+		uint64(id),
+	).Scan(&latest, &latestDef)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "snapshot job: err getting latest version")
+	}
+	if bytes.Equal(def, latestDef) {
+		return nil
+	}
+
+	insertSQL, args, err := sq.Insert("job_versions").
+		Columns("job_id", "version", "created_at", "definition").
+		Values(uint64(id), latest+1, time.Now().UTC(), def).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "snapshot job: err creating sql")
+	}
+	res, err := s.conn().Exec(insertSQL, args...)
+	if err != nil {
+		return errors.Wrap(err, "snapshot job: err inserting version")
+	}
+	versionID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().Exec("UPDATE jobs SET version_id = ? WHERE id = ?", versionID, uint64(id))
+	if err != nil {
+		return errors.Wrap(err, "snapshot job: err setting current version")
+	}
+	return nil
+}
+
+func (s *SQLiteRepo) GetJobVersions(in *GetJobVersionsInput) ([]*JobVersion, error) {
+	q := sq.Select("id", "job_id", "version", "created_at", "definition").
+		From("job_versions").
+		Where(sq.Eq{"job_id": uint64(in.JobID)}).
+		OrderBy("version")
+	if len(in.VersionIDs) > 0 {
+		ids := make([]uint64, len(in.VersionIDs))
+		for i, v := range in.VersionIDs {
+			ids[i] = uint64(v)
+		}
+		q = q.Where(sq.Eq{"id": ids})
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("get job versions: err closing rows: %s", err)
+		}
+	}()
+	versions := []*JobVersion{}
+	for rows.Next() {
+		v := JobVersion{}
+		var def []byte
+		if err := rows.Scan(&v.ID, &v.JobID, &v.Version, &v.CreatedAt, &def); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(def, &v.Job); err != nil {
+			return nil, errors.Wrap(err, "get job versions: err unmarshalling definition")
+		}
+		v.Job.ID = v.JobID
+		v.Job.VersionID = v.ID
+		versions = append(versions, &v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
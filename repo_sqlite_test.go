@@ -3,7 +3,9 @@ package pipeline
 import (
 	"database/sql"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,7 +41,7 @@ func newTestRepo(t *testing.T) *testRepo {
 func (r *testRepo) Close() {
 	err := os.Remove(r.DBPath)
 	if err != nil {
-		r.t.Logf("err removing db: ", err)
+		r.t.Logf("err removing db: %s", err)
 	}
 }
 
@@ -72,8 +74,9 @@ func TestSQLiteCreateJob(t *testing.T) {
 				},
 			},
 			expected: &Job{
-				ID:   1,
-				Name: "test1",
+				ID:        1,
+				VersionID: 1,
+				Name:      "test1",
 				ProcessorConfig: ProcessorConfig{
 					Type:   "processortype",
 					Config: map[string]string{"user": "jdoe"},
@@ -84,9 +87,10 @@ func TestSQLiteCreateJob(t *testing.T) {
 				},
 				InputPayloadTemplate: []byte("payload"),
 				Triggers: TriggerEvents{
-					CronSchedule: CronSchedule("* * * * *"),
-					JobSuccess:   JobIDs{JobID(3)},
-					JobFailure:   JobIDs{JobID(2)},
+					CronSchedule:   CronSchedule("* * * * *"),
+					JobSuccess:     JobIDs{JobID(3)},
+					JobFailure:     JobIDs{JobID(2)},
+					JobMapComplete: JobIDs{},
 				},
 			},
 		},
@@ -108,8 +112,9 @@ func TestSQLiteCreateJob(t *testing.T) {
 				},
 			},
 			expected: &Job{
-				ID:   2,
-				Name: "test1",
+				ID:        2,
+				VersionID: 2,
+				Name:      "test1",
 				ProcessorConfig: ProcessorConfig{
 					Type:   "processortype",
 					Config: map[string]string{"user": "jdoe"},
@@ -120,9 +125,10 @@ func TestSQLiteCreateJob(t *testing.T) {
 				},
 				InputPayloadTemplate: []byte("payload"),
 				Triggers: TriggerEvents{
-					CronSchedule: CronSchedule(""),
-					JobSuccess:   JobIDs{},
-					JobFailure:   JobIDs{},
+					CronSchedule:   CronSchedule(""),
+					JobSuccess:     JobIDs{},
+					JobFailure:     JobIDs{},
+					JobMapComplete: JobIDs{},
 				},
 			},
 		},
@@ -194,7 +200,8 @@ func TestSQLiteUpdateJob(t *testing.T) {
 				},
 			},
 			expected: &Job{
-				Name: "test2",
+				VersionID: 2,
+				Name:      "test2",
 				ProcessorConfig: ProcessorConfig{
 					Type:   "ptype2",
 					Config: map[string]string{"p": "config2"},
@@ -205,9 +212,10 @@ func TestSQLiteUpdateJob(t *testing.T) {
 					Config: map[string]string{"r": "config2"},
 				},
 				Triggers: TriggerEvents{
-					CronSchedule: CronSchedule("* * *2"),
-					JobSuccess:   JobIDs{JobID(4)},
-					JobFailure:   JobIDs{JobID(5)},
+					CronSchedule:   CronSchedule("* * *2"),
+					JobSuccess:     JobIDs{JobID(4)},
+					JobFailure:     JobIDs{JobID(5)},
+					JobMapComplete: JobIDs{},
 				},
 			},
 		},
@@ -242,7 +250,8 @@ func TestSQLiteUpdateJob(t *testing.T) {
 				},
 			},
 			expected: &Job{
-				Name: "test1",
+				VersionID: 3,
+				Name:      "test1",
 				ProcessorConfig: ProcessorConfig{
 					Type:   "ptype",
 					Config: map[string]string{"p": "config"},
@@ -253,9 +262,10 @@ func TestSQLiteUpdateJob(t *testing.T) {
 					Config: map[string]string{"r": "config"},
 				},
 				Triggers: TriggerEvents{
-					CronSchedule: CronSchedule("* * *"),
-					JobSuccess:   JobIDs{JobID(3)},
-					JobFailure:   JobIDs{JobID(2)},
+					CronSchedule:   CronSchedule("* * *"),
+					JobSuccess:     JobIDs{JobID(3)},
+					JobFailure:     JobIDs{JobID(2)},
+					JobMapComplete: JobIDs{},
 				},
 			},
 		},
@@ -290,7 +300,8 @@ func TestSQLiteUpdateJob(t *testing.T) {
 				},
 			},
 			expected: &Job{
-				Name: "test1",
+				VersionID: 5,
+				Name:      "test1",
 				ProcessorConfig: ProcessorConfig{
 					Type:   "ptype",
 					Config: map[string]string{"p": "config"},
@@ -301,9 +312,10 @@ func TestSQLiteUpdateJob(t *testing.T) {
 					Config: map[string]string{"r": "config"},
 				},
 				Triggers: TriggerEvents{
-					CronSchedule: CronSchedule(""),
-					JobSuccess:   JobIDs{},
-					JobFailure:   JobIDs{},
+					CronSchedule:   CronSchedule(""),
+					JobSuccess:     JobIDs{},
+					JobFailure:     JobIDs{},
+					JobMapComplete: JobIDs{},
 				},
 			},
 		},
@@ -334,6 +346,60 @@ func TestSQLiteUpdateJob(t *testing.T) {
 			t.Errorf("%s: expected %s\ngot: %s", test.name, test.expected, jobs[0])
 		}
 	}
+
+	//a failed update changes nothing, the triggers of a missing job aren't
+	//replaced before its snapshot fails
+	err := r.UpdateJob(&UpdateJobInput{JobID: 99, Triggers: &TriggerEventsInput{JobSuccess: JobIDs{1}}})
+	if err == nil {
+		t.Fatal("expected an error updating a missing job")
+	}
+	var triggers int
+	if err := r.DB.QueryRow("SELECT COUNT(*) FROM job_triggers WHERE job_id = 99").Scan(&triggers); err != nil {
+		t.Fatal(err)
+	}
+	if triggers != 0 {
+		t.Errorf("expected the triggers of the failed update rolled back, got %d", triggers)
+	}
+}
+
+func TestSQLiteInTx(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	var id JobID
+	err := r.InTx(func(tx Repository) error {
+		var err error
+		id, err = tx.CreateJob(&CreateJobInput{Name: "rolled back"})
+		if err != nil {
+			return err
+		}
+		return Err("abort")
+	})
+	if err != Err("abort") {
+		t.Fatalf("err = %v", err)
+	}
+	jobs, err := r.GetJobs(&GetJobsInput{JobIDs: JobIDs{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("job created in rolled back transaction: %+v", jobs[0])
+	}
+
+	err = r.InTx(func(tx Repository) error {
+		id, err = tx.CreateJob(&CreateJobInput{Name: "committed"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err = r.GetJobs(&GetJobsInput{JobIDs: JobIDs{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "committed" {
+		t.Errorf("jobs = %+v", jobs)
+	}
 }
 
 func TestParseGroupedJobIDs(t *testing.T) {
@@ -406,6 +472,7 @@ func TestSQLiteCreateRun(t *testing.T) {
 				Status:             RunStatusPtr(RunStatusPending),
 				StatusDetail:       StringPtr("status detail"),
 				ScheduledStartTime: time.Date(2017, time.Month(2), 24, 9, 37, 2, 1, time.UTC),
+				NotBefore:          TimePtr(time.Date(2017, time.Month(2), 25, 9, 37, 2, 1, time.UTC)),
 				StartTime:          TimePtr(time.Date(2017, time.Month(3), 24, 9, 37, 2, 1, time.UTC)),
 				EndTime:            TimePtr(time.Date(2017, time.Month(4), 24, 9, 37, 2, 1, time.UTC)),
 				Success:            BoolPtr(true),
@@ -423,6 +490,7 @@ func TestSQLiteCreateRun(t *testing.T) {
 				Status:             RunStatusPending,
 				StatusDetail:       "status detail",
 				ScheduledStartTime: time.Date(2017, time.Month(2), 24, 9, 37, 2, 1, time.UTC),
+				NotBefore:          time.Date(2017, time.Month(2), 25, 9, 37, 2, 1, time.UTC),
 				StartTime:          TimePtr(time.Date(2017, time.Month(3), 24, 9, 37, 2, 1, time.UTC)),
 				EndTime:            TimePtr(time.Date(2017, time.Month(4), 24, 9, 37, 2, 1, time.UTC)),
 				Attempt:            1,
@@ -648,6 +716,136 @@ func TestSQLiteGetRuns(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "multiple job ids and statuses",
+			createRuns: []*CreateRunInput{
+				&CreateRunInput{
+					JobID:  JobID(1),
+					Input:  []byte("r1"),
+					Status: RunStatusPtr(RunStatusPending),
+				},
+				&CreateRunInput{
+					JobID:  JobID(2),
+					Input:  []byte("r2"),
+					Status: RunStatusPtr(RunStatusRunning),
+				},
+				&CreateRunInput{
+					JobID:  JobID(3),
+					Input:  []byte("r3"),
+					Status: RunStatusPtr(RunStatusComplete),
+				},
+				&CreateRunInput{
+					JobID:  JobID(2),
+					Input:  []byte("r4"),
+					Status: RunStatusPtr(RunStatusComplete),
+				},
+			},
+			query: &GetRunsInput{
+				JobIDs:   JobIDs{1, 2},
+				Statuses: []RunStatus{RunStatusPending, RunStatusComplete},
+				OrderBy:  StringPtr("id"),
+			},
+			expected: []*Run{
+				&Run{
+					RunID:  RunID(1),
+					JobID:  JobID(1),
+					Input:  []byte("r1"),
+					Status: RunStatusPending,
+				},
+				&Run{
+					RunID:  RunID(4),
+					JobID:  JobID(2),
+					Input:  []byte("r4"),
+					Status: RunStatusComplete,
+				},
+			},
+		},
+		{
+			name: "scheduled time range, success and attempt",
+			createRuns: []*CreateRunInput{
+				&CreateRunInput{
+					JobID:              JobID(1),
+					Input:              []byte("r1"),
+					ScheduledStartTime: time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC),
+					Success:            BoolPtr(true),
+				},
+				&CreateRunInput{
+					JobID:              JobID(1),
+					Input:              []byte("r2"),
+					ScheduledStartTime: time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC),
+					Success:            BoolPtr(true),
+					Attempt:            IntPtr(1),
+				},
+				&CreateRunInput{
+					JobID:              JobID(1),
+					Input:              []byte("r3"),
+					ScheduledStartTime: time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC),
+					Success:            BoolPtr(false),
+					Attempt:            IntPtr(1),
+				},
+				&CreateRunInput{
+					JobID:              JobID(1),
+					Input:              []byte("r4"),
+					ScheduledStartTime: time.Date(2017, 3, 3, 0, 0, 0, 0, time.UTC),
+					Success:            BoolPtr(true),
+					Attempt:            IntPtr(1),
+				},
+			},
+			query: &GetRunsInput{
+				ScheduledStartTime: &TimeRange{
+					After:  TimePtr(time.Date(2017, 3, 1, 0, 0, 0, 1, time.UTC)),
+					Before: TimePtr(time.Date(2017, 3, 3, 0, 0, 0, 0, time.UTC)),
+				},
+				Success: BoolPtr(true),
+				Attempt: IntPtr(1),
+			},
+			expected: []*Run{
+				&Run{
+					RunID:              RunID(2),
+					JobID:              JobID(1),
+					Input:              []byte("r2"),
+					Status:             RunStatusPending,
+					ScheduledStartTime: time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC),
+					NotBefore:          time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC),
+					Success:            true,
+					Attempt:            1,
+				},
+			},
+		},
+		{
+			name: "descending summary",
+			createRuns: []*CreateRunInput{
+				&CreateRunInput{
+					JobID:  JobID(1),
+					Input:  []byte("r1"),
+					Output: []byte("o1"),
+					Log:    []byte("l1"),
+				},
+				&CreateRunInput{
+					JobID:  JobID(1),
+					Input:  []byte("r2"),
+					Output: []byte("o2"),
+					Log:    []byte("l2"),
+				},
+			},
+			query: &GetRunsInput{
+				OrderBy:    StringPtr("id"),
+				Descending: true,
+				Summary:    true,
+			},
+			expected: []*Run{
+				&Run{
+					RunID:  RunID(2),
+					JobID:  JobID(1),
+					Status: RunStatusPending,
+				},
+				&Run{
+					RunID:  RunID(1),
+					JobID:  JobID(1),
+					Status: RunStatusPending,
+				},
+			},
+		},
 	}
 	for _, test := range tests {
 		r := newTestRepo(t)
@@ -710,6 +908,7 @@ func TestSQLiteUpdateRun(t *testing.T) {
 				Status:             RunStatusPtr(RunStatusComplete),
 				StatusDetail:       StringPtr("detail2"),
 				ScheduledStartTime: TimePtr(time.Date(2015, 1, 19, 2, 37, 12, 4, time.UTC)),
+				NotBefore:          TimePtr(time.Date(2015, 1, 20, 2, 37, 12, 4, time.UTC)),
 				Attempt:            IntPtr(2),
 				StartTime:          TimePtr(time.Date(2016, 1, 19, 2, 37, 12, 4, time.UTC)),
 				EndTime:            TimePtr(time.Date(2017, 1, 19, 2, 37, 12, 4, time.UTC)),
@@ -726,6 +925,7 @@ func TestSQLiteUpdateRun(t *testing.T) {
 				Status:             RunStatusComplete,
 				StatusDetail:       "detail2",
 				ScheduledStartTime: time.Date(2015, 1, 19, 2, 37, 12, 4, time.UTC),
+				NotBefore:          time.Date(2015, 1, 20, 2, 37, 12, 4, time.UTC),
 				Attempt:            2,
 				StartTime:          TimePtr(time.Date(2016, 1, 19, 2, 37, 12, 4, time.UTC)),
 				EndTime:            TimePtr(time.Date(2017, 1, 19, 2, 37, 12, 4, time.UTC)),
@@ -762,3 +962,304 @@ func TestSQLiteUpdateRun(t *testing.T) {
 		}
 	}
 }
+
+func TestSQLiteUpdateRunIfStatus(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	id, err := r.CreateRun(&CreateRunInput{Status: RunStatusPtr(RunStatusPending), Input: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel := &UpdateRunInput{RunID: id, Status: RunStatusPtr(RunStatusCancelled), IfStatus: []RunStatus{RunStatusPending}}
+	if err := r.UpdateRun(cancel); err != nil {
+		t.Fatal(err)
+	}
+	claim := &UpdateRunInput{RunID: id, Status: RunStatusPtr(RunStatusRunning), IfStatus: []RunStatus{RunStatusPending}}
+	if err := r.UpdateRun(claim); err != ErrRunStatusChanged {
+		t.Errorf("expected %s, got %v", ErrRunStatusChanged, err)
+	}
+	runs, err := r.GetRuns(&GetRunsInput{RunID: &id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[0].Status != RunStatusCancelled {
+		t.Errorf("expected the run to stay cancelled, got %s", runs[0].Status)
+	}
+	claim.RunID = 42
+	if err := r.UpdateRun(claim); err != ErrRunNotFound {
+		t.Errorf("unknown run: expected %s, got %v", ErrRunNotFound, err)
+	}
+}
+
+func TestSQLiteGetRunsPagination(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	//two runs share each start time, one run has never started
+	startTimes := []*time.Time{
+		TimePtr(time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)),
+		TimePtr(time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC)),
+		nil,
+		TimePtr(time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)),
+		TimePtr(time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC)),
+	}
+	for _, st := range startTimes {
+		_, err := r.CreateRun(&CreateRunInput{JobID: 1, Input: []byte("in"), StartTime: st})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    *GetRunsInput
+		expected RunIDs
+	}{
+		{
+			name:     "ascending by start time",
+			query:    &GetRunsInput{Limit: Uint64Ptr(2)},
+			expected: RunIDs{3, 1, 4, 2, 5},
+		},
+		{
+			name:     "descending by start time",
+			query:    &GetRunsInput{Limit: Uint64Ptr(2), Descending: true},
+			expected: RunIDs{5, 2, 4, 1, 3},
+		},
+		{
+			name:     "descending by id",
+			query:    &GetRunsInput{Limit: Uint64Ptr(3), OrderBy: StringPtr("id"), Descending: true},
+			expected: RunIDs{5, 4, 3, 2, 1},
+		},
+	}
+	for _, test := range tests {
+		got := RunIDs{}
+		q := test.query
+		for pages := 0; ; pages++ {
+			if pages > len(startTimes) {
+				t.Fatalf("%s: cursor never ended", test.name)
+			}
+			if err := q.Validate(); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			runs, err := r.GetRuns(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, run := range runs {
+				got = append(got, run.RunID)
+			}
+			q.Cursor = q.NextCursor(runs)
+			if q.Cursor == nil {
+				break
+			}
+		}
+		if !reflect.DeepEqual(test.expected, got) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestSQLiteGetDueRuns(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	creates := []*CreateRunInput{
+		//due, but scheduled after run 3 so it comes second
+		{JobID: 1, Input: []byte("r1"), ScheduledStartTime: now.Add(-time.Minute)},
+		//not due yet
+		{JobID: 1, Input: []byte("r2"), ScheduledStartTime: now.Add(time.Minute)},
+		{JobID: 2, Input: []byte("r3"), ScheduledStartTime: now.Add(-time.Hour)},
+		//already started, start time is irrelevant
+		{JobID: 2, Input: []byte("r4"), ScheduledStartTime: now.Add(-time.Hour), Status: RunStatusPtr(RunStatusRunning)},
+		{JobID: 3, Input: []byte("r5"), ScheduledStartTime: now.Add(-time.Second)},
+		//held back past its scheduled start time
+		{JobID: 3, Input: []byte("r6"), ScheduledStartTime: now.Add(-2 * time.Hour), NotBefore: TimePtr(now.Add(time.Minute))},
+	}
+	for _, c := range creates {
+		if _, err := r.CreateRun(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runs, err := r.GetDueRuns(&GetDueRunsInput{Now: now, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := RunIDs{}
+	for _, run := range runs {
+		got = append(got, run.RunID)
+	}
+	if expected := (RunIDs{3, 1}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	//runs of paused jobs are held back
+	jobID, err := r.CreateJob(&CreateJobInput{Name: "job1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpdateJob(&UpdateJobInput{JobID: jobID, Paused: BoolPtr(true)}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = r.GetDueRuns(&GetDueRunsInput{Now: now, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = RunIDs{}
+	for _, run := range runs {
+		got = append(got, run.RunID)
+	}
+	if expected := (RunIDs{3, 5}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("paused: expected %v, got %v", expected, got)
+	}
+
+	//make sure polling doesn't scan the whole table
+	plan, err := r.DB.Query(`EXPLAIN QUERY PLAN
+		SELECT id FROM runs WHERE status = ? AND not_before < ?
+		AND NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.id = runs.job_id AND jobs.paused)
+		ORDER BY not_before ASC, id ASC LIMIT 2`, RunStatusPending, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plan.Close()
+	usesIndex := false
+	for plan.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := plan.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(detail, "runs_status_not_before") {
+			usesIndex = true
+		}
+	}
+	if !usesIndex {
+		t.Error("expected due runs query to use runs_status_not_before index")
+	}
+}
+
+func TestSQLiteTimeZones(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	due := time.Date(2017, 3, 1, 9, 0, 0, 0, time.UTC)
+	east := time.FixedZone("+02:00", 2*60*60)
+	west := time.FixedZone("-04:00", -4*60*60)
+	id, err := r.CreateRun(&CreateRunInput{JobID: 1, Input: []byte("{}"), ScheduledStartTime: due.In(west)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		now      time.Time
+		expected int
+	}{
+		{due.Add(time.Hour).In(west), 1},
+		{due.Add(-time.Hour).In(east), 0},
+		{due.Add(time.Hour).In(east), 1},
+		{due.Add(-time.Hour).In(west), 0},
+	}
+	for _, test := range tests {
+		runs, err := r.GetDueRuns(&GetDueRunsInput{Now: test.now, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != test.expected {
+			t.Errorf("now %s: expected %d due runs, got %d", test.now, test.expected, len(runs))
+		}
+	}
+
+	end := due.In(east)
+	err = r.UpdateRun(&UpdateRunInput{RunID: id, Status: RunStatusPtr(RunStatusComplete), EndTime: &end, Success: BoolPtr(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := due.Add(-time.Hour).In(west)
+	out, err := r.PruneRuns(&PruneRunsInput{SuccessEndedBefore: &before, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Deleted != 0 {
+		t.Errorf("expected a run that ended after the cutoff to be kept, got %d deleted", out.Deleted)
+	}
+	before = due.Add(time.Hour).In(west)
+	if out, err = r.PruneRuns(&PruneRunsInput{SuccessEndedBefore: &before, Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if out.Deleted != 1 {
+		t.Errorf("expected a run that ended before the cutoff to be pruned, got %d deleted", out.Deleted)
+	}
+}
+
+const benchmarkRunCount = 2000000
+
+// BenchmarkSQLiteGetDueRuns polls a runs table with millions of completed runs
+// and a small backlog of pending ones, like a long lived deployment would.
+// The table is seeded once, benchmarks with sub-benchmarks only run once.
+func BenchmarkSQLiteGetDueRuns(b *testing.B) {
+	if testing.Short() {
+		b.Skip("seeding runs table is slow")
+	}
+	r := seedBenchmarkRuns(b)
+
+	in := &GetDueRunsInput{Now: time.Now(), Limit: DefaultDueRunsBatchSize}
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			runs, err := r.GetDueRuns(in)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if uint64(len(runs)) != in.Limit {
+				b.Fatalf("expected %d runs, got %d", in.Limit, len(runs))
+			}
+		}
+	})
+}
+
+// seedBenchmarkRuns creates a database under a temporary directory of b,
+// closed and removed when b finishes
+func seedBenchmarkRuns(b *testing.B) *SQLiteRepo {
+	db, err := sql.Open("sqlite3", filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			b.Logf("err closing db: %s", err)
+		}
+	})
+	r := NewSQLiteRepo(db)
+	if err := r.MigrateDB(); err != nil {
+		b.Fatal(err)
+	}
+
+	start := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO runs
+		(job_id, processor_config, status, status_detail, scheduled_start_time, not_before, attempt, success, input)
+		VALUES (?, '{}', ?, '', ?, ?, 0, 1, '{}')`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchmarkRunCount; i++ {
+		status := RunStatusComplete
+		if i%10000 == 0 {
+			status = RunStatusPending
+		}
+		at := start.Add(time.Duration(i) * time.Minute)
+		_, err := stmt.Exec(i%500, status, at, at)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := stmt.Close(); err != nil {
+		b.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return r
+}
@@ -1,11 +1,19 @@
 package pipeline
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type ValidationWrapper struct {
 	repo Repository
 }
 
+func NewValidationWrapper(r Repository) *ValidationWrapper {
+	return &ValidationWrapper{repo: r}
+}
+
 func (v *ValidationWrapper) GetJobs(in *GetJobsInput) ([]*Job, error) {
 	if err := in.Validate(); err != nil {
 		return nil, err
@@ -16,6 +24,11 @@ func (v *ValidationWrapper) CreateJob(in *CreateJobInput) (JobID, error) {
 	if err := in.Validate(); err != nil {
 		return 0, err
 	}
+	if in.Triggers != nil {
+		if err := v.validateTriggers(&Job{Name: in.Name, LoopLimit: in.LoopLimit}, in.Triggers); err != nil {
+			return 0, err
+		}
+	}
 	return v.repo.CreateJob(in)
 }
 
@@ -23,15 +36,57 @@ func (v *ValidationWrapper) UpdateJob(in *UpdateJobInput) error {
 	if err := in.Validate(); err != nil {
 		return err
 	}
+	triggersChanged := in.Triggers != nil &&
+		(in.Triggers.JobSuccess != nil || in.Triggers.JobFailure != nil || in.Triggers.JobMapComplete != nil)
+	if !triggersChanged && in.LoopLimit == nil {
+		return v.repo.UpdateJob(in)
+	}
+	jobs, err := v.repo.GetJobs(&GetJobsInput{JobIDs: JobIDs{in.JobID}})
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 1 {
+		j := jobs[0]
+		if in.Name != nil {
+			j.Name = *in.Name
+		}
+		if in.LoopLimit != nil {
+			j.LoopLimit = *in.LoopLimit
+		}
+		if err := v.validateTriggers(j, in.Triggers); err != nil {
+			return err
+		}
+	}
 	return v.repo.UpdateJob(in)
 }
 
+func (v *ValidationWrapper) DeleteJob(in *DeleteJobInput) error {
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return v.repo.DeleteJob(in)
+}
+
+func (v *ValidationWrapper) GetJobVersions(in *GetJobVersionsInput) ([]*JobVersion, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	return v.repo.GetJobVersions(in)
+}
+
 func (v *ValidationWrapper) GetRuns(in *GetRunsInput) ([]*Run, error) {
 	if err := in.Validate(); err != nil {
 		return nil, err
 	}
 	return v.repo.GetRuns(in)
 }
+func (v *ValidationWrapper) GetDueRuns(in *GetDueRunsInput) ([]*Run, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	return v.repo.GetDueRuns(in)
+}
+
 func (v *ValidationWrapper) CreateRun(in *CreateRunInput) (RunID, error) {
 	if err := in.Validate(); err != nil {
 		return 0, err
@@ -46,8 +101,192 @@ func (v *ValidationWrapper) UpdateRun(in *UpdateRunInput) error {
 	return v.repo.UpdateRun(in)
 }
 
+func (v *ValidationWrapper) PruneRuns(in *PruneRunsInput) (*PruneRunsOutput, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	return v.repo.PruneRuns(in)
+}
+
+func (v *ValidationWrapper) GetRunRollups(in *GetRunRollupsInput) ([]*RunRollup, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	return v.repo.GetRunRollups(in)
+}
+
+func (v *ValidationWrapper) CreateTriggerDecision(in *CreateTriggerDecisionInput) error {
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return v.repo.CreateTriggerDecision(in)
+}
+
+func (v *ValidationWrapper) GetTriggerDecisions(in *GetTriggerDecisionsInput) ([]*TriggerDecision, error) {
+	return v.repo.GetTriggerDecisions(in)
+}
+
+func (v *ValidationWrapper) GetExecutions(in *GetExecutionsInput) ([]*Execution, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	return v.repo.GetExecutions(in)
+}
+
+func (v *ValidationWrapper) CreateBackfill(in *CreateBackfillInput) (BackfillID, error) {
+	if err := in.Validate(); err != nil {
+		return 0, err
+	}
+	return v.repo.CreateBackfill(in)
+}
+
+func (v *ValidationWrapper) GetBackfills(in *GetBackfillsInput) ([]*Backfill, error) {
+	return v.repo.GetBackfills(in)
+}
+
+func (v *ValidationWrapper) UpdateBackfill(in *UpdateBackfillInput) error {
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return v.repo.UpdateBackfill(in)
+}
+
+func (v *ValidationWrapper) CreateApproval(in *CreateApprovalInput) error {
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return v.repo.CreateApproval(in)
+}
+
+func (v *ValidationWrapper) GetApprovals(in *GetApprovalsInput) ([]*Approval, error) {
+	return v.repo.GetApprovals(in)
+}
+
+func (v *ValidationWrapper) DecideApproval(in *DecideApprovalInput) error {
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return v.repo.DecideApproval(in)
+}
+
+func (v *ValidationWrapper) CreateWebhook(in *CreateWebhookInput) error {
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return v.repo.CreateWebhook(in)
+}
+
+func (v *ValidationWrapper) GetWebhooks(in *GetWebhooksInput) ([]*Webhook, error) {
+	return v.repo.GetWebhooks(in)
+}
+
+func (v *ValidationWrapper) DeleteWebhook(in *DeleteWebhookInput) error {
+	if in.JobID == 0 {
+		return ErrFieldRequired{"JobID"}
+	}
+	return v.repo.DeleteWebhook(in)
+}
+
+func (v *ValidationWrapper) GetWatchedFiles(in *GetWatchedFilesInput) ([]*WatchedFile, error) {
+	if in.JobID == 0 {
+		return nil, ErrFieldRequired{"JobID"}
+	}
+	return v.repo.GetWatchedFiles(in)
+}
+
+func (v *ValidationWrapper) SaveWatchedFile(f *WatchedFile) error {
+	var errs []error
+	if f.JobID == 0 {
+		errs = append(errs, ErrFieldRequired{"JobID"})
+	}
+	if f.Path == "" {
+		errs = append(errs, ErrFieldRequired{"Path"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return v.repo.SaveWatchedFile(f)
+}
+
+func (v *ValidationWrapper) DeleteWatchedFiles(in *DeleteWatchedFilesInput) error {
+	if in.JobID == 0 {
+		return ErrFieldRequired{"JobID"}
+	}
+	return v.repo.DeleteWatchedFiles(in)
+}
+
+func (v *ValidationWrapper) CreateWebhookRun(in *CreateWebhookRunInput) (RunID, error) {
+	if err := in.Validate(); err != nil {
+		return 0, err
+	}
+	return v.repo.CreateWebhookRun(in)
+}
+
+// validateTriggers checks that the jobs triggering j exist and that the
+// triggers don't form a cycle through j, unless a job in the cycle has a
+// LoopLimit. j is a new job if its ID is 0, triggers that are nil are
+// unchanged.
+func (v *ValidationWrapper) validateTriggers(j *Job, in *TriggerEventsInput) error {
+	current, err := v.repo.GetJobs(&GetJobsInput{All: true})
+	if err != nil {
+		return err
+	}
+	if in != nil && in.JobSuccess != nil {
+		j.Triggers.JobSuccess = in.JobSuccess
+	}
+	if in != nil && in.JobFailure != nil {
+		j.Triggers.JobFailure = in.JobFailure
+	}
+	if in != nil && in.JobMapComplete != nil {
+		j.Triggers.JobMapComplete = in.JobMapComplete
+	}
+	jobs := []*Job{j}
+	exists := map[JobID]bool{}
+	for _, job := range current {
+		exists[job.ID] = true
+		if job.ID != j.ID {
+			jobs = append(jobs, job)
+		}
+	}
+
+	var errs []error
+	for _, t := range []struct {
+		field string
+		ids   JobIDs
+	}{
+		{"Triggers.JobSuccess", j.Triggers.JobSuccess},
+		{"Triggers.JobFailure", j.Triggers.JobFailure},
+		{"Triggers.JobMapComplete", j.Triggers.JobMapComplete},
+	} {
+		for _, id := range t.ids {
+			if !exists[id] {
+				errs = append(errs, ErrFieldInvalid{t.field, fmt.Sprintf("job %s doesn't exist", id)})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	if j.ID == 0 {
+		//nothing can trigger a job that doesn't exist yet
+		return nil
+	}
+	if path := FindTriggerCycle(jobs, j.ID); path != nil {
+		names := map[JobID]string{}
+		for _, job := range jobs {
+			names[job.ID] = job.Name
+		}
+		cycle := make([]string, len(path))
+		for i, id := range path {
+			cycle[i] = names[id]
+		}
+		return ValidationErrors{ErrTriggerCycle{Path: cycle}}
+	}
+	return nil
+}
+
 func (in *GetJobsInput) Validate() error {
-	if len(in.JobIDs) == 0 {
+	if len(in.JobIDs) == 0 && !in.All {
 		return ErrFieldRequired{"JobIDs"}
 	}
 	return nil
@@ -58,6 +297,25 @@ func (in *CreateJobInput) Validate() error {
 	if in.Name == "" {
 		errs = append(errs, ErrFieldRequired{"Name"})
 	}
+	if in.LoopLimit < 0 {
+		errs = append(errs, ErrFieldInvalid{"LoopLimit", "must not be negative"})
+	}
+	if err := ValidateTemplate(in.InputPayloadTemplate); err != nil {
+		errs = append(errs, ErrFieldInvalid{"InputPayloadTemplate", err.Error()})
+	}
+	if in.Triggers != nil && in.Triggers.JoinTimeout != nil && *in.Triggers.JoinTimeout < 0 {
+		errs = append(errs, ErrFieldInvalid{"Triggers.JoinTimeout", "must not be negative"})
+	}
+	if in.Triggers != nil {
+		errs = append(errs, in.Triggers.validateConditions()...)
+		errs = append(errs, in.Triggers.validateDelays()...)
+		errs = append(errs, in.Triggers.validateMap()...)
+	}
+	errs = append(errs, validateProcessor(in.Processor)...)
+	errs = append(errs, in.Sensor.validate()...)
+	if in.Sensor.Enabled() {
+		errs = append(errs, validateSensorProcessor(in.Processor)...)
+	}
 
 	if errs != nil {
 		return ValidationErrors(errs)
@@ -71,6 +329,31 @@ func (in *UpdateJobInput) Validate() error {
 	if in.Name != nil && *in.Name == "" {
 		errs = append(errs, ErrFieldRequired{"Name"})
 	}
+	if in.LoopLimit != nil && *in.LoopLimit < 0 {
+		errs = append(errs, ErrFieldInvalid{"LoopLimit", "must not be negative"})
+	}
+	if in.InputPayloadTemplate != nil {
+		if err := ValidateTemplate(in.InputPayloadTemplate); err != nil {
+			errs = append(errs, ErrFieldInvalid{"InputPayloadTemplate", err.Error()})
+		}
+	}
+	if in.Triggers != nil && in.Triggers.JoinTimeout != nil && *in.Triggers.JoinTimeout < 0 {
+		errs = append(errs, ErrFieldInvalid{"Triggers.JoinTimeout", "must not be negative"})
+	}
+	if in.Triggers != nil {
+		errs = append(errs, in.Triggers.validateConditions()...)
+		errs = append(errs, in.Triggers.validateDelays()...)
+		errs = append(errs, in.Triggers.validateMap()...)
+	}
+	if in.Processor != nil {
+		errs = append(errs, validateProcessor(*in.Processor)...)
+	}
+	if in.Sensor != nil {
+		errs = append(errs, in.Sensor.validate()...)
+		if in.Sensor.Enabled() && in.Processor != nil {
+			errs = append(errs, validateSensorProcessor(*in.Processor)...)
+		}
+	}
 
 	//TODO: check cron?
 
@@ -80,6 +363,111 @@ func (in *UpdateJobInput) Validate() error {
 	return nil
 }
 
+// validateProcessor checks the config of the processors the service runs
+// itself
+func validateProcessor(c ProcessorConfig) []error {
+	switch c.Type {
+	case ProcessorTypeApproval:
+		if _, err := approvalExpiry(c.Config); err != nil {
+			return []error{ErrFieldInvalid{"Processor.Config.Expiry", err.Error()}}
+		}
+	case ProcessorTypeWait:
+		if _, err := waitUntil(c.Config, time.Now()); err != nil {
+			return []error{ErrFieldInvalid{"Processor.Config", err.Error()}}
+		}
+	}
+	return nil
+}
+
+// validateSensorProcessor checks that the processor of a sensor job
+// processes something, approval and wait jobs can't poll
+func validateSensorProcessor(c ProcessorConfig) []error {
+	if c.Type == ProcessorTypeApproval || c.Type == ProcessorTypeWait {
+		return []error{ErrFieldInvalid{"Sensor", "a " + c.Type + " job can't be a sensor"}}
+	}
+	return nil
+}
+
+// validateConditions checks that conditions parse and belong to a trigger in
+// the list they are set with
+func (in *TriggerEventsInput) validateConditions() []error {
+	var errs []error
+	for _, t := range []struct {
+		field      string
+		ids        JobIDs
+		conditions map[JobID]Condition
+	}{
+		{"Triggers.JobSuccess", in.JobSuccess, in.JobSuccessConditions},
+		{"Triggers.JobFailure", in.JobFailure, in.JobFailureConditions},
+	} {
+		ids := map[JobID]bool{}
+		for _, id := range t.ids {
+			ids[id] = true
+		}
+		for _, id := range sortedConditionIDs(t.conditions) {
+			field := t.field + "Conditions"
+			if !ids[id] {
+				errs = append(errs, ErrFieldInvalid{field, fmt.Sprintf("job %s isn't in %s", id, t.field)})
+				continue
+			}
+			if err := t.conditions[id].Validate(); err != nil {
+				errs = append(errs, ErrFieldInvalid{field, err.Error()})
+			}
+		}
+	}
+	return errs
+}
+
+// validateDelays checks that delays aren't negative and belong to a trigger
+// in the list they are set with
+func (in *TriggerEventsInput) validateDelays() []error {
+	var errs []error
+	for _, t := range []struct {
+		field  string
+		ids    JobIDs
+		delays map[JobID]time.Duration
+	}{
+		{"Triggers.JobSuccess", in.JobSuccess, in.JobSuccessDelays},
+		{"Triggers.JobFailure", in.JobFailure, in.JobFailureDelays},
+	} {
+		ids := map[JobID]bool{}
+		for _, id := range t.ids {
+			ids[id] = true
+		}
+		for _, id := range sortedDelayIDs(t.delays) {
+			field := t.field + "Delays"
+			if !ids[id] {
+				errs = append(errs, ErrFieldInvalid{field, fmt.Sprintf("job %s isn't in %s", id, t.field)})
+				continue
+			}
+			if t.delays[id] < 0 {
+				errs = append(errs, ErrFieldInvalid{field, "must not be negative"})
+			}
+		}
+	}
+	return errs
+}
+
+// validateMap checks the fan-out and file watch settings
+func (in *TriggerEventsInput) validateMap() []error {
+	var errs []error
+	if in.MapPath != nil && *in.MapPath != "" {
+		if err := in.MapPath.Validate(); err != nil {
+			errs = append(errs, ErrFieldInvalid{"Triggers.MapPath", err.Error()})
+		}
+		if in.JoinSuccess != nil && *in.JoinSuccess {
+			errs = append(errs, ErrFieldInvalid{"Triggers.MapPath", "joins don't fan out"})
+		}
+	}
+	if in.MapMaxParallel != nil && *in.MapMaxParallel < 0 {
+		errs = append(errs, ErrFieldInvalid{"Triggers.MapMaxParallel", "must not be negative"})
+	}
+	if in.FileWatch != nil {
+		errs = append(errs, in.FileWatch.validate()...)
+	}
+	return errs
+}
+
 type ErrFieldRequired struct {
 	FieldName string
 }
@@ -88,6 +476,26 @@ func (e ErrFieldRequired) Error() string {
 	return "Field '" + e.FieldName + "' is required"
 }
 
+type ErrFieldInvalid struct {
+	FieldName string
+	Reason    string
+}
+
+func (e ErrFieldInvalid) Error() string {
+	return "Field '" + e.FieldName + "' is invalid: " + e.Reason
+}
+
+// ErrTriggerCycle is returned for triggers that would make a job trigger
+// itself, directly or through other jobs, without a loop limit. Path names
+// the jobs from the job back to itself.
+type ErrTriggerCycle struct {
+	Path []string
+}
+
+func (e ErrTriggerCycle) Error() string {
+	return "trigger cycle " + strings.Join(e.Path, " -> ") + ", set LoopLimit on a job in the cycle to allow it"
+}
+
 type ValidationErrors []error
 
 func (v ValidationErrors) Error() string {
@@ -98,7 +506,87 @@ func (v ValidationErrors) Error() string {
 	return strings.Join(errStrs, "\n")
 }
 
+func (in *DeleteJobInput) Validate() error {
+	if in.JobID == 0 {
+		return ValidationErrors{ErrFieldRequired{"JobID"}}
+	}
+	return nil
+}
+
+func (in *GetJobVersionsInput) Validate() error {
+	if in.JobID == 0 {
+		return ValidationErrors{ErrFieldRequired{"JobID"}}
+	}
+	return nil
+}
+
 func (in *GetRunsInput) Validate() error {
+	var errs []error
+
+	switch in.SortColumn() {
+	case RunsOrderByID, RunsOrderByScheduledStartTime, RunsOrderByNotBefore, RunsOrderByStartTime, RunsOrderByEndTime:
+	default:
+		errs = append(errs, ErrFieldInvalid{"OrderBy", "unknown column " + in.SortColumn()})
+	}
+	if in.Limit != nil && *in.Limit == 0 {
+		errs = append(errs, ErrFieldInvalid{"Limit", "must be greater than 0"})
+	}
+	if in.Cursor != nil {
+		c, err := in.Cursor.decode()
+		if err != nil {
+			errs = append(errs, ErrFieldInvalid{"Cursor", "malformed cursor"})
+		} else if c.OrderBy != in.SortColumn() {
+			errs = append(errs, ErrFieldInvalid{"Cursor", "cursor was created for a different OrderBy"})
+		}
+	}
+	ranges := []struct {
+		name string
+		r    *TimeRange
+	}{
+		{"ScheduledStartTime", in.ScheduledStartTime},
+		{"StartTime", in.StartTime},
+		{"EndTime", in.EndTime},
+	}
+	for _, tr := range ranges {
+		if tr.r != nil && tr.r.After != nil && tr.r.Before != nil && !tr.r.After.Before(*tr.r.Before) {
+			errs = append(errs, ErrFieldInvalid{tr.name, "After must be before Before"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *GetDueRunsInput) Validate() error {
+	var errs []error
+	if in.Now.IsZero() {
+		errs = append(errs, ErrFieldRequired{"Now"})
+	}
+	if in.Limit == 0 {
+		errs = append(errs, ErrFieldRequired{"Limit"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *CreateTriggerDecisionInput) Validate() error {
+	var errs []error
+	if in.RunID == 0 {
+		errs = append(errs, ErrFieldRequired{"RunID"})
+	}
+	if in.JobID == 0 {
+		errs = append(errs, ErrFieldRequired{"JobID"})
+	}
+	if in.CreatedAt.IsZero() {
+		errs = append(errs, ErrFieldRequired{"CreatedAt"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
 	return nil
 }
 
@@ -109,3 +597,149 @@ func (in *CreateRunInput) Validate() error {
 func (in *UpdateRunInput) Validate() error {
 	return nil
 }
+
+func (in *PruneRunsInput) Validate() error {
+	var errs []error
+	if in.KeepLast < 0 {
+		errs = append(errs, ErrFieldInvalid{"KeepLast", "must not be negative"})
+	}
+	if in.Limit == 0 {
+		errs = append(errs, ErrFieldRequired{"Limit"})
+	}
+	//without KeepLast a missing cutoff would delete every run with that outcome
+	if in.KeepLast == 0 && ((!in.KeepSuccesses && in.SuccessEndedBefore == nil) || (!in.KeepFailures && in.FailureEndedBefore == nil)) {
+		errs = append(errs, ErrFieldInvalid{"KeepLast", "required unless the runs deleted have a cutoff time"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *GetRunRollupsInput) Validate() error {
+	return nil
+}
+
+func (in *CreateBackfillInput) Validate() error {
+	var errs []error
+	if in.JobID == 0 {
+		errs = append(errs, ErrFieldRequired{"JobID"})
+	}
+	if len(in.Runs) == 0 {
+		errs = append(errs, ErrFieldRequired{"Runs"})
+	}
+	if in.MaxParallel < 0 {
+		errs = append(errs, ErrFieldInvalid{"MaxParallel", "must not be negative"})
+	}
+	for _, r := range in.Runs {
+		if r.JobID != in.JobID {
+			errs = append(errs, ErrFieldInvalid{"Runs", "runs must be of the backfilled job"})
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *UpdateBackfillInput) Validate() error {
+	var errs []error
+	if in.BackfillID == 0 {
+		errs = append(errs, ErrFieldRequired{"BackfillID"})
+	}
+	if in.Status != nil {
+		switch *in.Status {
+		case BackfillStatusRunning, BackfillStatusComplete, BackfillStatusCancelled:
+		default:
+			errs = append(errs, ErrFieldInvalid{"Status", "unknown status " + string(*in.Status)})
+		}
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *CreateApprovalInput) Validate() error {
+	var errs []error
+	if in.RunID == 0 {
+		errs = append(errs, ErrFieldRequired{"RunID"})
+	}
+	if in.JobID == 0 {
+		errs = append(errs, ErrFieldRequired{"JobID"})
+	}
+	if !in.ExpiresAt.After(in.RequestedAt) {
+		errs = append(errs, ErrFieldInvalid{"ExpiresAt", "must be after RequestedAt"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *DecideApprovalInput) Validate() error {
+	var errs []error
+	if in.RunID == 0 {
+		errs = append(errs, ErrFieldRequired{"RunID"})
+	}
+	switch in.Status {
+	case ApprovalStatusApproved, ApprovalStatusRejected:
+		if in.DecidedBy == "" {
+			errs = append(errs, ErrFieldRequired{"DecidedBy"})
+		}
+	case ApprovalStatusExpired:
+	default:
+		errs = append(errs, ErrFieldInvalid{"Status", "must be approved, rejected or expired"})
+	}
+	if in.DecidedAt.IsZero() {
+		errs = append(errs, ErrFieldRequired{"DecidedAt"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *CreateWebhookInput) Validate() error {
+	var errs []error
+	if in.JobID == 0 {
+		errs = append(errs, ErrFieldRequired{"JobID"})
+	}
+	if in.Key == "" {
+		errs = append(errs, ErrFieldRequired{"Key"})
+	}
+	if in.Secret == "" {
+		errs = append(errs, ErrFieldRequired{"Secret"})
+	}
+	if in.Auth != WebhookAuthHMAC && in.Auth != WebhookAuthToken {
+		errs = append(errs, ErrFieldInvalid{"Auth", "must be hmac or token"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *CreateWebhookRunInput) Validate() error {
+	var errs []error
+	if in.JobID == 0 {
+		errs = append(errs, ErrFieldRequired{"JobID"})
+	}
+	if in.Run == nil {
+		errs = append(errs, ErrFieldRequired{"Run"})
+	} else if in.Run.JobID != in.JobID {
+		errs = append(errs, ErrFieldInvalid{"Run", "must be a run of the webhook's job"})
+	}
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+	return nil
+}
+
+func (in *GetExecutionsInput) Validate() error {
+	if in.Limit != nil && *in.Limit == 0 {
+		return ErrFieldInvalid{"Limit", "must be greater than 0"}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidationWrapperTriggers(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	v := NewValidationWrapper(r)
+
+	create := func(name string, triggers *TriggerEventsInput) JobID {
+		id, err := v.CreateJob(&CreateJobInput{Name: name, Triggers: triggers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	a := create("a", nil)
+	b := create("b", &TriggerEventsInput{JobSuccess: JobIDs{a}})
+	c := create("c", &TriggerEventsInput{JobFailure: JobIDs{b}})
+
+	_, err := v.CreateJob(&CreateJobInput{Name: "d", Triggers: &TriggerEventsInput{JobSuccess: JobIDs{a, 9}}})
+	expected := ValidationErrors{ErrFieldInvalid{"Triggers.JobSuccess", "job 9 doesn't exist"}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("unknown trigger: expected %v, got %v", expected, err)
+	}
+
+	_, err = v.CreateJob(&CreateJobInput{Name: "d", Triggers: &TriggerEventsInput{
+		JobSuccess:           JobIDs{a},
+		JobSuccessConditions: map[JobID]Condition{a: "$.ok ==", b: "$.ok"},
+	}})
+	expected = ValidationErrors{
+		ErrFieldInvalid{"Triggers.JobSuccessConditions", `condition "$.ok ==": position 8: unexpected end`},
+		ErrFieldInvalid{"Triggers.JobSuccessConditions", "job 2 isn't in Triggers.JobSuccess"},
+	}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("invalid conditions: expected %v, got %v", expected, err)
+	}
+
+	tests := []struct {
+		name  string
+		input *UpdateJobInput
+		cycle []string
+	}{
+		{
+			name:  "self trigger",
+			input: &UpdateJobInput{JobID: a, Triggers: &TriggerEventsInput{JobSuccess: JobIDs{a}}},
+			cycle: []string{"a", "a"},
+		},
+		{
+			name:  "loop through other jobs",
+			input: &UpdateJobInput{JobID: a, Triggers: &TriggerEventsInput{JobSuccess: JobIDs{c}}},
+			cycle: []string{"a", "b", "c", "a"},
+		},
+		{
+			name:  "loop limit removed",
+			input: &UpdateJobInput{JobID: b, LoopLimit: IntPtr(0)},
+		},
+		{
+			name:  "bounded loop",
+			input: &UpdateJobInput{JobID: a, Triggers: &TriggerEventsInput{JobSuccess: JobIDs{c}}, LoopLimit: IntPtr(2)},
+		},
+		{
+			name:  "loop limit removed from a job in the loop",
+			input: &UpdateJobInput{JobID: a, LoopLimit: IntPtr(0)},
+			cycle: []string{"a", "b", "c", "a"},
+		},
+		{
+			name:  "loop bounded by another job",
+			input: &UpdateJobInput{JobID: b, LoopLimit: IntPtr(1)},
+		},
+		{
+			name:  "loop limit removed when the loop is bounded elsewhere",
+			input: &UpdateJobInput{JobID: a, LoopLimit: IntPtr(0)},
+		},
+	}
+	for _, test := range tests {
+		err := v.UpdateJob(test.input)
+		if test.cycle == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected err: %s", test.name, err)
+			}
+			continue
+		}
+		expected := ValidationErrors{ErrTriggerCycle{Path: test.cycle}}
+		if !reflect.DeepEqual(err, expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, expected, err)
+		}
+	}
+}
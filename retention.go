@@ -0,0 +1,92 @@
+package pipeline
+
+import "time"
+
+const (
+	DefaultPruneInterval  = time.Hour
+	DefaultPruneBatchSize = 500
+	//pause between batches so the worker gets a turn at the database
+	pruneBatchPause = 100 * time.Millisecond
+)
+
+// RetentionPolicy decides which completed runs are deleted. A run is kept if
+// any of the configured rules would keep it, and runs of an outcome no rule
+// applies to are all kept: with only FailureMaxAge set, every successful run
+// is kept. A zero field is a rule that isn't configured. Runs of executions
+// that haven't finished and runs of webhook deliveries received within
+// WebhookIdempotencyWindow are always kept.
+type RetentionPolicy struct {
+	KeepLast      int           //keep this many of each job's most recent runs
+	MaxAge        time.Duration //keep successful runs that ended more recently than this
+	FailureMaxAge time.Duration //keep failed runs that ended more recently than this, defaults to MaxAge
+	Rollup        bool          //keep daily per job counts and durations of deleted runs
+
+	Interval  time.Duration //time between prunes, defaults to DefaultPruneInterval
+	BatchSize uint64        //runs deleted per transaction, defaults to DefaultPruneBatchSize
+}
+
+// pruneInput builds the PruneRunsInput for the policy at time now
+func (p *RetentionPolicy) pruneInput(now time.Time) *PruneRunsInput {
+	in := &PruneRunsInput{
+		KeepLast:       p.KeepLast,
+		Limit:          p.BatchSize,
+		Rollup:         p.Rollup,
+		DeliveredAfter: TimePtr(now.Add(-WebhookIdempotencyWindow)),
+	}
+	if in.Limit == 0 {
+		in.Limit = DefaultPruneBatchSize
+	}
+	if p.MaxAge > 0 {
+		in.SuccessEndedBefore = TimePtr(now.Add(-p.MaxAge))
+	}
+	failureMaxAge := p.FailureMaxAge
+	if failureMaxAge == 0 {
+		failureMaxAge = p.MaxAge
+	}
+	if failureMaxAge > 0 {
+		in.FailureEndedBefore = TimePtr(now.Add(-failureMaxAge))
+	}
+	in.KeepSuccesses = p.KeepLast == 0 && p.MaxAge == 0
+	in.KeepFailures = p.KeepLast == 0 && failureMaxAge == 0
+	return in
+}
+
+// Prune deletes the runs that are outside of the policy in batches, returning
+// the number of runs deleted
+func (p *RetentionPolicy) Prune(r Repository, now time.Time) (int, error) {
+	in := p.pruneInput(now)
+	if err := in.Validate(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for {
+		out, err := r.PruneRuns(in)
+		if err != nil {
+			return total, err
+		}
+		total += out.Deleted
+		if uint64(out.Deleted) < in.Limit {
+			return total, nil
+		}
+		time.Sleep(pruneBatchPause)
+	}
+}
+
+func (s *Service) startPruner() {
+	interval := s.Retention.Interval
+	if interval == 0 {
+		interval = DefaultPruneInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := s.Retention.Prune(s.repo, time.Now())
+		if err != nil {
+			s.log.Printf("err pruning runs: %s", err)
+			continue
+		}
+		if deleted > 0 {
+			s.log.Printf("pruned %d runs", deleted)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyPrune(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	day := func(d, h, m int) time.Time {
+		return time.Date(2017, 3, d, h, m, 0, 0, time.UTC)
+	}
+	now := day(10, 0, 0)
+	complete := RunStatusPtr(RunStatusComplete)
+	creates := []*CreateRunInput{
+		//old success
+		{JobID: 1, Input: []byte("r1"), Status: complete, Success: BoolPtr(true), ScheduledStartTime: day(1, 9, 0), StartTime: TimePtr(day(1, 9, 0)), EndTime: TimePtr(day(1, 9, 1))},
+		//failure within FailureMaxAge
+		{JobID: 1, Input: []byte("r2"), Status: complete, Success: BoolPtr(false), ScheduledStartTime: day(8, 9, 0), StartTime: TimePtr(day(8, 9, 0)), EndTime: TimePtr(day(8, 9, 1))},
+		//old failure
+		{JobID: 1, Input: []byte("r3"), Status: complete, Success: BoolPtr(false), ScheduledStartTime: day(1, 10, 0), StartTime: TimePtr(day(1, 10, 0)), EndTime: TimePtr(day(1, 10, 2))},
+		//success within MaxAge
+		{JobID: 1, Input: []byte("r4"), Status: complete, Success: BoolPtr(true), ScheduledStartTime: day(9, 12, 0), StartTime: TimePtr(day(9, 12, 0)), EndTime: TimePtr(day(9, 12, 1))},
+		//pending runs are never pruned
+		{JobID: 1, Input: []byte("r5"), ScheduledStartTime: day(1, 0, 0)},
+		//old, but the most recent run of job 2
+		{JobID: 2, Input: []byte("r6"), Status: complete, Success: BoolPtr(true), ScheduledStartTime: day(1, 9, 0), StartTime: TimePtr(day(1, 9, 0)), EndTime: TimePtr(day(1, 9, 1))},
+	}
+	for _, c := range creates {
+		if _, err := r.CreateRun(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &RetentionPolicy{
+		KeepLast:      1,
+		MaxAge:        24 * time.Hour,
+		FailureMaxAge: 72 * time.Hour,
+		Rollup:        true,
+		BatchSize:     1,
+	}
+	deleted, err := p.Prune(r, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 runs deleted, got %d", deleted)
+	}
+
+	runs, err := r.GetRuns(&GetRunsInput{OrderBy: StringPtr("id"), Summary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := RunIDs{}
+	for _, run := range runs {
+		got = append(got, run.RunID)
+	}
+	if expected := (RunIDs{2, 4, 5, 6}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected runs %v to remain, got %v", expected, got)
+	}
+
+	rollups, err := r.GetRunRollups(&GetRunRollupsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*RunRollup{
+		{JobID: 1, Day: day(1, 0, 0), Successes: 1, Failures: 1, TotalDuration: 3 * time.Minute},
+	}
+	if !reflect.DeepEqual(expected, rollups) {
+		t.Errorf("expected rollups %+v, got %+v", expected[0], rollups)
+	}
+
+	//a failure age alone keeps every success
+	p = &RetentionPolicy{FailureMaxAge: time.Hour}
+	if deleted, err = p.Prune(r, now.AddDate(0, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected only the failure deleted, got %d runs", deleted)
+	}
+	runs, err = r.GetRuns(&GetRunsInput{OrderBy: StringPtr("id"), Summary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = RunIDs{}
+	for _, run := range runs {
+		got = append(got, run.RunID)
+	}
+	if expected := (RunIDs{4, 5, 6}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected runs %v to remain, got %v", expected, got)
+	}
+}
+
+func TestPruneRunsInputValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *PruneRunsInput
+		wantErr bool
+	}{
+		{
+			name: "keep last only",
+			in:   &PruneRunsInput{KeepLast: 10, Limit: 1},
+		},
+		{
+			name: "both cutoffs",
+			in:   &PruneRunsInput{SuccessEndedBefore: TimePtr(time.Now()), FailureEndedBefore: TimePtr(time.Now()), Limit: 1},
+		},
+		{
+			name:    "one cutoff would delete every failure",
+			in:      &PruneRunsInput{SuccessEndedBefore: TimePtr(time.Now()), Limit: 1},
+			wantErr: true,
+		},
+		{
+			name: "one cutoff, the other outcome kept",
+			in:   &PruneRunsInput{FailureEndedBefore: TimePtr(time.Now()), KeepSuccesses: true, Limit: 1},
+		},
+		{
+			name:    "missing limit",
+			in:      &PruneRunsInput{KeepLast: 10},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.in.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRetentionPolicyPruneKeeps(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+
+	now := time.Date(2017, 3, 10, 0, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -5)
+	done := func(in *CreateRunInput) *CreateRunInput {
+		in.Input = []byte("{}")
+		in.Status = RunStatusPtr(RunStatusComplete)
+		in.Success = BoolPtr(true)
+		in.ScheduledStartTime = old
+		in.StartTime = TimePtr(old)
+		in.EndTime = TimePtr(old)
+		return in
+	}
+	//an execution with a run still pending, whose join may read the others
+	root, err := r.CreateRun(done(&CreateRunInput{JobID: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CreateRun(done(&CreateRunInput{JobID: 2, RootRunID: root, ParentRunID: root})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CreateRun(&CreateRunInput{JobID: 3, Input: []byte("{}"), RootRunID: root, ParentRunID: root, ScheduledStartTime: old}); err != nil {
+		t.Fatal(err)
+	}
+	//runs of webhook deliveries, one within the idempotency window
+	for key, received := range map[string]time.Time{
+		"recent": now.Add(-time.Hour),
+		"old":    now.Add(-2 * WebhookIdempotencyWindow),
+	} {
+		_, err := r.CreateWebhookRun(&CreateWebhookRunInput{
+			JobID:          4,
+			IdempotencyKey: key,
+			Run:            done(&CreateRunInput{JobID: 4}),
+			ReceivedAt:     received,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &RetentionPolicy{MaxAge: 24 * time.Hour}
+	deleted, err := p.Prune(r, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected only the run of the old delivery deleted, got %d", deleted)
+	}
+	runs, err := r.GetRuns(&GetRunsInput{OrderBy: StringPtr("id"), Summary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := RunIDs{}
+	for _, run := range runs {
+		got = append(got, run.RunID)
+	}
+	if expected := (RunIDs{1, 2, 3, 4}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected runs %v to remain, got %v", expected, got)
+	}
+	id, err := r.CreateWebhookRun(&CreateWebhookRunInput{
+		JobID:          4,
+		IdempotencyKey: "recent",
+		Run:            done(&CreateRunInput{JobID: 4}),
+		ReceivedAt:     now,
+	})
+	if err != ErrDuplicateDelivery || id != 4 {
+		t.Errorf("expected the recent delivery to return run 4, got %s, %v", id, err)
+	}
+
+	//once the execution finished its runs are pruned too
+	if err := r.UpdateRun(&UpdateRunInput{RunID: 3, Status: RunStatusPtr(RunStatusComplete), Success: BoolPtr(true), EndTime: TimePtr(old)}); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err = p.Prune(r, now); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 3 {
+		t.Errorf("expected the finished execution deleted, got %d runs", deleted)
+	}
+}
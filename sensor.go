@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultSensorInterval is the time between pokes of a sensor that doesn't
+// set Interval
+const DefaultSensorInterval = time.Minute
+
+// Sensor makes a job poll until something is ready, such as an upstream file
+// or table. A run of a sensor job invokes the processor every Interval until
+// its output meets ReadyIf, and only then succeeds and fires its success
+// triggers. Pokes that aren't ready don't use up retry attempts, the run
+// counts them in Pokes. A failing poke fails the run as usual, a run that
+// times out fails without a retry.
+type Sensor struct {
+	ReadyIf  Condition     //the job isn't a sensor if empty
+	Interval time.Duration //DefaultSensorInterval if 0
+	Timeout  time.Duration //fails a run still not ready this long after its first poke, 0 pokes forever
+}
+
+// Enabled reports if the job is a sensor
+func (s Sensor) Enabled() bool {
+	return s.ReadyIf != ""
+}
+
+func (s Sensor) interval() time.Duration {
+	if s.Interval == 0 {
+		return DefaultSensorInterval
+	}
+	return s.Interval
+}
+
+func (s Sensor) validate() []error {
+	var errs []error
+	if !s.Enabled() {
+		if s.Interval != 0 || s.Timeout != 0 {
+			errs = append(errs, ErrFieldRequired{"Sensor.ReadyIf"})
+		}
+		return errs
+	}
+	if err := s.ReadyIf.Validate(); err != nil {
+		errs = append(errs, ErrFieldInvalid{"Sensor.ReadyIf", err.Error()})
+	}
+	if s.Interval < 0 {
+		errs = append(errs, ErrFieldInvalid{"Sensor.Interval", "must not be negative"})
+	}
+	if s.Timeout < 0 {
+		errs = append(errs, ErrFieldInvalid{"Sensor.Timeout", "must not be negative"})
+	}
+	return errs
+}
+
+// sensorResult checks res, the successful result of r, against the sensor
+// of r's job. It returns res if the job isn't a sensor or the output is
+// ready, and a failed result once the sensor timed out. Otherwise r is made
+// pending again, due in an Interval, and the result is nil.
+func (s *Service) sensorResult(r *Run, res *RunResult) (*RunResult, error) {
+	j, err := s.getJob(r.JobID)
+	if err == ErrJobNotFound {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sensor := j.Sensor
+	if !sensor.Enabled() {
+		return res, nil
+	}
+	ready, err := sensor.ReadyIf.Eval(res.Output)
+	if err != nil {
+		//output that doesn't have the value yet isn't ready
+		s.log.Printf("err evaluating the sensor condition of job %s on run %s: %s", r.JobID, r.RunID, err)
+	}
+	pokes := r.Pokes + 1
+	if ready {
+		if res.Detail == "" {
+			res.Detail = fmt.Sprintf("ready after %d pokes", pokes)
+		}
+		return res, s.repo.UpdateRun(&UpdateRunInput{RunID: r.RunID, Pokes: &pokes})
+	}
+	now := time.Now()
+	if sensor.Timeout > 0 && r.StartTime != nil && now.Sub(*r.StartTime) >= sensor.Timeout {
+		detail := fmt.Sprintf("sensor timed out after %d pokes", pokes)
+		return &RunResult{Output: res.Output, Log: res.Log, Detail: detail, final: true}, s.repo.UpdateRun(&UpdateRunInput{RunID: r.RunID, Pokes: &pokes})
+	}
+	next := now.Add(sensor.interval())
+	detail := fmt.Sprintf("poke %d not ready, next at %s", pokes, next.Format(time.RFC3339))
+	err = s.repo.UpdateRun(&UpdateRunInput{
+		RunID:        r.RunID,
+		Status:       RunStatusPtr(RunStatusPending),
+		StatusDetail: &detail,
+		NotBefore:    &next,
+		Pokes:        &pokes,
+		Output:       res.Output,
+		Log:          res.Log,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Status, r.StatusDetail, r.NotBefore, r.Pokes = RunStatusPending, detail, next, pokes
+	r.Output = res.Output
+	s.publish(EventRunPoked, r)
+	return nil, nil
+}
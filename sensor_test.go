@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestServiceSensor(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	files := 0
+	s.AddProcessor("test", func(config map[string]string) (RunProcessor, error) {
+		return processorFunc(func(in []byte) (*RunResult, error) {
+			if config["Check"] == "" {
+				return &RunResult{Success: true, Output: json.RawMessage(`{}`)}, nil
+			}
+			//the file shows up on the third poke
+			files++
+			return &RunResult{Success: true, Output: json.RawMessage(`{"exists":` + jsonBool(files >= 3) + `}`)}, nil
+		}), nil
+	})
+	create := func(in *CreateJobInput) JobID {
+		if in.Processor.Type == "" {
+			in.Processor = ProcessorConfig{Type: "test"}
+		}
+		in.InputPayloadTemplate = []byte("{}")
+		id, err := r.CreateJob(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	sensor := Sensor{ReadyIf: "$.exists", Interval: time.Minute, Timeout: 10 * time.Minute}
+	wait := create(&CreateJobInput{
+		Name:      "wait-for-file",
+		Processor: ProcessorConfig{Type: "test", Config: map[string]string{"Check": "file"}},
+		Retryer:   RetryerConfig{Type: RetryerTypeDefault, Config: map[string]string{"NumRetries": "1"}},
+		Sensor:    sensor,
+	})
+	load := create(&CreateJobInput{Name: "load", Triggers: &TriggerEventsInput{JobSuccess: JobIDs{wait}}})
+	alert := create(&CreateJobInput{Name: "alert", Triggers: &TriggerEventsInput{JobFailure: JobIDs{wait}}})
+
+	get := func(job JobID) []*Run {
+		runs, err := r.GetRuns(&GetRunsInput{JobID: &job})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runs
+	}
+	start := func() time.Time {
+		now := time.Now()
+		_, err := r.CreateRun(&CreateRunInput{JobID: wait, ProcessorConfig: ProcessorConfig{Type: "test", Config: map[string]string{"Check": "file"}}, Attempt: IntPtr(1), Input: []byte("{}"), ScheduledStartTime: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return now
+	}
+	poke := func(at time.Time) {
+		s.startDueRuns(at)
+		s.running.Wait()
+	}
+
+	//ready on the third poke
+	now := start()
+	poke(now.Add(time.Second))
+	runs := get(wait)
+	if len(runs) != 1 || runs[0].Status != RunStatusPending || runs[0].Pokes != 1 || runs[0].Attempt != 1 {
+		t.Fatalf("expected a pending run after a poke, got %+v", runs)
+	}
+	if d := runs[0].NotBefore.Sub(now); d < time.Minute || d > time.Minute+5*time.Second {
+		t.Errorf("expected the next poke in a minute, got %s", d)
+	}
+	if !runs[0].ScheduledStartTime.Equal(now) {
+		t.Errorf("expected the poke to keep the scheduled start time, got %s", runs[0].ScheduledStartTime)
+	}
+	firstStart := *runs[0].StartTime
+	poke(now.Add(2 * time.Minute))
+	poke(now.Add(4 * time.Minute))
+	runs = get(wait)
+	if len(runs) != 1 || runs[0].Status != RunStatusComplete || !runs[0].Success || runs[0].Pokes != 3 {
+		t.Fatalf("expected the run to succeed on its third poke, got %+v", runs)
+	}
+	if !runs[0].StartTime.Equal(firstStart) || runs[0].StatusDetail != "ready after 3 pokes" {
+		t.Errorf("expected the run to keep its first start time, got %+v", runs[0])
+	}
+	if len(get(load)) != 1 || len(get(alert)) != 0 {
+		t.Errorf("expected the ready sensor to trigger load only")
+	}
+
+	//times out
+	if err := r.UpdateJob(&UpdateJobInput{JobID: wait, Sensor: &Sensor{ReadyIf: "$.missing", Timeout: time.Nanosecond}}); err != nil {
+		t.Fatal(err)
+	}
+	start()
+	poke(time.Now().Add(time.Second))
+	runs = get(wait)
+	if len(runs) != 2 {
+		t.Fatalf("expected the timed out run not to be retried, got %d runs", len(runs))
+	}
+	if runs[1].Status != RunStatusComplete || runs[1].Success || runs[1].Pokes != 1 || runs[1].StatusDetail != "sensor timed out after 1 pokes" {
+		t.Fatalf("expected the sensor to time out, got %+v", runs[1])
+	}
+	if len(get(load)) != 1 || len(get(alert)) != 1 {
+		t.Errorf("expected the timed out sensor to trigger alert only")
+	}
+
+	//plain job again
+	if err := r.UpdateJob(&UpdateJobInput{JobID: wait, Sensor: &Sensor{}}); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := r.GetJobs(&GetJobsInput{JobIDs: JobIDs{wait}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Sensor.Enabled() {
+		t.Errorf("expected the sensor to be removed, got %+v", jobs[0].Sensor)
+	}
+
+	_, err = NewValidationWrapper(r).CreateJob(&CreateJobInput{Name: "bad", Sensor: Sensor{Interval: time.Minute}})
+	if err == nil {
+		t.Error("expected a sensor without ReadyIf to be invalid")
+	}
+}
+
+func jsonBool(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -1,13 +1,28 @@
 package pipeline
 
 import (
+	"encoding/json"
 	"log"
+	"os"
+	"sync"
 	"time"
 )
 
+// DefaultDueRunsBatchSize is the max number of pending runs fetched per poll
+const DefaultDueRunsBatchSize = 100
+
 type Service struct {
-	incomingRuns     chan *Run
-	finishedRuns     chan *RunResult
+	DueRunsBatchSize uint64
+	WatchInterval    time.Duration    //time between scans of watched directories, defaults to DefaultWatchInterval
+	BlobStore        BlobStore        //required if the repository stores payloads out of line
+	Retention        *RetentionPolicy //if nil, runs are never deleted
+
+	events           *EventBus
+	running          sync.WaitGroup
+	joinMu           sync.Mutex //serializes the decisions of joins, see join
+	mapMu            sync.Mutex //serializes the scheduling of fan-outs, see fanOut
+	backfillMu       sync.Mutex //serializes the scheduling of backfills, see backfillRunFinished
+	backfillSlots    map[RunID]BackfillID
 	repo             Repository
 	log              *log.Logger
 	cron             *CronScheduler
@@ -15,69 +30,466 @@ type Service struct {
 }
 
 func NewService(r Repository) *Service {
+	events := NewEventBus(DefaultEventHistory)
 	return &Service{
-		repo: r,
-		cron: NewCronScheduler(time.Now(), time.Hour),
+		DueRunsBatchSize: DefaultDueRunsBatchSize,
+		events:           events,
+		repo:             NewEventWrapper(r, events),
+		log:              log.New(os.Stderr, "pipeline: ", log.LstdFlags),
+		cron:             NewCronScheduler(time.Now(), time.Hour),
+		processorFactory: ProcessorFactory{},
+		backfillSlots:    map[RunID]BackfillID{},
 	}
 }
 
+// Events returns the bus the lifecycle events of runs are published on
+func (s *Service) Events() *EventBus {
+	return s.events
+}
+
+// Repository returns the service's repository. Runs created through it
+// publish created events.
+func (s *Service) Repository() Repository {
+	return s.repo
+}
+
+func (s *Service) AddProcessor(processorType string, m ProcessorMaker) {
+	s.processorFactory.Add(processorType, m)
+}
+
 // blocking
 func (s *Service) ListenAndServe() error {
 	go s.startBackgroundWorker()
+	go s.startWatcher()
+	if s.Retention != nil {
+		go s.startPruner()
+	}
 	return nil
 }
 
 func (s *Service) startBackgroundWorker() {
 	ticker := time.NewTicker(time.Second) // can probably change this to run on the min?
 	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			//check for new runs to start
-			rs, err := s.repo.GetRuns(&GetRunsInput{
-				Status:          RunStatusPtr(RunStatusPending),
-				StartTimeBefore: TimePtr(time.Now()),
-			})
-			if err != nil {
-				s.log.Printf("err getting pending runs: %s", err)
-				continue
+	for range ticker.C {
+		now := time.Now()
+		s.expireJoins(now)
+		s.expireApprovals(now)
+		s.wakeRuns(now)
+		s.startDueRuns(now)
+	}
+}
+
+// startDueRuns claims the runs due at now and processes each in its own
+// goroutine
+func (s *Service) startDueRuns(now time.Time) {
+	rs, err := s.repo.GetDueRuns(&GetDueRunsInput{
+		Now:   now,
+		Limit: s.DueRunsBatchSize,
+	})
+	if err != nil {
+		s.log.Printf("err getting pending runs: %s", err)
+		return
+	}
+	for _, r := range rs {
+		input, renderErr := s.attemptInput(r)
+		err := s.claimRun(r, input)
+		if err == ErrRunStatusChanged {
+			//cancelled since it was read
+			continue
+		}
+		if err != nil {
+			s.log.Printf("err claiming run %s: %s", r.RunID, err)
+			continue
+		}
+		s.running.Add(1)
+		go func(r *Run) {
+			defer s.running.Done()
+			if renderErr != nil {
+				s.finishRun(r, &RunResult{Detail: "err rendering input: " + renderErr.Error()})
+				return
 			}
-			for _, r := range rs {
-				s.incomingRuns <- r
+			s.processRun(r)
+		}(r)
+	}
+}
+
+// attemptInput renders the input of r again with its ID and attempt if its
+// template reads them, see templateContext. It returns nil if the input of r
+// stays as it is.
+func (s *Service) attemptInput(r *Run) ([]byte, error) {
+	if len(r.TemplateContext) == 0 || r.StartTime != nil {
+		//a run processed again, such as a sensor poking, keeps its input
+		return nil, nil
+	}
+	j, err := s.getJobVersion(r.JobID, r.JobVersionID)
+	if err != nil {
+		return nil, err
+	}
+	return renderAttempt(j, r)
+}
+
+// claimRun makes r running, input replaces the input of r unless it is nil
+func (s *Service) claimRun(r *Run, input []byte) error {
+	in := &UpdateRunInput{
+		RunID:    r.RunID,
+		Status:   RunStatusPtr(RunStatusRunning),
+		IfStatus: []RunStatus{RunStatusPending},
+		Input:    input,
+	}
+	if r.StartTime == nil {
+		//a run processed again, such as a sensor poking, keeps its first
+		//start time
+		now := time.Now()
+		in.StartTime = &now
+	}
+	if err := s.repo.UpdateRun(in); err != nil {
+		return err
+	}
+	r.Status = RunStatusRunning
+	if in.StartTime != nil {
+		r.StartTime = in.StartTime
+	}
+	if input != nil {
+		r.Input, r.InputRef = input, ""
+	}
+	s.publish(EventRunClaimed, r)
+	return nil
+}
+
+func (s *Service) processRun(r *Run) {
+	if r.BackfillID != 0 {
+		s.holdBackfillSlot(r)
+		defer s.releaseBackfillSlot(r)
+	}
+	s.publish(EventRunStarted, r)
+	var res *RunResult
+	var err error
+	switch r.ProcessorConfig.Type {
+	case ProcessorTypeApproval:
+		res, err = s.approvalResult(r)
+	case ProcessorTypeWait:
+		res, err = s.waitResult(r)
+	default:
+		res, err = s.process(r)
+		if err == nil && res.Success {
+			res, err = s.sensorResult(r, res)
+		}
+	}
+	if err == nil && res == nil && r.Status == RunStatusPending {
+		//a sensor that isn't ready, it pokes again once due
+		return
+	}
+	if err == nil && res == nil {
+		//waiting for a decision or the end of a wait, see ResolveApproval
+		//and wakeRuns. The run doesn't take a slot of its backfill while it
+		//waits.
+		if r.BackfillID != 0 {
+			if err := s.backfillRunFinished(r); err != nil {
+				s.log.Printf("err updating backfill %s of run %s: %s", r.BackfillID, r.RunID, err)
 			}
+		}
+		return
+	}
+	if err != nil {
+		res = &RunResult{Detail: err.Error()}
+	}
+	s.finishRun(r, res)
+}
+
+// finishRun saves the result of r, then retries r if it failed or triggers
+// the jobs downstream of it
+func (s *Service) finishRun(r *Run, res *RunResult) {
+	res.RunID = r.RunID
+
+	//save the result of the run
+	now := time.Now()
+	err := s.repo.UpdateRun(&UpdateRunInput{
+		RunID:        r.RunID,
+		Status:       RunStatusPtr(RunStatusComplete),
+		EndTime:      &now,
+		Output:       res.Output,
+		StatusDetail: &res.Detail,
+		Log:          res.Log,
+		Success:      &res.Success,
+		IfStatus:     []RunStatus{RunStatusRunning},
+	})
+	if err == ErrRunStatusChanged {
+		//cancelled while it ran, its result is dropped and nothing is
+		//triggered
+		return
+	}
+	if err != nil {
+		s.log.Printf("err saving run result: %s", err)
+		return
+	}
+	r.Status, r.EndTime, r.Success, r.StatusDetail = RunStatusComplete, &now, res.Success, res.Detail
+	r.Output = res.Output
 
-		case r := <-s.incomingRuns:
-			//process runs that should be run
-			proc, err := s.processorFactory.Make(r.ProcessorConfig)
+	if res.Success {
+		s.publish(EventRunSucceeded, r)
+	} else {
+		s.publish(EventRunFailed, r)
+		if !res.final {
+			retried, err := s.retry(r)
 			if err != nil {
-				s.log.Printf("err processing run: %s", err)
-				continue
+				s.log.Printf("err retrying run %s: %s", r.RunID, err)
 			}
-			res, err := proc.Process(r.Input)
-			if err != nil {
-				s.log.Printf("err processing run: %s", err)
-				continue
+			if retried {
+				return
 			}
-			s.finishedRuns <- res
-
-		case res := <-s.finishedRuns:
-			//save the result of runs
-			err := s.repo.UpdateRun(&UpdateRunInput{
-				RunID:        RunID(res.RunID),
-				Output:       res.Output,
-				StatusDetail: &res.Detail,
-				Log:          res.Log,
-				Success:      &res.Success,
-			})
+		}
+	}
+	if r.MapCount > 0 {
+		if err := s.mapRunFinished(r); err != nil {
+			s.log.Printf("err updating fan-out of run %s: %s", r.RunID, err)
+		}
+	}
+	if err := s.triggerDownstream(r); err != nil {
+		s.log.Printf("err triggering jobs downstream of run %s: %s", r.RunID, err)
+	}
+}
+
+func (s *Service) process(r *Run) (*RunResult, error) {
+	if err := r.LoadPayloads(s.BlobStore); err != nil {
+		return nil, err
+	}
+	proc, err := s.processorFactory.Make(r.ProcessorConfig)
+	if err != nil {
+		return nil, err
+	}
+	return proc.Process(r.Input)
+}
+
+// retry creates the next attempt of a failed run if its job's retryer allows
+// it
+func (s *Service) retry(r *Run) (bool, error) {
+	job, err := s.getJob(r.JobID)
+	if err != nil {
+		return false, err
+	}
+	retryer, err := NewRetryer(job.RetryerConfig)
+	if err != nil {
+		return false, err
+	}
+	//the context of the failed attempt, attempts are counted from 1 on runs
+	if !retryer.ShouldRetry(JobContext{Attempt: r.Attempt - 1, ScheduledStartTime: r.ScheduledStartTime}) {
+		return false, nil
+	}
+	next := &Run{
+		JobID:              r.JobID,
+		JobVersionID:       r.JobVersionID,
+		ProcessorConfig:    r.ProcessorConfig,
+		ScheduledStartTime: time.Now(),
+		Attempt:            r.Attempt + 1,
+		ParentRunID:        r.ParentRunID,
+		RootRunID:          r.Root(),
+		MapIndex:           r.MapIndex,
+		MapCount:           r.MapCount,
+		BackfillID:         r.BackfillID,
+		Input:              r.Input,
+		TemplateContext:    r.TemplateContext,
+	}
+	if r.BackfillID != 0 {
+		//runs of a backfill keep their logical date
+		next.ScheduledStartTime, next.NotBefore = r.ScheduledStartTime, time.Now()
+	}
+	id, err := s.repo.CreateRun(next.CreateRunInput())
+	if err != nil {
+		return false, err
+	}
+	s.publishNext(EventRunRetried, r, r.JobID, id)
+	return true, nil
+}
+
+// triggerDownstream creates runs of the jobs triggered by the success or
+// failure of r, passing its output as their input. Every run that finished
+// and won't be retried passes here.
+func (s *Service) triggerDownstream(r *Run) error {
+	if r.BackfillID != 0 {
+		defer func() {
+			if err := s.backfillRunFinished(r); err != nil {
+				s.log.Printf("err updating backfill %s of run %s: %s", r.BackfillID, r.RunID, err)
+			}
+		}()
+		backfills, err := s.repo.GetBackfills(&GetBackfillsInput{BackfillIDs: BackfillIDs{r.BackfillID}})
+		if err != nil {
+			return err
+		}
+		if len(backfills) != 1 || !backfills[0].IncludeDownstream || backfills[0].Status != BackfillStatusRunning {
+			return nil
+		}
+	}
+	jobs, err := s.repo.GetJobs(&GetJobsInput{All: true})
+	if err != nil {
+		return err
+	}
+	output := json.RawMessage(r.Output)
+	if len(output) == 0 {
+		output = json.RawMessage("{}")
+	}
+	for _, j := range jobs {
+		upstream := j.Triggers.JobFailure
+		if r.Success {
+			upstream = j.Triggers.JobSuccess
+		}
+		if j.Paused || !jobIDsContain(upstream, r.JobID) {
+			continue
+		}
+		if j.LoopLimit > 0 {
+			n, err := s.chainRuns(r, j.ID)
 			if err != nil {
-				s.log.Printf("err saving run result: %s", err)
+				return err
+			}
+			if n >= j.LoopLimit {
+				s.log.Printf("job %s reached its loop limit of %d, not triggered by run %s", j.ID, j.LoopLimit, r.RunID)
 				continue
 			}
-			if res.Success {
-				//rerun if necessary
-			} else {
-
+		}
+		triggered, err := s.evalCondition(j, r, output)
+		if err != nil {
+			return err
+		}
+		if !triggered {
+			continue
+		}
+		if r.Success && j.Triggers.JoinSuccess {
+			if err := s.join(j, r, jobs); err != nil {
+				return err
+			}
+			continue
+		}
+		if j.Triggers.MapPath != "" {
+			if err := s.fanOut(j, r, output); err != nil {
+				return err
+			}
+			continue
+		}
+		next, err := j.MakeRun(downstreamContext(r, time.Now().Add(j.Triggers.Delay(r.JobID, r.Success)), output))
+		if err != nil {
+			if err := s.failDownstream(j, r, "err rendering input: "+err.Error(), time.Now()); err != nil {
+				return err
 			}
+			continue
+		}
+		next.ParentRunID = r.RunID
+		next.RootRunID = r.Root()
+		next.BackfillID = r.BackfillID
+		id, err := s.repo.CreateRun(next.CreateRunInput())
+		if err != nil {
+			return err
+		}
+		s.publishNext(EventRunTriggeredDownstream, r, j.ID, id)
+	}
+	return nil
+}
+
+// downstreamContext is the context of a run triggered by r with output, due
+// at due. The runs of a backfill keep the scheduled start time of the run
+// that was backfilled, the logical date of the whole execution.
+func downstreamContext(r *Run, due time.Time, output []byte) JobContext {
+	jc := JobContext{ScheduledStartTime: due, PreviousOutput: output, UpstreamJobID: r.JobID, UpstreamRunID: r.RunID}
+	if r.BackfillID != 0 {
+		jc.ScheduledStartTime, jc.NotBefore = r.ScheduledStartTime, due
+	}
+	return jc
+}
+
+// evalCondition evaluates the condition of the trigger of j by r, recording
+// the decision. Triggers without a condition are always triggered and aren't
+// recorded.
+func (s *Service) evalCondition(j *Job, r *Run, output []byte) (bool, error) {
+	c := j.Triggers.Condition(r.JobID, r.Success)
+	if c == "" {
+		return true, nil
+	}
+	triggered, evalErr := c.Eval(output)
+	decision := &CreateTriggerDecisionInput{
+		RunID:     r.RunID,
+		JobID:     j.ID,
+		Condition: c,
+		Triggered: triggered,
+		CreatedAt: time.Now(),
+	}
+	if evalErr != nil {
+		s.log.Printf("err evaluating condition of job %s on run %s: %s", j.ID, r.RunID, evalErr)
+		decision.Error = evalErr.Error()
+	}
+	if err := s.repo.CreateTriggerDecision(decision); err != nil {
+		return false, err
+	}
+	if !triggered {
+		s.log.Printf("job %s not triggered by run %s, condition %q doesn't hold", j.ID, r.RunID, c)
+	}
+	return triggered, nil
+}
+
+// chainRuns counts the runs of job in the chain of triggered runs ending with
+// r
+func (s *Service) chainRuns(r *Run, job JobID) (int, error) {
+	n := 0
+	for {
+		if r.JobID == job {
+			n++
+		}
+		if r.ParentRunID == 0 {
+			return n, nil
+		}
+		runs, err := s.repo.GetRuns(&GetRunsInput{RunID: &r.ParentRunID, Summary: true})
+		if err != nil {
+			return 0, err
 		}
+		if len(runs) != 1 {
+			//pruned, the chain can't be followed further
+			return n, nil
+		}
+		r = runs[0]
+	}
+}
+
+// getJobVersion returns the version of job id a run was created from, or the
+// job if the run has no version
+func (s *Service) getJobVersion(id JobID, version JobVersionID) (*Job, error) {
+	if version == 0 {
+		return s.getJob(id)
+	}
+	versions, err := s.repo.GetJobVersions(&GetJobVersionsInput{JobID: id, VersionIDs: []JobVersionID{version}})
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) != 1 {
+		return nil, ErrJobNotFound
+	}
+	return &versions[0].Job, nil
+}
+
+func (s *Service) getJob(id JobID) (*Job, error) {
+	jobs, err := s.repo.GetJobs(&GetJobsInput{JobIDs: JobIDs{id}})
+	if err != nil {
+		return nil, err
 	}
+	if len(jobs) != 1 {
+		return nil, ErrJobNotFound
+	}
+	return jobs[0], nil
+}
+
+func (s *Service) publish(t EventType, r *Run) {
+	s.events.Publish(Event{
+		Type:    t,
+		JobID:   r.JobID,
+		RunID:   r.RunID,
+		Attempt: r.Attempt,
+		Detail:  r.StatusDetail,
+	})
+}
+
+func (s *Service) publishNext(t EventType, r *Run, nextJobID JobID, nextRunID RunID) {
+	s.events.Publish(Event{
+		Type:      t,
+		JobID:     r.JobID,
+		RunID:     r.RunID,
+		Attempt:   r.Attempt,
+		NextJobID: nextJobID,
+		NextRunID: nextRunID,
+	})
 }
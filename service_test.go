@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestServiceCancelledRuns(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	processed := 0
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func([]byte) (*RunResult, error) {
+			processed++
+			close(started)
+			<-release
+			return &RunResult{Success: true, Output: json.RawMessage(`{}`)}, nil
+		}), nil
+	})
+	extract, err := r.CreateJob(&CreateJobInput{Name: "extract", Processor: ProcessorConfig{Type: "test"}, InputPayloadTemplate: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	load, err := r.CreateJob(&CreateJobInput{
+		Name:                 "load",
+		Processor:            ProcessorConfig{Type: "test"},
+		InputPayloadTemplate: []byte("{}"),
+		Triggers:             &TriggerEventsInput{JobSuccess: JobIDs{extract}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func() RunID {
+		id, err := r.CreateRun(&CreateRunInput{JobID: extract, ProcessorConfig: ProcessorConfig{Type: "test"}, Input: []byte("{}"), ScheduledStartTime: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	status := func(id RunID) RunStatus {
+		runs, err := r.GetRuns(&GetRunsInput{RunID: &id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runs[0].Status
+	}
+
+	//cancelled after the worker read it, before it claimed it
+	id := create()
+	due, err := r.GetDueRuns(&GetDueRunsInput{Now: time.Now().Add(time.Second), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpdateRun(&UpdateRunInput{RunID: id, Status: RunStatusPtr(RunStatusCancelled), IfStatus: []RunStatus{RunStatusPending}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.claimRun(due[0], nil); err != ErrRunStatusChanged {
+		t.Errorf("claim: expected %s, got %v", ErrRunStatusChanged, err)
+	}
+	if st := status(id); st != RunStatusCancelled {
+		t.Errorf("claim: expected the run to stay cancelled, got %s", st)
+	}
+
+	//cancelled while it runs, its result is dropped
+	id = create()
+	s.startDueRuns(time.Now().Add(time.Second))
+	<-started
+	if err := r.UpdateRun(&UpdateRunInput{RunID: id, Status: RunStatusPtr(RunStatusCancelled)}); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	s.running.Wait()
+	if processed != 1 {
+		t.Errorf("expected only the claimed run to be processed, got %d", processed)
+	}
+	if st := status(id); st != RunStatusCancelled {
+		t.Errorf("finish: expected the run to stay cancelled, got %s", st)
+	}
+	runs, err := r.GetRuns(&GetRunsInput{JobID: &load})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 0 {
+		t.Errorf("expected a cancelled run not to trigger downstream jobs, got %d runs", len(runs))
+	}
+}
+
+func TestServiceAttemptInput(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	var inputs []string
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func(input []byte) (*RunResult, error) {
+			inputs = append(inputs, string(input))
+			return &RunResult{Success: len(inputs) > 1}, nil
+		}), nil
+	})
+	id, err := r.CreateJob(&CreateJobInput{
+		Name:                 "extract",
+		Processor:            ProcessorConfig{Type: "test"},
+		Retryer:              RetryerConfig{Type: RetryerTypeDefault, Config: map[string]string{"NumRetries": "1"}},
+		InputPayloadTemplate: []byte(`{"run":{{.Run.ID}},"attempt":{{.Attempt}},"rows":{{index .Params "rows"}}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := r.GetJobs(&GetJobsInput{JobIDs: JobIDs{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := jobs[0].MakeRun(JobContext{ScheduledStartTime: time.Now(), Params: map[string]interface{}{"rows": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(run.Input) != `{"run":0,"attempt":1,"rows":3}` || len(run.TemplateContext) == 0 {
+		t.Fatalf("expected the input rendered without an ID and its context, got %s and %s", run.Input, run.TemplateContext)
+	}
+	if _, err := r.CreateRun(run.CreateRunInput()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		s.startDueRuns(time.Now().Add(time.Minute))
+		s.running.Wait()
+	}
+	expected := []string{`{"run":1,"attempt":1,"rows":3}`, `{"run":2,"attempt":2,"rows":3}`}
+	if len(inputs) != 2 || inputs[0] != expected[0] || inputs[1] != expected[1] {
+		t.Errorf("expected each attempt rendered with its ID and attempt %v, got %v", expected, inputs)
+	}
+
+	jobs[0].InputPayloadTemplate = []byte(`{"rows":{{index .Params "rows"}}}`)
+	if run, err = jobs[0].MakeRun(JobContext{Params: map[string]interface{}{"rows": 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if run.TemplateContext != nil {
+		t.Errorf("expected no context for a template that renders every attempt the same, got %s", run.TemplateContext)
+	}
+}
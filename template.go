@@ -0,0 +1,349 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Input payload templates are text/template templates whose output must be
+// JSON. The data is the upstream output and the context of the run, see
+// templateData. Values are written as they are, so strings need quotes in
+// the template, or toJson:
+//
+//	{"file": {{toJson .file}}, "day": "{{scheduledStartTime | dateAdd "-24h" | date "2006-01-02"}}"}
+//
+// A missing field fails the render, runs started by a schedule or by hand
+// have no upstream fields. Optional fields are read with index, which gives
+// nil for a missing key:
+//
+//	{"rows": {{index . "rows" | default 0}}}
+//
+// Functions take the piped value last:
+//
+//	toJson v                  v as JSON
+//	scheduledStartTime        the scheduled start time of the run
+//	date layout t             formats a time, or an RFC3339 string, with a Go layout
+//	dateAdd duration t        adds a duration such as "-24h" to a time
+//	addDays n t               adds n days to a time
+//	default def v             def if v is empty
+//	env name                  the environment variable TemplateEnvPrefix+name
+//	upper, lower, trim s
+//	trimPrefix, trimSuffix prefix s
+//	replace old new s
+//	contains, hasPrefix, hasSuffix sub s
+//	split sep s, join sep list
+
+// TemplateEnvPrefix is the prefix of the environment variables templates can
+// read with env, the rest of the service's environment isn't exposed to jobs
+const TemplateEnvPrefix = "PIPELINE_TEMPLATE_ENV_"
+
+// templateFuncs returns the functions of the templates of runs scheduled at
+// start
+func templateFuncs(start time.Time) template.FuncMap {
+	return template.FuncMap{
+		"toJson": func(v interface{}) (string, error) {
+			d, err := json.Marshal(v)
+			return string(d), err
+		},
+		"scheduledStartTime": func() time.Time { return start },
+		"date": func(layout string, t interface{}) (string, error) {
+			tm, err := toTime(t)
+			if err != nil {
+				return "", err
+			}
+			return tm.Format(layout), nil
+		},
+		"dateAdd": func(d string, t interface{}) (time.Time, error) {
+			dur, err := time.ParseDuration(d)
+			if err != nil {
+				return time.Time{}, err
+			}
+			tm, err := toTime(t)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return tm.Add(dur), nil
+		},
+		"addDays": func(n int, t interface{}) (time.Time, error) {
+			tm, err := toTime(t)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return tm.AddDate(0, 0, n), nil
+		},
+		"default": func(def, v interface{}) interface{} {
+			if isEmpty(v) {
+				return def
+			}
+			return v
+		},
+		"env":        func(name string) string { return os.Getenv(TemplateEnvPrefix + name) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"contains":   func(sub, s string) bool { return strings.Contains(s, sub) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join": func(sep string, list interface{}) (string, error) {
+			v := reflect.ValueOf(list)
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return "", fmt.Errorf("join: expected a list, got %T", list)
+			}
+			parts := make([]string, v.Len())
+			for i := range parts {
+				parts[i] = fmt.Sprint(v.Index(i).Interface())
+			}
+			return strings.Join(parts, sep), nil
+		},
+	}
+}
+
+// toTime converts a time, or a string in RFC3339, such as a time in an
+// upstream output
+func toTime(t interface{}) (time.Time, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		return time.Parse(time.RFC3339, v)
+	}
+	return time.Time{}, fmt.Errorf("expected a time, got %T", t)
+}
+
+// isEmpty reports if v is nil or the zero value of its type, an empty list
+// or map included
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+// parseTemplate parses an input payload template
+func parseTemplate(tmpl []byte, start time.Time) (*template.Template, error) {
+	return template.New("input").Funcs(templateFuncs(start)).Option("missingkey=error").Parse(string(tmpl))
+}
+
+// ValidateTemplate reports if tmpl doesn't parse as an input payload
+// template
+func ValidateTemplate(tmpl []byte) error {
+	_, err := parseTemplate(tmpl, time.Time{})
+	return err
+}
+
+// TemplateJob is .Job in input payload templates
+type TemplateJob struct {
+	ID        JobID
+	Name      string
+	VersionID JobVersionID
+}
+
+// TemplateRun is .Run in input payload templates. The input of a run is
+// rendered when the run is created, before it has an ID, and again when each
+// attempt is claimed, see templateContext.
+type TemplateRun struct {
+	ID                 RunID //0 when the run is created
+	Attempt            int   //starts at 1
+	ScheduledStartTime time.Time
+}
+
+// TemplateUpstream is .Upstream in input payload templates, the run whose
+// completion triggered the run. Runs started by a schedule, by hand or by a
+// webhook have no upstream job and run. Output is the upstream output, or
+// the body of a webhook, whatever its JSON type.
+type TemplateUpstream struct {
+	JobID  JobID
+	RunID  RunID
+	Output interface{}
+}
+
+// templateData is the data of the template of a run of j. The fields of an
+// upstream output that is a JSON object are at the top, {{.rows}}, along
+// with .Job, .Run, .ScheduledStartTime, .Attempt, .Upstream and .Params,
+// which hide upstream fields of the same name. Other outputs, such as the string
+// elements of a fan-out, are only in .Upstream.Output.
+func templateData(j *Job, jc JobContext) (map[string]interface{}, error) {
+	var output interface{}
+	if len(jc.PreviousOutput) > 0 {
+		if err := json.Unmarshal(jc.PreviousOutput, &output); err != nil {
+			return nil, err
+		}
+	}
+	fields, _ := output.(map[string]interface{})
+	data := make(map[string]interface{}, len(fields)+6)
+	for k, v := range fields {
+		data[k] = v
+	}
+	params := jc.Params
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	data["Job"] = TemplateJob{ID: j.ID, Name: j.Name, VersionID: j.VersionID}
+	data["Run"] = TemplateRun{ID: jc.RunID, Attempt: jc.Attempt + 1, ScheduledStartTime: jc.ScheduledStartTime}
+	data["ScheduledStartTime"] = jc.ScheduledStartTime
+	data["Attempt"] = jc.Attempt + 1
+	data["Upstream"] = TemplateUpstream{JobID: jc.UpstreamJobID, RunID: jc.UpstreamRunID, Output: output}
+	data["Params"] = params
+	return data, nil
+}
+
+// renderInput executes the template of j with templateData. The result must
+// be JSON, unless the template renders nothing.
+func renderInput(j *Job, jc JobContext) ([]byte, error) {
+	data, err := templateData(j, jc)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseTemplate(j.InputPayloadTemplate, jc.ScheduledStartTime)
+	if err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	if err := t.Execute(out, data); err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out.Bytes())) > 0 && !json.Valid(out.Bytes()) {
+		return nil, errors.New("rendered input is not valid JSON: " + out.String())
+	}
+	return out.Bytes(), nil
+}
+
+// templateContext is the data a run's input was rendered from, stored on
+// runs whose template reads .Run or .Attempt so that each attempt is
+// rendered again once it is claimed, with its ID and attempt number
+type templateContext struct {
+	Output        json.RawMessage        `json:",omitempty"`
+	Params        map[string]interface{} `json:",omitempty"`
+	UpstreamJobID JobID                  `json:",omitempty"`
+	UpstreamRunID RunID                  `json:",omitempty"`
+}
+
+// attemptContext returns the templateContext of a run of j made with jc, nil
+// if the template of j renders the same input for every attempt
+func attemptContext(j *Job, jc JobContext) ([]byte, error) {
+	t, err := parseTemplate(j.InputPayloadTemplate, jc.ScheduledStartTime)
+	if err != nil {
+		return nil, err
+	}
+	if t.Tree == nil || !readsAttempt(t.Tree.Root) {
+		return nil, nil
+	}
+	return json.Marshal(templateContext{
+		Output:        jc.PreviousOutput,
+		Params:        jc.Params,
+		UpstreamJobID: jc.UpstreamJobID,
+		UpstreamRunID: jc.UpstreamRunID,
+	})
+}
+
+// renderAttempt renders the input of r, an attempt of a run of j, from the
+// templateContext of r
+func renderAttempt(j *Job, r *Run) ([]byte, error) {
+	c := templateContext{}
+	if err := json.Unmarshal(r.TemplateContext, &c); err != nil {
+		return nil, err
+	}
+	return renderInput(j, JobContext{
+		RunID:              r.RunID,
+		Attempt:            r.Attempt - 1,
+		ScheduledStartTime: r.ScheduledStartTime,
+		PreviousOutput:     c.Output,
+		UpstreamJobID:      c.UpstreamJobID,
+		UpstreamRunID:      c.UpstreamRunID,
+		Params:             c.Params,
+	})
+}
+
+// readsAttempt reports if a template node may read .Run or .Attempt. The
+// whole data, {{toJson .}}, counts, index only if its key is one of them.
+func readsAttempt(n parse.Node) bool {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Nodes {
+			if readsAttempt(c) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return readsAttempt(n.Pipe)
+	case *parse.IfNode:
+		return readsAttempt(n.Pipe) || readsAttempt(n.List) || readsAttempt(n.ElseList)
+	case *parse.RangeNode:
+		return readsAttempt(n.Pipe) || readsAttempt(n.List) || readsAttempt(n.ElseList)
+	case *parse.WithNode:
+		return readsAttempt(n.Pipe) || readsAttempt(n.List) || readsAttempt(n.ElseList)
+	case *parse.TemplateNode:
+		return readsAttempt(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Cmds {
+			if readsAttempt(c) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		args := n.Args
+		if len(args) >= 3 && isIdentifier(args[0], "index") && isData(args[1]) {
+			if key, ok := args[2].(*parse.StringNode); ok {
+				if isAttemptField(key.Text) {
+					return true
+				}
+				args = args[2:]
+			}
+		}
+		for _, a := range args {
+			if readsAttempt(a) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return readsAttempt(n.Node)
+	case *parse.FieldNode:
+		return isAttemptField(n.Ident[0])
+	case *parse.VariableNode:
+		return n.Ident[0] == "$" && (len(n.Ident) == 1 || isAttemptField(n.Ident[1]))
+	case *parse.DotNode:
+		return true
+	}
+	return false
+}
+
+func isAttemptField(name string) bool {
+	return name == "Run" || name == "Attempt"
+}
+
+func isIdentifier(n parse.Node, name string) bool {
+	id, ok := n.(*parse.IdentifierNode)
+	return ok && id.Ident == name
+}
+
+// isData reports if n is the whole data, . or $
+func isData(n parse.Node) bool {
+	if _, ok := n.(*parse.DotNode); ok {
+		return true
+	}
+	v, ok := n.(*parse.VariableNode)
+	return ok && len(v.Ident) == 1 && v.Ident[0] == "$"
+}
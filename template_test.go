@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderInput(t *testing.T) {
+	t.Setenv(TemplateEnvPrefix+"BUCKET", "raw")
+	jc := JobContext{
+		ScheduledStartTime: time.Date(2017, 5, 1, 3, 0, 0, 0, time.UTC),
+		PreviousOutput:     []byte(`{"file":"a \"b\".csv","rows":3,"tags":["x","y"],"at":"2017-04-01T10:00:00Z","empty":""}`),
+	}
+	tests := []struct {
+		tmpl     string
+		expected string
+		err      string
+	}{
+		{`{"rows":{{.rows}}}`, `{"rows":3}`, ""},
+		{`{"file":{{toJson .file}},"tags":{{toJson .tags}}}`, `{"file":"a \"b\".csv","tags":["x","y"]}`, ""},
+		{`{"q":"<a&b>"}`, `{"q":"<a&b>"}`, ""},
+		{`{"day":"{{scheduledStartTime | date "2006-01-02"}}"}`, `{"day":"2017-05-01"}`, ""},
+		{`{"day":"{{scheduledStartTime | addDays -1 | date "2006-01-02"}}"}`, `{"day":"2017-04-30"}`, ""},
+		{`{"hour":"{{scheduledStartTime | dateAdd "-4h" | date "2006-01-02T15"}}"}`, `{"hour":"2017-04-30T23"}`, ""},
+		{`{"at":"{{.at | date "Jan 2"}}"}`, `{"at":"Apr 1"}`, ""},
+		{`{"v":{{.empty | default "none" | toJson}},"w":{{index . "missing" | default 5}}}`, `{"v":"none","w":5}`, ""},
+		{`{"f":{{.file | trimSuffix ".csv" | upper | toJson}}}`, `{"f":"A \"B\""}`, ""},
+		{`{"f":"{{.tags | join "," | replace "," ";"}}","p":{{hasSuffix ".csv" .file}}}`, `{"f":"x;y","p":true}`, ""},
+		{``, ``, ""},
+		{`{"rows":{{.rows}`, "", "bad character"},
+		{`{"rows":{{.rows | nope}}}`, "", `function "nope" not defined`},
+		{`{"file":{{.file}}}`, "", "not valid JSON"},
+		{`{"file":"{{.missing}}"}`, "", `map has no entry for key "missing"`},
+		{`{"env":"{{env "HOME"}}","bucket":"{{env "BUCKET"}}"}`, `{"env":"","bucket":"raw"}`, ""},
+		{`{"at":"{{.rows | date "2006"}}"}`, "", "expected a time"},
+	}
+	for _, test := range tests {
+		out, err := renderInput(&Job{InputPayloadTemplate: []byte(test.tmpl)}, jc)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", test.tmpl, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.tmpl, err)
+			continue
+		}
+		if string(out) != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.tmpl, test.expected, out)
+		}
+	}
+
+	//context
+	job := &Job{ID: 2, Name: "load", VersionID: 3}
+	jc = JobContext{
+		RunID:              9,
+		Attempt:            1,
+		ScheduledStartTime: time.Date(2017, 5, 1, 3, 0, 0, 0, time.UTC),
+		PreviousOutput:     []byte(`{"rows":3,"Job":"hidden"}`),
+		UpstreamJobID:      1,
+		UpstreamRunID:      7,
+		Params:             map[string]interface{}{"table": "events"},
+	}
+	contextTests := []struct {
+		tmpl     string
+		output   string
+		expected string
+	}{
+		{`{"day":"{{.ScheduledStartTime | date "2006-01-02"}}","rows":{{.rows}}}`, "", `{"day":"2017-05-01","rows":3}`},
+		{`{"job":"{{.Job.Name}}","id":{{.Job.ID}},"version":{{.Job.VersionID}},"day":"{{.Run.ScheduledStartTime | date "2006-01-02"}}"}`, "", `{"job":"load","id":2,"version":3,"day":"2017-05-01"}`},
+		{`{"from":{{.Upstream.JobID}},"run":{{.Upstream.RunID}},"hidden":{{toJson .Upstream.Output.Job}}}`, "", `{"from":1,"run":7,"hidden":"hidden"}`},
+		{`{"table":{{toJson .Params.table}},"missing":{{index .Params "day" | default "null"}}}`, "", `{"table":"events","missing":null}`},
+		{`{"element":{{toJson .Upstream.Output}},"job":"{{.Job.Name}}"}`, `"a.csv"`, `{"element":"a.csv","job":"load"}`},
+		{`{"run":{{.Run.ID}},"attempt":{{.Run.Attempt}},"again":{{.Attempt}}}`, "", `{"run":9,"attempt":2,"again":2}`},
+	}
+	for _, test := range contextTests {
+		c := jc
+		if test.output != "" {
+			c.PreviousOutput = []byte(test.output)
+		}
+		job.InputPayloadTemplate = []byte(test.tmpl)
+		out, err := renderInput(job, c)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.tmpl, err)
+			continue
+		}
+		if string(out) != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.tmpl, test.expected, out)
+		}
+	}
+
+	//no upstream output, only the context
+	job.InputPayloadTemplate = []byte(`{"job":"{{.Job.Name}}","rows":{{index . "rows" | default 0}}}`)
+	out, err := renderInput(job, JobContext{ScheduledStartTime: jc.ScheduledStartTime})
+	if err != nil || string(out) != `{"job":"load","rows":0}` {
+		t.Errorf("expected a run without upstream output to render the context, got %s, %v", out, err)
+	}
+
+	//templates reading the fields of an attempt
+	readTests := []struct {
+		tmpl     string
+		expected bool
+	}{
+		{`{"rows":{{.rows}},"day":"{{.ScheduledStartTime | date "2006"}}"}`, false},
+		{`{"rows":{{index . "rows" | default 0}}}`, false},
+		{`{{range .tags}}{{.}}{{end}}`, true},
+		{`{"a":{{.Attempt}}}`, true},
+		{`{"id":{{with .Run}}{{.ID}}{{end}}}`, true},
+		{`{"a":{{index . "Attempt"}}}`, true},
+		{`{{if .rows}}{"all":{{toJson $}}}{{end}}`, true},
+		{`{"id":{{$.Run.ID}}}`, true},
+	}
+	for _, test := range readTests {
+		tmpl, err := parseTemplate([]byte(test.tmpl), time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readsAttempt(tmpl.Tree.Root); got != test.expected {
+			t.Errorf("%s: expected readsAttempt %v, got %v", test.tmpl, test.expected, got)
+		}
+	}
+
+	_, err = NewValidationWrapper(nil).CreateJob(&CreateJobInput{Name: "bad", InputPayloadTemplate: []byte("{{.rows")})
+	if err == nil || !strings.Contains(err.Error(), "InputPayloadTemplate") {
+		t.Errorf("expected a template that doesn't parse to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ProcessorTypeWait is the processor of wait jobs, which delay the jobs they
+// trigger. A run of a wait job waits for the Duration of the processor
+// config ("30m") or until its Until wall-clock time ("09:00" in the
+// TimeZone of the config, UTC by default), then succeeds with its input as
+// output. Waiting runs don't hold a worker, see wakeRuns.
+const ProcessorTypeWait = "wait"
+
+// waitUntil returns the end of a wait that starts at now
+func waitUntil(config map[string]string, now time.Time) (time.Time, error) {
+	duration, until := config["Duration"], config["Until"]
+	switch {
+	case duration != "" && until != "":
+		return time.Time{}, errors.New("Duration and Until can't be combined")
+	case duration != "":
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if d < 0 {
+			return time.Time{}, errors.New("Duration must not be negative")
+		}
+		return now.Add(d), nil
+	case until != "":
+		loc := time.UTC
+		if tz := config["TimeZone"]; tz != "" {
+			var err error
+			if loc, err = time.LoadLocation(tz); err != nil {
+				return time.Time{}, err
+			}
+		}
+		clock, err := time.Parse("15:04", until)
+		if err != nil {
+			return time.Time{}, errors.New("Until must be a time such as 09:00")
+		}
+		local := now.In(loc)
+		t := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+		if t.Before(local) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, errors.New("Duration or Until is required")
+}
+
+// waitResult starts the wait of r, a run of a wait job. The run is parked in
+// the waiting status until the end of the wait, the result is then nil. A
+// wait that is already over succeeds right away.
+func (s *Service) waitResult(r *Run) (*RunResult, error) {
+	now := time.Now()
+	until, err := waitUntil(r.ProcessorConfig.Config, now)
+	if err != nil {
+		return nil, err
+	}
+	if !until.After(now) {
+		return s.waitOver(r)
+	}
+	detail := "waiting until " + until.Format(time.RFC3339)
+	err = s.repo.UpdateRun(&UpdateRunInput{
+		RunID:        r.RunID,
+		Status:       RunStatusPtr(RunStatusWaiting),
+		StatusDetail: &detail,
+		NotBefore:    &until,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Status, r.StatusDetail, r.NotBefore = RunStatusWaiting, detail, until
+	s.publish(EventRunWaiting, r)
+	return nil, nil
+}
+
+// waitOver is the result of a wait run whose wait is over, it passes its
+// input on
+func (s *Service) waitOver(r *Run) (*RunResult, error) {
+	if err := r.LoadPayloads(s.BlobStore); err != nil {
+		return nil, err
+	}
+	return &RunResult{Output: json.RawMessage(r.Input), Success: true}, nil
+}
+
+// wakeRuns completes the waiting runs of wait jobs whose wait is over, the
+// end of the wait is their NotBefore
+func (s *Service) wakeRuns(now time.Time) {
+	runs, err := s.repo.GetRuns(&GetRunsInput{
+		Status:    RunStatusPtr(RunStatusWaiting),
+		NotBefore: &TimeRange{Before: &now},
+	})
+	if err != nil {
+		s.log.Printf("err getting waiting runs: %s", err)
+		return
+	}
+	for _, r := range runs {
+		if r.ProcessorConfig.Type != ProcessorTypeWait {
+			//approvals wait for a decision
+			continue
+		}
+		//running, so the next poll doesn't wake it again
+		err := s.repo.UpdateRun(&UpdateRunInput{
+			RunID:    r.RunID,
+			Status:   RunStatusPtr(RunStatusRunning),
+			IfStatus: []RunStatus{RunStatusWaiting},
+		})
+		if err == ErrRunStatusChanged {
+			continue
+		}
+		if err != nil {
+			s.log.Printf("err waking run %s: %s", r.RunID, err)
+			continue
+		}
+		r.Status = RunStatusRunning
+		s.running.Add(1)
+		go func(r *Run) {
+			defer s.running.Done()
+			if r.BackfillID != 0 {
+				s.holdBackfillSlot(r)
+				defer s.releaseBackfillSlot(r)
+			}
+			res, err := s.waitOver(r)
+			if err != nil {
+				res = &RunResult{Detail: err.Error()}
+			}
+			s.finishRun(r, res)
+		}(r)
+	}
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWaitUntil(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		config map[string]string
+		want   time.Time
+		err    bool
+	}{
+		{map[string]string{"Duration": "30m"}, now.Add(30 * time.Minute), false},
+		{map[string]string{"Until": "12:00"}, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), false},
+		{map[string]string{"Until": "09:00"}, time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC), false},
+		{map[string]string{"Until": "05:00", "TimeZone": "America/New_York"}, time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC), false},
+		{map[string]string{"Duration": "-1m"}, time.Time{}, true},
+		{map[string]string{"Duration": "1m", "Until": "12:00"}, time.Time{}, true},
+		{map[string]string{"Until": "noon"}, time.Time{}, true},
+		{map[string]string{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := waitUntil(tt.config, now)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: expected err %v, got %v", tt.config, tt.err, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%v: expected %s, got %s", tt.config, tt.want, got)
+		}
+	}
+}
+
+func TestServiceWait(t *testing.T) {
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.AddProcessor("test", func(map[string]string) (RunProcessor, error) {
+		return processorFunc(func(in []byte) (*RunResult, error) {
+			return &RunResult{Success: true, Output: json.RawMessage(`{"rows":2}`)}, nil
+		}), nil
+	})
+	create := func(in *CreateJobInput) JobID {
+		if in.Processor.Type == "" {
+			in.Processor = ProcessorConfig{Type: "test"}
+		}
+		if in.InputPayloadTemplate == nil {
+			in.InputPayloadTemplate = []byte("{}")
+		}
+		id, err := r.CreateJob(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	extract := create(&CreateJobInput{Name: "extract"})
+	report := create(&CreateJobInput{
+		Name: "report",
+		Triggers: &TriggerEventsInput{
+			JobSuccess:       JobIDs{extract},
+			JobSuccessDelays: map[JobID]time.Duration{extract: time.Hour},
+		},
+	})
+	hold := create(&CreateJobInput{
+		Name:                 "hold",
+		Processor:            ProcessorConfig{Type: ProcessorTypeWait, Config: map[string]string{"Duration": "30m"}},
+		InputPayloadTemplate: []byte(`{"rows":{{.rows}}}`),
+		Triggers:             &TriggerEventsInput{JobSuccess: JobIDs{extract}},
+	})
+	load := create(&CreateJobInput{Name: "load", Triggers: &TriggerEventsInput{JobSuccess: JobIDs{hold}}})
+
+	runs := func(job JobID) []*Run {
+		runs, err := r.GetRuns(&GetRunsInput{JobID: &job})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runs
+	}
+	_, err := r.CreateRun(&CreateRunInput{JobID: extract, ProcessorConfig: ProcessorConfig{Type: "test"}, Attempt: IntPtr(1), Input: []byte("{}"), ScheduledStartTime: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		s.startDueRuns(time.Now())
+		s.running.Wait()
+	}
+	ran := time.Now()
+
+	reports := runs(report)
+	if len(reports) != 1 || reports[0].Status != RunStatusPending {
+		t.Fatalf("expected a pending report run, got %+v", reports)
+	}
+	if d := reports[0].ScheduledStartTime.Sub(start); d < 59*time.Minute || d > 61*time.Minute {
+		t.Errorf("expected the report run an hour after extract, got %s", d)
+	}
+
+	holds := runs(hold)
+	if len(holds) != 1 || holds[0].Status != RunStatusWaiting {
+		t.Fatalf("expected a waiting hold run, got %+v", holds)
+	}
+	if d := holds[0].NotBefore.Sub(start); d < 29*time.Minute || d > 31*time.Minute {
+		t.Errorf("expected the hold run to wait 30m, got %s", d)
+	}
+	if holds[0].ScheduledStartTime.After(ran) {
+		t.Errorf("expected the wait to keep the scheduled start time of the hold run, got %s", holds[0].ScheduledStartTime)
+	}
+	if len(runs(load)) != 0 {
+		t.Fatal("expected load not to run during the wait")
+	}
+
+	s.wakeRuns(time.Now().Add(10 * time.Minute))
+	s.running.Wait()
+	if holds = runs(hold); holds[0].Status != RunStatusWaiting {
+		t.Fatalf("expected the hold run to wait, got %+v", holds[0])
+	}
+	s.wakeRuns(time.Now().Add(time.Hour))
+	s.running.Wait()
+	holds = runs(hold)
+	if holds[0].Status != RunStatusComplete || !holds[0].Success || string(holds[0].Output) != `{"rows":2}` {
+		t.Errorf("expected the hold run to succeed with its input, got %+v", holds[0])
+	}
+	loads := runs(load)
+	if len(loads) != 1 || string(loads[0].Input) != "{}" {
+		t.Errorf("expected the hold run to trigger load, got %+v", loads)
+	}
+}
@@ -0,0 +1,250 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	//DefaultWatchInterval is the time between scans of the watched directories
+	DefaultWatchInterval = 10 * time.Second
+	//watchRetryMin and watchRetryMax bound the backoff of a file whose run
+	//couldn't be created, such as when its input doesn't render
+	watchRetryMin = time.Minute
+	watchRetryMax = time.Hour
+)
+
+// FileWatch triggers a job for the files of a local directory. Each new or
+// modified file whose name matches Pattern gets a run, with the file as the
+// template data: {{.path}}, {{.name}}, {{.size}} and {{.mod_time}}. The
+// state of the files is stored in the repository, so files that triggered
+// before a restart don't trigger again.
+type FileWatch struct {
+	Dir       string        //the job isn't watching if empty
+	Pattern   string        //glob matched against file names, such as *.csv, every file if empty
+	Recursive bool          //watches the subdirectories of Dir too
+	StableFor time.Duration //a file triggers once its size and mtime haven't changed for this long
+	Debounce  time.Duration //min time between two runs for the same file
+}
+
+// Enabled reports if the job watches a directory
+func (w FileWatch) Enabled() bool {
+	return w.Dir != ""
+}
+
+func (w FileWatch) validate() []error {
+	var errs []error
+	if !w.Enabled() {
+		if w.Pattern != "" || w.Recursive || w.StableFor != 0 || w.Debounce != 0 {
+			errs = append(errs, ErrFieldRequired{"Triggers.FileWatch.Dir"})
+		}
+		return errs
+	}
+	if !filepath.IsAbs(w.Dir) {
+		errs = append(errs, ErrFieldInvalid{"Triggers.FileWatch.Dir", "must be an absolute path"})
+	}
+	if _, err := filepath.Match(w.Pattern, ""); err != nil {
+		errs = append(errs, ErrFieldInvalid{"Triggers.FileWatch.Pattern", err.Error()})
+	}
+	if w.StableFor < 0 {
+		errs = append(errs, ErrFieldInvalid{"Triggers.FileWatch.StableFor", "must not be negative"})
+	}
+	if w.Debounce < 0 {
+		errs = append(errs, ErrFieldInvalid{"Triggers.FileWatch.Debounce", "must not be negative"})
+	}
+	return errs
+}
+
+// WatchedFile is the state of a file of a FileWatch
+type WatchedFile struct {
+	JobID     JobID
+	Path      string
+	Size      int64
+	ModTime   time.Time
+	ChangedAt time.Time //when the size or mtime last changed, or the file was found
+	//the size and mtime the file last triggered with, zero if it hasn't
+	TriggeredSize    int64
+	TriggeredModTime time.Time
+	TriggeredAt      *time.Time
+	//failed triggers since the file last changed or triggered, it isn't
+	//tried again before RetryAt
+	Failures int
+	RetryAt  *time.Time
+}
+
+// due reports if f is stable and changed since it last triggered
+func (f *WatchedFile) due(w FileWatch, now time.Time) bool {
+	if now.Sub(f.ChangedAt) < w.StableFor {
+		return false
+	}
+	if f.RetryAt != nil && now.Before(*f.RetryAt) {
+		return false
+	}
+	if f.TriggeredAt == nil {
+		return true
+	}
+	if f.Size == f.TriggeredSize && f.ModTime.Equal(f.TriggeredModTime) {
+		return false
+	}
+	return now.Sub(*f.TriggeredAt) >= w.Debounce
+}
+
+// scan lists the regular files of the watch by path
+func (w FileWatch) scan() (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	err := filepath.Walk(w.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == w.Dir {
+				return err
+			}
+			//a file removed during the walk
+			return nil
+		}
+		if info.IsDir() {
+			if path != w.Dir && !w.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if w.Pattern != "" {
+			if ok, _ := filepath.Match(w.Pattern, info.Name()); !ok {
+				return nil
+			}
+		}
+		files[path] = info
+		return nil
+	})
+	return files, err
+}
+
+// retryDelay is the time before a file that failed to trigger f.Failures
+// times is tried again, doubling from watchRetryMin up to watchRetryMax
+func (f *WatchedFile) retryDelay() time.Duration {
+	d := watchRetryMin
+	for i := 1; i < f.Failures && d < watchRetryMax; i++ {
+		d *= 2
+	}
+	if d > watchRetryMax {
+		d = watchRetryMax
+	}
+	return d
+}
+
+// startWatcher scans the watched directories every WatchInterval. It runs
+// apart from the worker so walking large directories doesn't delay due runs.
+func (s *Service) startWatcher() {
+	interval := s.WatchInterval
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.watchFiles(time.Now())
+	}
+}
+
+// watchFiles scans the directories watched by jobs that aren't paused and
+// creates runs for the files that are due
+func (s *Service) watchFiles(now time.Time) {
+	jobs, err := s.repo.GetJobs(&GetJobsInput{All: true})
+	if err != nil {
+		s.log.Printf("err getting jobs to watch files: %s", err)
+		return
+	}
+	for _, j := range jobs {
+		if !j.Triggers.FileWatch.Enabled() || j.Paused {
+			continue
+		}
+		if err := s.watchJobFiles(j, now); err != nil {
+			s.log.Printf("err watching files of job %s: %s", j.ID, err)
+		}
+	}
+}
+
+func (s *Service) watchJobFiles(j *Job, now time.Time) error {
+	w := j.Triggers.FileWatch
+	found, err := w.scan()
+	if err != nil {
+		return err
+	}
+	known, err := s.repo.GetWatchedFiles(&GetWatchedFilesInput{JobID: j.ID})
+	if err != nil {
+		return err
+	}
+	var removed []string
+	byPath := map[string]*WatchedFile{}
+	for _, f := range known {
+		if _, ok := found[f.Path]; !ok {
+			//deleted, or no longer matching the watch
+			removed = append(removed, f.Path)
+			continue
+		}
+		byPath[f.Path] = f
+	}
+	if len(removed) > 0 {
+		err := s.repo.DeleteWatchedFiles(&DeleteWatchedFilesInput{JobID: j.ID, Paths: removed})
+		if err != nil {
+			return err
+		}
+	}
+	for path, info := range found {
+		f := byPath[path]
+		changed := f == nil || f.Size != info.Size() || !f.ModTime.Equal(info.ModTime())
+		if f == nil {
+			f = &WatchedFile{JobID: j.ID, Path: path}
+		}
+		if changed {
+			f.Size, f.ModTime, f.ChangedAt = info.Size(), info.ModTime(), now
+			f.Failures, f.RetryAt = 0, nil
+		}
+		if f.due(w, now) {
+			if err := s.triggerFile(j, f, now); err != nil {
+				f.Failures++
+				retry := now.Add(f.retryDelay())
+				f.RetryAt = &retry
+				s.log.Printf("err triggering job %s for %s, retrying at %s: %s", j.ID, path, retry.Format(time.RFC3339), err)
+				if err := s.repo.SaveWatchedFile(f); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if changed {
+			if err := s.repo.SaveWatchedFile(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// triggerFile creates the run of f and records that f triggered. A restart
+// between the two triggers the file again.
+func (s *Service) triggerFile(j *Job, f *WatchedFile, now time.Time) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"path":     f.Path,
+		"name":     filepath.Base(f.Path),
+		"size":     f.Size,
+		"mod_time": f.ModTime.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+	run, err := j.MakeRun(JobContext{ScheduledStartTime: now, PreviousOutput: data})
+	if err != nil {
+		return errors.New("err rendering input: " + err.Error())
+	}
+	if _, err := s.repo.CreateRun(run.CreateRunInput()); err != nil {
+		return err
+	}
+	f.TriggeredSize, f.TriggeredModTime, f.TriggeredAt = f.Size, f.ModTime, &now
+	f.Failures, f.RetryAt = 0, nil
+	return s.repo.SaveWatchedFile(f)
+}
@@ -0,0 +1,200 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchedFileDue(t *testing.T) {
+	now := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	mod := now.Add(-time.Hour)
+	triggered := now.Add(-time.Minute)
+	w := FileWatch{Dir: "/data", StableFor: 30 * time.Second, Debounce: 5 * time.Minute}
+	tests := []struct {
+		name     string
+		file     WatchedFile
+		expected bool
+	}{
+		{"new and stable", WatchedFile{Size: 1, ModTime: mod, ChangedAt: now.Add(-time.Minute)}, true},
+		{"new and still changing", WatchedFile{Size: 1, ModTime: mod, ChangedAt: now.Add(-10 * time.Second)}, false},
+		{"unchanged since triggered", WatchedFile{Size: 1, ModTime: mod, ChangedAt: now.Add(-time.Hour), TriggeredSize: 1, TriggeredModTime: mod, TriggeredAt: &triggered}, false},
+		{"changed within debounce", WatchedFile{Size: 2, ModTime: mod, ChangedAt: now.Add(-time.Minute), TriggeredSize: 1, TriggeredModTime: mod, TriggeredAt: &triggered}, false},
+		{"changed after debounce", WatchedFile{Size: 2, ModTime: mod, ChangedAt: now.Add(-time.Minute), TriggeredSize: 1, TriggeredModTime: mod, TriggeredAt: &mod}, true},
+		{"failed, before retry", WatchedFile{Size: 1, ModTime: mod, ChangedAt: now.Add(-time.Hour), Failures: 1, RetryAt: &now}, true},
+		{"failed, backing off", WatchedFile{Size: 1, ModTime: mod, ChangedAt: now.Add(-time.Hour), Failures: 2, RetryAt: &[]time.Time{now.Add(time.Second)}[0]}, false},
+	}
+	for _, test := range tests {
+		if got := test.file.due(w, now); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestServiceWatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRepo(t)
+	defer r.Close()
+	s := NewService(r)
+	s.log.SetOutput(testWriter{t})
+	job, err := r.CreateJob(&CreateJobInput{
+		Name:                 "import",
+		Processor:            ProcessorConfig{Type: "test"},
+		InputPayloadTemplate: []byte(`{"path":"{{.path}}","name":"{{.name}}","size":{{.size}}}`),
+		Triggers: &TriggerEventsInput{FileWatch: &FileWatch{
+			Dir:       dir,
+			Pattern:   "*.csv",
+			StableFor: 30 * time.Second,
+			Debounce:  5 * time.Minute,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	inputs := func() []map[string]interface{} {
+		runs, err := r.GetRuns(&GetRunsInput{JobID: &job})
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := make([]map[string]interface{}, len(runs))
+		for i, run := range runs {
+			if err := json.Unmarshal(run.Input, &in[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return in
+	}
+
+	now := time.Now()
+	a := write("a.csv", "1,2")
+	write("b.txt", "ignored")
+	write("sub/c.csv", "not recursive")
+	s.watchFiles(now)
+	if n := len(inputs()); n != 0 {
+		t.Fatalf("expected no run before the file is stable, got %d", n)
+	}
+	s.watchFiles(now.Add(time.Minute))
+	in := inputs()
+	if len(in) != 1 || in[0]["path"] != a || in[0]["name"] != "a.csv" || in[0]["size"] != 3.0 {
+		t.Fatalf("expected a run for a.csv, got %+v", in)
+	}
+	s.watchFiles(now.Add(2 * time.Minute))
+	if n := len(inputs()); n != 1 {
+		t.Errorf("expected a.csv not to trigger again, got %d runs", n)
+	}
+
+	//a restart reads the state from the repository
+	s = NewService(r)
+	s.log.SetOutput(testWriter{t})
+	s.watchFiles(now.Add(3 * time.Minute))
+	if n := len(inputs()); n != 1 {
+		t.Errorf("expected a.csv not to trigger again after a restart, got %d runs", n)
+	}
+
+	//modified, triggers again once stable and debounced
+	write("a.csv", "1,2,3,4")
+	s.watchFiles(now.Add(4 * time.Minute))
+	s.watchFiles(now.Add(5 * time.Minute))
+	if n := len(inputs()); n != 1 {
+		t.Errorf("expected the debounce to hold the modified file, got %d runs", n)
+	}
+	s.watchFiles(now.Add(7 * time.Minute))
+	in = inputs()
+	if len(in) != 2 || in[1]["size"] != 7.0 {
+		t.Fatalf("expected the modified a.csv to trigger again, got %+v", in)
+	}
+
+	//recursive, paused
+	recursive := FileWatch{Dir: dir, Pattern: "*.csv", Recursive: true}
+	if err := r.UpdateJob(&UpdateJobInput{JobID: job, Triggers: &TriggerEventsInput{FileWatch: &recursive}}); err != nil {
+		t.Fatal(err)
+	}
+	paused := true
+	if err := r.UpdateJob(&UpdateJobInput{JobID: job, Paused: &paused}); err != nil {
+		t.Fatal(err)
+	}
+	s.watchFiles(now.Add(8 * time.Minute))
+	if n := len(inputs()); n != 2 {
+		t.Errorf("expected a paused job not to watch, got %d runs", n)
+	}
+	paused = false
+	if err := r.UpdateJob(&UpdateJobInput{JobID: job, Paused: &paused}); err != nil {
+		t.Fatal(err)
+	}
+	s.watchFiles(now.Add(9 * time.Minute))
+	in = inputs()
+	if len(in) != 3 || in[2]["name"] != "c.csv" {
+		t.Fatalf("expected sub/c.csv to trigger, got %+v", in)
+	}
+
+	//deleted files are forgotten
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	s.watchFiles(now.Add(10 * time.Minute))
+	files, err := r.GetWatchedFiles(&GetWatchedFilesInput{JobID: job})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0].Path) != "c.csv" {
+		t.Errorf("expected only sub/c.csv to be watched, got %+v", files)
+	}
+
+	//a file whose input doesn't render backs off, 1m then 2m
+	bad, err := r.CreateJob(&CreateJobInput{
+		Name:                 "bad import",
+		Processor:            ProcessorConfig{Type: "test"},
+		InputPayloadTemplate: []byte(`{"rows":{{.rows}}}`),
+		Triggers:             &TriggerEventsInput{FileWatch: &FileWatch{Dir: dir, Pattern: "*.csv"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("d.csv", "1")
+	failures := func() int {
+		files, err := r.GetWatchedFiles(&GetWatchedFilesInput{JobID: bad})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 || files[0].TriggeredAt != nil {
+			t.Fatalf("expected d.csv not to trigger bad import, got %+v", files)
+		}
+		return files[0].Failures
+	}
+	later := now.Add(time.Hour)
+	for _, tick := range []struct {
+		after    time.Duration
+		failures int
+	}{{0, 1}, {30 * time.Second, 1}, {time.Minute, 2}, {2 * time.Minute, 2}, {3 * time.Minute, 3}} {
+		s.watchFiles(later.Add(tick.after))
+		if n := failures(); n != tick.failures {
+			t.Errorf("after %s: expected %d failures, got %d", tick.after, tick.failures, n)
+		}
+	}
+
+	_, err = NewValidationWrapper(r).CreateJob(&CreateJobInput{
+		Name:     "bad",
+		Triggers: &TriggerEventsInput{FileWatch: &FileWatch{Dir: "relative"}},
+	})
+	if err == nil {
+		t.Error("expected a relative directory to be invalid")
+	}
+}
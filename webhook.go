@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const (
+	ErrWebhookNotFound     = Err("webhook not found")
+	ErrWebhookUnauthorized = Err("webhook delivery not authenticated")
+	ErrDuplicateDelivery   = Err("webhook delivery already received")
+)
+
+// WebhookIdempotencyWindow is how long a delivery's IdempotencyKey is
+// remembered at least. Retention keeps the runs of deliveries received
+// within it, older keys are forgotten when their run is pruned.
+const WebhookIdempotencyWindow = 24 * time.Hour
+
+// WebhookAuth is how deliveries to a webhook prove they know its secret
+type WebhookAuth string
+
+const (
+	//the delivery is signed, its signature is "sha256=" and the hex HMAC-SHA256
+	//of the body keyed by the secret
+	WebhookAuthHMAC WebhookAuth = "hmac"
+	//the delivery carries the secret as a bearer token
+	WebhookAuthToken WebhookAuth = "token"
+)
+
+// Webhook lets external systems start runs of a job. Deliveries are posted
+// to a URL ending in Key, which is unguessable and unique to the job, and
+// their body is the template data of the run's input.
+type Webhook struct {
+	JobID     JobID
+	Key       string
+	Secret    string
+	Auth      WebhookAuth
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is a request received on the URL of a webhook
+type WebhookDelivery struct {
+	Key            string
+	Body           []byte //JSON, empty is {}
+	Signature      string //required by WebhookAuthHMAC
+	Token          string //required by WebhookAuthToken
+	IdempotencyKey string //deliveries with the key of an earlier delivery of the webhook are ignored, empty never matches
+	ReceivedAt     time.Time
+}
+
+// NewWebhook gives the job a webhook with a new key and secret, replacing
+// the previous webhook of the job, whose URL then stops working
+func NewWebhook(r Repository, job JobID, auth WebhookAuth, now time.Time) (*Webhook, error) {
+	jobs, err := r.GetJobs(&GetJobsInput{JobIDs: JobIDs{job}})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, ErrJobNotFound
+	}
+	key, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{JobID: job, Key: key, Secret: secret, Auth: auth, CreatedAt: now}
+	err = r.CreateWebhook(&CreateWebhookInput{
+		JobID:     w.JobID,
+		Key:       w.Key,
+		Secret:    w.Secret,
+		Auth:      w.Auth,
+		CreatedAt: w.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ReceiveWebhook authenticates a delivery and creates a run of the webhook's
+// job, with the body as the data of its input template. A delivery repeating
+// the IdempotencyKey of an earlier one, within WebhookIdempotencyWindow,
+// creates nothing, it returns the run of the earlier delivery along with
+// ErrDuplicateDelivery.
+func ReceiveWebhook(r Repository, d *WebhookDelivery) (RunID, error) {
+	webhooks, err := r.GetWebhooks(&GetWebhooksInput{Keys: []string{d.Key}})
+	if err != nil {
+		return 0, err
+	}
+	if len(webhooks) != 1 {
+		return 0, ErrWebhookNotFound
+	}
+	w := webhooks[0]
+	if !w.Authenticate(d) {
+		return 0, ErrWebhookUnauthorized
+	}
+	jobs, err := r.GetJobs(&GetJobsInput{JobIDs: JobIDs{w.JobID}})
+	if err != nil {
+		return 0, err
+	}
+	if len(jobs) != 1 {
+		return 0, ErrJobNotFound
+	}
+	body := d.Body
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	if !json.Valid(body) {
+		return 0, ErrFieldInvalid{"Body", "must be JSON"}
+	}
+	run, err := jobs[0].MakeRun(JobContext{ScheduledStartTime: d.ReceivedAt, PreviousOutput: body})
+	if err != nil {
+		return 0, ErrFieldInvalid{"Body", "err rendering input: " + err.Error()}
+	}
+	return r.CreateWebhookRun(&CreateWebhookRunInput{
+		JobID:          w.JobID,
+		IdempotencyKey: d.IdempotencyKey,
+		Run:            run.CreateRunInput(),
+		ReceivedAt:     d.ReceivedAt,
+	})
+}
+
+// Authenticate reports if the delivery proves it knows the secret of w
+func (w *Webhook) Authenticate(d *WebhookDelivery) bool {
+	switch w.Auth {
+	case WebhookAuthHMAC:
+		return hmac.Equal([]byte(d.Signature), []byte(w.Sign(d.Body)))
+	case WebhookAuthToken:
+		return d.Token != "" && subtle.ConstantTimeCompare([]byte(d.Token), []byte(w.Secret)) == 1
+	}
+	return false
+}
+
+// Sign returns the signature of body that WebhookAuthHMAC expects
+func (w *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
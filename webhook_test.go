@@ -0,0 +1,28 @@
+package pipeline
+
+import "testing"
+
+func TestWebhookAuthenticate(t *testing.T) {
+	body := []byte(`{"file":"a.csv"}`)
+	hmacHook := &Webhook{Secret: "s3cret", Auth: WebhookAuthHMAC}
+	tokenHook := &Webhook{Secret: "s3cret", Auth: WebhookAuthToken}
+	tests := []struct {
+		name string
+		w    *Webhook
+		d    *WebhookDelivery
+		want bool
+	}{
+		{"signed", hmacHook, &WebhookDelivery{Body: body, Signature: hmacHook.Sign(body)}, true},
+		{"signed other body", hmacHook, &WebhookDelivery{Body: []byte("{}"), Signature: hmacHook.Sign(body)}, false},
+		{"token to hmac", hmacHook, &WebhookDelivery{Body: body, Token: "s3cret"}, false},
+		{"token", tokenHook, &WebhookDelivery{Body: body, Token: "s3cret"}, true},
+		{"wrong token", tokenHook, &WebhookDelivery{Body: body, Token: "secret"}, false},
+		{"signature to token", tokenHook, &WebhookDelivery{Body: body, Signature: tokenHook.Sign(body)}, false},
+		{"unknown auth", &Webhook{Secret: "s3cret"}, &WebhookDelivery{Token: "s3cret"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.w.Authenticate(tt.d); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}